@@ -1,6 +1,14 @@
 package constants
 
 const (
-	ExportTypeCsv  = "csv"
-	ExportTypeJson = "json"
+	ExportTypeCsv     = "csv"
+	ExportTypeJson    = "json"
+	ExportTypeNdjson  = "ndjson"
+	ExportTypeParquet = "parquet"
+	ExportTypeXlsx    = "xlsx"
+)
+
+const (
+	ExportDestinationTypeDir = "dir"
+	ExportDestinationTypeS3  = "s3"
 )
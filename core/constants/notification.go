@@ -6,13 +6,14 @@ const (
 )
 
 const (
-	NotificationTriggerTaskFinish       = "task_finish"
-	NotificationTriggerTaskError        = "task_error"
-	NotificationTriggerTaskEmptyResults = "task_empty_results"
-	NotificationTriggerNodeStatusChange = "node_status_change"
-	NotificationTriggerNodeOnline       = "node_online"
-	NotificationTriggerNodeOffline      = "node_offline"
-	NotificationTriggerAlert            = "alert"
+	NotificationTriggerTaskFinish         = "task_finish"
+	NotificationTriggerTaskError          = "task_error"
+	NotificationTriggerTaskEmptyResults   = "task_empty_results"
+	NotificationTriggerTaskResultsChanged = "task_results_changed"
+	NotificationTriggerNodeStatusChange   = "node_status_change"
+	NotificationTriggerNodeOnline         = "node_online"
+	NotificationTriggerNodeOffline        = "node_offline"
+	NotificationTriggerAlert              = "alert"
 )
 
 const (
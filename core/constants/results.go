@@ -8,3 +8,51 @@ const (
 	DedupTypeIgnore    = "ignore"
 	DedupTypeOverwrite = "overwrite"
 )
+
+const (
+	ValidationActionReject     = "reject"
+	ValidationActionQuarantine = "quarantine"
+)
+
+const (
+	LineageKey   = "_meta" // metadata envelope of result lineage
+	SourceUrlKey = "_url"  // source url of a result supplied by sdk
+)
+
+// fields of result lineage envelope
+const (
+	LineageFieldTaskId     = "task_id"
+	LineageFieldSpiderId   = "spider_id"
+	LineageFieldScheduleId = "schedule_id"
+	LineageFieldNodeKey    = "node_key"
+	LineageFieldIngestTs   = "ingest_ts"
+	LineageFieldSourceUrl  = "source_url"
+)
+
+// aggregate functions of result metrics
+const (
+	ResultMetricOpCount = "count"
+	ResultMetricOpSum   = "sum"
+	ResultMetricOpAvg   = "avg"
+	ResultMetricOpMin   = "min"
+	ResultMetricOpMax   = "max"
+)
+
+// time buckets of result group-by fields
+const (
+	ResultBucketHour  = "hour"
+	ResultBucketDay   = "day"
+	ResultBucketWeek  = "week"
+	ResultBucketMonth = "month"
+	ResultBucketYear  = "year"
+)
+
+// types of result transforms applied on ingestion
+const (
+	ResultTransformTypeRename  = "rename"
+	ResultTransformTypeDrop    = "drop"
+	ResultTransformTypeRegex   = "regex"
+	ResultTransformTypeTrim    = "trim"
+	ResultTransformTypeUrl     = "url"
+	ResultTransformTypeCompute = "compute"
+)
@@ -8,3 +8,22 @@ const (
 	ScheduleStatusErrorNotFoundNode   = "Not Found Node"
 	ScheduleStatusErrorNotFoundSpider = "Not Found Spider"
 )
+
+const (
+	ScheduleConcurrencyPolicyAllow   = "allow"   // run regardless of previous tasks (default)
+	ScheduleConcurrencyPolicySkip    = "skip"    // skip if previous task is pending or running
+	ScheduleConcurrencyPolicyQueue   = "queue"   // run after previous task finishes
+	ScheduleConcurrencyPolicyReplace = "replace" // cancel previous task and run
+)
+
+const (
+	ScheduleMisfirePolicyIgnore   = "ignore"    // skip runs missed while master was down (default)
+	ScheduleMisfirePolicyFireOnce = "fire_once" // fire the latest missed run once
+	ScheduleMisfirePolicyFireAll  = "fire_all"  // fire missed runs up to misfire limit
+)
+
+const (
+	ScheduleRunStatusFired   = "fired"
+	ScheduleRunStatusSkipped = "skipped"
+	ScheduleRunStatusError   = "error"
+)
@@ -7,6 +7,13 @@ const (
 	TaskStatusError     = "error"
 	TaskStatusCancelled = "cancelled"
 	TaskStatusAbnormal  = "abnormal"
+	TaskStatusTimeout   = "timeout"    // killed after exceeding max runtime
+	TaskStatusOomKilled = "oom-killed" // killed after exceeding memory limit
+)
+
+const (
+	TaskRetryBackoffFixed       = "fixed"
+	TaskRetryBackoffExponential = "exponential"
 )
 
 const (
@@ -37,3 +44,7 @@ const (
 const (
 	TaskKey = "_tid"
 )
+
+const (
+	TaskEventStatusUpdated = "task:status_updated" // sent with task id when a runner updates task status
+)
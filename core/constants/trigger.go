@@ -0,0 +1,17 @@
+package constants
+
+const (
+	TriggerTypeTask    = "task"    // task of source spider reaching one of source statuses
+	TriggerTypeWebhook = "webhook" // inbound webhook with the secret token of trigger
+	TriggerTypeFile    = "file"    // new file appearing in a watched workspace directory
+)
+
+const (
+	TriggerRunStatusFired   = "fired"
+	TriggerRunStatusSkipped = "skipped"
+	TriggerRunStatusError   = "error"
+)
+
+const (
+	TriggerTokenHeader = "X-Trigger-Token"
+)
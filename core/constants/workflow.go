@@ -0,0 +1,23 @@
+package constants
+
+const (
+	WorkflowEdgeConditionSuccess = "success" // upstream step finished
+	WorkflowEdgeConditionFailure = "failure" // upstream step failed or was cancelled
+	WorkflowEdgeConditionAlways  = "always"  // upstream step ended in any way, including skipped
+)
+
+const (
+	WorkflowStepStatusPending   = "pending"
+	WorkflowStepStatusRunning   = "running"
+	WorkflowStepStatusFinished  = "finished"
+	WorkflowStepStatusError     = "error"
+	WorkflowStepStatusCancelled = "cancelled"
+	WorkflowStepStatusSkipped   = "skipped"
+)
+
+const (
+	WorkflowRunStatusRunning   = "running"
+	WorkflowRunStatusFinished  = "finished"
+	WorkflowRunStatusError     = "error"
+	WorkflowRunStatusCancelled = "cancelled"
+)
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/export"
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func PostDataCollection(c *gin.Context) {
+	var dc models.DataCollectionV2
+	if err := c.ShouldBindJSON(&dc); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := validateRetentionArchive(dc.Retention.Archive); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := export.EncryptDestinationSecret(&dc.Retention.Archive.Destination, entity.ExportDestination{}); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	modelSvc := service.NewModelServiceV2[models.DataCollectionV2]()
+
+	dc.SetCreated(u.Id)
+	dc.SetUpdated(u.Id)
+	id, err := modelSvc.InsertOne(dc)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	result, err := modelSvc.GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, result)
+}
+
+func PutDataCollectionById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	var dc models.DataCollectionV2
+	if err := c.ShouldBindJSON(&dc); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := validateRetentionArchive(dc.Retention.Archive); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	modelSvc := service.NewModelServiceV2[models.DataCollectionV2]()
+
+	// keep secret key of archive destination, which is not returned to users
+	existing, err := modelSvc.GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if err := export.EncryptDestinationSecret(&dc.Retention.Archive.Destination, existing.Retention.Archive.Destination); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	dc.SetUpdated(u.Id)
+	if err := modelSvc.ReplaceById(id, dc); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	result, err := modelSvc.GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, result)
+}
+
+// validateRetentionArchive validates the destination of a retention archive, whose file is kept
+// in export directory if destination type is empty
+func validateRetentionArchive(archive entity.RetentionArchive) (err error) {
+	if !archive.Enabled || archive.Destination.Type == "" {
+		return nil
+	}
+	return validateExportDestination(archive.Destination)
+}
@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	errors2 "github.com/crawlab-team/crawlab/core/errors"
+	"github.com/crawlab-team/crawlab/core/export"
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/schedule"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+)
+
+func PostExportSchedule(c *gin.Context) {
+	var s models.ExportScheduleV2
+	if err := c.ShouldBindJSON(&s); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := validateExportSchedule(&s); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	if err := export.EncryptDestinationSecret(&s.Destination, entity.ExportDestination{}); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	modelSvc := service.NewModelServiceV2[models.ExportScheduleV2]()
+
+	s.LastId = primitive.NilObjectID
+	s.LastIds = nil
+	s.SetCreated(u.Id)
+	s.SetUpdated(u.Id)
+	id, err := modelSvc.InsertOne(s)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	s.Id = id
+
+	if s.Enabled {
+		scheduleSvc, err := schedule.GetExportScheduleServiceV2()
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		if err := scheduleSvc.Enable(s, u.Id); err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+	}
+
+	HandleSuccessWithData(c, s)
+}
+
+func PutExportScheduleById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	var s models.ExportScheduleV2
+	if err := c.ShouldBindJSON(&s); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if s.Id != id {
+		HandleErrorBadRequest(c, errors2.ErrorHttpBadRequest)
+		return
+	}
+	if err := validateExportSchedule(&s); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	modelSvc := service.NewModelServiceV2[models.ExportScheduleV2]()
+
+	// keep high-water mark and cron entry, which are maintained by the export schedule service
+	existing, err := modelSvc.GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	s.LastId = existing.LastId
+	s.LastIds = existing.LastIds
+	s.EntryId = existing.EntryId
+	if err := export.EncryptDestinationSecret(&s.Destination, existing.Destination); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	s.SetUpdated(u.Id)
+	err = modelSvc.ReplaceById(id, s)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	scheduleSvc, err := schedule.GetExportScheduleServiceV2()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	if s.Enabled {
+		if err := scheduleSvc.Enable(s, u.Id); err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+	} else {
+		if err := scheduleSvc.Disable(s, u.Id); err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+	}
+
+	HandleSuccessWithData(c, s)
+}
+
+func DeleteExportScheduleById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := deleteExportSchedules([]primitive.ObjectID{id}, GetUserFromContextV2(c).Id); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+func DeleteExportScheduleList(c *gin.Context) {
+	var payload struct {
+		Ids []primitive.ObjectID `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := deleteExportSchedules(payload.Ids, GetUserFromContextV2(c).Id); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+// deleteExportSchedules removes cron entries of export schedules, and deletes the schedules and
+// their runs
+func deleteExportSchedules(ids []primitive.ObjectID, by primitive.ObjectID) (err error) {
+	modelSvc := service.NewModelServiceV2[models.ExportScheduleV2]()
+
+	scheduleSvc, err := schedule.GetExportScheduleServiceV2()
+	if err != nil {
+		return err
+	}
+	schedules, err := modelSvc.GetMany(bson.M{"_id": bson.M{"$in": ids}}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return err
+	}
+	for _, s := range schedules {
+		if err := scheduleSvc.Disable(s, by); err != nil {
+			return err
+		}
+	}
+
+	return mongo.RunTransaction(func(ctx mongo2.SessionContext) (err error) {
+		if err := modelSvc.DeleteManyContext(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		return service.NewModelServiceV2[models.ExportRunV2]().DeleteManyContext(ctx, bson.M{"schedule_id": bson.M{"$in": ids}})
+	})
+}
+
+func PostExportScheduleEnable(c *gin.Context) {
+	postExportScheduleEnableDisableFunc(true)(c)
+}
+
+func PostExportScheduleDisable(c *gin.Context) {
+	postExportScheduleEnableDisableFunc(false)(c)
+}
+
+func postExportScheduleEnableDisableFunc(isEnable bool) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			HandleErrorBadRequest(c, err)
+			return
+		}
+		svc, err := schedule.GetExportScheduleServiceV2()
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		s, err := service.NewModelServiceV2[models.ExportScheduleV2]().GetById(id)
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		u := GetUserFromContextV2(c)
+		if isEnable {
+			err = svc.Enable(*s, u.Id)
+		} else {
+			err = svc.Disable(*s, u.Id)
+		}
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		HandleSuccess(c)
+	}
+}
+
+// PostExportScheduleRun runs an export schedule immediately in the background
+func PostExportScheduleRun(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	svc, err := schedule.GetExportScheduleServiceV2()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if _, err := service.NewModelServiceV2[models.ExportScheduleV2]().GetById(id); err != nil {
+		HandleErrorNotFound(c, err)
+		return
+	}
+	go func() {
+		if _, err := svc.Run(id); err != nil {
+			trace.PrintError(err)
+		}
+	}()
+	HandleSuccess(c)
+}
+
+func GetExportScheduleRuns(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// pagination
+	p, err := GetPagination(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// query
+	query := bson.M{"schedule_id": id}
+
+	// list
+	modelSvc := service.NewModelServiceV2[models.ExportRunV2]()
+	data, err := modelSvc.GetMany(query, &mongo.FindOptions{
+		Sort:  bson.D{{"_id", -1}},
+		Skip:  (p.Page - 1) * p.Size,
+		Limit: p.Size,
+	})
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			HandleSuccessWithListData(c, nil, 0)
+			return
+		}
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// total
+	total, err := modelSvc.Count(query)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithListData(c, data, total)
+}
+
+func validateExportSchedule(s *models.ExportScheduleV2) (err error) {
+	if s.Target == "" {
+		return errors.New("empty target")
+	}
+	if _, err := export.GetExportService(s.ExportType); err != nil {
+		return err
+	}
+	return validateExportDestination(s.Destination)
+}
+
+func validateExportDestination(dest entity.ExportDestination) (err error) {
+	switch dest.Type {
+	case constants.ExportDestinationTypeDir:
+		if dest.Path == "" {
+			return errors.New("empty destination path")
+		}
+		if _, err := export.GetDestinationDir(dest.Path); err != nil {
+			return err
+		}
+	case constants.ExportDestinationTypeS3:
+		if dest.Endpoint == "" || dest.Bucket == "" {
+			return errors.New("empty destination endpoint or bucket")
+		}
+	default:
+		return errors.New(fmt.Sprintf("invalid destination type: %s", dest.Type))
+	}
+	return nil
+}
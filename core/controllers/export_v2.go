@@ -1,12 +1,15 @@
 package controllers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/export"
-	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/gin-gonic/gin"
+	"path/filepath"
+	"strings"
 )
 
 func PostExport(c *gin.Context) {
@@ -14,17 +17,22 @@ func PostExport(c *gin.Context) {
 	exportTarget := c.Query("target")
 	exportFilter, _ := GetFilter(c)
 
-	var exportId string
-	var err error
-	switch exportType {
-	case constants.ExportTypeCsv:
-		exportId, err = export.GetCsvService().Export(exportType, exportTarget, exportFilter)
-	case constants.ExportTypeJson:
-		exportId, err = export.GetJsonService().Export(exportType, exportTarget, exportFilter)
-	default:
-		HandleErrorBadRequest(c, errors.New(fmt.Sprintf("invalid export type: %s", exportType)))
+	svc, err := export.GetExportService(exportType)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	columns, err := getExportColumns(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
 		return
 	}
+
+	exportId, err := svc.ExportWithOptions(exportTarget, exportFilter, &entity.ExportOptions{
+		Columns: columns,
+		Gzip:    c.Query("gzip") == "true",
+	})
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
@@ -37,16 +45,13 @@ func GetExport(c *gin.Context) {
 	exportType := c.Param("type")
 	exportId := c.Param("id")
 
-	var exp interfaces.Export
-	var err error
-	switch exportType {
-	case constants.ExportTypeCsv:
-		exp, err = export.GetCsvService().GetExport(exportId)
-	case constants.ExportTypeJson:
-		exp, err = export.GetJsonService().GetExport(exportId)
-	default:
-		HandleErrorBadRequest(c, errors.New(fmt.Sprintf("invalid export type: %s", exportType)))
+	svc, err := export.GetExportService(exportType)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
 	}
+
+	exp, err := svc.GetExport(exportId)
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
@@ -59,29 +64,63 @@ func GetExportDownload(c *gin.Context) {
 	exportType := c.Param("type")
 	exportId := c.Param("id")
 
-	var exp interfaces.Export
-	var err error
-	switch exportType {
-	case constants.ExportTypeCsv:
-		exp, err = export.GetCsvService().GetExport(exportId)
-	case constants.ExportTypeJson:
-		exp, err = export.GetJsonService().GetExport(exportId)
-	default:
-		HandleErrorBadRequest(c, errors.New(fmt.Sprintf("invalid export type: %s", exportType)))
+	svc, err := export.GetExportService(exportType)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
 	}
+
+	exp, err := svc.GetExport(exportId)
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
 	}
+	if exp.GetStatus() != constants.TaskStatusFinished {
+		HandleErrorBadRequest(c, errors.New(fmt.Sprintf("export is not finished: %s", exp.GetStatus())))
+		return
+	}
 
-	switch exportType {
-	case constants.ExportTypeCsv:
+	fileName := filepath.Base(exp.GetDownloadPath())
+	switch {
+	case strings.HasSuffix(fileName, ".gz"):
+		c.Header("Content-Type", "application/gzip")
+	case exportType == constants.ExportTypeCsv:
 		c.Header("Content-Type", "text/csv")
-	case constants.ExportTypeJson:
+	case exportType == constants.ExportTypeJson:
 		c.Header("Content-Type", "text/plain")
-	default:
-		HandleErrorBadRequest(c, errors.New(fmt.Sprintf("invalid export type: %s", exportType)))
+	case exportType == constants.ExportTypeNdjson:
+		c.Header("Content-Type", "application/x-ndjson")
+	case exportType == constants.ExportTypeParquet:
+		c.Header("Content-Type", "application/vnd.apache.parquet")
+	case exportType == constants.ExportTypeXlsx:
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
 	}
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", exp.GetDownloadPath()))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
 	c.File(exp.GetDownloadPath())
 }
+
+// getExportColumns returns selected columns from "columns" query, which is a json array of
+// column keys or of {"key", "name"} objects to rename columns
+func getExportColumns(c *gin.Context) (columns []entity.ExportColumn, err error) {
+	columnsStr := c.Query("columns")
+	if columnsStr == "" {
+		return nil, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(columnsStr), &items); err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid columns: %v", err))
+	}
+	for _, item := range items {
+		var column entity.ExportColumn
+		if err := json.Unmarshal(item, &column.Key); err != nil {
+			if err := json.Unmarshal(item, &column); err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid columns: %v", err))
+			}
+		}
+		if column.Key == "" {
+			return nil, errors.New("invalid columns: empty key")
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
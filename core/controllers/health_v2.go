@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/core/node/leader"
+	"github.com/gin-gonic/gin"
+)
+
+// GetHealth returns the health of this master with the leader of masters
+func GetHealth(c *gin.Context) {
+	leaderSvc := leader.GetLeaderServiceV2()
+	h := &entity.Health{
+		NodeKey:        config.GetNodeConfigService().GetNodeKey(),
+		IsLeader:       leaderSvc.IsLeader(),
+		LeaderElection: leaderSvc.IsEnabled(),
+	}
+	if h.IsLeader {
+		h.Leader = h.NodeKey
+	}
+	lease, err := leaderSvc.GetLease()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if lease != nil {
+		if !lease.Expired {
+			h.Leader = lease.Holder
+		}
+		h.LeaseExpireTs = lease.ExpireTs
+	}
+	HandleSuccessWithData(c, h)
+}
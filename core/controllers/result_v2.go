@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/result"
@@ -10,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"time"
 )
 
 func GetResultList(c *gin.Context) {
@@ -104,16 +107,83 @@ func GetResultList(c *gin.Context) {
 }
 
 func getResultListQuery(c *gin.Context) (q generic.ListQuery) {
-	f, err := GetFilter(c)
-	if err != nil {
-		return q
+	if f, err := GetFilter(c); err == nil {
+		for _, cond := range f.Conditions {
+			q = append(q, generic.ListQueryCondition{
+				Key:   cond.Key,
+				Op:    cond.Op,
+				Value: utils.NormalizeObjectId(cond.Value),
+			})
+		}
 	}
-	for _, cond := range f.Conditions {
-		q = append(q, generic.ListQueryCondition{
-			Key:   cond.Key,
-			Op:    cond.Op,
-			Value: utils.NormalizeObjectId(cond.Value),
-		})
+	return append(q, getResultLineageQuery(c)...)
+}
+
+// getResultLineageQuery returns conditions on fields of the lineage envelope of results
+func getResultLineageQuery(c *gin.Context) (q generic.ListQuery) {
+	lineageKey := func(field string) string {
+		return constants.LineageKey + "." + field
 	}
+
+	// ids
+	for _, field := range []string{
+		constants.LineageFieldTaskId,
+		constants.LineageFieldSpiderId,
+		constants.LineageFieldScheduleId,
+	} {
+		id, err := primitive.ObjectIDFromHex(c.Query(field))
+		if err != nil {
+			continue
+		}
+		q = append(q, generic.ListQueryCondition{Key: lineageKey(field), Op: generic.OpEqual, Value: id})
+	}
+
+	// strings
+	for _, field := range []string{
+		constants.LineageFieldNodeKey,
+		constants.LineageFieldSourceUrl,
+	} {
+		if v := c.Query(field); v != "" {
+			q = append(q, generic.ListQueryCondition{Key: lineageKey(field), Op: generic.OpEqual, Value: v})
+		}
+	}
+
+	// ingestion time range
+	if ts, err := time.Parse(time.RFC3339, c.Query(constants.LineageFieldIngestTs+"_from")); err == nil {
+		q = append(q, generic.ListQueryCondition{Key: lineageKey(constants.LineageFieldIngestTs), Op: "$gte", Value: ts})
+	}
+	if ts, err := time.Parse(time.RFC3339, c.Query(constants.LineageFieldIngestTs+"_to")); err == nil {
+		q = append(q, generic.ListQueryCondition{Key: lineageKey(constants.LineageFieldIngestTs), Op: "$lte", Value: ts})
+	}
+
 	return q
 }
+
+// PostSpiderResultsAggregate runs an aggregation query over results of a spider matching the
+// filter and lineage query params
+func PostSpiderResultsAggregate(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	postResultsAggregate(c, id, getResultListQuery(c))
+}
+
+func postResultsAggregate(c *gin.Context, spiderId primitive.ObjectID, query generic.ListQuery) {
+	var agg entity.ResultAggregation
+	if err := c.ShouldBindJSON(&agg); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := result.ValidateAggregation(&agg); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	res, err := result.Aggregate(spiderId, query, &agg)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccessWithData(c, res)
+}
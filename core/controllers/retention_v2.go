@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/retention"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetDataCollectionRetentionDryRun returns the results of a data collection to be deleted by its
+// retention policy without deleting them
+func GetDataCollectionRetentionDryRun(c *gin.Context) {
+	dc, err := getRetentionDataCollection(c)
+	if err != nil {
+		HandleErrorNotFound(c, err)
+		return
+	}
+	run, err := retention.GetRetentionServiceV2().Run(dc, true)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccessWithData(c, run)
+}
+
+// PostDataCollectionRetentionRun applies the retention policy of a data collection immediately in
+// the background
+func PostDataCollectionRetentionRun(c *gin.Context) {
+	dc, err := getRetentionDataCollection(c)
+	if err != nil {
+		HandleErrorNotFound(c, err)
+		return
+	}
+	go func() {
+		if _, err := retention.GetRetentionServiceV2().Run(dc, false); err != nil {
+			trace.PrintError(err)
+		}
+	}()
+	HandleSuccess(c)
+}
+
+// GetRetentionDryRun returns the results of all data collections to be deleted by retention
+// policies without deleting them
+func GetRetentionDryRun(c *gin.Context) {
+	runs, err := retention.GetRetentionServiceV2().RunAll(true)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccessWithListData(c, runs, len(runs))
+}
+
+func getRetentionDataCollection(c *gin.Context) (dc *models.DataCollectionV2, err error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	return service.NewModelServiceV2[models.DataCollectionV2]().GetById(id)
+}
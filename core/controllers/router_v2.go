@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"github.com/crawlab-team/crawlab/core/errors"
 	"github.com/crawlab-team/crawlab/core/middlewares"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/gin-gonic/gin"
@@ -51,11 +52,38 @@ func registerBuiltinHandler(group *gin.RouterGroup, method, path string, handler
 	group.Handle(method, path, handlerFunc)
 }
 
+// handleMethodNotAllowed disables a built-in action of a controller, which would bypass the
+// validation or side effects of its custom actions
+func handleMethodNotAllowed(c *gin.Context) {
+	HandleErrorMethodNotAllowed(c, errors.ErrorHttpMethodNotAllowed)
+}
+
 func InitRoutes(app *gin.Engine) (err error) {
 	// routes groups
 	groups := NewRouterGroups(app)
 
-	RegisterController(groups.AuthGroup, "/data/collections", NewControllerV2[models2.DataCollectionV2]())
+	RegisterController(groups.AuthGroup, "/data/collections", NewControllerV2[models2.DataCollectionV2]([]Action{
+		{
+			Method:      http.MethodPost,
+			Path:        "",
+			HandlerFunc: PostDataCollection,
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/:id",
+			HandlerFunc: PutDataCollectionById,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/retention/dry-run",
+			HandlerFunc: GetDataCollectionRetentionDryRun,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/retention/run",
+			HandlerFunc: PostDataCollectionRetentionRun,
+		},
+	}...))
 	RegisterController(groups.AuthGroup, "/environments", NewControllerV2[models2.EnvironmentV2]())
 	RegisterController(groups.AuthGroup, "/nodes", NewControllerV2[models2.NodeV2]())
 	RegisterController(groups.AuthGroup, "/projects", NewControllerV2[models2.ProjectV2]([]Action{
@@ -86,6 +114,11 @@ func InitRoutes(app *gin.Engine) (err error) {
 			Path:        "/:id/disable",
 			HandlerFunc: PostScheduleDisable,
 		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/runs",
+			HandlerFunc: GetScheduleRuns,
+		},
 	}...))
 	RegisterController(groups.AuthGroup, "/spiders", NewControllerV2[models2.SpiderV2]([]Action{
 		{
@@ -174,6 +207,16 @@ func InitRoutes(app *gin.Engine) (err error) {
 			HandlerFunc: PostSpiderRun,
 		},
 
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/results/aggregate",
+			HandlerFunc: PostSpiderResultsAggregate,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/transforms/test",
+			HandlerFunc: PostSpiderTransformsTest,
+		},
 		{
 			Method:      http.MethodGet,
 			Path:        "/:id/data-source",
@@ -226,11 +269,36 @@ func InitRoutes(app *gin.Engine) (err error) {
 			Path:        "/:id/logs",
 			HandlerFunc: GetTaskLogs,
 		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/logs/stream",
+			HandlerFunc: GetTaskLogsStream,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/logs",
+			HandlerFunc: GetTaskLogsSearch,
+		},
 		{
 			Method:      http.MethodGet,
 			Path:        "/:id/data",
 			HandlerFunc: GetTaskData,
 		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/data/aggregate",
+			HandlerFunc: PostTaskDataAggregate,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/data/quarantine",
+			HandlerFunc: GetTaskDataQuarantine,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/data/diff",
+			HandlerFunc: GetTaskDataDiff,
+		},
 	}...))
 	RegisterController(groups.AuthGroup, "/tokens", NewControllerV2[models2.TokenV2]([]Action{
 		{
@@ -262,6 +330,7 @@ func InitRoutes(app *gin.Engine) (err error) {
 		},
 	}...))
 
+	RegisterController(groups.AuthGroup, "/results/diffs", NewControllerV2[models2.ResultDiffV2]())
 	RegisterActions(groups.AuthGroup, "/results", []Action{
 		{
 			Method:      http.MethodGet,
@@ -269,6 +338,152 @@ func InitRoutes(app *gin.Engine) (err error) {
 			HandlerFunc: GetResultList,
 		},
 	})
+	RegisterController(groups.AuthGroup, "/retention/runs", NewControllerV2[models2.RetentionRunV2]())
+	RegisterActions(groups.AuthGroup, "/retention", []Action{
+		{
+			Method:      http.MethodGet,
+			Path:        "/dry-run",
+			HandlerFunc: GetRetentionDryRun,
+		},
+	})
+	RegisterController(groups.AuthGroup, "/workflows/runs", NewControllerV2[models2.WorkflowRunV2]([]Action{
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/cancel",
+			HandlerFunc: PostWorkflowRunCancel,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/steps/:key/retry",
+			HandlerFunc: PostWorkflowRunStepRetry,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/steps/:key/skip",
+			HandlerFunc: PostWorkflowRunStepSkip,
+		},
+	}...))
+	RegisterController(groups.AuthGroup, "/workflows", NewControllerV2[models2.WorkflowV2]([]Action{
+		{
+			Method:      http.MethodPost,
+			Path:        "",
+			HandlerFunc: PostWorkflow,
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/:id",
+			HandlerFunc: PutWorkflowById,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/run",
+			HandlerFunc: PostWorkflowRun,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/runs",
+			HandlerFunc: GetWorkflowRuns,
+		},
+		{
+			Method:      http.MethodPatch,
+			Path:        "",
+			HandlerFunc: handleMethodNotAllowed,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/:id",
+			HandlerFunc: DeleteWorkflowById,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "",
+			HandlerFunc: DeleteWorkflowList,
+		},
+	}...))
+	RegisterController(groups.AuthGroup, "/triggers", NewControllerV2[models2.TriggerV2]([]Action{
+		{
+			Method:      http.MethodPost,
+			Path:        "",
+			HandlerFunc: PostTrigger,
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/:id",
+			HandlerFunc: PutTriggerById,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/token",
+			HandlerFunc: PostTriggerToken,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/runs",
+			HandlerFunc: GetTriggerRuns,
+		},
+		{
+			Method:      http.MethodPatch,
+			Path:        "",
+			HandlerFunc: handleMethodNotAllowed,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/:id",
+			HandlerFunc: DeleteTriggerById,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "",
+			HandlerFunc: DeleteTriggerList,
+		},
+	}...))
+	RegisterController(groups.AuthGroup, "/export/schedules", NewControllerV2[models2.ExportScheduleV2]([]Action{
+		{
+			Method:      http.MethodPost,
+			Path:        "",
+			HandlerFunc: PostExportSchedule,
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/:id",
+			HandlerFunc: PutExportScheduleById,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/enable",
+			HandlerFunc: PostExportScheduleEnable,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/disable",
+			HandlerFunc: PostExportScheduleDisable,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/:id/run",
+			HandlerFunc: PostExportScheduleRun,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/:id/runs",
+			HandlerFunc: GetExportScheduleRuns,
+		},
+		{
+			Method:      http.MethodPatch,
+			Path:        "",
+			HandlerFunc: handleMethodNotAllowed,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/:id",
+			HandlerFunc: DeleteExportScheduleById,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "",
+			HandlerFunc: DeleteExportScheduleList,
+		},
+	}...))
 	RegisterActions(groups.AuthGroup, "/export", []Action{
 		{
 			Method:      http.MethodPost,
@@ -345,6 +560,20 @@ func InitRoutes(app *gin.Engine) (err error) {
 			HandlerFunc: GetSystemInfo,
 		},
 	})
+	RegisterActions(groups.AnonymousGroup, "/webhooks", []Action{
+		{
+			Method:      http.MethodPost,
+			Path:        "/triggers/:id",
+			HandlerFunc: PostTriggerWebhook,
+		},
+	})
+	RegisterActions(groups.AnonymousGroup, "/health", []Action{
+		{
+			Path:        "",
+			Method:      http.MethodGet,
+			HandlerFunc: GetHealth,
+		},
+	})
 	RegisterActions(groups.AnonymousGroup, "/", []Action{
 		{
 			Method:      http.MethodPost,
@@ -85,6 +85,26 @@ func TestInitRoutes_ProjectsRoute(t *testing.T) {
 	}
 }
 
+func TestInitRoutes_CustomActions(t *testing.T) {
+	router := gin.Default()
+
+	controllers.InitRoutes(router)
+
+	// built-in actions bypassing validation or side effects of custom actions are overridden
+	handlers := map[string]string{}
+	for _, route := range router.Routes() {
+		handlers[route.Method+" - "+route.Path] = route.Handler
+	}
+	for _, basePath := range []string{"/triggers", "/export/schedules", "/workflows"} {
+		assert.Contains(t, handlers["PATCH - "+basePath], "controllers.handleMethodNotAllowed", basePath)
+		assert.NotContains(t, handlers["DELETE - "+basePath+"/:id"], "BaseControllerV2", basePath)
+		assert.NotContains(t, handlers["DELETE - "+basePath], "BaseControllerV2", basePath)
+	}
+	assert.Contains(t, handlers["DELETE - /triggers/:id"], "controllers.DeleteTriggerById")
+	assert.Contains(t, handlers["DELETE - /export/schedules"], "controllers.DeleteExportScheduleList")
+	assert.Contains(t, handlers["DELETE - /workflows/:id"], "controllers.DeleteWorkflowById")
+}
+
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 	m.Run()
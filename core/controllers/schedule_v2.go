@@ -1,12 +1,16 @@
 package controllers
 
 import (
+	errors2 "errors"
 	"github.com/crawlab-team/crawlab/core/errors"
 	"github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/schedule"
+	"github.com/crawlab-team/crawlab/db/mongo"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
 )
 
 func PostSchedule(c *gin.Context) {
@@ -128,3 +132,47 @@ func postScheduleEnableDisableFunc(isEnable bool) func(c *gin.Context) {
 		HandleSuccess(c)
 	}
 }
+
+// GetScheduleRuns returns fire events of a schedule, latest first
+func GetScheduleRuns(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// pagination
+	p, err := GetPagination(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// query
+	query := bson.M{"schedule_id": id}
+
+	// list
+	modelSvc := service.NewModelServiceV2[models.ScheduleRunV2]()
+	data, err := modelSvc.GetMany(query, &mongo.FindOptions{
+		Sort:  bson.D{{"ts", -1}, {"_id", -1}},
+		Skip:  (p.Page - 1) * p.Size,
+		Limit: p.Size,
+	})
+	if err != nil {
+		if errors2.Is(err, mongo2.ErrNoDocuments) {
+			HandleSuccessWithListData(c, nil, 0)
+			return
+		}
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// total
+	total, err := modelSvc.Count(query)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithListData(c, data, total)
+}
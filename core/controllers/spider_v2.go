@@ -3,10 +3,12 @@ package controllers
 import (
 	"errors"
 	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/fs"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/result"
 	"github.com/crawlab-team/crawlab/core/spider/admin"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/db/mongo"
@@ -250,6 +252,10 @@ func PostSpider(c *gin.Context) {
 		HandleErrorBadRequest(c, err)
 		return
 	}
+	if err := validateSpiderTransforms(s.Transforms); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
 
 	// user
 	u := GetUserFromContextV2(c)
@@ -303,6 +309,10 @@ func PutSpiderById(c *gin.Context) {
 		HandleErrorBadRequest(c, err)
 		return
 	}
+	if err := validateSpiderTransforms(s.Transforms); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
 
 	u := GetUserFromContextV2(c)
 
@@ -785,3 +795,59 @@ func getSpiderRootPath(c *gin.Context) (rootPath string, err error) {
 
 	return filepath.Join(s.GitId.Hex(), s.GitRootPath), nil
 }
+
+// validateSpiderTransforms validates result transforms declared by a spider, which are applied
+// to results on ingestion
+func validateSpiderTransforms(transforms []entity.ResultTransform) (err error) {
+	if len(transforms) == 0 {
+		return nil
+	}
+	_, err = result.NewTransformer(transforms)
+	return err
+}
+
+// PostSpiderTransformsTest runs result transforms against a sample record, using transforms of
+// the spider if none is given, and returns the transformed record
+func PostSpiderTransformsTest(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	var payload struct {
+		Transforms []entity.ResultTransform `json:"transforms"`
+		Record     map[string]interface{}   `json:"record"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if payload.Record == nil {
+		HandleErrorBadRequest(c, errors.New("empty record"))
+		return
+	}
+
+	// transforms
+	if payload.Transforms == nil {
+		s, err := service.NewModelServiceV2[models2.SpiderV2]().GetById(id)
+		if err != nil {
+			HandleErrorNotFound(c, err)
+			return
+		}
+		payload.Transforms = s.Transforms
+	}
+	transformer, err := result.NewTransformer(payload.Transforms)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// transform
+	res := entity.ResultTransformTest{Record: payload.Record, Errors: []string{}}
+	for _, err := range transformer.Transform(payload.Record) {
+		res.Errors = append(res.Errors, err.Error())
+	}
+
+	HandleSuccessWithData(c, res)
+}
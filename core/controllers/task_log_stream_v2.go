@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/crawlab-team/crawlab/core/task/log"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// taskLogStreamWriter writes log lines of a live task log stream to a client
+type taskLogStreamWriter interface {
+	WriteLine(l log.Line) (err error)
+	Close(err error)
+}
+
+// wsTaskLogStreamWriter writes each log line as a json text message to a websocket
+type wsTaskLogStreamWriter struct {
+	w *WsWriter
+}
+
+func (w *wsTaskLogStreamWriter) WriteLine(l log.Line) (err error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(data)
+	return err
+}
+
+func (w *wsTaskLogStreamWriter) Close(err error) {
+	if err != nil {
+		w.w.CloseWithError(err)
+	} else {
+		w.w.CloseWithText("task finished")
+	}
+	_ = w.w.Close()
+}
+
+// sseTaskLogStreamWriter writes each log line as a "line" server-sent event, and an "end" or
+// "error" event when the stream is closed
+type sseTaskLogStreamWriter struct {
+	c *gin.Context
+}
+
+func (w *sseTaskLogStreamWriter) WriteLine(l log.Line) (err error) {
+	w.c.SSEvent("line", l)
+	w.c.Writer.Flush()
+	return w.c.Request.Context().Err()
+}
+
+func (w *sseTaskLogStreamWriter) Close(err error) {
+	if err != nil {
+		w.c.SSEvent("error", err.Error())
+	} else {
+		w.c.SSEvent("end", "task finished")
+	}
+	w.c.Writer.Flush()
+}
+
+// newTaskLogStreamWriter upgrades the request to a websocket if requested, otherwise responds
+// with server-sent events. The returned context is cancelled when the client disconnects.
+func newTaskLogStreamWriter(c *gin.Context) (w taskLogStreamWriter, ctx context.Context, err error) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Writer.Flush()
+		return &sseTaskLogStreamWriter{c: c}, c.Request.Context(), nil
+	}
+
+	ws, err := NewWsWriter(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// read until the client closes the connection
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := ws.conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return &wsTaskLogStreamWriter{w: ws}, ctx, nil
+}
+
+var errTaskLogStreamLagged = errors.New("log stream lagged behind, please reconnect")
@@ -21,8 +21,11 @@ import (
 	mongo2 "go.mongodb.org/mongo-driver/mongo"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 func GetTaskById(c *gin.Context) {
@@ -56,9 +59,101 @@ func GetTaskById(c *gin.Context) {
 	// task stat
 	t.Stat, _ = service.NewModelServiceV2[models.TaskStatV2]().GetById(id)
 
+	// sub-tasks
+	if t.HasSub {
+		t.SubTasks, err = getSubTasks(t.Id)
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+	}
+
+	// retry chain
+	if t.Attempt <= 1 && t.RetryPolicy.MaxAttempts > 1 {
+		retries, err := getRetryTasks([]primitive.ObjectID{t.Id})
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		t.Retries = retries[t.Id]
+	}
+
 	HandleSuccessWithData(c, t)
 }
 
+// getRetryTasks returns retry tasks of the given tasks (first attempts) ordered by attempt
+func getRetryTasks(ids []primitive.ObjectID) (retries map[primitive.ObjectID][]models.TaskV2, err error) {
+	tasks, err := service.NewModelServiceV2[models.TaskV2]().GetMany(bson.M{
+		"first_attempt_id": bson.M{"$in": ids},
+	}, &mongo.FindOptions{
+		Sort: bson.D{{"attempt", 1}},
+	})
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return nil, err
+	}
+	retries = map[primitive.ObjectID][]models.TaskV2{}
+	for _, rt := range tasks {
+		retries[rt.FirstAttemptId] = append(retries[rt.FirstAttemptId], rt)
+	}
+	return retries, nil
+}
+
+// getSubTasks returns sub-tasks of a parent task with their stats and retry chains
+func getSubTasks(parentId primitive.ObjectID) (subTasks []models.TaskV2, err error) {
+	subTasks, err = service.NewModelServiceV2[models.TaskV2]().GetMany(bson.M{
+		"parent_id":        parentId,
+		"first_attempt_id": bson.M{"$exists": false},
+	}, &mongo.FindOptions{
+		Sort: bson.D{{"_id", 1}},
+	})
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(subTasks) == 0 {
+		return nil, nil
+	}
+
+	// sub-task ids
+	var ids []primitive.ObjectID
+	for _, st := range subTasks {
+		ids = append(ids, st.Id)
+	}
+
+	// sub-task stats
+	stats, err := service.NewModelServiceV2[models.TaskStatV2]().GetMany(bson.M{
+		"_id": bson.M{
+			"$in": ids,
+		},
+	}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return nil, err
+	}
+	statsDict := map[primitive.ObjectID]models.TaskStatV2{}
+	for _, ts := range stats {
+		statsDict[ts.Id] = ts
+	}
+	for i, st := range subTasks {
+		ts, ok := statsDict[st.Id]
+		if ok {
+			subTasks[i].Stat = &ts
+		}
+	}
+
+	// sub-task retry chains
+	retries, err := getRetryTasks(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, st := range subTasks {
+		subTasks[i].Retries = retries[st.Id]
+	}
+
+	return subTasks, nil
+}
+
 func GetTaskList(c *gin.Context) {
 	withStats := c.Query("stats")
 	if withStats == "" {
@@ -156,6 +251,16 @@ func GetTaskList(c *gin.Context) {
 		}
 	}
 
+	// retry chains
+	retries, err := getRetryTasks(taskIds)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	for i, t := range tasks {
+		tasks[i].Retries = retries[t.Id]
+	}
+
 	// response
 	HandleSuccessWithListData(c, tasks, total)
 }
@@ -167,40 +272,17 @@ func DeleteTaskById(c *gin.Context) {
 		return
 	}
 
-	// delete in db
-	if err := mongo.RunTransaction(func(context mongo2.SessionContext) (err error) {
-		// delete task
-		_, err = service.NewModelServiceV2[models.TaskV2]().GetById(id)
-		if err != nil {
-			return err
-		}
-		err = service.NewModelServiceV2[models.TaskV2]().DeleteById(id)
-		if err != nil {
-			return err
-		}
-
-		// delete task stat
-		_, err = service.NewModelServiceV2[models.TaskStatV2]().GetById(id)
-		if err != nil {
-			log2.Warnf("delete task stat error: %s", err.Error())
-			return nil
-		}
-		err = service.NewModelServiceV2[models.TaskStatV2]().DeleteById(id)
-		if err != nil {
-			log2.Warnf("delete task stat error: %s", err.Error())
-			return nil
-		}
-
-		return nil
-	}); err != nil {
+	// task
+	_, err = service.NewModelServiceV2[models.TaskV2]().GetById(id)
+	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
 	}
 
-	// delete task logs
-	logPath := filepath.Join(viper.GetString("log.path"), id.Hex())
-	if err := os.RemoveAll(logPath); err != nil {
-		log2.Warnf("failed to remove task log directory: %s", logPath)
+	// delete task with sub-tasks
+	if err := deleteTasks([]primitive.ObjectID{id}); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
 	}
 
 	HandleSuccess(c)
@@ -215,20 +297,48 @@ func DeleteList(c *gin.Context) {
 		return
 	}
 
+	// delete tasks with sub-tasks
+	if err := deleteTasks(payload.Ids); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccess(c)
+}
+
+// deleteTasks deletes tasks along with their sub-tasks and retries, and task stats and logs of all
+// of them
+func deleteTasks(ids []primitive.ObjectID) (err error) {
+	// sub-tasks and retries
+	subTasks, err := service.NewModelServiceV2[models.TaskV2]().GetMany(bson.M{
+		"$or": []bson.M{
+			{"parent_id": bson.M{"$in": ids}},
+			{"first_attempt_id": bson.M{"$in": ids}},
+		},
+		"has_sub": bson.M{"$ne": true},
+	}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return err
+	}
+	for _, st := range subTasks {
+		ids = append(ids, st.Id)
+	}
+
+	// delete in db
 	if err := mongo.RunTransaction(func(context mongo2.SessionContext) error {
 		// delete tasks
 		if err := service.NewModelServiceV2[models.TaskV2]().DeleteMany(bson.M{
 			"_id": bson.M{
-				"$in": payload.Ids,
+				"$in": ids,
 			},
 		}); err != nil {
 			return err
 		}
 
 		// delete task stats
-		if err := service.NewModelServiceV2[models.TaskV2]().DeleteMany(bson.M{
+		if err := service.NewModelServiceV2[models.TaskStatV2]().DeleteMany(bson.M{
 			"_id": bson.M{
-				"$in": payload.Ids,
+				"$in": ids,
 			},
 		}); err != nil {
 			log2.Warnf("delete task stat error: %s", err.Error())
@@ -237,14 +347,13 @@ func DeleteList(c *gin.Context) {
 
 		return nil
 	}); err != nil {
-		HandleErrorInternalServerError(c, err)
-		return
+		return err
 	}
 
 	// delete tasks logs
 	wg := sync.WaitGroup{}
-	wg.Add(len(payload.Ids))
-	for _, id := range payload.Ids {
+	wg.Add(len(ids))
+	for _, id := range ids {
 		go func(id string) {
 			// delete task logs
 			logPath := filepath.Join(viper.GetString("log.path"), id)
@@ -256,7 +365,7 @@ func DeleteList(c *gin.Context) {
 	}
 	wg.Wait()
 
-	HandleSuccess(c)
+	return nil
 }
 
 func PostTaskRun(c *gin.Context) {
@@ -325,27 +434,19 @@ func PostTaskRestart(c *gin.Context) {
 		return
 	}
 
-	// options
-	opts := &interfaces.SpiderRunOptions{
-		Mode:     t.Mode,
-		NodeIds:  t.NodeIds,
-		Cmd:      t.Cmd,
-		Param:    t.Param,
-		Priority: t.Priority,
-	}
-
 	// user
+	var userId primitive.ObjectID
 	if u := GetUserFromContextV2(c); u != nil {
-		opts.UserId = u.Id
+		userId = u.Id
 	}
 
-	// run
+	// run, with sub-tasks of parent task rerun on the same nodes
 	adminSvc, err := admin.GetSpiderAdminServiceV2()
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
 	}
-	taskIds, err := adminSvc.Schedule(t.SpiderId, opts)
+	taskIds, err := adminSvc.Restart(t, userId)
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
@@ -406,13 +507,20 @@ func GetTaskLogs(c *gin.Context) {
 		return
 	}
 
+	// filter
+	filter := &log.Filter{
+		Pattern: c.Query("pattern"),
+		Stream:  c.Query("stream"),
+		Level:   c.Query("level"),
+	}
+
 	// logs
-	logDriver, err := log.GetFileLogDriver()
+	logDriver, err := log.GetDefaultLogDriver()
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
 	}
-	logs, err := logDriver.Find(id.Hex(), "", (p.Page-1)*p.Size, p.Size)
+	lines, err := logDriver.FindLines(id.Hex(), filter, (p.Page-1)*p.Size, p.Size)
 	if err != nil {
 		if strings.HasSuffix(err.Error(), "Status:404 Not Found") {
 			HandleSuccess(c)
@@ -421,15 +529,237 @@ func GetTaskLogs(c *gin.Context) {
 		HandleErrorInternalServerError(c, err)
 		return
 	}
-	total, err := logDriver.Count(id.Hex(), "")
+	total, err := logDriver.CountLines(id.Hex(), filter)
 	if err != nil {
 		HandleErrorInternalServerError(c, err)
 		return
 	}
 
+	// structured log lines with stream, level and timestamp
+	if c.Query("structured") == "true" {
+		HandleSuccessWithListData(c, lines, total)
+		return
+	}
+
+	// plain log lines
+	var logs []string
+	for _, l := range lines {
+		logs = append(logs, l.Msg)
+	}
 	HandleSuccessWithListData(c, logs, total)
 }
 
+// GetTaskLogsStream streams logs of a task over websocket or server-sent events. The last n
+// lines (default 100) are sent first, and then new lines as they are received from the task
+// runner, until the task reaches a terminal status or the client disconnects. New lines are only
+// received on the master connected by the task runner (see log.Broker).
+func GetTaskLogsStream(c *gin.Context) {
+	// id
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// number of last lines
+	n := 100
+	if c.Query("n") != "" {
+		n, err = strconv.Atoi(c.Query("n"))
+		if err != nil || n < 0 {
+			HandleErrorBadRequest(c, errors.New("invalid n"))
+			return
+		}
+	}
+
+	// filter
+	filter := &log.Filter{
+		Pattern: c.Query("pattern"),
+		Stream:  c.Query("stream"),
+		Level:   c.Query("level"),
+	}
+	var re *regexp.Regexp
+	if filter.Pattern != "" {
+		re, err = regexp.Compile(filter.Pattern)
+		if err != nil {
+			HandleErrorBadRequest(c, err)
+			return
+		}
+	}
+
+	// task
+	t, err := service.NewModelServiceV2[models.TaskV2]().GetById(id)
+	if errors.Is(err, mongo2.ErrNoDocuments) {
+		HandleErrorNotFound(c, err)
+		return
+	}
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// log driver
+	logDriver, err := log.GetDefaultLogDriver()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// subscribe before reading last lines so that no lines are missed in between
+	sub := log.GetLogBroker().Subscribe(id.Hex())
+	defer sub.Close()
+
+	// last lines
+	total, err := logDriver.CountLines(id.Hex(), filter)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	skip := total - n
+	if skip < 0 {
+		skip = 0
+	}
+	var lines []log.Line
+	if n > 0 && total > 0 {
+		lines, err = logDriver.FindLines(id.Hex(), filter, skip, n)
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+	}
+
+	// writer
+	w, ctx, err := newTaskLogStreamWriter(c)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	var closeErr error
+	defer func() {
+		w.Close(closeErr)
+	}()
+
+	var lastSeq int64
+	for _, l := range lines {
+		if err := w.WriteLine(l); err != nil {
+			return
+		}
+		if l.Seq > lastSeq {
+			lastSeq = l.Seq
+		}
+	}
+
+	// writeLine writes a new line if it matches the filter and has not been sent yet
+	writeLine := func(l log.Line) (err error) {
+		if l.Seq > 0 && l.Seq <= lastSeq {
+			return nil
+		}
+		if filter.Stream != "" && l.Stream != filter.Stream {
+			return nil
+		}
+		if filter.Level != "" && l.Level != filter.Level {
+			return nil
+		}
+		if re != nil && !re.MatchString(l.Msg) {
+			return nil
+		}
+		return w.WriteLine(l)
+	}
+
+	// new lines
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	finished := isTaskFinished(t.Status)
+	for {
+		if finished {
+			// flush remaining lines received before task finished
+			for {
+				select {
+				case l, ok := <-sub.Lines():
+					if !ok {
+						return
+					}
+					if err := writeLine(l); err != nil {
+						return
+					}
+					continue
+				default:
+				}
+				break
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case l, ok := <-sub.Lines():
+			if !ok {
+				if sub.Lagged() {
+					closeErr = errTaskLogStreamLagged
+				}
+				return
+			}
+			if err := writeLine(l); err != nil {
+				return
+			}
+		case <-ticker.C:
+			t, err = service.NewModelServiceV2[models.TaskV2]().GetById(id)
+			if err != nil {
+				closeErr = err
+				return
+			}
+			finished = isTaskFinished(t.Status)
+		}
+	}
+}
+
+func isTaskFinished(status string) (ok bool) {
+	switch status {
+	case constants.TaskStatusFinished, constants.TaskStatusError, constants.TaskStatusCancelled, constants.TaskStatusAbnormal,
+		constants.TaskStatusTimeout, constants.TaskStatusOomKilled:
+		return true
+	default:
+		return false
+	}
+}
+
+func GetTaskLogsSearch(c *gin.Context) {
+	// pattern
+	pattern := c.Query("pattern")
+	if pattern == "" {
+		HandleErrorBadRequest(c, errors.New("pattern is required"))
+		return
+	}
+
+	// pagination
+	p, err := GetPagination(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// log driver
+	logDriver, err := log.GetDefaultLogDriver()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	searchDriver, ok := logDriver.(log.SearchDriver)
+	if !ok {
+		HandleErrorBadRequest(c, errors.New("log search is not supported by current log driver"))
+		return
+	}
+
+	// search
+	lines, total, err := searchDriver.Search(pattern, (p.Page-1)*p.Size, p.Size)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithListData(c, lines, total)
+}
+
 func GetTaskData(c *gin.Context) {
 	// id
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
@@ -488,3 +818,138 @@ func GetTaskData(c *gin.Context) {
 
 	HandleSuccessWithListData(c, data, total)
 }
+
+// PostTaskDataAggregate runs an aggregation query over results of a task
+func PostTaskDataAggregate(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	t, err := service.NewModelServiceV2[models.TaskV2]().GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	query := append(generic.ListQuery{
+		generic.ListQueryCondition{
+			Key:   constants.TaskKey,
+			Op:    generic.OpEqual,
+			Value: t.Id,
+		},
+	}, getResultListQuery(c)...)
+	postResultsAggregate(c, t.SpiderId, query)
+}
+
+// GetTaskDataQuarantine returns result records of a task failing validation against the fields
+// of its data collection
+func GetTaskDataQuarantine(c *gin.Context) {
+	// id
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// pagination
+	p, err := GetPagination(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// query
+	query := bson.M{"task_id": id}
+
+	// list
+	modelSvc := service.NewModelServiceV2[models.ResultQuarantineV2]()
+	data, err := modelSvc.GetMany(query, &mongo.FindOptions{
+		Sort:  bson.D{{"_id", -1}},
+		Skip:  (p.Page - 1) * p.Size,
+		Limit: p.Size,
+	})
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			HandleSuccessWithListData(c, nil, 0)
+			return
+		}
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// total
+	total, err := modelSvc.Count(query)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithListData(c, data, total)
+}
+
+// GetTaskDataDiff compares result records of a task against those of a base task of the same
+// spider, which defaults to the previous finished task. Records are matched on "keys" (comma
+// separated), which default to dedup keys of the data collection.
+func GetTaskDataDiff(c *gin.Context) {
+	// id
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// base task id
+	var baseId primitive.ObjectID
+	if c.Query("base_task_id") != "" {
+		baseId, err = primitive.ObjectIDFromHex(c.Query("base_task_id"))
+		if err != nil {
+			HandleErrorBadRequest(c, err)
+			return
+		}
+	} else {
+		prev, err := result.GetPreviousTask(id)
+		if err != nil {
+			if errors.Is(err, mongo2.ErrNoDocuments) {
+				HandleErrorBadRequest(c, errors.New("no previous finished task to compare with"))
+				return
+			}
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		baseId = prev.Id
+	}
+
+	// options
+	opts := &result.DiffOptions{
+		Keys:         splitQueryList(c.Query("keys")),
+		IgnoreFields: splitQueryList(c.Query("ignore_fields")),
+		Save:         c.Query("save") == "true",
+	}
+	if c.Query("limit") != "" {
+		opts.Limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil {
+			HandleErrorBadRequest(c, err)
+			return
+		}
+	}
+
+	// diff
+	diff, err := result.DiffTaskResults(baseId, id, opts)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, diff)
+}
+
+// splitQueryList splits a comma-separated query value, ignoring empty items
+func splitQueryList(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/crawlab-team/crawlab/core/constants"
+	errors2 "github.com/crawlab-team/crawlab/core/errors"
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/trigger"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"io"
+)
+
+// maxWebhookPayloadSize is the max size of payloads of inbound webhooks
+const maxWebhookPayloadSize = 1 << 20
+
+// triggerWithToken is a trigger with its secret token, which is only returned on creation
+type triggerWithToken struct {
+	models.TriggerV2
+	Token string `json:"token,omitempty"`
+}
+
+func PostTrigger(c *gin.Context) {
+	var tr models.TriggerV2
+	if err := c.ShouldBindJSON(&tr); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := trigger.ValidateTrigger(&tr); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if tr.Type == constants.TriggerTypeWebhook {
+		token, err := trigger.GenerateToken()
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+		tr.Token = token
+	}
+
+	u := GetUserFromContextV2(c)
+
+	tr.SetCreated(u.Id)
+	tr.SetUpdated(u.Id)
+	id, err := service.NewModelServiceV2[models.TriggerV2]().InsertOne(tr)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	tr.Id = id
+
+	HandleSuccessWithData(c, triggerWithToken{TriggerV2: tr, Token: tr.Token})
+}
+
+func PutTriggerById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	var tr models.TriggerV2
+	if err := c.ShouldBindJSON(&tr); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if tr.Id != id {
+		HandleErrorBadRequest(c, errors2.ErrorHttpBadRequest)
+		return
+	}
+	if err := trigger.ValidateTrigger(&tr); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	modelSvc := service.NewModelServiceV2[models.TriggerV2]()
+
+	// keep token, which is only changed by regenerating
+	existing, err := modelSvc.GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	tr.Token = existing.Token
+	if tr.Type == constants.TriggerTypeWebhook && tr.Token == "" {
+		tr.Token, err = trigger.GenerateToken()
+		if err != nil {
+			HandleErrorInternalServerError(c, err)
+			return
+		}
+	}
+
+	u := GetUserFromContextV2(c)
+
+	tr.SetUpdated(u.Id)
+	if err := modelSvc.ReplaceById(id, tr); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, tr)
+}
+
+func DeleteTriggerById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := deleteTriggers([]primitive.ObjectID{id}); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+func DeleteTriggerList(c *gin.Context) {
+	var payload struct {
+		Ids []primitive.ObjectID `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := deleteTriggers(payload.Ids); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccess(c)
+}
+
+// deleteTriggers deletes triggers and their runs
+func deleteTriggers(ids []primitive.ObjectID) (err error) {
+	return mongo.RunTransaction(func(ctx mongo2.SessionContext) (err error) {
+		if err := service.NewModelServiceV2[models.TriggerV2]().DeleteManyContext(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		return service.NewModelServiceV2[models.TriggerRunV2]().DeleteManyContext(ctx, bson.M{"trigger_id": bson.M{"$in": ids}})
+	})
+}
+
+// PostTriggerToken regenerates the secret token of a webhook trigger
+func PostTriggerToken(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	token, err := trigger.GenerateToken()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if err := service.NewModelServiceV2[models.TriggerV2]().UpdateById(id, bson.M{"$set": bson.M{"token": token}}); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	HandleSuccessWithData(c, token)
+}
+
+// GetTriggerRuns returns fire events of a trigger, latest first
+func GetTriggerRuns(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// pagination
+	p, err := GetPagination(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// query
+	query := bson.M{"trigger_id": id}
+
+	// list
+	modelSvc := service.NewModelServiceV2[models.TriggerRunV2]()
+	data, err := modelSvc.GetMany(query, &mongo.FindOptions{
+		Sort:  bson.D{{"ts", -1}, {"_id", -1}},
+		Skip:  (p.Page - 1) * p.Size,
+		Limit: p.Size,
+	})
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			HandleSuccessWithListData(c, nil, 0)
+			return
+		}
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// total
+	total, err := modelSvc.Count(query)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithListData(c, data, total)
+}
+
+// PostTriggerWebhook fires a webhook trigger, authenticated by the secret token of the trigger in
+// header "X-Trigger-Token" or query "token". The request body is the json payload of the trigger.
+func PostTriggerWebhook(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// trigger
+	tr, err := service.NewModelServiceV2[models.TriggerV2]().GetById(id)
+	if err != nil || tr.Type != constants.TriggerTypeWebhook || !tr.Enabled {
+		HandleErrorNotFound(c, errors.New("webhook trigger not found"))
+		return
+	}
+
+	// token
+	token := c.GetHeader(constants.TriggerTokenHeader)
+	if token == "" {
+		token = c.Query("token")
+	}
+	if tr.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(tr.Token)) != 1 {
+		HandleErrorUnauthorized(c, errors.New("invalid trigger token"))
+		return
+	}
+
+	// payload
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookPayloadSize))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// fire
+	triggerSvc, err := trigger.GetTriggerServiceV2()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	run, err := triggerSvc.Webhook(tr, payload)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, run)
+}
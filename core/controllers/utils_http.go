@@ -47,6 +47,10 @@ func HandleErrorNotFoundNoPrint(c *gin.Context, err error) {
 	HandleErrorNoPrint(http.StatusNotFound, c, err)
 }
 
+func HandleErrorMethodNotAllowed(c *gin.Context, err error) {
+	HandleError(http.StatusMethodNotAllowed, c, err)
+}
+
 func HandleErrorInternalServerError(c *gin.Context, err error) {
 	HandleError(http.StatusInternalServerError, c, err)
 }
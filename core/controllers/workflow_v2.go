@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/crawlab-team/crawlab/core/constants"
+	errors2 "github.com/crawlab-team/crawlab/core/errors"
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/workflow"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+)
+
+func PostWorkflow(c *gin.Context) {
+	var w models.WorkflowV2
+	if err := c.ShouldBindJSON(&w); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if err := workflow.ValidateWorkflow(&w); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	w.SetCreated(u.Id)
+	w.SetUpdated(u.Id)
+	id, err := service.NewModelServiceV2[models.WorkflowV2]().InsertOne(w)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	w.Id = id
+
+	HandleSuccessWithData(c, w)
+}
+
+func PutWorkflowById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	var w models.WorkflowV2
+	if err := c.ShouldBindJSON(&w); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	if w.Id != id {
+		HandleErrorBadRequest(c, errors2.ErrorHttpBadRequest)
+		return
+	}
+	if err := workflow.ValidateWorkflow(&w); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	w.SetUpdated(u.Id)
+	if err := service.NewModelServiceV2[models.WorkflowV2]().ReplaceById(id, w); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, w)
+}
+
+// PostWorkflowRun starts a run of a workflow
+func DeleteWorkflowById(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	deleteWorkflows(c, []primitive.ObjectID{id})
+}
+
+func DeleteWorkflowList(c *gin.Context) {
+	var payload struct {
+		Ids []primitive.ObjectID `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+	deleteWorkflows(c, payload.Ids)
+}
+
+// deleteWorkflows deletes workflows and their runs, unless they are running or run by schedules
+func deleteWorkflows(c *gin.Context, ids []primitive.ObjectID) {
+	runModelSvc := service.NewModelServiceV2[models.WorkflowRunV2]()
+
+	n, err := runModelSvc.Count(bson.M{
+		"workflow_id": bson.M{"$in": ids},
+		"status":      constants.WorkflowRunStatusRunning,
+	})
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if n > 0 {
+		HandleErrorBadRequest(c, errors.New("workflows are running"))
+		return
+	}
+	n, err = service.NewModelServiceV2[models.ScheduleV2]().Count(bson.M{"workflow_id": bson.M{"$in": ids}})
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if n > 0 {
+		HandleErrorBadRequest(c, errors.New("workflows are used by schedules"))
+		return
+	}
+
+	if err := mongo.RunTransaction(func(ctx mongo2.SessionContext) (err error) {
+		if err := service.NewModelServiceV2[models.WorkflowV2]().DeleteManyContext(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		return runModelSvc.DeleteManyContext(ctx, bson.M{"workflow_id": bson.M{"$in": ids}})
+	}); err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccess(c)
+}
+
+func PostWorkflowRun(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	workflowSvc, err := workflow.GetWorkflowServiceV2()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	run, err := workflowSvc.Run(id, primitive.NilObjectID, u.Id)
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			HandleErrorNotFound(c, err)
+			return
+		}
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, run)
+}
+
+// GetWorkflowRuns returns runs of a workflow, latest first
+func GetWorkflowRuns(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// pagination
+	p, err := GetPagination(c)
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	// query
+	query := bson.M{"workflow_id": id}
+
+	// list
+	modelSvc := service.NewModelServiceV2[models.WorkflowRunV2]()
+	data, err := modelSvc.GetMany(query, &mongo.FindOptions{
+		Sort:  bson.D{{"start_ts", -1}, {"_id", -1}},
+		Skip:  (p.Page - 1) * p.Size,
+		Limit: p.Size,
+	})
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			HandleSuccessWithListData(c, nil, 0)
+			return
+		}
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	// total
+	total, err := modelSvc.Count(query)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithListData(c, data, total)
+}
+
+// PostWorkflowRunCancel cancels a running workflow run and tasks of its running steps
+func PostWorkflowRunCancel(c *gin.Context) {
+	postWorkflowRunFunc(c, func(svc *workflow.ServiceV2, id primitive.ObjectID, u *models.UserV2) error {
+		return svc.Cancel(id, u.Id)
+	})
+}
+
+// PostWorkflowRunStepRetry reruns a failed or cancelled step of a workflow run and its downstream steps
+func PostWorkflowRunStepRetry(c *gin.Context) {
+	postWorkflowRunFunc(c, func(svc *workflow.ServiceV2, id primitive.ObjectID, u *models.UserV2) error {
+		return svc.RetryStep(id, c.Param("key"))
+	})
+}
+
+// PostWorkflowRunStepSkip skips a pending or running step of a workflow run
+func PostWorkflowRunStepSkip(c *gin.Context) {
+	postWorkflowRunFunc(c, func(svc *workflow.ServiceV2, id primitive.ObjectID, u *models.UserV2) error {
+		return svc.SkipStep(id, c.Param("key"), u.Id)
+	})
+}
+
+func postWorkflowRunFunc(c *gin.Context, fn func(svc *workflow.ServiceV2, id primitive.ObjectID, u *models.UserV2) error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	u := GetUserFromContextV2(c)
+
+	workflowSvc, err := workflow.GetWorkflowServiceV2()
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+	if err := fn(workflowSvc, id, u); err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			HandleErrorNotFound(c, err)
+			return
+		}
+		HandleErrorBadRequest(c, err)
+		return
+	}
+
+	run, err := service.NewModelServiceV2[models.WorkflowRunV2]().GetById(id)
+	if err != nil {
+		HandleErrorInternalServerError(c, err)
+		return
+	}
+
+	HandleSuccessWithData(c, run)
+}
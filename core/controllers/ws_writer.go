@@ -16,7 +16,7 @@ type WsWriter struct {
 }
 
 func (w *WsWriter) Write(data []byte) (n int, err error) {
-	log.Infof("websocket write: %s", string(data))
+	log.Debugf("websocket write: %s", string(data))
 	err = w.conn.WriteMessage(websocket.TextMessage, data)
 	if err != nil {
 		return 0, err
@@ -48,7 +48,7 @@ func NewWsWriter(c *gin.Context) (writer *WsWriter, err error) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Errorf("websocket open connection error: %v", err)
-		trace.PrintError(err)
+		return nil, trace.TraceError(err)
 	}
 
 	return &WsWriter{
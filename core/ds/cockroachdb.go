@@ -4,6 +4,7 @@ import (
 	"github.com/crawlab-team/crawlab/core/constants"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/trace"
@@ -68,3 +69,23 @@ func NewDataSourceCockroachdbService(colId primitive.ObjectID, dsId primitive.Ob
 
 	return svc, nil
 }
+
+// NewDataSourceCockroachdbServiceV2 creates a result service writing results of a data collection
+// to a Cockroachdb database, used as a result sink of V2 spiders
+func NewDataSourceCockroachdbServiceV2(ds *models2.DatabaseV2, colName string) (svc2 interfaces.ResultService, err error) {
+	// service
+	svc := &CockroachdbService{}
+	svc.ds = newDataSourceFromV2(ds)
+	svc.dc = &models.DataCollection{Name: colName}
+
+	// session
+	svc.s, err = utils.GetCockroachdbSessionWithTimeoutV2(ds, sinkConnectTimeout)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// collection
+	svc.col = svc.s.Collection(colName)
+
+	return svc, nil
+}
@@ -11,6 +11,7 @@ import (
 	entity2 "github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/db/generic"
@@ -218,3 +219,20 @@ func (svc *ElasticsearchService) SetTime(t time.Time) {
 func (svc *ElasticsearchService) GetTime() (t time.Time) {
 	return svc.t
 }
+
+// NewDataSourceElasticsearchServiceV2 creates a result service writing results of a data
+// collection to an Elasticsearch index, used as a result sink of V2 spiders
+func NewDataSourceElasticsearchServiceV2(ds *models2.DatabaseV2, colName string) (svc2 interfaces.ResultService, err error) {
+	// service
+	svc := &ElasticsearchService{}
+	svc.ds = newDataSourceFromV2(ds)
+	svc.dc = &models.DataCollection{Name: colName}
+
+	// client
+	svc.c, err = utils.GetElasticsearchClientWithTimeoutV2(ds, sinkConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/db/generic"
@@ -114,3 +115,27 @@ func (svc *KafkaService) SetTime(t time.Time) {
 func (svc *KafkaService) GetTime() (t time.Time) {
 	return svc.t
 }
+
+// NewDataSourceKafkaServiceV2 creates a result service writing results of a data collection
+// as messages to a Kafka topic (database of the data source), used as a result sink of V2 spiders
+func NewDataSourceKafkaServiceV2(ds *models2.DatabaseV2, colName string) (svc2 interfaces.ResultService, err error) {
+	// service
+	svc := &KafkaService{}
+	svc.ds = newDataSourceFromV2(ds)
+	svc.dc = &models.DataCollection{Name: colName}
+
+	// connection
+	svc.c, err = utils.GetKafkaConnectionWithTimeoutV2(ds, sinkConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+func (svc *KafkaService) Close() (err error) {
+	if svc.c == nil {
+		return nil
+	}
+	return svc.c.Close()
+}
@@ -1,10 +1,14 @@
 package ds
 
 import (
+	"context"
 	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/result"
 	"github.com/crawlab-team/crawlab/core/utils"
 	utils2 "github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/db/generic"
@@ -47,6 +51,10 @@ func (svc *MongoService) Count(query generic.ListQuery) (n int, err error) {
 	return svc.col.Count(utils.GetMongoQuery(query))
 }
 
+func (svc *MongoService) Aggregate(query generic.ListQuery, agg *entity.ResultAggregation) (res *entity.ResultAggregationResult, err error) {
+	return result.AggregateMongo(svc.col, utils.GetMongoQuery(query), agg)
+}
+
 func NewDataSourceMongoService(colId primitive.ObjectID, dsId primitive.ObjectID) (svc2 interfaces.ResultService, err error) {
 	// service
 	svc := &MongoService{}
@@ -116,3 +124,33 @@ func (svc *MongoService) SetTime(t time.Time) {
 func (svc *MongoService) GetTime() (t time.Time) {
 	return svc.t
 }
+
+// NewDataSourceMongoServiceV2 creates a result service writing results of a data collection
+// to a MongoDB database, used as a result sink of V2 spiders
+func NewDataSourceMongoServiceV2(ds *models2.DatabaseV2, colName string) (svc2 interfaces.ResultService, err error) {
+	// service
+	svc := &MongoService{}
+	svc.ds = newDataSourceFromV2(ds)
+	svc.dc = &models.DataCollection{Name: colName}
+
+	// mongo client
+	svc.c, err = utils2.GetMongoClientWithTimeoutV2(ds, sinkConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// mongo database
+	svc.db = mongo.GetMongoDb(ds.Database, mongo.WithDbClient(svc.c))
+
+	// mongo col
+	svc.col = mongo.GetMongoColWithDb(colName, svc.db)
+
+	return svc, nil
+}
+
+func (svc *MongoService) Close() (err error) {
+	if svc.c == nil {
+		return nil
+	}
+	return svc.c.Disconnect(context.Background())
+}
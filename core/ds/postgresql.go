@@ -4,6 +4,7 @@ import (
 	"github.com/crawlab-team/crawlab/core/constants"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/utils"
 	utils2 "github.com/crawlab-team/crawlab/core/utils"
@@ -69,3 +70,23 @@ func NewDataSourcePostgresqlService(colId primitive.ObjectID, dsId primitive.Obj
 
 	return svc, nil
 }
+
+// NewDataSourcePostgresqlServiceV2 creates a result service writing results of a data collection
+// to a Postgresql database, used as a result sink of V2 spiders
+func NewDataSourcePostgresqlServiceV2(ds *models2.DatabaseV2, colName string) (svc2 interfaces.ResultService, err error) {
+	// service
+	svc := &PostgresqlService{}
+	svc.ds = newDataSourceFromV2(ds)
+	svc.dc = &models.DataCollection{Name: colName}
+
+	// session
+	svc.s, err = utils2.GetPostgresqlSessionWithTimeoutV2(ds, sinkConnectTimeout)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// collection
+	svc.col = svc.s.Collection(colName)
+
+	return svc, nil
+}
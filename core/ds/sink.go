@@ -0,0 +1,100 @@
+package ds
+
+import (
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/errors"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/trace"
+	"sync"
+	"time"
+)
+
+const sinkConnectTimeout = 10 * time.Second
+
+// SinkFn creates a result service writing results of a data collection to a data source
+type SinkFn func(ds *models2.DatabaseV2, colName string) (svc interfaces.ResultService, err error)
+
+// SinkRegistry maps data source types to result sinks. Result sinks are available without the
+// database registry service, so that V2 spiders can write results to any supported data source.
+type SinkRegistry struct {
+	// internals
+	fns sync.Map
+}
+
+func (r *SinkRegistry) Register(dsType string, fn SinkFn) {
+	r.fns.Store(dsType, fn)
+}
+
+func (r *SinkRegistry) Unregister(dsType string) {
+	r.fns.Delete(dsType)
+}
+
+func (r *SinkRegistry) Get(dsType string) (fn SinkFn) {
+	res, ok := r.fns.Load(dsType)
+	if !ok {
+		return nil
+	}
+	fn, _ = res.(SinkFn)
+	return fn
+}
+
+// NewSink creates a result sink writing results of a data collection to a data source
+func (r *SinkRegistry) NewSink(ds *models2.DatabaseV2, colName string) (svc interfaces.ResultService, err error) {
+	fn := r.Get(ds.DataSource)
+	if fn == nil {
+		return nil, errors.NewResultError(fmt.Sprintf("result sink of data source type %s is not implemented", ds.DataSource))
+	}
+
+	// decrypt password on a copy of data source
+	_ds := *ds
+	if _ds.EncryptedPassword != "" {
+		_ds.Password, err = utils.DecryptAES(_ds.EncryptedPassword)
+		if err != nil {
+			return nil, trace.TraceError(err)
+		}
+	}
+
+	return fn(&_ds, colName)
+}
+
+// newDataSourceFromV2 converts a V2 data source to the data source model used by result services
+func newDataSourceFromV2(ds *models2.DatabaseV2) (res *models.DataSource) {
+	return &models.DataSource{
+		Id:       ds.Id,
+		Name:     ds.Name,
+		Type:     ds.DataSource,
+		Host:     ds.Host,
+		Port:     ds.Port,
+		Url:      ds.URI,
+		Database: ds.Database,
+		Username: ds.Username,
+		Password: ds.Password,
+	}
+}
+
+func newSinkRegistry() (r *SinkRegistry) {
+	r = &SinkRegistry{}
+	r.Register(constants.DataSourceTypeMongo, NewDataSourceMongoServiceV2)
+	r.Register(constants.DataSourceTypeMysql, NewDataSourceMysqlServiceV2)
+	r.Register(constants.DataSourceTypePostgresql, NewDataSourcePostgresqlServiceV2)
+	r.Register(constants.DataSourceTypeMssql, NewDataSourceMssqlServiceV2)
+	r.Register(constants.DataSourceTypeSqlite, NewDataSourceSqliteServiceV2)
+	r.Register(constants.DataSourceTypeCockroachdb, NewDataSourceCockroachdbServiceV2)
+	r.Register(constants.DataSourceTypeElasticSearch, NewDataSourceElasticsearchServiceV2)
+	r.Register(constants.DataSourceTypeKafka, NewDataSourceKafkaServiceV2)
+	return r
+}
+
+var sinkRegistry *SinkRegistry
+var sinkRegistryOnce sync.Once
+
+func GetSinkRegistry() (r *SinkRegistry) {
+	sinkRegistryOnce.Do(func() {
+		sinkRegistry = newSinkRegistry()
+	})
+	return sinkRegistry
+}
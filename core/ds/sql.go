@@ -74,3 +74,10 @@ func (svc *SqlService) SetTime(t time.Time) {
 func (svc *SqlService) GetTime() (t time.Time) {
 	return svc.t
 }
+
+func (svc *SqlService) Close() (err error) {
+	if svc.s == nil {
+		return nil
+	}
+	return svc.s.Close()
+}
@@ -0,0 +1,246 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/result"
+	utils2 "github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/db/generic"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/upper/db/v4"
+	"strconv"
+	"strings"
+)
+
+// Aggregate runs an aggregation query over results with GROUP BY. Nested fields are not
+// supported, as nested values are stored as json strings.
+func (svc *SqlService) Aggregate(query generic.ListQuery, agg *entity.ResultAggregation) (res *entity.ResultAggregationResult, err error) {
+	d, err := newSqlAggregateDialect(svc.ds.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.validate(agg); err != nil {
+		return nil, err
+	}
+	cond := utils2.GetSqlQuery(query)
+	res = &entity.ResultAggregationResult{Rows: []map[string]interface{}{}}
+
+	// groups
+	if len(agg.GroupBy) > 0 || len(agg.Metrics) > 0 {
+		var columns, groupBy []interface{}
+		for i, g := range agg.GroupBy {
+			expr := d.groupByExpr(g)
+			columns = append(columns, db.Raw(expr+" AS "+d.quote(fmt.Sprintf("g%d", i))))
+			groupBy = append(groupBy, db.Raw(expr))
+		}
+		for i, m := range agg.Metrics {
+			columns = append(columns, db.Raw(d.metricExpr(m)+" AS "+d.quote(fmt.Sprintf("m%d", i))))
+		}
+		var orderBy []interface{}
+		for _, s := range result.GetAggregationSort(agg) {
+			orderBy = append(orderBy, db.Raw(d.orderByExpr(s)))
+		}
+		sel := svc.s.SQL().Select(columns...).From(svc.dc.Name)
+		if len(cond) > 0 {
+			sel = sel.Where(cond)
+		}
+		if len(groupBy) > 0 {
+			sel = sel.GroupBy(groupBy...)
+		}
+		var rows []map[string]interface{}
+		if err := sel.OrderBy(orderBy...).Limit(agg.Limit).All(&rows); err != nil {
+			return nil, trace.TraceError(err)
+		}
+		for _, r := range rows {
+			row := map[string]interface{}{}
+			for i, g := range agg.GroupBy {
+				row[g.GetName()] = normalizeSqlValue(r[fmt.Sprintf("g%d", i)])
+			}
+			for i, m := range agg.Metrics {
+				row[m.GetName()] = normalizeSqlNumber(r[fmt.Sprintf("m%d", i)])
+			}
+			res.Rows = append(res.Rows, row)
+		}
+	}
+
+	// facets
+	if len(agg.Facets) > 0 {
+		res.Facets = map[string][]entity.ResultFacetValue{}
+		for _, f := range agg.Facets {
+			sel := svc.s.SQL().Select(
+				db.Raw(d.quote(f)+" AS "+d.quote("value")),
+				db.Raw("COUNT(*) AS "+d.quote("count")),
+			).From(svc.dc.Name)
+			if len(cond) > 0 {
+				sel = sel.Where(cond)
+			}
+			var rows []map[string]interface{}
+			if err := sel.GroupBy(db.Raw(d.quote(f))).
+				OrderBy(db.Raw(d.quote("count")+" DESC"), db.Raw(d.quote("value")+" ASC")).
+				Limit(agg.FacetLimit).
+				All(&rows); err != nil {
+				return nil, trace.TraceError(err)
+			}
+			res.Facets[f] = []entity.ResultFacetValue{}
+			for _, r := range rows {
+				count, _ := normalizeSqlNumber(r["count"]).(int64)
+				res.Facets[f] = append(res.Facets[f], entity.ResultFacetValue{
+					Value: normalizeSqlValue(r["value"]),
+					Count: count,
+				})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// sqlAggregateDialect builds sql expressions of aggregation queries for a data source type
+type sqlAggregateDialect string
+
+func newSqlAggregateDialect(dsType string) (d sqlAggregateDialect, err error) {
+	switch dsType {
+	case constants.DataSourceTypeMysql,
+		constants.DataSourceTypePostgresql,
+		constants.DataSourceTypeCockroachdb,
+		constants.DataSourceTypeMssql,
+		constants.DataSourceTypeSqlite:
+		return sqlAggregateDialect(dsType), nil
+	default:
+		return d, errors.New(fmt.Sprintf("aggregation is not supported by data source type %s", dsType))
+	}
+}
+
+// validate rejects nested fields, which are flattened into json strings in sql tables
+func (d sqlAggregateDialect) validate(agg *entity.ResultAggregation) (err error) {
+	fields := append([]string{}, agg.Facets...)
+	for _, g := range agg.GroupBy {
+		fields = append(fields, g.Field)
+	}
+	for _, m := range agg.Metrics {
+		fields = append(fields, m.Field)
+	}
+	for _, f := range fields {
+		if strings.Contains(f, ".") {
+			return errors.New(fmt.Sprintf("nested field is not supported by sql data sources: %s", f))
+		}
+	}
+	return nil
+}
+
+// quote quotes an identifier, which is validated to contain only letters, digits and underscores
+func (d sqlAggregateDialect) quote(name string) string {
+	switch d {
+	case constants.DataSourceTypeMysql:
+		return "`" + name + "`"
+	case constants.DataSourceTypeMssql:
+		return "[" + name + "]"
+	default:
+		return `"` + name + `"`
+	}
+}
+
+// groupByExpr returns the expression of a group-by field, formatting time buckets the same as
+// the aggregation pipeline of mongo
+func (d sqlAggregateDialect) groupByExpr(g entity.ResultGroupBy) string {
+	col := d.quote(g.Field)
+	if g.Bucket == "" {
+		return col
+	}
+	switch d {
+	case constants.DataSourceTypeMysql:
+		return fmt.Sprintf("DATE_FORMAT(%s, '%s')", col, map[string]string{
+			constants.ResultBucketHour:  "%Y-%m-%dT%H:00",
+			constants.ResultBucketDay:   "%Y-%m-%d",
+			constants.ResultBucketWeek:  "%x-W%v",
+			constants.ResultBucketMonth: "%Y-%m",
+			constants.ResultBucketYear:  "%Y",
+		}[g.Bucket])
+	case constants.DataSourceTypeMssql:
+		ts := fmt.Sprintf("TRY_CAST(%s AS datetime2)", col)
+		if g.Bucket == constants.ResultBucketWeek {
+			return fmt.Sprintf("CONCAT(FORMAT(%s, 'yyyy'), '-W', FORMAT(DATEPART(ISO_WEEK, %s), '00'))", ts, ts)
+		}
+		return fmt.Sprintf("FORMAT(%s, '%s')", ts, map[string]string{
+			constants.ResultBucketHour:  "yyyy-MM-dd''T''HH:00",
+			constants.ResultBucketDay:   "yyyy-MM-dd",
+			constants.ResultBucketMonth: "yyyy-MM",
+			constants.ResultBucketYear:  "yyyy",
+		}[g.Bucket])
+	case constants.DataSourceTypeSqlite:
+		// sqlite has no iso week, weeks start on monday
+		return fmt.Sprintf("strftime('%s', %s)", map[string]string{
+			constants.ResultBucketHour:  "%Y-%m-%dT%H:00",
+			constants.ResultBucketDay:   "%Y-%m-%d",
+			constants.ResultBucketWeek:  "%Y-W%W",
+			constants.ResultBucketMonth: "%Y-%m",
+			constants.ResultBucketYear:  "%Y",
+		}[g.Bucket], col)
+	default:
+		return fmt.Sprintf("to_char(CAST(%s AS timestamp), '%s')", col, map[string]string{
+			constants.ResultBucketHour:  `YYYY-MM-DD"T"HH24:00`,
+			constants.ResultBucketDay:   "YYYY-MM-DD",
+			constants.ResultBucketWeek:  `IYYY-"W"IW`,
+			constants.ResultBucketMonth: "YYYY-MM",
+			constants.ResultBucketYear:  "YYYY",
+		}[g.Bucket])
+	}
+}
+
+// metricExpr returns the expression of a metric. Values of sum and avg are cast to numbers, as
+// results may be stored as strings.
+func (d sqlAggregateDialect) metricExpr(m entity.ResultMetric) string {
+	if m.Op == constants.ResultMetricOpCount {
+		return "COUNT(*)"
+	}
+	col := d.quote(m.Field)
+	if m.Op == constants.ResultMetricOpSum || m.Op == constants.ResultMetricOpAvg {
+		switch d {
+		case constants.DataSourceTypePostgresql, constants.DataSourceTypeCockroachdb:
+			col = fmt.Sprintf("CAST(%s AS double precision)", col)
+		case constants.DataSourceTypeMssql:
+			col = fmt.Sprintf("TRY_CAST(%s AS float)", col)
+		}
+	}
+	return strings.ToUpper(m.Op) + "(" + col + ")"
+}
+
+func (d sqlAggregateDialect) orderByExpr(s generic.ListSort) string {
+	if s.Direction == generic.SortDirectionDesc {
+		return d.quote(s.Key) + " DESC"
+	}
+	return d.quote(s.Key) + " ASC"
+}
+
+// normalizeSqlValue converts bytes returned by some drivers to strings and integers to int64
+func normalizeSqlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// normalizeSqlNumber converts numbers returned as bytes or strings by some drivers to int64 or
+// float64, keeping other values as they are
+func normalizeSqlNumber(v interface{}) interface{} {
+	v = normalizeSqlValue(v)
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return v
+}
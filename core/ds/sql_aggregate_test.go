@@ -0,0 +1,50 @@
+package ds
+
+import (
+	"testing"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/db/generic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqlAggregateDialect(t *testing.T) {
+	_, err := newSqlAggregateDialect(constants.DataSourceTypeKafka)
+	require.NotNil(t, err)
+
+	day := entity.ResultGroupBy{Field: "ts", Bucket: constants.ResultBucketDay}
+	sum := entity.ResultMetric{Op: constants.ResultMetricOpSum, Field: "price"}
+
+	d, err := newSqlAggregateDialect(constants.DataSourceTypeMysql)
+	require.Nil(t, err)
+	require.Equal(t, "`category`", d.groupByExpr(entity.ResultGroupBy{Field: "category"}))
+	require.Equal(t, "DATE_FORMAT(`ts`, '%Y-%m-%d')", d.groupByExpr(day))
+	require.Equal(t, "SUM(`price`)", d.metricExpr(sum))
+	require.Equal(t, "COUNT(*)", d.metricExpr(entity.ResultMetric{Op: constants.ResultMetricOpCount}))
+	require.Equal(t, "`m0` DESC", d.orderByExpr(generic.ListSort{Key: "m0", Direction: generic.SortDirectionDesc}))
+
+	d, _ = newSqlAggregateDialect(constants.DataSourceTypePostgresql)
+	require.Equal(t, `to_char(CAST("ts" AS timestamp), 'YYYY-MM-DD')`, d.groupByExpr(day))
+	require.Equal(t, `SUM(CAST("price" AS double precision))`, d.metricExpr(sum))
+	require.Equal(t, `MIN("price")`, d.metricExpr(entity.ResultMetric{Op: constants.ResultMetricOpMin, Field: "price"}))
+
+	d, _ = newSqlAggregateDialect(constants.DataSourceTypeMssql)
+	require.Equal(t, "FORMAT(TRY_CAST([ts] AS datetime2), 'yyyy-MM-dd')", d.groupByExpr(day))
+	require.Equal(t, "AVG(TRY_CAST([price] AS float))", d.metricExpr(entity.ResultMetric{Op: constants.ResultMetricOpAvg, Field: "price"}))
+
+	d, _ = newSqlAggregateDialect(constants.DataSourceTypeSqlite)
+	require.Equal(t, `strftime('%Y-%m-%d', "ts")`, d.groupByExpr(day))
+
+	// nested fields
+	require.NotNil(t, d.validate(&entity.ResultAggregation{Facets: []string{"a.b"}}))
+	require.Nil(t, d.validate(&entity.ResultAggregation{Facets: []string{"a"}, Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpCount}}}))
+}
+
+func TestNormalizeSqlNumber(t *testing.T) {
+	require.Equal(t, int64(3), normalizeSqlNumber([]byte("3")))
+	require.Equal(t, 2.5, normalizeSqlNumber("2.5"))
+	require.Equal(t, int64(4), normalizeSqlNumber(4))
+	require.Equal(t, "a", normalizeSqlNumber([]byte("a")))
+	require.Nil(t, normalizeSqlNumber(nil))
+}
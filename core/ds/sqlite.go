@@ -3,6 +3,7 @@ package ds
 import (
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	utils2 "github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/trace"
@@ -50,3 +51,23 @@ func NewDataSourceSqliteService(colId primitive.ObjectID, dsId primitive.ObjectI
 
 	return svc, nil
 }
+
+// NewDataSourceSqliteServiceV2 creates a result service writing results of a data collection
+// to a Sqlite database, used as a result sink of V2 spiders
+func NewDataSourceSqliteServiceV2(ds *models2.DatabaseV2, colName string) (svc2 interfaces.ResultService, err error) {
+	// service
+	svc := &SqliteService{}
+	svc.ds = newDataSourceFromV2(ds)
+	svc.dc = &models.DataCollection{Name: colName}
+
+	// session
+	svc.s, err = utils2.GetSqliteSessionWithTimeoutV2(ds, sinkConnectTimeout)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// collection
+	svc.col = svc.s.Collection(colName)
+
+	return svc, nil
+}
@@ -2,20 +2,59 @@ package entity
 
 import (
 	"github.com/crawlab-team/crawlab/core/interfaces"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
 type Export struct {
-	Id           string            `json:"id"`
-	Type         string            `json:"type"`
-	Target       string            `json:"target"`
-	Filter       interfaces.Filter `json:"filter"`
-	Status       string            `json:"status"`
-	StartTs      time.Time         `json:"start_ts"`
-	EndTs        time.Time         `json:"end_ts"`
-	FileName     string            `json:"file_name"`
-	DownloadPath string            `json:"-"`
-	Limit        int               `json:"-"`
+	Id           string            `json:"id" bson:"_id"`
+	Type         string            `json:"type" bson:"type"`
+	Target       string            `json:"target" bson:"target"`
+	Filter       interfaces.Filter `json:"filter" bson:"-"`
+	Query        string            `json:"-" bson:"query,omitempty"` // mongo query converted from filter, in extended json
+	Columns      []ExportColumn    `json:"columns,omitempty" bson:"columns,omitempty"`
+	Gzip         bool              `json:"gzip" bson:"gzip"`
+	Status       string            `json:"status" bson:"status"`
+	Error        string            `json:"error,omitempty" bson:"error,omitempty"`
+	RowCount     int64             `json:"row_count" bson:"row_count"`
+	StartTs      time.Time         `json:"start_ts" bson:"start_ts"`
+	EndTs        time.Time         `json:"end_ts" bson:"end_ts"`
+	UpdateTs     time.Time         `json:"update_ts" bson:"update_ts"`
+	FileName     string            `json:"file_name" bson:"file_name"`
+	DownloadPath string            `json:"-" bson:"download_path"`
+	NodeKey      string            `json:"node_key" bson:"node_key"` // master writing the file, which is the only one to resume it
+
+	// checkpoint to resume from
+	LastId primitive.ObjectID `json:"-" bson:"last_id,omitempty"` // _id of last exported record
+	Offset int64              `json:"-" bson:"offset,omitempty"`  // file size after last exported record
+
+	// _ids of exported records created within the overlap window before the last one, which are
+	// collected for incremental exports and not persisted
+	Overlap   time.Duration        `json:"-" bson:"-"`
+	RecentIds []primitive.ObjectID `json:"-" bson:"-"`
+}
+
+// ExportColumn is a column of exported records, with key in the data collection and name in
+// exported file
+type ExportColumn struct {
+	Key  string `json:"key" bson:"key"`
+	Name string `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+// GetName returns the column name in exported file, which defaults to the key
+func (c ExportColumn) GetName() string {
+	if c.Name == "" {
+		return c.Key
+	}
+	return c.Name
+}
+
+type ExportOptions struct {
+	Columns    []ExportColumn       // selected columns, all columns if empty
+	Gzip       bool                 // whether to compress exported file
+	AfterId    primitive.ObjectID   // export only records with _id greater than this if not zero
+	ExcludeIds []primitive.ObjectID // records not to export, e.g. exported by the previous run
+	Overlap    time.Duration        // window before the last record whose _ids are collected
 }
 
 func (e *Export) GetId() string {
@@ -0,0 +1,21 @@
+package entity
+
+// ExportDestination is where files of scheduled exports are delivered, either a directory on the
+// master node or a bucket of an S3-compatible object store
+type ExportDestination struct {
+	Type string `json:"type" bson:"type"` // dir or s3
+
+	// directory relative to the root of directory destinations ("export.destination.root")
+	Path string `json:"path,omitempty" bson:"path,omitempty"`
+
+	// s3-compatible object store, addressed in path style
+	Endpoint  string `json:"endpoint,omitempty" bson:"endpoint,omitempty"` // e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region    string `json:"region,omitempty" bson:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty" bson:"bucket,omitempty"`
+	Prefix    string `json:"prefix,omitempty" bson:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty" bson:"access_key,omitempty"`
+
+	// secret key is only accepted on write and stored encrypted
+	SecretKey          string `json:"secret_key,omitempty" bson:"-"`
+	EncryptedSecretKey string `json:"-" bson:"encrypted_secret_key,omitempty"`
+}
@@ -0,0 +1,12 @@
+package entity
+
+import "time"
+
+// Health is the health of a master, showing which master leads
+type Health struct {
+	NodeKey        string    `json:"node_key"`        // node key of this master
+	IsLeader       bool      `json:"is_leader"`       // whether this master is the leader
+	LeaderElection bool      `json:"leader_election"` // whether leader election is enabled
+	Leader         string    `json:"leader"`          // node key of the leader
+	LeaseExpireTs  time.Time `json:"lease_expire_ts"` // expire time of the lease held by the leader
+}
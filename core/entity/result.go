@@ -34,6 +34,8 @@ func (r Result) GetTaskId() (id primitive.ObjectID) {
 			return id
 		}
 		return oid
+	case primitive.ObjectID:
+		return _tid.(primitive.ObjectID)
 	default:
 		return id
 	}
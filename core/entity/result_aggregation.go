@@ -0,0 +1,61 @@
+package entity
+
+import "github.com/crawlab-team/crawlab/db/generic"
+
+// ResultAggregation is an aggregation query over results. Results are grouped by group-by fields
+// with metrics computed for each group, which lists distinct values of group-by fields if there
+// are no metrics. Facets list top values of fields with their counts.
+type ResultAggregation struct {
+	GroupBy    []ResultGroupBy    `json:"group_by"`
+	Metrics    []ResultMetric     `json:"metrics"`
+	Sort       []generic.ListSort `json:"sort"`  // keys are names of group-by fields or metrics, first metric descending if empty
+	Limit      int                `json:"limit"` // max number of groups
+	Facets     []string           `json:"facets"`
+	FacetLimit int                `json:"facet_limit"` // max number of top values of each facet
+}
+
+// ResultGroupBy is a field to group results by, with its values truncated to time buckets if
+// bucket is set
+type ResultGroupBy struct {
+	Field  string `json:"field"`
+	Bucket string `json:"bucket"` // hour, day, week, month or year
+	Name   string `json:"name"`   // output column name, defaults to field
+}
+
+// GetName returns the output column name of the group-by field
+func (g ResultGroupBy) GetName() string {
+	if g.Name == "" {
+		return g.Field
+	}
+	return g.Name
+}
+
+// ResultMetric is an aggregate function over a field of results in each group
+type ResultMetric struct {
+	Op    string `json:"op"`    // count, sum, avg, min or max
+	Field string `json:"field"` // ignored by count
+	Name  string `json:"name"`  // output column name, defaults to op and field joined by underscore
+}
+
+// GetName returns the output column name of the metric
+func (m ResultMetric) GetName() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	if m.Field == "" {
+		return m.Op
+	}
+	return m.Op + "_" + m.Field
+}
+
+// ResultAggregationResult is the result of an aggregation query
+type ResultAggregationResult struct {
+	Rows   []map[string]interface{}      `json:"rows"`
+	Facets map[string][]ResultFacetValue `json:"facets,omitempty"`
+}
+
+// ResultFacetValue is a value of a facet with the number of results having it
+type ResultFacetValue struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
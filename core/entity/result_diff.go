@@ -0,0 +1,16 @@
+package entity
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// ResultFieldDiff is a changed field of a result record
+type ResultFieldDiff struct {
+	Field string      `json:"field" bson:"field"`
+	Old   interface{} `json:"old" bson:"old"`
+	New   interface{} `json:"new" bson:"new"`
+}
+
+// ResultChange is a result record matched in both tasks with changed fields
+type ResultChange struct {
+	Key    bson.M            `json:"key" bson:"key"` // values of key fields
+	Fields []ResultFieldDiff `json:"fields" bson:"fields"`
+}
@@ -0,0 +1,26 @@
+package entity
+
+// ResultTransform is a transform of a field of result records applied on ingestion, before the
+// records are validated and persisted
+type ResultTransform struct {
+	Type       string `json:"type" bson:"type"`                                 // rename, drop, regex, trim, url or compute
+	Field      string `json:"field" bson:"field"`                               // source field, all string fields for trim if empty
+	Target     string `json:"target,omitempty" bson:"target,omitempty"`         // target field, same as source field if empty (required by rename and compute)
+	Pattern    string `json:"pattern,omitempty" bson:"pattern,omitempty"`       // regular expression of regex
+	Group      int    `json:"group,omitempty" bson:"group,omitempty"`           // capture group extracted by regex, the first one if zero and any exists
+	Expression string `json:"expression,omitempty" bson:"expression,omitempty"` // js expression of compute, with the record as variable "record"
+}
+
+// GetTarget returns the target field of the transform
+func (t ResultTransform) GetTarget() string {
+	if t.Target == "" {
+		return t.Field
+	}
+	return t.Target
+}
+
+// ResultTransformTest is the result of running result transforms against a sample record
+type ResultTransformTest struct {
+	Record map[string]interface{} `json:"record"`
+	Errors []string               `json:"errors"`
+}
@@ -0,0 +1,23 @@
+package entity
+
+// RetentionPolicy limits how long and how many results of a data collection are kept. Age of
+// results is the creation time of their _id.
+type RetentionPolicy struct {
+	Enabled    bool             `json:"enabled" bson:"enabled"`
+	MaxAgeDays int              `json:"max_age_days" bson:"max_age_days"` // results older than this are deleted, no limit if zero
+	MaxCount   int64            `json:"max_count" bson:"max_count"`       // results beyond the newest this many are deleted, no limit if zero
+	Archive    RetentionArchive `json:"archive" bson:"archive"`
+}
+
+// IsEmpty returns whether the retention policy does not limit results
+func (p RetentionPolicy) IsEmpty() bool {
+	return !p.Enabled || (p.MaxAgeDays <= 0 && p.MaxCount <= 0)
+}
+
+// RetentionArchive exports results to a file delivered to a destination before they are deleted
+type RetentionArchive struct {
+	Enabled     bool              `json:"enabled" bson:"enabled"`
+	ExportType  string            `json:"export_type" bson:"export_type"` // csv if empty
+	Gzip        bool              `json:"gzip" bson:"gzip"`
+	Destination ExportDestination `json:"destination" bson:"destination"` // file is kept in export directory if type is empty
+}
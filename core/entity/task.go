@@ -2,7 +2,10 @@ package entity
 
 import (
 	"encoding/json"
+	"github.com/crawlab-team/crawlab/core/constants"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"slices"
+	"time"
 )
 
 type TaskMessage struct {
@@ -23,8 +26,92 @@ func (m *TaskMessage) ToString() (string, error) {
 type TaskRunOptions struct {
 }
 
+// TaskLimits are resource limits of a task process. Zero values mean unlimited.
+type TaskLimits struct {
+	Timeout     int     `json:"timeout" bson:"timeout"`           // max runtime in seconds
+	MemoryLimit int64   `json:"memory_limit" bson:"memory_limit"` // memory ceiling in MB
+	CpuLimit    float64 `json:"cpu_limit" bson:"cpu_limit"`       // cpu quota in cores, e.g. 0.5
+}
+
+// WithDefaults returns limits with unset fields taken from defaults
+func (l TaskLimits) WithDefaults(defaults TaskLimits) (res TaskLimits) {
+	res = l
+	if res.Timeout == 0 {
+		res.Timeout = defaults.Timeout
+	}
+	if res.MemoryLimit == 0 {
+		res.MemoryLimit = defaults.MemoryLimit
+	}
+	if res.CpuLimit == 0 {
+		res.CpuLimit = defaults.CpuLimit
+	}
+	return res
+}
+
+// TaskRetryPolicy is the policy of retrying failed tasks
+type TaskRetryPolicy struct {
+	MaxAttempts int      `json:"max_attempts" bson:"max_attempts"` // max attempts including the first run, 0 or 1 means no retry
+	Backoff     string   `json:"backoff" bson:"backoff"`           // fixed (default) or exponential
+	Interval    int      `json:"interval" bson:"interval"`         // (initial) interval between attempts in seconds
+	MaxInterval int      `json:"max_interval" bson:"max_interval"` // max interval of exponential backoff in seconds
+	Statuses    []string `json:"statuses" bson:"statuses"`         // retryable task statuses, defaults to error, abnormal and timeout
+	ExitCodes   []int    `json:"exit_codes" bson:"exit_codes"`     // retryable exit codes of errored tasks, empty means any
+}
+
+// DefaultTaskRetryStatuses are retryable task statuses if not specified in retry policy
+var DefaultTaskRetryStatuses = []string{
+	constants.TaskStatusError,
+	constants.TaskStatusAbnormal,
+	constants.TaskStatusTimeout,
+}
+
+// IsRetryable returns whether a task of the given attempt (starting from 1) ending with the
+// given status and exit code (nil if the task process has not exited) should be retried
+func (p TaskRetryPolicy) IsRetryable(attempt int, status string, exitCode *int) (ok bool) {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	statuses := p.Statuses
+	if len(statuses) == 0 {
+		statuses = DefaultTaskRetryStatuses
+	}
+	if !slices.Contains(statuses, status) {
+		return false
+	}
+	if status == constants.TaskStatusError && len(p.ExitCodes) > 0 {
+		return exitCode != nil && slices.Contains(p.ExitCodes, *exitCode)
+	}
+	return true
+}
+
+// GetDelay returns the delay before starting the next attempt after the given attempt failed
+func (p TaskRetryPolicy) GetDelay(attempt int) (delay time.Duration) {
+	delay = time.Duration(p.Interval) * time.Second
+	if p.Backoff != constants.TaskRetryBackoffExponential {
+		return delay
+	}
+	maxDelay := time.Duration(p.MaxInterval) * time.Second
+	for i := 1; i < attempt && (maxDelay == 0 || delay < maxDelay); i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
 type StreamMessageTaskData struct {
-	TaskId  primitive.ObjectID `json:"task_id"`
-	Records []Result           `json:"data"`
-	Logs    []string           `json:"logs"`
+	TaskId     primitive.ObjectID `json:"task_id"`
+	Records    []Result           `json:"data"`
+	Logs       []string           `json:"logs"`
+	LogEntries []TaskLogEntry     `json:"log_entries,omitempty"`
+}
+
+// TaskLogEntry is a log line captured by a task runner
+type TaskLogEntry struct {
+	Seq    int64     `json:"seq"`             // monotonic sequence number within the task
+	Stream string    `json:"stream"`          // stdout or stderr
+	Level  string    `json:"level,omitempty"` // detected log level
+	Ts     time.Time `json:"ts"`              // capture timestamp
+	Msg    string    `json:"msg"`
 }
@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskRetryPolicy_IsRetryable(t *testing.T) {
+	exitCode := func(n int) *int { return &n }
+
+	p := TaskRetryPolicy{MaxAttempts: 3}
+	assert.True(t, p.IsRetryable(1, constants.TaskStatusError, exitCode(1)))
+	assert.True(t, p.IsRetryable(2, constants.TaskStatusTimeout, exitCode(-1)))
+	assert.True(t, p.IsRetryable(1, constants.TaskStatusError, nil))
+	assert.False(t, p.IsRetryable(3, constants.TaskStatusError, exitCode(1)))
+	assert.False(t, p.IsRetryable(1, constants.TaskStatusCancelled, exitCode(-1)))
+	assert.False(t, p.IsRetryable(1, constants.TaskStatusFinished, exitCode(0)))
+
+	p = TaskRetryPolicy{MaxAttempts: 3, Statuses: []string{constants.TaskStatusError}, ExitCodes: []int{0, 2}}
+	assert.True(t, p.IsRetryable(1, constants.TaskStatusError, exitCode(2)))
+	assert.True(t, p.IsRetryable(1, constants.TaskStatusError, exitCode(0)))
+	assert.False(t, p.IsRetryable(1, constants.TaskStatusError, exitCode(1)))
+	assert.False(t, p.IsRetryable(1, constants.TaskStatusError, nil)) // e.g. failed to start
+	assert.False(t, p.IsRetryable(1, constants.TaskStatusTimeout, exitCode(-1)))
+
+	assert.False(t, TaskRetryPolicy{}.IsRetryable(1, constants.TaskStatusError, exitCode(1)))
+}
+
+func TestTaskRetryPolicy_GetDelay(t *testing.T) {
+	p := TaskRetryPolicy{Interval: 10}
+	assert.Equal(t, 10*time.Second, p.GetDelay(1))
+	assert.Equal(t, 10*time.Second, p.GetDelay(3))
+
+	p = TaskRetryPolicy{Backoff: constants.TaskRetryBackoffExponential, Interval: 10, MaxInterval: 60}
+	assert.Equal(t, 10*time.Second, p.GetDelay(1))
+	assert.Equal(t, 20*time.Second, p.GetDelay(2))
+	assert.Equal(t, 40*time.Second, p.GetDelay(3))
+	assert.Equal(t, 60*time.Second, p.GetDelay(4))
+	assert.Equal(t, 60*time.Second, p.GetDelay(100))
+}
+
+func TestTaskLimits_WithDefaults(t *testing.T) {
+	l := TaskLimits{Timeout: 60}.WithDefaults(TaskLimits{Timeout: 3600, MemoryLimit: 512})
+	assert.Equal(t, TaskLimits{Timeout: 60, MemoryLimit: 512}, l)
+}
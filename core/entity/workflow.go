@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// WorkflowStep is a step of a workflow running a spider
+type WorkflowStep struct {
+	Key         string               `json:"key" bson:"key"` // unique key of the step in the workflow
+	Name        string               `json:"name" bson:"name"`
+	SpiderId    primitive.ObjectID   `json:"spider_id" bson:"spider_id"`
+	Mode        string               `json:"mode" bson:"mode"`
+	NodeIds     []primitive.ObjectID `json:"node_ids" bson:"node_ids"`
+	Cmd         string               `json:"cmd" bson:"cmd"`
+	Param       string               `json:"param" bson:"param"`
+	Priority    int                  `json:"priority" bson:"priority"`
+	RetryPolicy TaskRetryPolicy      `json:"retry_policy" bson:"retry_policy"` // defaults to the policy of the spider
+}
+
+// WorkflowEdge is a dependency of a workflow step on an upstream step. A step runs once all of
+// its upstream steps have ended and the conditions of all its edges are met, or it is skipped.
+type WorkflowEdge struct {
+	From      string `json:"from" bson:"from"`           // key of the upstream step
+	To        string `json:"to" bson:"to"`               // key of the downstream step
+	Condition string `json:"condition" bson:"condition"` // success (default), failure or always
+}
+
+// WorkflowRunStep is the state of a step in a workflow run
+type WorkflowRunStep struct {
+	WorkflowStep `bson:",inline"`
+	Status       string               `json:"status" bson:"status"`
+	TaskId       primitive.ObjectID   `json:"task_id" bson:"task_id"`   // task of the step, or its last attempt if retried by retry policy
+	TaskIds      []primitive.ObjectID `json:"task_ids" bson:"task_ids"` // tasks created for the step, one per manual retry
+	Error        string               `json:"error" bson:"error"`
+	StartTs      time.Time            `json:"start_ts" bson:"start_ts"`
+	EndTs        time.Time            `json:"end_ts" bson:"end_ts"`
+}
@@ -7,3 +7,4 @@ func NewHttpError(msg string) (err error) {
 var ErrorHttpBadRequest = NewHttpError("bad request")
 var ErrorHttpUnauthorized = NewHttpError("unauthorized")
 var ErrorHttpNotFound = NewHttpError("not found")
+var ErrorHttpMethodNotAllowed = NewHttpError("method not allowed")
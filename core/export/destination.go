@@ -0,0 +1,155 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/spf13/viper"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// s3DefaultRegion is the region of s3 destinations without region, which is also accepted by
+// MinIO, so that the client does not look up bucket locations
+const s3DefaultRegion = "us-east-1"
+
+// Deliver copies an exported file to a destination, and returns the location of the delivered file
+func Deliver(dest entity.ExportDestination, filePath string) (location string, err error) {
+	switch dest.Type {
+	case constants.ExportDestinationTypeDir:
+		return deliverToDir(dest, filePath)
+	case constants.ExportDestinationTypeS3:
+		return deliverToS3(dest, filePath)
+	default:
+		return "", errors.New(fmt.Sprintf("invalid export destination type: %s", dest.Type))
+	}
+}
+
+// GetDestinationRoot returns the root of directory destinations, which is configured by
+// "export.destination.root" and defaults to <tempDir>/export/destinations
+func GetDestinationRoot() (root string) {
+	root = viper.GetString("export.destination.root")
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "export", "destinations")
+	}
+	return root
+}
+
+// GetDestinationDir returns the directory of a directory destination, whose path must be relative
+// to and inside the root of directory destinations
+func GetDestinationDir(destPath string) (dirPath string, err error) {
+	destPath = filepath.Clean(filepath.FromSlash(destPath))
+	if destPath == "." || filepath.IsAbs(destPath) || destPath == ".." || strings.HasPrefix(destPath, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("invalid export destination path: %s", destPath))
+	}
+	return filepath.Join(GetDestinationRoot(), destPath), nil
+}
+
+// EncryptDestinationSecret encrypts the secret key of a destination written by users, which is
+// only accepted on write and never returned. The encrypted secret key of the existing destination
+// is kept if no secret key is given and the access key is unchanged.
+func EncryptDestinationSecret(dest *entity.ExportDestination, existing entity.ExportDestination) (err error) {
+	if dest.SecretKey == "" {
+		if dest.AccessKey == existing.AccessKey {
+			dest.EncryptedSecretKey = existing.EncryptedSecretKey
+		}
+		return nil
+	}
+	dest.EncryptedSecretKey, err = utils.EncryptAES(dest.SecretKey)
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	dest.SecretKey = ""
+	return nil
+}
+
+func deliverToDir(dest entity.ExportDestination, filePath string) (location string, err error) {
+	if dest.Path == "" {
+		return "", errors.New("empty export destination path")
+	}
+	dirPath, err := GetDestinationDir(dest.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return "", trace.TraceError(err)
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", trace.TraceError(err)
+	}
+	defer src.Close()
+
+	// write to a temporary file first, so that consumers of the directory never see a
+	// partially delivered file
+	location = filepath.Join(dirPath, filepath.Base(filePath))
+	tmpPath := location + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return "", trace.TraceError(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return "", trace.TraceError(err)
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", trace.TraceError(err)
+	}
+	if err := os.Rename(tmpPath, location); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", trace.TraceError(err)
+	}
+	return location, nil
+}
+
+func deliverToS3(dest entity.ExportDestination, filePath string) (location string, err error) {
+	if dest.Endpoint == "" || dest.Bucket == "" {
+		return "", errors.New("empty export destination endpoint or bucket")
+	}
+	endpoint, err := url.Parse(dest.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return "", errors.New(fmt.Sprintf("invalid export destination endpoint: %s", dest.Endpoint))
+	}
+	// secret key is given in plain text by the global retention policy in settings
+	secretKey := dest.SecretKey
+	if secretKey == "" && dest.EncryptedSecretKey != "" {
+		secretKey, err = utils.DecryptAES(dest.EncryptedSecretKey)
+		if err != nil {
+			return "", trace.TraceError(err)
+		}
+	}
+	region := dest.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	c, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(dest.AccessKey, secretKey, ""),
+		Secure:       endpoint.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return "", trace.TraceError(err)
+	}
+	key := dest.Prefix + filepath.Base(filePath)
+	if _, err := c.FPutObject(context.Background(), dest.Bucket, key, filePath, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	}); err != nil {
+		return "", trace.TraceError(err)
+	}
+	objectUrl := *c.EndpointURL()
+	objectUrl.Path = "/" + dest.Bucket + "/" + key
+	return objectUrl.String(), nil
+}
@@ -0,0 +1,149 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeTestExportFile(t *testing.T, content string) string {
+	filePath := filepath.Join(t.TempDir(), "test_export.csv")
+	require.Nil(t, os.WriteFile(filePath, []byte(content), 0644))
+	return filePath
+}
+
+func TestDeliver_Dir(t *testing.T) {
+	root := t.TempDir()
+	viper.Set("export.destination.root", root)
+	defer viper.Set("export.destination.root", nil)
+
+	filePath := writeTestExportFile(t, "a,b\n1,2\n")
+	dest := entity.ExportDestination{
+		Type: constants.ExportDestinationTypeDir,
+		Path: "exports/daily",
+	}
+
+	location, err := Deliver(dest, filePath)
+	require.Nil(t, err)
+	require.Equal(t, filepath.Join(root, "exports", "daily", "test_export.csv"), location)
+	data, err := os.ReadFile(location)
+	require.Nil(t, err)
+	require.Equal(t, "a,b\n1,2\n", string(data))
+	_, err = os.Stat(location + ".tmp")
+	require.True(t, os.IsNotExist(err))
+
+	// paths outside root
+	for _, p := range []string{"/tmp/exports", "../exports", "exports/../..", "."} {
+		dest.Path = p
+		_, err = Deliver(dest, filePath)
+		require.NotNil(t, err, p)
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(root), "exports"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestEncryptDestinationSecret(t *testing.T) {
+	dest := entity.ExportDestination{
+		Type:      constants.ExportDestinationTypeS3,
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+	}
+	require.Nil(t, EncryptDestinationSecret(&dest, entity.ExportDestination{}))
+	require.Empty(t, dest.SecretKey)
+	require.NotEmpty(t, dest.EncryptedSecretKey)
+	require.NotContains(t, dest.EncryptedSecretKey, "minioadmin")
+
+	// secret key is never returned
+	data, err := json.Marshal(dest)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "secret")
+
+	// existing secret key is kept if not given
+	update := entity.ExportDestination{Type: dest.Type, AccessKey: dest.AccessKey}
+	require.Nil(t, EncryptDestinationSecret(&update, dest))
+	require.Equal(t, dest.EncryptedSecretKey, update.EncryptedSecretKey)
+
+	// but not for another access key
+	update = entity.ExportDestination{Type: dest.Type, AccessKey: "other"}
+	require.Nil(t, EncryptDestinationSecret(&update, dest))
+	require.Empty(t, update.EncryptedSecretKey)
+}
+
+// decodeTestAwsChunked decodes a payload uploaded with streaming signature, whose chunks are
+// "<hex size>;chunk-signature=<signature>\r\n<data>\r\n" ending with a chunk of size 0
+func decodeTestAwsChunked(t *testing.T, body []byte) (data []byte) {
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		require.True(t, ok)
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		require.Nil(t, err)
+		if size == 0 {
+			return data
+		}
+		data = append(data, rest[:size]...)
+		body = rest[size+2:]
+	}
+}
+
+func TestDeliver_S3(t *testing.T) {
+	// minio-style stand-in storing objects by path
+	var mu sync.Mutex
+	objects := map[string]string{}
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+			body = decodeTestAwsChunked(t, body)
+		}
+		w.Header().Set("ETag", `"test"`)
+		mu.Lock()
+		defer mu.Unlock()
+		objects[r.URL.Path] = string(body)
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	filePath := writeTestExportFile(t, "a,b\n1,2\n")
+	dest := entity.ExportDestination{
+		Type:      constants.ExportDestinationTypeS3,
+		Endpoint:  server.URL + "/",
+		Bucket:    "crawlab",
+		Prefix:    "daily/",
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+	}
+	require.Nil(t, EncryptDestinationSecret(&dest, entity.ExportDestination{}))
+
+	location, err := Deliver(dest, filePath)
+	require.Nil(t, err)
+	require.Equal(t, server.URL+"/crawlab/daily/test_export.csv", location)
+	require.Equal(t, "a,b\n1,2\n", objects["/crawlab/daily/test_export.csv"])
+	require.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=minioadmin/"))
+	require.Contains(t, authorization, "/us-east-1/s3/aws4_request,")
+
+	// endpoint without scheme
+	_, err = Deliver(entity.ExportDestination{Type: dest.Type, Endpoint: "minio:9000", Bucket: dest.Bucket}, filePath)
+	require.NotNil(t, err)
+
+	// upload error
+	dest.Bucket = ""
+	_, err = Deliver(dest, filePath)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,560 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"math"
+	"time"
+)
+
+// parquet physical types, converted types, encodings and codecs
+const (
+	parquetTypeBoolean   int32 = 0
+	parquetTypeInt64     int32 = 2
+	parquetTypeDouble    int32 = 5
+	parquetTypeByteArray int32 = 6
+
+	parquetConvertedTypeUtf8            int32 = 0
+	parquetConvertedTypeTimestampMillis int32 = 9
+
+	parquetRepetitionOptional int32 = 1
+
+	parquetEncodingPlain int32 = 0
+	parquetEncodingRle   int32 = 3
+
+	parquetCodecUncompressed int32 = 0
+	parquetCodecGzip         int32 = 2
+
+	parquetPageTypeData int32 = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetRowGroupSize is the number of rows buffered in memory and written as a row group
+const parquetRowGroupSize = 10000
+
+type parquetColumn struct {
+	name          string
+	typ           int32
+	convertedType int32 // -1 if none
+}
+
+// typeName returns the name of the column type
+func (col parquetColumn) typeName() string {
+	switch {
+	case col.typ == parquetTypeBoolean:
+		return "boolean"
+	case col.convertedType == parquetConvertedTypeTimestampMillis:
+		return "timestamp"
+	case col.typ == parquetTypeInt64:
+		return "integer"
+	case col.typ == parquetTypeDouble:
+		return "double"
+	default:
+		return "string"
+	}
+}
+
+type parquetColumnChunk struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type parquetRowGroup struct {
+	numRows int64
+	chunks  []parquetColumnChunk
+}
+
+// parquetRowWriter writes records to an Apache Parquet file. Records are buffered and
+// written as row groups of flat optional columns with plain encoding, one data page per column
+// chunk. Column types are inferred from the first row group, and an error is returned for values
+// in later row groups not convertible to the column type.
+type parquetRowWriter struct {
+	w         io.Writer
+	columns   []entity.ExportColumn
+	codec     int32
+	offset    int64
+	schema    []parquetColumn
+	rows      []bson.M
+	rowGroups []parquetRowGroup
+}
+
+func (rw *parquetRowWriter) WriteRow(data bson.M) (err error) {
+	rw.rows = append(rw.rows, data)
+	if len(rw.rows) >= parquetRowGroupSize {
+		return rw.Flush()
+	}
+	return nil
+}
+
+// Flush writes buffered records as a row group
+func (rw *parquetRowWriter) Flush() (err error) {
+	if rw.schema == nil {
+		rw.schema = rw.inferSchema()
+	}
+	if len(rw.rows) == 0 {
+		return nil
+	}
+
+	// check values before writing, so that no partial row group is written
+	if err := rw.checkValues(); err != nil {
+		return err
+	}
+
+	rg := parquetRowGroup{numRows: int64(len(rw.rows))}
+	for i, c := range rw.columns {
+		chunk, err := rw.writeColumnChunk(rw.schema[i], c.Key)
+		if err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, chunk)
+	}
+	rw.rowGroups = append(rw.rowGroups, rg)
+	rw.rows = nil
+	return nil
+}
+
+// Close writes buffered records and the file footer
+func (rw *parquetRowWriter) Close() (err error) {
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	footer := rw.encodeFileMetaData()
+	if err := rw.write(footer); err != nil {
+		return err
+	}
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(footer)))
+	if err := rw.write(size); err != nil {
+		return err
+	}
+	return rw.write(parquetMagic)
+}
+
+func (rw *parquetRowWriter) write(data []byte) (err error) {
+	n, err := rw.w.Write(data)
+	rw.offset += int64(n)
+	return err
+}
+
+// inferSchema infers column types from buffered records
+func (rw *parquetRowWriter) inferSchema() (schema []parquetColumn) {
+	for _, c := range rw.columns {
+		col := parquetColumn{name: c.GetName(), typ: -1, convertedType: -1}
+		for _, row := range rw.rows {
+			typ, convertedType := getParquetType(row[c.Key])
+			if typ < 0 {
+				continue
+			}
+			if col.typ < 0 {
+				col.typ, col.convertedType = typ, convertedType
+				continue
+			}
+			if col.typ == typ && col.convertedType == convertedType {
+				continue
+			}
+			if (col.typ == parquetTypeInt64 || col.typ == parquetTypeDouble) && col.convertedType < 0 &&
+				(typ == parquetTypeInt64 || typ == parquetTypeDouble) && convertedType < 0 {
+				// mixed integers and floats
+				col.typ = parquetTypeDouble
+				continue
+			}
+			// mixed types
+			col.typ, col.convertedType = parquetTypeByteArray, parquetConvertedTypeUtf8
+			break
+		}
+		if col.typ < 0 {
+			// no values
+			col.typ, col.convertedType = parquetTypeByteArray, parquetConvertedTypeUtf8
+		}
+		schema = append(schema, col)
+	}
+	return schema
+}
+
+// getParquetType returns physical and converted type of a value, or -1 for null
+func getParquetType(v interface{}) (typ int32, convertedType int32) {
+	switch v.(type) {
+	case nil:
+		return -1, -1
+	case bool:
+		return parquetTypeBoolean, -1
+	case int, int32, int64:
+		return parquetTypeInt64, -1
+	case float32, float64:
+		return parquetTypeDouble, -1
+	case time.Time, primitive.DateTime:
+		return parquetTypeInt64, parquetConvertedTypeTimestampMillis
+	default:
+		return parquetTypeByteArray, parquetConvertedTypeUtf8
+	}
+}
+
+// checkValues returns an error if a value of buffered records does not match its column type
+func (rw *parquetRowWriter) checkValues() (err error) {
+	var offset int64
+	for _, rg := range rw.rowGroups {
+		offset += rg.numRows
+	}
+	for i, c := range rw.columns {
+		for j, row := range rw.rows {
+			if _, err := convertParquetValue(rw.schema[i], row[c.Key]); err != nil {
+				return errors.New(fmt.Sprintf("row %d: %v", offset+int64(j)+1, err))
+			}
+		}
+	}
+	return nil
+}
+
+// writeColumnChunk writes values of a column of buffered records as a column chunk
+func (rw *parquetRowWriter) writeColumnChunk(col parquetColumn, key string) (chunk parquetColumnChunk, err error) {
+	// definition levels and plain encoded values
+	levels := make([]bool, len(rw.rows))
+	var values bytes.Buffer
+	var bits []bool
+	for i, row := range rw.rows {
+		v, err := convertParquetValue(col, row[key])
+		if err != nil {
+			return chunk, err
+		}
+		if v == nil {
+			continue
+		}
+		levels[i] = true
+		switch col.typ {
+		case parquetTypeBoolean:
+			bits = append(bits, v.(bool))
+		case parquetTypeInt64:
+			_ = binary.Write(&values, binary.LittleEndian, v.(int64))
+		case parquetTypeDouble:
+			_ = binary.Write(&values, binary.LittleEndian, v.(float64))
+		default:
+			s := v.(string)
+			_ = binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+			values.WriteString(s)
+		}
+	}
+	if col.typ == parquetTypeBoolean {
+		values.Write(packBits(bits))
+	}
+
+	// page data
+	var page bytes.Buffer
+	encodedLevels := encodeParquetLevels(levels)
+	_ = binary.Write(&page, binary.LittleEndian, uint32(len(encodedLevels)))
+	page.Write(encodedLevels)
+	page.Write(values.Bytes())
+	uncompressedSize := page.Len()
+	data := page.Bytes()
+	if rw.codec == parquetCodecGzip {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, _ = gw.Write(data)
+		if err := gw.Close(); err != nil {
+			return chunk, err
+		}
+		data = buf.Bytes()
+	}
+
+	// page header
+	t := newThriftWriter()
+	t.structBegin()
+	t.i32Field(1, parquetPageTypeData)
+	t.i32Field(2, int32(uncompressedSize))
+	t.i32Field(3, int32(len(data)))
+	t.structField(5)
+	t.i32Field(1, int32(len(rw.rows)))
+	t.i32Field(2, parquetEncodingPlain)
+	t.i32Field(3, parquetEncodingRle)
+	t.i32Field(4, parquetEncodingRle)
+	t.structEnd()
+	t.structEnd()
+	header := t.bytes()
+
+	chunk = parquetColumnChunk{
+		offset:           rw.offset,
+		numValues:        int64(len(rw.rows)),
+		uncompressedSize: int64(len(header) + uncompressedSize),
+		compressedSize:   int64(len(header) + len(data)),
+	}
+	if err := rw.write(header); err != nil {
+		return chunk, err
+	}
+	if err := rw.write(data); err != nil {
+		return chunk, err
+	}
+	return chunk, nil
+}
+
+// convertParquetValue converts a value to the go type of a column, returns nil if null, or an
+// error if the value does not match the column type
+func convertParquetValue(col parquetColumn, v interface{}) (res interface{}, err error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch col.typ {
+	case parquetTypeBoolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case parquetTypeInt64:
+		if col.convertedType == parquetConvertedTypeTimestampMillis {
+			switch v := v.(type) {
+			case time.Time:
+				return v.UnixMilli(), nil
+			case primitive.DateTime:
+				return int64(v), nil
+			}
+			break
+		}
+		switch v := v.(type) {
+		case int:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case float32:
+			if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+				return int64(f), nil
+			}
+		case float64:
+			// integral floats, e.g. numbers of json documents
+			if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+				return int64(v), nil
+			}
+		}
+	case parquetTypeDouble:
+		switch v := v.(type) {
+		case int:
+			return float64(v), nil
+		case int32:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case float32:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	default:
+		return formatCell(v), nil
+	}
+	return nil, errors.New(fmt.Sprintf("value %v of column \"%s\" does not match column type %s inferred from the first %d rows", v, col.name, col.typeName(), parquetRowGroupSize))
+}
+
+// encodeParquetLevels encodes definition levels of bit width 1 with run-length encoding
+func encodeParquetLevels(levels []bool) (data []byte) {
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		data = binary.AppendUvarint(data, uint64(j-i)<<1)
+		if levels[i] {
+			data = append(data, 1)
+		} else {
+			data = append(data, 0)
+		}
+		i = j
+	}
+	return data
+}
+
+// packBits packs booleans into bytes, least significant bit first
+func packBits(bits []bool) (data []byte) {
+	data = make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	return data
+}
+
+// encodeFileMetaData encodes the file footer
+func (rw *parquetRowWriter) encodeFileMetaData() (data []byte) {
+	var numRows int64
+	for _, rg := range rw.rowGroups {
+		numRows += rg.numRows
+	}
+
+	t := newThriftWriter()
+	t.structBegin()
+
+	// version
+	t.i32Field(1, 1)
+
+	// schema
+	t.listField(2, thriftTypeStruct, len(rw.schema)+1)
+	t.structBegin()
+	t.binaryField(4, "schema")
+	t.i32Field(5, int32(len(rw.schema)))
+	t.structEnd()
+	for _, col := range rw.schema {
+		t.structBegin()
+		t.i32Field(1, col.typ)
+		t.i32Field(3, parquetRepetitionOptional)
+		t.binaryField(4, col.name)
+		if col.convertedType >= 0 {
+			t.i32Field(6, col.convertedType)
+		}
+		t.structEnd()
+	}
+
+	// num rows
+	t.i64Field(3, numRows)
+
+	// row groups
+	t.listField(4, thriftTypeStruct, len(rw.rowGroups))
+	for _, rg := range rw.rowGroups {
+		var totalSize int64
+		t.structBegin()
+		t.listField(1, thriftTypeStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			col := rw.schema[i]
+			totalSize += chunk.uncompressedSize
+			t.structBegin()
+			t.i64Field(2, chunk.offset)
+			t.structField(3)
+			t.i32Field(1, col.typ)
+			t.listField(2, thriftTypeI32, 2)
+			t.i32(parquetEncodingPlain)
+			t.i32(parquetEncodingRle)
+			t.listField(3, thriftTypeBinary, 1)
+			t.binary(col.name)
+			t.i32Field(4, rw.codec)
+			t.i64Field(5, chunk.numValues)
+			t.i64Field(6, chunk.uncompressedSize)
+			t.i64Field(7, chunk.compressedSize)
+			t.i64Field(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64Field(2, totalSize)
+		t.i64Field(3, rg.numRows)
+		t.structEnd()
+	}
+
+	// created by
+	t.binaryField(6, "crawlab")
+
+	t.structEnd()
+	return t.bytes()
+}
+
+func newParquetRowWriter(w io.Writer, export *entity.Export) (rw rowWriter, err error) {
+	codec := parquetCodecUncompressed
+	if export.Gzip {
+		codec = parquetCodecGzip
+	}
+	_rw := &parquetRowWriter{
+		w:       w,
+		columns: export.Columns,
+		codec:   codec,
+	}
+	if err := _rw.write(parquetMagic); err != nil {
+		return nil, err
+	}
+	return _rw, nil
+}
+
+// thrift compact protocol types
+const (
+	thriftTypeI32    byte = 5
+	thriftTypeI64    byte = 6
+	thriftTypeBinary byte = 8
+	thriftTypeList   byte = 9
+	thriftTypeStruct byte = 12
+)
+
+// thriftWriter encodes structs with the thrift compact protocol used by parquet metadata
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastIds []int16 // last field id of each nested struct
+}
+
+func (t *thriftWriter) bytes() []byte {
+	return t.buf.Bytes()
+}
+
+func (t *thriftWriter) structBegin() {
+	t.lastIds = append(t.lastIds, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.lastIds = t.lastIds[:len(t.lastIds)-1]
+}
+
+// structField writes the header of a struct field and begins the struct
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftTypeStruct)
+	t.structBegin()
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := t.lastIds[len(t.lastIds)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(uint64(zigzag(int64(id))))
+	}
+	t.lastIds[len(t.lastIds)-1] = id
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftTypeI32)
+	t.i32(v)
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftTypeI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binaryField(id int16, s string) {
+	t.fieldHeader(id, thriftTypeBinary)
+	t.binary(s)
+}
+
+func (t *thriftWriter) listField(id int16, elemType byte, n int) {
+	t.fieldHeader(id, thriftTypeList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xF0 | elemType)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) i32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func newThriftWriter() (t *thriftWriter) {
+	return &thriftWriter{}
+}
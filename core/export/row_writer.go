@@ -0,0 +1,213 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"strconv"
+	"time"
+)
+
+// rowWriter writes exported records to a file of an export format
+type rowWriter interface {
+	// WriteRow writes a record
+	WriteRow(data bson.M) (err error)
+	// Flush writes buffered records to the underlying writer
+	Flush() (err error)
+	// Close writes buffered records and trailing data of the file
+	Close() (err error)
+}
+
+// newRowWriterFn creates a row writer of an export, which is resumed if rows have already been
+// written (export.RowCount > 0)
+type newRowWriterFn func(w io.Writer, export *entity.Export) (rw rowWriter, err error)
+
+type csvRowWriter struct {
+	w       *csv.Writer
+	columns []entity.ExportColumn
+}
+
+func (rw *csvRowWriter) WriteRow(data bson.M) (err error) {
+	var cells []string
+	for _, c := range rw.columns {
+		v, ok := data[c.Key]
+		if !ok {
+			cells = append(cells, "")
+			continue
+		}
+		cells = append(cells, formatCell(v))
+	}
+	return rw.w.Write(cells)
+}
+
+func (rw *csvRowWriter) Flush() (err error) {
+	rw.w.Flush()
+	return rw.w.Error()
+}
+
+func (rw *csvRowWriter) Close() (err error) {
+	return rw.Flush()
+}
+
+func newCsvRowWriter(w io.Writer, export *entity.Export) (rw rowWriter, err error) {
+	csvWriter := csv.NewWriter(w)
+	if export.RowCount == 0 {
+		// write bom
+		if _, err := w.Write([]byte{0xEF, 0xBB, 0xBF}); err != nil {
+			return nil, err
+		}
+
+		// write header row
+		var header []string
+		for _, c := range export.Columns {
+			header = append(header, c.GetName())
+		}
+		if err := csvWriter.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	return &csvRowWriter{w: csvWriter, columns: export.Columns}, nil
+}
+
+// jsonRowWriter writes records as a json array, or as newline-delimited json if ndjson
+type jsonRowWriter struct {
+	w       io.Writer
+	enc     *json.Encoder
+	columns []entity.ExportColumn
+	ndjson  bool
+	rows    int64
+}
+
+func (rw *jsonRowWriter) WriteRow(data bson.M) (err error) {
+	if !rw.ndjson && rw.rows > 0 {
+		if _, err := rw.w.Write([]byte{','}); err != nil {
+			return err
+		}
+	}
+	rw.rows++
+	return rw.enc.Encode(projectRow(data, rw.columns))
+}
+
+func (rw *jsonRowWriter) Flush() (err error) {
+	return nil
+}
+
+func (rw *jsonRowWriter) Close() (err error) {
+	if rw.ndjson {
+		return nil
+	}
+	_, err = rw.w.Write([]byte{']'})
+	return err
+}
+
+func newJsonRowWriter(w io.Writer, export *entity.Export) (rw rowWriter, err error) {
+	if export.RowCount == 0 {
+		if _, err := w.Write([]byte{'['}); err != nil {
+			return nil, err
+		}
+	}
+	return &jsonRowWriter{
+		w:       w,
+		enc:     json.NewEncoder(w),
+		columns: export.Columns,
+		rows:    export.RowCount,
+	}, nil
+}
+
+func newNdjsonRowWriter(w io.Writer, export *entity.Export) (rw rowWriter, err error) {
+	return &jsonRowWriter{
+		w:       w,
+		enc:     json.NewEncoder(w),
+		columns: export.Columns,
+		ndjson:  true,
+		rows:    export.RowCount,
+	}, nil
+}
+
+// projectRow selects and renames columns of a record for json output, or returns the whole
+// record if no columns are selected
+func projectRow(data bson.M, columns []entity.ExportColumn) (res map[string]interface{}) {
+	res = map[string]interface{}{}
+	if len(columns) == 0 {
+		for k, v := range data {
+			res[k] = jsonValue(v)
+		}
+		return res
+	}
+	for _, c := range columns {
+		v, ok := data[c.Key]
+		if !ok {
+			continue
+		}
+		res[c.GetName()] = jsonValue(v)
+	}
+	return res
+}
+
+// jsonValue converts nested bson documents and arrays to json-friendly values
+func jsonValue(v interface{}) (res interface{}) {
+	switch v := v.(type) {
+	case primitive.D:
+		m := map[string]interface{}{}
+		for _, e := range v {
+			m[e.Key] = jsonValue(e.Value)
+		}
+		return m
+	case bson.M:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case primitive.A:
+		var a []interface{}
+		for _, e := range v {
+			a = append(a, jsonValue(e))
+		}
+		return a
+	case primitive.DateTime:
+		return v.Time()
+	default:
+		return v
+	}
+}
+
+// formatCell formats a value as a text cell
+func formatCell(v interface{}) (cell string) {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	case int:
+		return strconv.Itoa(v)
+	case int32:
+		return strconv.Itoa(int(v))
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().Format("2006-01-02 15:04:05")
+	case primitive.D, primitive.A, bson.M:
+		data, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
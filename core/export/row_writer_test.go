@@ -0,0 +1,402 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testExportColumns = []entity.ExportColumn{
+	{Key: "name", Name: "Name"},
+	{Key: "count"},
+	{Key: "score"},
+	{Key: "ok"},
+	{Key: "ts"},
+}
+
+func testExportRows(n int) (rows []bson.M) {
+	for i := 0; i < n; i++ {
+		rows = append(rows, bson.M{
+			"_id":   primitive.NewObjectID(),
+			"name":  "item <&>",
+			"count": int32(i),
+			"score": 1.5,
+			"ok":    i%2 == 0,
+			"ts":    primitive.NewDateTimeFromTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+			"tags":  primitive.A{"a", "b"},
+		})
+	}
+	return rows
+}
+
+func writeTestExportRows(t *testing.T, fn newRowWriterFn, export *entity.Export, rows []bson.M, close bool) []byte {
+	var buf bytes.Buffer
+	rw, err := fn(&buf, export)
+	require.Nil(t, err)
+	for _, row := range rows {
+		require.Nil(t, rw.WriteRow(row))
+	}
+	if close {
+		require.Nil(t, rw.Close())
+	} else {
+		require.Nil(t, rw.Flush())
+	}
+	return buf.Bytes()
+}
+
+func TestCsvRowWriter_Resume(t *testing.T) {
+	export := &entity.Export{Columns: testExportColumns}
+	rows := testExportRows(3)
+
+	data := writeTestExportRows(t, newCsvRowWriter, export, rows[:2], false)
+	export.RowCount = 2
+	data = append(data, writeTestExportRows(t, newCsvRowWriter, export, rows[2:], true)...)
+
+	lines := strings.Split(strings.TrimSpace(string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF}))), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, "Name,count,score,ok,ts", lines[0])
+	require.Equal(t, "item <&>,2,1.5,true,2024-01-01 00:00:00", lines[3])
+}
+
+func TestJsonRowWriter_Resume(t *testing.T) {
+	export := &entity.Export{Columns: testExportColumns[:2]}
+	rows := testExportRows(3)
+
+	data := writeTestExportRows(t, newJsonRowWriter, export, rows[:2], false)
+	export.RowCount = 2
+	data = append(data, writeTestExportRows(t, newJsonRowWriter, export, rows[2:], true)...)
+
+	var res []map[string]interface{}
+	require.Nil(t, json.Unmarshal(data, &res))
+	require.Len(t, res, 3)
+	require.Equal(t, map[string]interface{}{"Name": "item <&>", "count": float64(2)}, res[2])
+}
+
+func TestNdjsonRowWriter(t *testing.T) {
+	export := &entity.Export{}
+	data := writeTestExportRows(t, newNdjsonRowWriter, export, testExportRows(2), true)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var res map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(lines[1]), &res))
+	require.Equal(t, "item <&>", res["name"])
+	require.Equal(t, []interface{}{"a", "b"}, res["tags"])
+}
+
+func TestXlsxRowWriter(t *testing.T) {
+	export := &entity.Export{Columns: testExportColumns}
+	rows := testExportRows(2)
+	rows[1]["name"] = "item\x00"
+	data := writeTestExportRows(t, newXlsxRowWriter, export, rows, true)
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	require.Nil(t, err)
+	defer f.Close()
+	res, err := f.GetRows(xlsxSheetName)
+	require.Nil(t, err)
+	require.Equal(t, [][]string{
+		{"Name", "count", "score", "ok", "ts"},
+		{"item <&>", "0", "1.5", "TRUE", "1/1/24 00:00"},
+		{"item", "1", "1.5", "FALSE", "1/1/24 00:00"},
+	}, res)
+
+	// cell types
+	typ, err := f.GetCellType(xlsxSheetName, "D2")
+	require.Nil(t, err)
+	require.Equal(t, excelize.CellTypeBool, typ)
+	typ, err = f.GetCellType(xlsxSheetName, "E2")
+	require.Nil(t, err)
+	require.Equal(t, excelize.CellTypeUnset, typ) // numeric date time with number format
+}
+
+func TestParquetRowWriter(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		export := &entity.Export{Columns: testExportColumns, Gzip: gz}
+		rows := testExportRows(parquetRowGroupSize + 5)
+		rows[1]["count"] = nil
+		rows[parquetRowGroupSize+1]["count"] = float64(3) // integral float of a later row group
+		data := writeTestExportRows(t, newParquetRowWriter, export, rows, true)
+
+		require.Equal(t, parquetMagic, data[:4])
+		require.Equal(t, parquetMagic, data[len(data)-4:])
+		footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+		footer := data[len(data)-8-footerLen : len(data)-8]
+
+		// file metadata
+		fields := readTestThriftStruct(t, bytes.NewReader(footer))
+		require.Equal(t, int64(1), fields[1])
+		require.Equal(t, int64(len(rows)), fields[3])
+		require.Len(t, fields[4], 2) // row groups
+		require.Equal(t, "crawlab", fields[6])
+
+		// row values
+		names, res := readTestParquet(t, data)
+		require.Equal(t, []string{"Name", "count", "score", "ok", "ts"}, names)
+		require.Len(t, res, len(rows))
+		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		require.Equal(t, []interface{}{"item <&>", int64(0), 1.5, true, ts}, res[0])
+		require.Equal(t, []interface{}{"item <&>", nil, 1.5, false, ts}, res[1])
+		require.Equal(t, []interface{}{"item <&>", int64(3), 1.5, false, ts}, res[parquetRowGroupSize+1])
+		require.Equal(t, []interface{}{"item <&>", int64(len(rows) - 1), 1.5, true, ts}, res[len(rows)-1])
+	}
+}
+
+func TestParquetRowWriter_MixedTypes(t *testing.T) {
+	export := &entity.Export{Columns: testExportColumns[:3]}
+	rows := testExportRows(3)
+	rows[1]["count"] = "n/a"
+	rows[2]["score"] = int32(2)
+	data := writeTestExportRows(t, newParquetRowWriter, export, rows, true)
+
+	// mixed types of the first row group are written as strings, and mixed numbers as doubles
+	_, res := readTestParquet(t, data)
+	require.Equal(t, []interface{}{"item <&>", "0", 1.5}, res[0])
+	require.Equal(t, []interface{}{"item <&>", "n/a", 1.5}, res[1])
+	require.Equal(t, []interface{}{"item <&>", "2", float64(2)}, res[2])
+}
+
+// testPyarrowScript prints rows of a parquet file read by pyarrow as json lines
+const testPyarrowScript = `
+import json, sys
+import pyarrow.parquet as pq
+for row in pq.read_table(sys.argv[1]).to_pylist():
+    print(json.dumps(row, default=str))
+`
+
+func TestParquetRowWriter_Pyarrow(t *testing.T) {
+	// read back with a reference implementation of parquet if available
+	if err := exec.Command("python3", "-c", "import pyarrow.parquet").Run(); err != nil {
+		t.Skipf("pyarrow is not available: %v", err)
+	}
+
+	for _, gz := range []bool{false, true} {
+		export := &entity.Export{Columns: testExportColumns, Gzip: gz}
+		rows := testExportRows(parquetRowGroupSize + 5)
+		rows[1]["count"] = nil
+		data := writeTestExportRows(t, newParquetRowWriter, export, rows, true)
+		filePath := filepath.Join(t.TempDir(), "export.parquet")
+		require.Nil(t, os.WriteFile(filePath, data, 0644))
+
+		out, err := exec.Command("python3", "-c", testPyarrowScript, filePath).Output()
+		require.Nil(t, err)
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		require.Len(t, lines, len(rows))
+		var res map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(lines[0]), &res))
+		require.Equal(t, map[string]interface{}{
+			"Name":  "item <&>",
+			"count": float64(0),
+			"score": 1.5,
+			"ok":    true,
+			"ts":    "2024-01-01 00:00:00",
+		}, res)
+		require.Nil(t, json.Unmarshal([]byte(lines[1]), &res))
+		require.Nil(t, res["count"])
+		require.Nil(t, json.Unmarshal([]byte(lines[len(lines)-1]), &res))
+		require.Equal(t, float64(len(rows)-1), res["count"])
+	}
+}
+
+func TestParquetRowWriter_TypeMismatch(t *testing.T) {
+	export := &entity.Export{Columns: testExportColumns}
+	rows := testExportRows(parquetRowGroupSize + 2)
+	rows[parquetRowGroupSize+1]["count"] = "n/a"
+
+	var buf bytes.Buffer
+	rw, err := newParquetRowWriter(&buf, export)
+	require.Nil(t, err)
+	for _, row := range rows {
+		require.Nil(t, rw.WriteRow(row))
+	}
+	size := buf.Len()
+	err = rw.Close()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), fmt.Sprintf("row %d", parquetRowGroupSize+2))
+	require.Contains(t, err.Error(), `column "count"`)
+	require.Equal(t, size, buf.Len()) // no partial row group
+}
+
+func TestThriftWriter(t *testing.T) {
+	w := newThriftWriter()
+	w.structBegin()
+	w.i32Field(1, 1)
+	w.i64Field(3, -2)
+	w.binaryField(20, "a")
+	w.listField(21, thriftTypeI32, 2)
+	w.i32(1)
+	w.i32(2)
+	w.structEnd()
+	require.Equal(t, []byte{0x15, 0x02, 0x26, 0x03, 0x08, 0x28, 0x01, 'a', 0x19, 0x25, 0x02, 0x04, 0x00}, w.bytes())
+}
+
+func TestEncodeParquetLevels(t *testing.T) {
+	require.Equal(t, []byte{0x04, 0x01, 0x02, 0x00, 0x06, 0x01}, encodeParquetLevels([]bool{true, true, false, true, true, true}))
+}
+
+// readTestParquet reads column names and row values of a parquet file of flat optional columns
+// with plain encoding and one data page per column chunk, as written by parquetRowWriter
+func readTestParquet(t *testing.T, data []byte) (names []string, rows [][]interface{}) {
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	meta := readTestThriftStruct(t, bytes.NewReader(data[len(data)-8-footerLen:len(data)-8]))
+
+	// schema without root
+	var schema []map[int16]interface{}
+	for _, el := range meta[2].([]interface{})[1:] {
+		el := el.(map[int16]interface{})
+		schema = append(schema, el)
+		names = append(names, el[4].(string))
+	}
+
+	for _, rg := range meta[4].([]interface{}) {
+		rg := rg.(map[int16]interface{})
+		groupRows := make([][]interface{}, rg[3].(int64))
+		for i := range groupRows {
+			groupRows[i] = make([]interface{}, len(schema))
+		}
+		for i, chunk := range rg[1].([]interface{}) {
+			md := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			r := bytes.NewReader(data[md[9].(int64):])
+			header := readTestThriftStruct(t, r)
+			page := make([]byte, header[3].(int64))
+			_, err := io.ReadFull(r, page)
+			require.Nil(t, err)
+			if md[4].(int64) == int64(parquetCodecGzip) {
+				gr, err := gzip.NewReader(bytes.NewReader(page))
+				require.Nil(t, err)
+				page, err = io.ReadAll(gr)
+				require.Nil(t, err)
+			}
+			require.Equal(t, header[2].(int64), int64(len(page)))
+
+			// definition levels
+			levelsLen := binary.LittleEndian.Uint32(page[:4])
+			lr := bytes.NewReader(page[4 : 4+levelsLen])
+			var levels []bool
+			for lr.Len() > 0 {
+				h, err := binary.ReadUvarint(lr)
+				require.Nil(t, err)
+				v, err := lr.ReadByte()
+				require.Nil(t, err)
+				for k := uint64(0); k < h>>1; k++ {
+					levels = append(levels, v == 1)
+				}
+			}
+			require.Len(t, levels, len(groupRows))
+
+			// plain encoded values
+			vr := bytes.NewReader(page[4+levelsLen:])
+			var bit int
+			var b byte
+			for j, defined := range levels {
+				if !defined {
+					continue
+				}
+				var v interface{}
+				switch int32(schema[i][1].(int64)) {
+				case parquetTypeBoolean:
+					if bit%8 == 0 {
+						b, err = vr.ReadByte()
+						require.Nil(t, err)
+					}
+					v = b&(1<<(bit%8)) != 0
+					bit++
+				case parquetTypeInt64:
+					var n int64
+					require.Nil(t, binary.Read(vr, binary.LittleEndian, &n))
+					v = n
+					if schema[i][6] == int64(parquetConvertedTypeTimestampMillis) {
+						v = time.UnixMilli(n).UTC()
+					}
+				case parquetTypeDouble:
+					var f float64
+					require.Nil(t, binary.Read(vr, binary.LittleEndian, &f))
+					v = f
+				default:
+					var n uint32
+					require.Nil(t, binary.Read(vr, binary.LittleEndian, &n))
+					s := make([]byte, n)
+					_, err := io.ReadFull(vr, s)
+					require.Nil(t, err)
+					v = string(s)
+				}
+				groupRows[j][i] = v
+			}
+			require.Zero(t, vr.Len())
+		}
+		rows = append(rows, groupRows...)
+	}
+	return names, rows
+}
+
+// readTestThriftStruct reads a struct of thrift compact protocol, returning fields by id
+func readTestThriftStruct(t *testing.T, r *bytes.Reader) (fields map[int16]interface{}) {
+	fields = map[int16]interface{}{}
+	var id int16
+	for {
+		b, err := r.ReadByte()
+		require.Nil(t, err)
+		if b == 0 {
+			return fields
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := binary.ReadUvarint(r)
+			require.Nil(t, err)
+			id = int16(int64(v>>1) ^ -int64(v&1))
+		}
+		fields[id] = readTestThriftValue(t, r, b&0x0F)
+	}
+}
+
+func readTestThriftValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case thriftTypeI32, thriftTypeI64:
+		v, err := binary.ReadUvarint(r)
+		require.Nil(t, err)
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftTypeBinary:
+		n, err := binary.ReadUvarint(r)
+		require.Nil(t, err)
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		require.Nil(t, err)
+		return string(b)
+	case thriftTypeList:
+		h, err := r.ReadByte()
+		require.Nil(t, err)
+		n := int(h >> 4)
+		if n == 15 {
+			v, err := binary.ReadUvarint(r)
+			require.Nil(t, err)
+			n = int(v)
+		}
+		var res []interface{}
+		for i := 0; i < n; i++ {
+			res = append(res, readTestThriftValue(t, r, h&0x0F))
+		}
+		return res
+	case thriftTypeStruct:
+		return readTestThriftStruct(t, r)
+	default:
+		t.Fatalf("unexpected thrift type: %d", typ)
+		return nil
+	}
+}
@@ -0,0 +1,520 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	"github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/hashicorp/go-uuid"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// exportCollectionName is the mongo collection of persisted export records
+const exportCollectionName = "exports"
+
+// StreamService exports records of a data collection to a file of an export format. Records are
+// streamed from the mongo cursor in _id order, and the export record is persisted with progress
+// and row count so that it survives a master restart. Exports of formats that can be appended to
+// (csv, json, ndjson) are checkpointed and resumed from the last checkpoint after a restart,
+// while the others are restarted from scratch.
+type StreamService struct {
+	// settings
+	exportType      string
+	ext             string
+	newRowWriter    newRowWriterFn
+	resumable       bool // whether the file can be truncated to a checkpoint and appended to
+	compressed      bool // whether the format handles compression by itself
+	checkpointCount int64
+
+	// internals
+	running sync.Map // running exports by id
+}
+
+func (svc *StreamService) GenerateId() (exportId string, err error) {
+	exportId, err = uuid.GenerateUUID()
+	if err != nil {
+		return "", trace.TraceError(err)
+	}
+	return exportId, nil
+}
+
+func (svc *StreamService) Export(exportType, target string, filter interfaces.Filter) (exportId string, err error) {
+	return svc.ExportWithOptions(target, filter, nil)
+}
+
+// ExportWithOptions exports records of target data collection matching the filter with selected
+// columns and optional gzip compression
+func (svc *StreamService) ExportWithOptions(target string, filter interfaces.Filter, opts *entity.ExportOptions) (exportId string, err error) {
+	export, err := svc.newExport(target, filter, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// execute export
+	go svc.export(export)
+
+	return export.Id, nil
+}
+
+// ExportAndWait is the same as ExportWithOptions, but returns the export after it has ended
+func (svc *StreamService) ExportAndWait(target string, filter interfaces.Filter, opts *entity.ExportOptions) (export *entity.Export, err error) {
+	export, err = svc.newExport(target, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	svc.export(export)
+	return export, nil
+}
+
+func (svc *StreamService) newExport(target string, filter interfaces.Filter, opts *entity.ExportOptions) (export *entity.Export, err error) {
+	// generate export id
+	exportId, err := svc.GenerateId()
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &entity.ExportOptions{}
+	}
+
+	// mongo query
+	query, err := utils.FilterToQuery(filter)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+	if query == nil {
+		query = bson.M{}
+	}
+	if !opts.AfterId.IsZero() || len(opts.ExcludeIds) > 0 {
+		idQuery := bson.M{}
+		if !opts.AfterId.IsZero() {
+			idQuery["$gt"] = opts.AfterId
+		}
+		if len(opts.ExcludeIds) > 0 {
+			idQuery["$nin"] = opts.ExcludeIds
+		}
+		afterQuery := bson.M{"_id": idQuery}
+		if len(query) == 0 {
+			query = afterQuery
+		} else {
+			query = bson.M{"$and": []bson.M{query, afterQuery}}
+		}
+	}
+	queryJson, err := bson.MarshalExtJSON(query, true, false)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// export
+	fileName := svc.getFileName(exportId, opts.Gzip)
+	export = &entity.Export{
+		Id:           exportId,
+		Type:         svc.exportType,
+		Target:       target,
+		Filter:       filter,
+		Query:        string(queryJson),
+		Columns:      opts.Columns,
+		Gzip:         opts.Gzip,
+		Status:       constants.TaskStatusRunning,
+		StartTs:      time.Now(),
+		UpdateTs:     time.Now(),
+		FileName:     fileName,
+		DownloadPath: svc.getDownloadPath(fileName),
+		NodeKey:      config.GetNodeConfigService().GetNodeKey(),
+		Overlap:      opts.Overlap,
+	}
+
+	// save
+	svc.running.Store(exportId, export)
+	if err := svc.save(export); err != nil {
+		svc.running.Delete(exportId)
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func (svc *StreamService) GetExport(exportId string) (export interfaces.Export, err error) {
+	// running export
+	if res, ok := svc.running.Load(exportId); ok {
+		return res.(*entity.Export), nil
+	}
+
+	// persisted export
+	var e entity.Export
+	if err := mongo.GetMongoCol(exportCollectionName).Find(bson.M{"_id": exportId}, nil).One(&e); err != nil {
+		return nil, trace.TraceError(errors.New("export not found"))
+	}
+	if e.Type != svc.exportType {
+		return nil, trace.TraceError(errors.New("export not found"))
+	}
+	return &e, nil
+}
+
+// resume continues a running export interrupted by a master restart
+func (svc *StreamService) resume(export *entity.Export) {
+	if _, loaded := svc.running.LoadOrStore(export.Id, export); loaded {
+		return
+	}
+	if !svc.canResume(export) {
+		// restart from scratch
+		export.RowCount = 0
+		export.Offset = 0
+		export.LastId = primitive.NilObjectID
+	}
+	log.Infof("resuming export (id: %s, rows: %d)", export.Id, export.RowCount)
+	go svc.export(export)
+}
+
+// canResume returns whether an export can be resumed from its checkpoint, which requires the
+// checkpointed part of the file to be intact
+func (svc *StreamService) canResume(export *entity.Export) (ok bool) {
+	if !svc.resumable || export.LastId.IsZero() {
+		return false
+	}
+	fi, err := os.Stat(export.DownloadPath)
+	if err != nil || fi.Size() < export.Offset {
+		log.Warnf("export file is missing or shorter than checkpoint, restarting export (id: %s)", export.Id)
+		return false
+	}
+	return true
+}
+
+func (svc *StreamService) export(export *entity.Export) {
+	defer svc.running.Delete(export.Id)
+
+	if err := svc._export(export); err != nil {
+		export.Status = constants.TaskStatusError
+		export.Error = err.Error()
+		log.Errorf("export error (id: %s): %v", export.Id, err)
+		trace.PrintError(err)
+	} else {
+		export.Status = constants.TaskStatusFinished
+		log.Infof("export finished (id: %s, rows: %d)", export.Id, export.RowCount)
+	}
+	export.EndTs = time.Now()
+	if err := svc.save(export); err != nil {
+		trace.PrintError(err)
+	}
+}
+
+func (svc *StreamService) _export(export *entity.Export) (err error) {
+	// check empty
+	if export.Target == "" {
+		return errors.New("empty target")
+	}
+
+	// mongo query
+	query := bson.M{}
+	if export.Query != "" {
+		if err := bson.UnmarshalExtJSON([]byte(export.Query), true, &query); err != nil {
+			return trace.TraceError(err)
+		}
+	}
+
+	// columns
+	if len(export.Columns) == 0 && svc.exportType != constants.ExportTypeJson && svc.exportType != constants.ExportTypeNdjson {
+		export.Columns, err = svc.getColumns(export.Target, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	// mongo cursor, in _id order for checkpoints
+	if !export.LastId.IsZero() {
+		query = bson.M{"$and": []bson.M{query, {"_id": bson.M{"$gt": export.LastId}}}}
+	}
+	col := mongo.GetMongoCol(export.Target)
+	cur, err := col.GetCollection().Find(col.GetContext(), query, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	defer cur.Close(context.Background())
+
+	// file
+	f, err := svc.openFile(export)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// writer chain: row writer -> buffer -> (gzip) -> file
+	var gw *gzip.Writer
+	var w io.Writer = f
+	if export.Gzip && !svc.compressed {
+		gw = gzip.NewWriter(f)
+		w = gw
+	}
+	bw := bufio.NewWriter(w)
+	rw, err := svc.newRowWriter(bw, export)
+	if err != nil {
+		return trace.TraceError(err)
+	}
+
+	// checkpoint saves progress, and flushes written rows to the file if resumable
+	checkpoint := func() (err error) {
+		if svc.resumable {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if gw != nil {
+				// end the gzip member, so that the file can be truncated here
+				if err := gw.Close(); err != nil {
+					return err
+				}
+				gw.Reset(f)
+			}
+			export.Offset, err = f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+		}
+		export.UpdateTs = time.Now()
+		return svc.save(export)
+	}
+
+	// iterate cursor
+	var n int64
+	for cur.Next(context.Background()) {
+		var data bson.M
+		if err := cur.Decode(&data); err != nil {
+			return trace.TraceError(err)
+		}
+		if err := rw.WriteRow(data); err != nil {
+			return trace.TraceError(err)
+		}
+		export.RowCount++
+		if id, ok := data["_id"].(primitive.ObjectID); ok {
+			export.LastId = id
+			if export.Overlap > 0 {
+				export.RecentIds = appendRecentId(export.RecentIds, id, export.Overlap)
+			}
+		} else {
+			export.LastId = primitive.NilObjectID
+		}
+		n++
+		if n%svc.checkpointCount == 0 {
+			if err := checkpoint(); err != nil {
+				return trace.TraceError(err)
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return trace.TraceError(err)
+	}
+
+	// close
+	if err := rw.Close(); err != nil {
+		return trace.TraceError(err)
+	}
+	if err := bw.Flush(); err != nil {
+		return trace.TraceError(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return trace.TraceError(err)
+		}
+	}
+	return nil
+}
+
+// openFile opens the export file, truncated to the checkpoint offset. An error is returned if
+// the file is missing or shorter than the checkpoint offset.
+func (svc *StreamService) openFile(export *entity.Export) (f *os.File, err error) {
+	if err := os.MkdirAll(path.Dir(export.DownloadPath), 0755); err != nil {
+		return nil, trace.TraceError(err)
+	}
+	flag := os.O_CREATE | os.O_WRONLY
+	if export.Offset > 0 {
+		flag = os.O_WRONLY
+	}
+	f, err = os.OpenFile(export.DownloadPath, flag, 0644)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, trace.TraceError(err)
+	}
+	if fi.Size() < export.Offset {
+		_ = f.Close()
+		return nil, trace.TraceError(errors.New(fmt.Sprintf("export file is shorter than checkpoint offset %d", export.Offset)))
+	}
+	if err := f.Truncate(export.Offset); err != nil {
+		_ = f.Close()
+		return nil, trace.TraceError(err)
+	}
+	if _, err := f.Seek(export.Offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, trace.TraceError(err)
+	}
+	return f, nil
+}
+
+func (svc *StreamService) save(export *entity.Export) (err error) {
+	col := mongo.GetMongoCol(exportCollectionName)
+	if err := col.ReplaceWithOptions(bson.M{"_id": export.Id}, export, options.Replace().SetUpsert(true)); err != nil {
+		return trace.TraceError(err)
+	}
+	return nil
+}
+
+// getColumns returns sorted keys of sampled records, excluding _id and task key
+func (svc *StreamService) getColumns(target string, query bson.M) (columns []entity.ExportColumn, err error) {
+	// get mongo collection
+	col := mongo.GetMongoCol(target)
+
+	// get 10 records
+	var data []bson.M
+	if err := col.Find(query, &mongo.FindOptions{Limit: 10}).All(&data); err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// keys set
+	keysSet := make(map[string]bool)
+	for _, d := range data {
+		for k := range d {
+			keysSet[k] = true
+		}
+	}
+
+	// keys
+	keys := make([]string, 0, len(keysSet))
+	for k := range keysSet {
+		// skip task key
+		if k == constants.TaskKey {
+			continue
+		}
+
+		// skip _id
+		if k == "_id" {
+			continue
+		}
+
+		// append to keys
+		keys = append(keys, k)
+	}
+
+	// order keys
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		columns = append(columns, entity.ExportColumn{Key: k})
+	}
+	return columns, nil
+}
+
+// getExportDir returns the export directory, which is configured by "export.path" and defaults
+// to <tempDir>/export/<type>
+func (svc *StreamService) getExportDir() (dir string) {
+	baseDir := viper.GetString("export.path")
+	if baseDir == "" {
+		baseDir = path.Join(os.TempDir(), "export")
+	}
+	return path.Join(baseDir, svc.exportType)
+}
+
+func (svc *StreamService) getFileName(exportId string, gz bool) (fileName string) {
+	fileName = fmt.Sprintf("%s_%s.%s", exportId, time.Now().Format("20060102150405"), svc.ext)
+	if gz && !svc.compressed {
+		fileName += ".gz"
+	}
+	return fileName
+}
+
+// getDownloadPath returns the download path for the export
+// format: <exportDir>/<exportId>_<timestamp>.<ext>
+func (svc *StreamService) getDownloadPath(fileName string) (downloadPath string) {
+	return path.Join(svc.getExportDir(), fileName)
+}
+
+// appendRecentId appends the _id of an exported record, which is exported in _id order, and drops
+// _ids created more than the overlap window before it
+func appendRecentId(ids []primitive.ObjectID, id primitive.ObjectID, overlap time.Duration) []primitive.ObjectID {
+	ids = append(ids, id)
+	since := id.Timestamp().Add(-overlap)
+	i := 0
+	for i < len(ids) && ids[i].Timestamp().Before(since) {
+		i++
+	}
+	return ids[i:]
+}
+
+func newStreamService(exportType, ext string, newRowWriter newRowWriterFn, resumable, compressed bool) (svc *StreamService) {
+	return &StreamService{
+		exportType:      exportType,
+		ext:             ext,
+		newRowWriter:    newRowWriter,
+		resumable:       resumable,
+		compressed:      compressed,
+		checkpointCount: 1000,
+	}
+}
+
+var exportServices map[string]*StreamService
+var exportServicesOnce sync.Once
+
+func getExportServices() map[string]*StreamService {
+	exportServicesOnce.Do(func() {
+		exportServices = map[string]*StreamService{
+			constants.ExportTypeCsv:     NewCsvService().(*StreamService),
+			constants.ExportTypeJson:    NewJsonService().(*StreamService),
+			constants.ExportTypeNdjson:  newStreamService(constants.ExportTypeNdjson, "ndjson", newNdjsonRowWriter, true, false),
+			constants.ExportTypeParquet: newStreamService(constants.ExportTypeParquet, "parquet", newParquetRowWriter, false, true),
+			constants.ExportTypeXlsx:    newStreamService(constants.ExportTypeXlsx, "xlsx", newXlsxRowWriter, false, true),
+		}
+	})
+	return exportServices
+}
+
+// GetExportService returns the export service of an export type
+func GetExportService(exportType string) (svc *StreamService, err error) {
+	svc, ok := getExportServices()[exportType]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("invalid export type: %s", exportType))
+	}
+	return svc, nil
+}
+
+// ResumeExports resumes exports of this master that were running when it was stopped. Exports
+// of other masters are left to them, as their files are on other disks.
+func ResumeExports() {
+	var exports []entity.Export
+	if err := mongo.GetMongoCol(exportCollectionName).Find(bson.M{
+		"status":   constants.TaskStatusRunning,
+		"node_key": config.GetNodeConfigService().GetNodeKey(),
+	}, nil).All(&exports); err != nil {
+		trace.PrintError(err)
+		return
+	}
+	for i := range exports {
+		export := &exports[i]
+		svc, err := GetExportService(export.Type)
+		if err != nil {
+			trace.PrintError(err)
+			continue
+		}
+		svc.resume(export)
+	}
+}
@@ -0,0 +1,82 @@
+package export
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStreamService_CanResume(t *testing.T) {
+	svc := newStreamService(constants.ExportTypeCsv, "csv", newCsvRowWriter, true, false)
+	export := &entity.Export{
+		DownloadPath: filepath.Join(t.TempDir(), "export.csv"),
+		LastId:       primitive.NewObjectID(),
+		Offset:       4,
+	}
+
+	// missing file
+	require.False(t, svc.canResume(export))
+
+	// file shorter than checkpoint
+	require.Nil(t, os.WriteFile(export.DownloadPath, []byte("a,b"), 0644))
+	require.False(t, svc.canResume(export))
+
+	// intact checkpoint
+	require.Nil(t, os.WriteFile(export.DownloadPath, []byte("a,b\n1,"), 0644))
+	require.True(t, svc.canResume(export))
+
+	// not resumable
+	svc.resumable = false
+	require.False(t, svc.canResume(export))
+}
+
+func TestStreamService_OpenFile(t *testing.T) {
+	svc := newStreamService(constants.ExportTypeCsv, "csv", newCsvRowWriter, true, false)
+	export := &entity.Export{
+		DownloadPath: filepath.Join(t.TempDir(), "export.csv"),
+		Offset:       4,
+	}
+
+	// missing file is not created and zero-filled
+	_, err := svc.openFile(export)
+	require.NotNil(t, err)
+	_, err = os.Stat(export.DownloadPath)
+	require.True(t, os.IsNotExist(err))
+
+	// file shorter than checkpoint
+	require.Nil(t, os.WriteFile(export.DownloadPath, []byte("a,b"), 0644))
+	_, err = svc.openFile(export)
+	require.NotNil(t, err)
+
+	// truncated to checkpoint
+	require.Nil(t, os.WriteFile(export.DownloadPath, []byte("a,b\n1,"), 0644))
+	f, err := svc.openFile(export)
+	require.Nil(t, err)
+	_, err = f.WriteString("2\n")
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	data, err := os.ReadFile(export.DownloadPath)
+	require.Nil(t, err)
+	require.Equal(t, "a,b\n2\n", string(data))
+}
+
+func TestAppendRecentId(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	id1 := primitive.NewObjectIDFromTimestamp(now)
+	id2 := primitive.NewObjectIDFromTimestamp(now.Add(3 * time.Minute))
+	id3 := primitive.NewObjectIDFromTimestamp(now.Add(6 * time.Minute))
+
+	var ids []primitive.ObjectID
+	ids = appendRecentId(ids, id1, 5*time.Minute)
+	ids = appendRecentId(ids, id2, 5*time.Minute)
+	require.Equal(t, []primitive.ObjectID{id1, id2}, ids)
+
+	// ids created before the window are dropped
+	ids = appendRecentId(ids, id3, 5*time.Minute)
+	require.Equal(t, []primitive.ObjectID{id2, id3}, ids)
+}
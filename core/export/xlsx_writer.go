@@ -0,0 +1,124 @@
+package export
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// xlsxSheetName is the name of the worksheet of exported records
+const xlsxSheetName = "Sheet1"
+
+// xlsxDateTimeNumFmt is the built-in number format of date time cells (m/d/yy h:mm)
+const xlsxDateTimeNumFmt = 22
+
+// xlsxRowWriter writes records to a single worksheet of an Excel workbook. The worksheet is
+// written with the stream writer of excelize, which buffers rows in a temporary file, so that
+// memory usage does not grow with the number of rows.
+type xlsxRowWriter struct {
+	w             io.Writer
+	f             *excelize.File
+	sw            *excelize.StreamWriter
+	columns       []entity.ExportColumn
+	dateTimeStyle int
+	rows          int
+}
+
+func (rw *xlsxRowWriter) WriteRow(data bson.M) (err error) {
+	var cells []interface{}
+	for _, c := range rw.columns {
+		cells = append(cells, rw.getCell(data[c.Key]))
+	}
+	return rw.writeRow(cells)
+}
+
+func (rw *xlsxRowWriter) Flush() (err error) {
+	// the workbook is only written on close
+	return nil
+}
+
+func (rw *xlsxRowWriter) Close() (err error) {
+	defer rw.f.Close()
+	if err := rw.sw.Flush(); err != nil {
+		return err
+	}
+	return rw.f.Write(rw.w)
+}
+
+func (rw *xlsxRowWriter) writeRow(cells []interface{}) (err error) {
+	rw.rows++
+	cell, err := excelize.CoordinatesToCellName(1, rw.rows)
+	if err != nil {
+		return err
+	}
+	return rw.sw.SetRow(cell, cells)
+}
+
+// getCell converts a value to a cell value of excelize
+func (rw *xlsxRowWriter) getCell(v interface{}) (cell interface{}) {
+	switch v := v.(type) {
+	case nil, bool, int, int32, int64, float32:
+		return v
+	case float64:
+		// nan and infinity are not valid numeric cells
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return formatCell(v)
+		}
+		return v
+	case time.Time:
+		return excelize.Cell{StyleID: rw.dateTimeStyle, Value: v.UTC()}
+	case primitive.DateTime:
+		return excelize.Cell{StyleID: rw.dateTimeStyle, Value: v.Time().UTC()}
+	default:
+		return xlsxSanitize(formatCell(v))
+	}
+}
+
+// xlsxSanitize removes characters not allowed in xml
+func xlsxSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r != utf8.RuneError && r != 0xFFFE && r != 0xFFFF) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func newXlsxRowWriter(w io.Writer, export *entity.Export) (rw rowWriter, err error) {
+	f := excelize.NewFile()
+	dateTimeStyle, err := f.NewStyle(&excelize.Style{NumFmt: xlsxDateTimeNumFmt})
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter(xlsxSheetName)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	_rw := &xlsxRowWriter{
+		w:             w,
+		f:             f,
+		sw:            sw,
+		columns:       export.Columns,
+		dateTimeStyle: dateTimeStyle,
+	}
+
+	// header row
+	var header []interface{}
+	for _, c := range export.Columns {
+		header = append(header, xlsxSanitize(c.GetName()))
+	}
+	if err := _rw.writeRow(header); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return _rw, nil
+}
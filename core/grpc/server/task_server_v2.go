@@ -7,11 +7,13 @@ import (
 	"github.com/apex/log"
 	"github.com/crawlab-team/crawlab/core/constants"
 	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/event"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	nodeconfig "github.com/crawlab-team/crawlab/core/node/config"
 	"github.com/crawlab-team/crawlab/core/notification"
+	"github.com/crawlab-team/crawlab/core/result"
 	"github.com/crawlab-team/crawlab/core/task/stats"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/db/mongo"
@@ -110,10 +112,6 @@ func (svr TaskServerV2) Fetch(ctx context.Context, request *grpc.Request) (respo
 }
 
 func (svr TaskServerV2) SendNotification(_ context.Context, request *grpc.TaskServiceSendNotificationRequest) (response *grpc.Response, err error) {
-	if !utils.IsPro() {
-		return nil, nil
-	}
-
 	// task id
 	taskId, err := primitive.ObjectIDFromHex(request.TaskId)
 	if err != nil {
@@ -121,6 +119,13 @@ func (svr TaskServerV2) SendNotification(_ context.Context, request *grpc.TaskSe
 		return nil, trace.TraceError(err)
 	}
 
+	// task status updated by runner, which fires triggers
+	event.SendEvent(constants.TaskEventStatusUpdated, taskId)
+
+	if !utils.IsPro() {
+		return nil, nil
+	}
+
 	// arguments
 	var args []any
 
@@ -140,6 +145,15 @@ func (svr TaskServerV2) SendNotification(_ context.Context, request *grpc.TaskSe
 	}
 	args = append(args, taskStat)
 
+	// skip if the task is going to be retried, so that notification is sent after the final attempt
+	attempt := task.Attempt
+	if attempt == 0 {
+		attempt = 1
+	}
+	if task.RetryPolicy.IsRetryable(attempt, task.Status, taskStat.ExitCode) {
+		return nil, nil
+	}
+
 	// spider
 	spider, err := service.NewModelServiceV2[models2.SpiderV2]().GetById(task.SpiderId)
 	if err != nil {
@@ -180,6 +194,9 @@ func (svr TaskServerV2) SendNotification(_ context.Context, request *grpc.TaskSe
 	// notification service
 	svc := notification.GetNotificationServiceV2()
 
+	// settings triggered by changed results, which share a diff computed once
+	var resultsChangedSettings []models2.NotificationSettingV2
+
 	for _, s := range settings {
 		// compatible with old settings
 		trigger := s.Trigger
@@ -194,7 +211,8 @@ func (svr TaskServerV2) SendNotification(_ context.Context, request *grpc.TaskSe
 				go svc.Send(&s, args...)
 			}
 		case constants.NotificationTriggerTaskError:
-			if task.Status == constants.TaskStatusError || task.Status == constants.TaskStatusAbnormal {
+			switch task.Status {
+			case constants.TaskStatusError, constants.TaskStatusAbnormal, constants.TaskStatusTimeout, constants.TaskStatusOomKilled:
 				go svc.Send(&s, args...)
 			}
 		case constants.NotificationTriggerTaskEmptyResults:
@@ -203,12 +221,44 @@ func (svr TaskServerV2) SendNotification(_ context.Context, request *grpc.TaskSe
 					go svc.Send(&s, args...)
 				}
 			}
+		case constants.NotificationTriggerTaskResultsChanged:
+			if task.Status == constants.TaskStatusFinished {
+				resultsChangedSettings = append(resultsChangedSettings, s)
+			}
 		}
 	}
+	if len(resultsChangedSettings) > 0 {
+		go svr.sendResultsChangedNotifications(task, resultsChangedSettings, args)
+	}
 
 	return nil, nil
 }
 
+// sendResultsChangedNotifications diffs results of a task against the previous finished task of
+// the same spider, and sends notifications with the saved diff if any record has changed
+func (svr TaskServerV2) sendResultsChangedNotifications(task *models2.TaskV2, settings []models2.NotificationSettingV2, args []any) {
+	prev, err := result.GetPreviousTask(task.Id)
+	if err != nil {
+		if !errors.Is(err, mongo2.ErrNoDocuments) {
+			trace.PrintError(err)
+		}
+		return
+	}
+	diff, err := result.DiffTaskResults(prev.Id, task.Id, &result.DiffOptions{Save: true})
+	if err != nil {
+		log.Errorf("failed to diff results of task %s: %v", task.Id.Hex(), err)
+		return
+	}
+	if !diff.HasChanges() {
+		return
+	}
+	args = append(args, diff)
+	svc := notification.GetNotificationServiceV2()
+	for _, s := range settings {
+		go svc.Send(&s, args...)
+	}
+}
+
 func (svr TaskServerV2) handleInsertData(msg *grpc.StreamMessage) (err error) {
 	data, err := svr.deserialize(msg)
 	if err != nil {
@@ -236,6 +286,9 @@ func (svr TaskServerV2) handleInsertLogs(msg *grpc.StreamMessage) (err error) {
 	if err != nil {
 		return err
 	}
+	if len(data.LogEntries) > 0 {
+		return svr.statsSvc.InsertLogEntries(data.TaskId, data.LogEntries...)
+	}
 	return svr.statsSvc.InsertLogs(data.TaskId, data.Logs...)
 }
 
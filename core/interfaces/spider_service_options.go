@@ -9,7 +9,14 @@ type SpiderRunOptions struct {
 	Param      string               `json:"param"`
 	ScheduleId primitive.ObjectID   `json:"schedule_id"`
 	Priority   int                  `json:"priority"`
+	TriggerId  primitive.ObjectID   `json:"trigger_id"`
+	Depth      int                  `json:"-"` // depth of the trigger chain which creates the tasks
 	UserId     primitive.ObjectID   `json:"-"`
+
+	// workflow step which creates the tasks
+	WorkflowRunId   primitive.ObjectID   `json:"-"`
+	WorkflowStep    string               `json:"-"`
+	UpstreamTaskIds []primitive.ObjectID `json:"-"`
 }
 
 type SpiderCloneOptions struct {
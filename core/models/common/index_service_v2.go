@@ -43,8 +43,10 @@ func CreateIndexesV2() {
 		{Keys: bson.M{"mode": 1}},
 		{Keys: bson.M{"priority": 1}},
 		{Keys: bson.M{"parent_id": 1}},
+		{Keys: bson.M{"first_attempt_id": 1}},
 		{Keys: bson.M{"has_sub": 1}},
 		{Keys: bson.M{"create_ts": -1}},
+		{Keys: bson.M{"workflow_run_id": 1}},
 	})
 
 	// task stats
@@ -52,6 +54,12 @@ func CreateIndexesV2() {
 		{Keys: bson.M{"create_ts": 1}},
 	})
 
+	// result quarantines
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.ResultQuarantineV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.M{"task_id": 1}},
+		{Keys: bson.M{"spider_id": 1}},
+	})
+
 	// schedules
 	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.ScheduleV2{})).MustCreateIndexes([]mongo2.IndexModel{
 		{Keys: bson.M{"name": 1}},
@@ -231,4 +239,63 @@ func CreateIndexesV2() {
 			},
 		},
 	})
+
+	// export schedules
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.ExportScheduleV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.M{"name": 1}},
+		{Keys: bson.M{"enabled": 1}},
+	})
+
+	// export runs
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.ExportRunV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.M{"schedule_id": 1}},
+		{Keys: bson.M{"status": 1}},
+	})
+
+	// result diffs
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.ResultDiffV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.M{"spider_id": 1}},
+		{Keys: bson.M{"task_id": 1}},
+	})
+
+	// schedule runs
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.ScheduleRunV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.D{{"schedule_id", 1}, {"ts", -1}}},
+	})
+
+	// triggers
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.TriggerV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.D{{"type", 1}, {"enabled", 1}}},
+		{Keys: bson.M{"source_spider_id": 1}},
+	})
+
+	// trigger runs, with unique event keys so that an event fires a trigger once
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.TriggerRunV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.D{{"trigger_id", 1}, {"ts", -1}}},
+		{
+			Keys: bson.D{{"trigger_id", 1}, {"key", 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"key": bson.M{"$type": "string"},
+			}),
+		},
+	})
+
+	// workflow runs
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.WorkflowRunV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.D{{"workflow_id", 1}, {"start_ts", -1}}},
+		{Keys: bson.M{"schedule_id": 1}},
+		{Keys: bson.M{"status": 1}},
+	})
+
+	// retention runs
+	mongo.GetMongoCol(service.GetCollectionNameByInstance(models2.RetentionRunV2{})).MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.M{"data_collection_id": 1}},
+		{Keys: bson.M{"start_ts": -1}},
+	})
+
+	// exports
+	mongo.GetMongoCol("exports").MustCreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.M{"status": 1}},
+		{Keys: bson.M{"type": 1}},
+	})
 }
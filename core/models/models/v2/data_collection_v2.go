@@ -14,4 +14,13 @@ type DataCollectionV2 struct {
 		Keys    []string `json:"keys" bson:"keys"`
 		Type    string   `json:"type" bson:"type"`
 	} `json:"dedup" bson:"dedup"`
+	Validation struct {
+		Enabled bool   `json:"enabled" bson:"enabled"`
+		Action  string `json:"action" bson:"action"` // reject (default) or quarantine
+	} `json:"validation" bson:"validation"`
+	Lineage struct {
+		Enabled bool     `json:"enabled" bson:"enabled"`
+		Fields  []string `json:"fields" bson:"fields"` // fields of lineage envelope, all if empty
+	} `json:"lineage" bson:"lineage"`
+	Retention entity.RetentionPolicy `json:"retention" bson:"retention"` // global retention policy applies if not enabled
 }
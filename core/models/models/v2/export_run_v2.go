@@ -0,0 +1,22 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// ExportRunV2 is a run of an export schedule
+type ExportRunV2 struct {
+	any                      `collection:"export_runs"`
+	BaseModelV2[ExportRunV2] `bson:",inline"`
+	ScheduleId               primitive.ObjectID `json:"schedule_id" bson:"schedule_id"`
+	ExportId                 string             `json:"export_id" bson:"export_id"`
+	Status                   string             `json:"status" bson:"status"`
+	Error                    string             `json:"error" bson:"error"`
+	RowCount                 int64              `json:"row_count" bson:"row_count"`
+	AfterId                  primitive.ObjectID `json:"after_id" bson:"after_id"` // exported records are newer than this _id in incremental mode, which is the overlap window before the high-water _id
+	LastId                   primitive.ObjectID `json:"last_id" bson:"last_id"`   // _id of last exported record
+	Location                 string             `json:"location" bson:"location"` // delivered file path or object url
+	StartTs                  time.Time          `json:"start_ts" bson:"start_ts"`
+	EndTs                    time.Time          `json:"end_ts" bson:"end_ts"`
+}
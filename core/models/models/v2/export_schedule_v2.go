@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExportScheduleV2 is a recurring export of a data collection delivered to a destination
+type ExportScheduleV2 struct {
+	any                           `collection:"export_schedules"`
+	BaseModelV2[ExportScheduleV2] `bson:",inline"`
+	Name                          string                   `json:"name" bson:"name"`
+	Description                   string                   `json:"description" bson:"description"`
+	Cron                          string                   `json:"cron" bson:"cron"`
+	EntryId                       cron.EntryID             `json:"entry_id" bson:"entry_id"`
+	Target                        string                   `json:"target" bson:"target"`
+	Conditions                    []entity.Condition       `json:"conditions" bson:"conditions"`
+	ExportType                    string                   `json:"export_type" bson:"export_type"`
+	Columns                       []entity.ExportColumn    `json:"columns" bson:"columns"`
+	Gzip                          bool                     `json:"gzip" bson:"gzip"`
+	Destination                   entity.ExportDestination `json:"destination" bson:"destination"`
+	Incremental                   bool                     `json:"incremental" bson:"incremental"` // export only records newer than last run
+	LastId                        primitive.ObjectID       `json:"last_id" bson:"last_id"`         // high-water _id of last successful run
+	LastIds                       []primitive.ObjectID     `json:"-" bson:"last_ids"`              // _ids exported within the overlap window before the high-water _id
+	Enabled                       bool                     `json:"enabled" bson:"enabled"`
+}
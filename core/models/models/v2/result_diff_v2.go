@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResultDiffV2 is the difference between results of two tasks of a spider, with records matched
+// on key fields. Listed records are capped, while counts cover all records.
+type ResultDiffV2 struct {
+	any                       `collection:"result_diffs"`
+	BaseModelV2[ResultDiffV2] `bson:",inline"`
+	SpiderId                  primitive.ObjectID    `json:"spider_id" bson:"spider_id"`
+	BaseTaskId                primitive.ObjectID    `json:"base_task_id" bson:"base_task_id"`
+	TaskId                    primitive.ObjectID    `json:"task_id" bson:"task_id"`
+	Keys                      []string              `json:"keys" bson:"keys"`
+	AddedCount                int                   `json:"added_count" bson:"added_count"`
+	RemovedCount              int                   `json:"removed_count" bson:"removed_count"`
+	ChangedCount              int                   `json:"changed_count" bson:"changed_count"`
+	UnchangedCount            int                   `json:"unchanged_count" bson:"unchanged_count"`
+	Added                     []bson.M              `json:"added" bson:"added"`
+	Removed                   []bson.M              `json:"removed" bson:"removed"`
+	Changed                   []entity.ResultChange `json:"changed" bson:"changed"`
+	Truncated                 bool                  `json:"truncated" bson:"truncated"` // whether listed records are capped
+}
+
+// HasChanges returns whether any record was added, removed or changed
+func (d *ResultDiffV2) HasChanges() bool {
+	return d.AddedCount > 0 || d.RemovedCount > 0 || d.ChangedCount > 0
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResultQuarantineV2 is a result record failing validation against the fields of its data collection
+type ResultQuarantineV2 struct {
+	any                             `collection:"result_quarantines"`
+	BaseModelV2[ResultQuarantineV2] `bson:",inline"`
+	TaskId                          primitive.ObjectID `json:"task_id" bson:"task_id"`
+	SpiderId                        primitive.ObjectID `json:"spider_id" bson:"spider_id"`
+	ColName                         string             `json:"col_name" bson:"col_name"`
+	Record                          bson.M             `json:"record" bson:"record"`
+	Error                           string             `json:"error" bson:"error"`
+}
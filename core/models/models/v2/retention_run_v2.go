@@ -0,0 +1,26 @@
+package models
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// RetentionRunV2 is a report of results of a data collection deleted by its retention policy
+type RetentionRunV2 struct {
+	any                         `collection:"retention_runs"`
+	BaseModelV2[RetentionRunV2] `bson:",inline"`
+	DataCollectionId            primitive.ObjectID     `json:"data_collection_id" bson:"data_collection_id"`
+	ColName                     string                 `json:"col_name" bson:"col_name"`
+	Policy                      entity.RetentionPolicy `json:"policy" bson:"policy"`
+	DryRun                      bool                   `json:"dry_run" bson:"dry_run"`
+	BeforeId                    primitive.ObjectID     `json:"before_id" bson:"before_id"` // results with _id less than this are deleted
+	Status                      string                 `json:"status" bson:"status"`
+	Error                       string                 `json:"error" bson:"error"`
+	MatchedCount                int64                  `json:"matched_count" bson:"matched_count"`
+	DeletedCount                int64                  `json:"deleted_count" bson:"deleted_count"`
+	ArchiveExportId             string                 `json:"archive_export_id,omitempty" bson:"archive_export_id,omitempty"`
+	ArchiveLocation             string                 `json:"archive_location,omitempty" bson:"archive_location,omitempty"` // delivered file path or object url
+	StartTs                     time.Time              `json:"start_ts" bson:"start_ts"`
+	EndTs                       time.Time              `json:"end_ts" bson:"end_ts"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// ScheduleRunV2 is a fire event of a schedule, with the tasks it produced or the reason it was
+// skipped
+type ScheduleRunV2 struct {
+	any                        `collection:"schedule_runs"`
+	BaseModelV2[ScheduleRunV2] `bson:",inline"`
+	ScheduleId                 primitive.ObjectID   `json:"schedule_id" bson:"schedule_id"`
+	SpiderId                   primitive.ObjectID   `json:"spider_id" bson:"spider_id"`
+	Ts                         time.Time            `json:"ts" bson:"ts"`           // scheduled fire time
+	Misfire                    bool                 `json:"misfire" bson:"misfire"` // whether the run was missed while master was down
+	Status                     string               `json:"status" bson:"status"`   // fired, skipped or error
+	Reason                     string               `json:"reason" bson:"reason"`   // reason of skipped or errored runs
+	TaskIds                    []primitive.ObjectID `json:"task_ids" bson:"task_ids"`
+}
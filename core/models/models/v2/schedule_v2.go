@@ -1,22 +1,34 @@
 package models
 
 import (
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/robfig/cron/v3"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type ScheduleV2 struct {
 	any                     `collection:"schedules"`
 	BaseModelV2[ScheduleV2] `bson:",inline"`
-	Name                    string               `json:"name" bson:"name"`
-	Description             string               `json:"description" bson:"description"`
-	SpiderId                primitive.ObjectID   `json:"spider_id" bson:"spider_id"`
-	Cron                    string               `json:"cron" bson:"cron"`
-	EntryId                 cron.EntryID         `json:"entry_id" bson:"entry_id"`
-	Cmd                     string               `json:"cmd" bson:"cmd"`
-	Param                   string               `json:"param" bson:"param"`
-	Mode                    string               `json:"mode" bson:"mode"`
-	NodeIds                 []primitive.ObjectID `json:"node_ids" bson:"node_ids"`
-	Priority                int                  `json:"priority" bson:"priority"`
-	Enabled                 bool                 `json:"enabled" bson:"enabled"`
+	Name                    string                 `json:"name" bson:"name"`
+	Description             string                 `json:"description" bson:"description"`
+	SpiderId                primitive.ObjectID     `json:"spider_id" bson:"spider_id"`
+	WorkflowId              primitive.ObjectID     `json:"workflow_id,omitempty" bson:"workflow_id,omitempty"` // workflow to run instead of the spider if set
+	Cron                    string                 `json:"cron" bson:"cron"`
+	EntryId                 cron.EntryID           `json:"entry_id" bson:"entry_id"`
+	Cmd                     string                 `json:"cmd" bson:"cmd"`
+	Param                   string                 `json:"param" bson:"param"`
+	Mode                    string                 `json:"mode" bson:"mode"`
+	NodeIds                 []primitive.ObjectID   `json:"node_ids" bson:"node_ids"`
+	Priority                int                    `json:"priority" bson:"priority"`
+	Limits                  entity.TaskLimits      `json:"limits" bson:"limits"`
+	RetryPolicy             entity.TaskRetryPolicy `json:"retry_policy" bson:"retry_policy"`
+	Enabled                 bool                   `json:"enabled" bson:"enabled"`
+	Timezone                string                 `json:"timezone" bson:"timezone"`                     // IANA time zone of cron, defaults to the time zone of the server
+	Jitter                  int                    `json:"jitter" bson:"jitter"`                         // max random delay of runs in seconds
+	ConcurrencyPolicy       string                 `json:"concurrency_policy" bson:"concurrency_policy"` // allow (default), skip, queue or replace
+	MisfirePolicy           string                 `json:"misfire_policy" bson:"misfire_policy"`         // ignore (default), fire_once or fire_all
+	MisfireLimit            int                    `json:"misfire_limit" bson:"misfire_limit"`           // max missed runs fired by fire_all, defaults to 10
+	LastFireTs              time.Time              `json:"last_fire_ts" bson:"last_fire_ts"`             // scheduled time of the last fire
+	NextFireTs              time.Time              `json:"next_fire_ts" bson:"next_fire_ts"`
 }
@@ -1,22 +1,24 @@
 package models
 
 import (
+	"github.com/crawlab-team/crawlab/core/entity"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type SpiderV2 struct {
 	any                   `collection:"spiders"`
 	BaseModelV2[SpiderV2] `bson:",inline"`
-	Name                  string               `json:"name" bson:"name"`                     // spider name
-	ColId                 primitive.ObjectID   `json:"col_id" bson:"col_id"`                 // data collection id (deprecated) # TODO: remove this field in the future
-	ColName               string               `json:"col_name,omitempty" bson:"col_name"`   // data collection name
-	DataSourceId          primitive.ObjectID   `json:"data_source_id" bson:"data_source_id"` // data source id
-	DataSource            *DatabaseV2          `json:"data_source,omitempty" bson:"-"`       // data source
-	Description           string               `json:"description" bson:"description"`       // description
-	ProjectId             primitive.ObjectID   `json:"project_id" bson:"project_id"`         // Project.Id
-	Mode                  string               `json:"mode" bson:"mode"`                     // default Task.Mode
-	NodeIds               []primitive.ObjectID `json:"node_ids" bson:"node_ids"`             // default Task.NodeIds
-	GitId                 primitive.ObjectID   `json:"git_id" bson:"git_id"`                 // related Git.Id
+	Name                  string               `json:"name" bson:"name"`                                 // spider name
+	ColId                 primitive.ObjectID   `json:"col_id" bson:"col_id"`                             // data collection id (deprecated) # TODO: remove this field in the future
+	ColName               string               `json:"col_name,omitempty" bson:"col_name"`               // data collection name
+	DataSourceId          primitive.ObjectID   `json:"data_source_id" bson:"data_source_id"`             // data source id
+	DataSource            *DatabaseV2          `json:"data_source,omitempty" bson:"-"`                   // data source
+	SinkDataSourceIds     []primitive.ObjectID `json:"sink_data_source_ids" bson:"sink_data_source_ids"` // additional data sources results are also written to
+	Description           string               `json:"description" bson:"description"`                   // description
+	ProjectId             primitive.ObjectID   `json:"project_id" bson:"project_id"`                     // Project.Id
+	Mode                  string               `json:"mode" bson:"mode"`                                 // default Task.Mode
+	NodeIds               []primitive.ObjectID `json:"node_ids" bson:"node_ids"`                         // default Task.NodeIds
+	GitId                 primitive.ObjectID   `json:"git_id" bson:"git_id"`                             // related Git.Id
 	GitRootPath           string               `json:"git_root_path" bson:"git_root_path"`
 	Git                   *GitV2               `json:"git,omitempty" bson:"-"`
 
@@ -28,4 +30,13 @@ type SpiderV2 struct {
 	Param       string `json:"param" bson:"param"` // default task param
 	Priority    int    `json:"priority" bson:"priority"`
 	AutoInstall bool   `json:"auto_install" bson:"auto_install"`
+
+	// resource limits
+	Limits entity.TaskLimits `json:"limits" bson:"limits"` // default Task.Limits
+
+	// retry
+	RetryPolicy entity.TaskRetryPolicy `json:"retry_policy" bson:"retry_policy"` // default Task.RetryPolicy
+
+	// transforms of results applied on ingestion
+	Transforms []entity.ResultTransform `json:"transforms" bson:"transforms"`
 }
@@ -12,5 +12,14 @@ type TaskStatV2 struct {
 	WaitDuration            int64     `json:"wait_duration" bson:"wait_duration,omitempty"`       // in millisecond
 	RuntimeDuration         int64     `json:"runtime_duration" bson:"runtime_duration,omitempty"` // in millisecond
 	TotalDuration           int64     `json:"total_duration" bson:"total_duration,omitempty"`     // in millisecond
-	ResultCount             int64     `json:"result_count" bson:"result_count"`
+	ResultCount             int64     `json:"result_count" bson:"result_count"`                   // number of results inserted or updated
+	ResultNewCount          int64     `json:"result_new_count" bson:"result_new_count"`           // number of results inserted
+	ResultUpdatedCount      int64     `json:"result_updated_count" bson:"result_updated_count"`   // number of existing results overwritten (dedup)
+	ResultSkippedCount      int64     `json:"result_skipped_count" bson:"result_skipped_count"`   // number of duplicate results ignored (dedup)
+	ResultRejectedCount     int64     `json:"result_rejected_count" bson:"result_rejected_count"` // number of invalid results rejected or quarantined (validation)
+	ExitCode                *int      `json:"exit_code,omitempty" bson:"exit_code,omitempty"`     // exit code of task process, -1 if terminated by signal, nil if not exited
+	Signal                  string    `json:"signal,omitempty" bson:"signal,omitempty"`           // signal terminating task process, e.g. SIGKILL
+	UserCpuTime             int64     `json:"user_cpu_time" bson:"user_cpu_time,omitempty"`       // in millisecond
+	SysCpuTime              int64     `json:"sys_cpu_time" bson:"sys_cpu_time,omitempty"`         // in millisecond
+	MaxRss                  int64     `json:"max_rss" bson:"max_rss,omitempty"`                   // peak resident memory in bytes
 }
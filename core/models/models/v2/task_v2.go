@@ -1,28 +1,41 @@
 package models
 
 import (
+	"github.com/crawlab-team/crawlab/core/entity"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type TaskV2 struct {
 	any                 `collection:"tasks"`
 	BaseModelV2[TaskV2] `bson:",inline"`
-	SpiderId            primitive.ObjectID   `json:"spider_id" bson:"spider_id"`
-	Status              string               `json:"status" bson:"status"`
-	NodeId              primitive.ObjectID   `json:"node_id" bson:"node_id"`
-	Cmd                 string               `json:"cmd" bson:"cmd"`
-	Param               string               `json:"param" bson:"param"`
-	Error               string               `json:"error" bson:"error"`
-	Pid                 int                  `json:"pid" bson:"pid"`
-	ScheduleId          primitive.ObjectID   `json:"schedule_id" bson:"schedule_id"`
-	Type                string               `json:"type" bson:"type"`
-	Mode                string               `json:"mode" bson:"mode"`
-	NodeIds             []primitive.ObjectID `json:"node_ids" bson:"node_ids"`
-	ParentId            primitive.ObjectID   `json:"parent_id" bson:"parent_id"`
-	Priority            int                  `json:"priority" bson:"priority"`
-	Stat                *TaskStatV2          `json:"stat,omitempty" bson:"-"`
-	HasSub              bool                 `json:"has_sub" json:"has_sub"`
-	SubTasks            []TaskV2             `json:"sub_tasks,omitempty" bson:"-"`
-	Spider              *SpiderV2            `json:"spider,omitempty" bson:"-"`
-	UserId              primitive.ObjectID   `json:"-" bson:"-"`
+	SpiderId            primitive.ObjectID     `json:"spider_id" bson:"spider_id"`
+	Status              string                 `json:"status" bson:"status"`
+	NodeId              primitive.ObjectID     `json:"node_id" bson:"node_id"`
+	Cmd                 string                 `json:"cmd" bson:"cmd"`
+	Param               string                 `json:"param" bson:"param"`
+	Error               string                 `json:"error" bson:"error"`
+	ExitReason          string                 `json:"exit_reason" bson:"exit_reason"` // e.g. exited with code 1, cancelled with SIGTERM
+	Pid                 int                    `json:"pid" bson:"pid"`
+	ScheduleId          primitive.ObjectID     `json:"schedule_id" bson:"schedule_id"`
+	Type                string                 `json:"type" bson:"type"`
+	Mode                string                 `json:"mode" bson:"mode"`
+	NodeIds             []primitive.ObjectID   `json:"node_ids" bson:"node_ids"`
+	ParentId            primitive.ObjectID     `json:"parent_id" bson:"parent_id"`
+	Priority            int                    `json:"priority" bson:"priority"`
+	Limits              entity.TaskLimits      `json:"limits" bson:"limits"`
+	RetryPolicy         entity.TaskRetryPolicy `json:"retry_policy" bson:"retry_policy"`
+	Attempt             int                    `json:"attempt" bson:"attempt"`                                       // attempt number starting from 1
+	FirstAttemptId      primitive.ObjectID     `json:"first_attempt_id,omitempty" bson:"first_attempt_id,omitempty"` // first attempt of the task retried, set on retries
+	Retried             bool                   `json:"retried" bson:"retried"`                                       // whether retry policy has been applied after failure
+	TriggerId           primitive.ObjectID     `json:"trigger_id,omitempty" bson:"trigger_id,omitempty"`
+	TriggerDepth        int                    `json:"trigger_depth,omitempty" bson:"trigger_depth,omitempty"` // depth of the trigger chain which created the task
+	WorkflowRunId       primitive.ObjectID     `json:"workflow_run_id,omitempty" bson:"workflow_run_id,omitempty"`
+	WorkflowStep        string                 `json:"workflow_step,omitempty" bson:"workflow_step,omitempty"`         // key of the workflow step which created the task
+	UpstreamTaskIds     []primitive.ObjectID   `json:"upstream_task_ids,omitempty" bson:"upstream_task_ids,omitempty"` // tasks of upstream workflow steps
+	Stat                *TaskStatV2            `json:"stat,omitempty" bson:"-"`
+	HasSub              bool                   `json:"has_sub" bson:"has_sub"`
+	SubTasks            []TaskV2               `json:"sub_tasks,omitempty" bson:"-"`
+	Retries             []TaskV2               `json:"retries,omitempty" bson:"-"`
+	Spider              *SpiderV2              `json:"spider,omitempty" bson:"-"`
+	UserId              primitive.ObjectID     `json:"-" bson:"-"`
 }
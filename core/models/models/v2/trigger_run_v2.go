@@ -0,0 +1,24 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// TriggerRunV2 is a fire event of a trigger, with the tasks it produced or the reason it was
+// skipped
+type TriggerRunV2 struct {
+	any                       `collection:"trigger_runs"`
+	BaseModelV2[TriggerRunV2] `bson:",inline"`
+	TriggerId                 primitive.ObjectID   `json:"trigger_id" bson:"trigger_id"`
+	SpiderId                  primitive.ObjectID   `json:"spider_id" bson:"spider_id"`
+	Type                      string               `json:"type" bson:"type"`
+	Key                       string               `json:"key,omitempty" bson:"key,omitempty"` // unique key of event per trigger, e.g. task:<id>:<status> or file:<path>
+	SourceTaskId              primitive.ObjectID   `json:"source_task_id,omitempty" bson:"source_task_id,omitempty"`
+	File                      string               `json:"file,omitempty" bson:"file,omitempty"`
+	Depth                     int                  `json:"depth" bson:"depth"`   // depth of the trigger chain
+	Status                    string               `json:"status" bson:"status"` // fired, skipped or error
+	Reason                    string               `json:"reason" bson:"reason"` // reason of skipped or errored runs
+	TaskIds                   []primitive.ObjectID `json:"task_ids" bson:"task_ids"`
+	Ts                        time.Time            `json:"ts" bson:"ts"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// TriggerV2 runs a spider on an event, which is a task of another spider reaching a status, an
+// inbound webhook or a new file in a watched workspace directory. Param may contain placeholders
+// of the event, e.g. {{task_id}}, {{file}} or {{payload.url}}.
+type TriggerV2 struct {
+	any                    `collection:"triggers"`
+	BaseModelV2[TriggerV2] `bson:",inline"`
+	Name                   string `json:"name" bson:"name"`
+	Description            string `json:"description" bson:"description"`
+	Type                   string `json:"type" bson:"type"` // task, webhook or file
+	Enabled                bool   `json:"enabled" bson:"enabled"`
+
+	// run options
+	SpiderId primitive.ObjectID   `json:"spider_id" bson:"spider_id"`
+	Mode     string               `json:"mode" bson:"mode"`
+	NodeIds  []primitive.ObjectID `json:"node_ids" bson:"node_ids"`
+	Cmd      string               `json:"cmd" bson:"cmd"`
+	Param    string               `json:"param" bson:"param"`
+	Priority int                  `json:"priority" bson:"priority"`
+
+	// task source
+	SourceSpiderId primitive.ObjectID `json:"source_spider_id,omitempty" bson:"source_spider_id,omitempty"`
+	SourceStatuses []string           `json:"source_statuses,omitempty" bson:"source_statuses,omitempty"` // defaults to finished
+
+	// webhook source
+	Token string `json:"-" bson:"token,omitempty"` // secret token of webhook, only returned on creation or regeneration
+
+	// file source
+	WatchPath    string `json:"watch_path,omitempty" bson:"watch_path,omitempty"`       // directory relative to workspace
+	WatchPattern string `json:"watch_pattern,omitempty" bson:"watch_pattern,omitempty"` // glob of file names, any if empty
+}
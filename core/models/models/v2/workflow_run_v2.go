@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// WorkflowRunV2 is a run of a workflow, owning the tasks of its steps. Steps and edges are copied
+// from the workflow when the run starts, so that editing the workflow does not affect it.
+type WorkflowRunV2 struct {
+	any                        `collection:"workflow_runs"`
+	BaseModelV2[WorkflowRunV2] `bson:",inline"`
+	WorkflowId                 primitive.ObjectID       `json:"workflow_id" bson:"workflow_id"`
+	ScheduleId                 primitive.ObjectID       `json:"schedule_id,omitempty" bson:"schedule_id,omitempty"`
+	Status                     string                   `json:"status" bson:"status"`
+	Steps                      []entity.WorkflowRunStep `json:"steps" bson:"steps"`
+	Edges                      []entity.WorkflowEdge    `json:"edges" bson:"edges"`
+	StartTs                    time.Time                `json:"start_ts" bson:"start_ts"`
+	EndTs                      time.Time                `json:"end_ts" bson:"end_ts"`
+	Version                    int                      `json:"version" bson:"version"` // incremented on each update, for optimistic concurrency
+}
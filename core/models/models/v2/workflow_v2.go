@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/crawlab-team/crawlab/core/entity"
+)
+
+// WorkflowV2 is a DAG of spider steps, whose edges run downstream steps depending on the results
+// of upstream steps
+type WorkflowV2 struct {
+	any                     `collection:"workflows"`
+	BaseModelV2[WorkflowV2] `bson:",inline"`
+	Name                    string                `json:"name" bson:"name"`
+	Description             string                `json:"description" bson:"description"`
+	Steps                   []entity.WorkflowStep `json:"steps" bson:"steps"`
+	Edges                   []entity.WorkflowEdge `json:"edges" bson:"edges"`
+}
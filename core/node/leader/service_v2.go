@@ -0,0 +1,215 @@
+package leader
+
+import (
+	"errors"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	"github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+// leaseName is the _id of the lease document held by the leader of masters
+const leaseName = "master"
+
+// Lease is a lease document in mongo held by the leader of masters until it expires
+type Lease struct {
+	Id         string    `json:"id" bson:"_id"`
+	Holder     string    `json:"holder" bson:"holder"` // node key of the leader
+	AcquiredTs time.Time `json:"acquired_ts" bson:"acquired_ts"`
+	RenewedTs  time.Time `json:"renewed_ts" bson:"renewed_ts"`
+	ExpireTs   time.Time `json:"expire_ts" bson:"expire_ts"`
+	Expired    bool      `json:"expired" bson:"expired,omitempty"` // whether expired by time of the mongo server, not stored
+}
+
+// ServiceV2 elects the leader of masters with a lease document in mongo, which is renewed by the
+// leader and acquired by another master after it expires. Only the leader runs cron, the node
+// monitor and cleanup loops, while followers keep serving the api and grpc. If leader election is
+// disabled in settings "master.leaderElection.enabled", the master is always the leader.
+type ServiceV2 struct {
+	// dependencies
+	cfgSvc interfaces.NodeConfigService
+
+	// settings
+	enabled       bool
+	ttl           time.Duration
+	renewInterval time.Duration
+
+	// internals
+	col       *mongo.Col
+	leading   bool
+	expireTs  time.Time // expire time of the lease held by this master
+	callbacks []func()  // called when this master becomes the leader
+	stopped   bool
+	mu        sync.RWMutex
+}
+
+func (svc *ServiceV2) Start() {
+	if !svc.enabled {
+		svc.setLeading(true)
+		return
+	}
+
+	log.Infof("[LeaderServiceV2] master[%s] started leader election", svc.cfgSvc.GetNodeKey())
+	for {
+		if svc.stopped {
+			return
+		}
+
+		svc.setLeading(svc.acquire())
+
+		time.Sleep(svc.renewInterval)
+	}
+}
+
+// Stop stops leader election and releases the lease if held, so that another master takes over
+// without waiting for it to expire
+func (svc *ServiceV2) Stop() {
+	svc.stopped = true
+	if !svc.enabled || !svc.IsLeader() {
+		return
+	}
+	if _, err := svc.col.GetCollection().UpdateOne(svc.col.GetContext(), bson.M{
+		"_id":    leaseName,
+		"holder": svc.cfgSvc.GetNodeKey(),
+	}, []bson.M{{"$set": bson.M{"expire_ts": "$$NOW"}}}); err != nil {
+		trace.PrintError(err)
+	}
+	svc.setLeading(false)
+}
+
+// IsLeader returns whether this master is the leader
+func (svc *ServiceV2) IsLeader() (ok bool) {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	return svc.leading
+}
+
+// OnLeading registers a callback called in a goroutine each time this master becomes the leader,
+// or immediately if it is the leader already
+func (svc *ServiceV2) OnLeading(fn func()) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.callbacks = append(svc.callbacks, fn)
+	if svc.leading {
+		go fn()
+	}
+}
+
+// GetLease returns the lease document of the leader, or nil if leader election is disabled or
+// no master has acquired it
+func (svc *ServiceV2) GetLease() (lease *Lease, err error) {
+	if !svc.enabled {
+		return nil, nil
+	}
+	var l Lease
+	if err := svc.col.Aggregate(mongo2.Pipeline{
+		{{"$match", bson.M{"_id": leaseName}}},
+		{{"$set", bson.M{"expired": bson.M{"$lt": bson.A{"$expire_ts", "$$NOW"}}}}},
+	}, nil).One(&l); err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, trace.TraceError(err)
+	}
+	return &l, nil
+}
+
+// IsEnabled returns whether leader election is enabled
+func (svc *ServiceV2) IsEnabled() (ok bool) {
+	return svc.enabled
+}
+
+// acquire acquires or renews the lease, and returns whether this master holds it. A lease held
+// by another master is only acquired after it expires, which fails with a duplicate key error of
+// upsert otherwise. Times of the lease are those of the mongo server ($$NOW), so that clock skew
+// between masters does not shorten or extend the lease.
+func (svc *ServiceV2) acquire() (ok bool) {
+	key := svc.cfgSvc.GetNodeKey()
+	now := time.Now()
+	_, err := svc.col.GetCollection().UpdateOne(svc.col.GetContext(), bson.M{
+		"_id": leaseName,
+		"$or": []bson.M{
+			{"holder": key},
+			{"$expr": bson.M{"$lt": bson.A{"$expire_ts", "$$NOW"}}},
+		},
+	}, []bson.M{{"$set": bson.M{
+		"holder": bson.M{"$literal": key},
+		"acquired_ts": bson.M{"$cond": bson.A{
+			// renewed before expired
+			bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$holder", bson.M{"$literal": key}}},
+				bson.M{"$gte": bson.A{"$expire_ts", "$$NOW"}},
+			}},
+			"$acquired_ts",
+			"$$NOW",
+		}},
+		"renewed_ts": "$$NOW",
+		"expire_ts":  bson.M{"$add": bson.A{"$$NOW", svc.ttl.Milliseconds()}},
+	}}}, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo2.IsDuplicateKeyError(err) {
+			return false
+		}
+		trace.PrintError(err)
+
+		// keep leading until the lease held expires, as no other master can acquire it before
+		return svc.IsLeader() && time.Now().Before(svc.expireTs)
+	}
+
+	// local expire time counted from before the update, which is no later than that of the lease
+	svc.expireTs = now.Add(svc.ttl)
+	return true
+}
+
+func (svc *ServiceV2) setLeading(leading bool) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if svc.leading == leading {
+		return
+	}
+	svc.leading = leading
+	if !leading {
+		log.Warnf("[LeaderServiceV2] master[%s] is no longer the leader", svc.cfgSvc.GetNodeKey())
+		return
+	}
+	log.Infof("[LeaderServiceV2] master[%s] became the leader", svc.cfgSvc.GetNodeKey())
+	for _, fn := range svc.callbacks {
+		go fn()
+	}
+}
+
+func newLeaderServiceV2() (svc *ServiceV2) {
+	svc = &ServiceV2{
+		cfgSvc:  config.GetNodeConfigService(),
+		enabled: viper.GetBool("master.leaderElection.enabled"),
+		ttl:     15 * time.Second,
+		col:     mongo.GetMongoCol("leases"),
+	}
+	if ttl := viper.GetDuration("master.leaderElection.ttl"); ttl > 0 {
+		svc.ttl = ttl
+	}
+	svc.renewInterval = svc.ttl / 3
+	return svc
+}
+
+var leaderSvcV2 *ServiceV2
+var leaderSvcV2Once = new(sync.Once)
+
+func GetLeaderServiceV2() *ServiceV2 {
+	leaderSvcV2Once.Do(func() {
+		leaderSvcV2 = newLeaderServiceV2()
+	})
+	return leaderSvcV2
+}
+
+// IsLeader returns whether this master is the leader of masters
+func IsLeader() (ok bool) {
+	return GetLeaderServiceV2().IsLeader()
+}
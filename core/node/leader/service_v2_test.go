@@ -0,0 +1,141 @@
+package leader
+
+import (
+	"context"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	"github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+	"time"
+)
+
+type testNodeConfigService struct {
+	interfaces.NodeConfigService
+	key string
+}
+
+func (svc *testNodeConfigService) GetNodeKey() string {
+	return svc.key
+}
+
+// newTestLeaderServices returns leader services of masters sharing a lease collection, or skips
+// the test if mongo is not available
+func newTestLeaderServices(t *testing.T, ttl time.Duration, keys ...string) (services []*ServiceV2) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := mongo2.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017").SetServerSelectionTimeout(time.Second))
+	if err == nil {
+		err = c.Ping(ctx, nil)
+		_ = c.Disconnect(ctx)
+	}
+	if err != nil {
+		t.Skipf("MongoDB is not available: %v", err)
+	}
+
+	viper.Set("mongo.db", "crawlab_test")
+	col := mongo.GetMongoCol("test_leases")
+	_ = col.GetCollection().Drop(col.GetContext())
+	t.Cleanup(func() {
+		_ = col.GetCollection().Drop(col.GetContext())
+	})
+	for _, key := range keys {
+		services = append(services, &ServiceV2{
+			cfgSvc:        &testNodeConfigService{key: key},
+			enabled:       true,
+			ttl:           ttl,
+			renewInterval: ttl / 3,
+			col:           col,
+		})
+	}
+	return services
+}
+
+func TestServiceV2_Acquire(t *testing.T) {
+	services := newTestLeaderServices(t, 2*time.Second, "master-a", "master-b")
+	a, b := services[0], services[1]
+
+	// acquired by a
+	require.True(t, a.acquire())
+	a.setLeading(true)
+	require.False(t, b.acquire())
+	lease, err := b.GetLease()
+	require.Nil(t, err)
+	require.Equal(t, "master-a", lease.Holder)
+	require.False(t, lease.Expired)
+	require.Equal(t, 2*time.Second, lease.ExpireTs.Sub(lease.RenewedTs))
+
+	// renewed by a, keeping acquired time
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, a.acquire())
+	renewed, err := a.GetLease()
+	require.Nil(t, err)
+	require.Equal(t, lease.AcquiredTs, renewed.AcquiredTs)
+	require.True(t, renewed.RenewedTs.After(lease.RenewedTs))
+
+	// acquired by b after expired
+	time.Sleep(2100 * time.Millisecond)
+	lease, err = b.GetLease()
+	require.Nil(t, err)
+	require.True(t, lease.Expired)
+	require.True(t, b.acquire())
+	require.False(t, a.acquire())
+	lease, err = b.GetLease()
+	require.Nil(t, err)
+	require.Equal(t, "master-b", lease.Holder)
+	require.True(t, lease.AcquiredTs.After(renewed.AcquiredTs))
+
+	// released by b on stop
+	b.setLeading(true)
+	b.Stop()
+	require.False(t, b.IsLeader())
+	require.True(t, a.acquire())
+	lease, err = a.GetLease()
+	require.Nil(t, err)
+	require.Equal(t, "master-a", lease.Holder)
+	require.Equal(t, int64(1), countTestLeases(t, a))
+}
+
+func countTestLeases(t *testing.T, svc *ServiceV2) (n int64) {
+	n, err := svc.col.GetCollection().CountDocuments(svc.col.GetContext(), bson.M{})
+	require.Nil(t, err)
+	return n
+}
+
+func TestServiceV2_OnLeading(t *testing.T) {
+	svc := &ServiceV2{cfgSvc: config.GetNodeConfigService()}
+	calls := make(chan bool, 10)
+
+	// registered before leading
+	svc.OnLeading(func() { calls <- true })
+	require.False(t, svc.IsLeader())
+
+	// leader election disabled
+	svc.Start()
+	require.True(t, svc.IsLeader())
+	require.True(t, waitCall(calls))
+
+	// registered while leading
+	svc.OnLeading(func() { calls <- true })
+	require.True(t, waitCall(calls))
+
+	// called again on becoming the leader again
+	svc.setLeading(false)
+	svc.setLeading(true)
+	require.True(t, waitCall(calls))
+	require.True(t, waitCall(calls))
+	require.False(t, waitCall(calls))
+}
+
+func waitCall(calls chan bool) (ok bool) {
+	select {
+	case <-calls:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
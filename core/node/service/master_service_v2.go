@@ -6,18 +6,23 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	config2 "github.com/crawlab-team/crawlab/core/config"
 	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/export"
 	"github.com/crawlab-team/crawlab/core/grpc/server"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/common"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	"github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/core/node/leader"
 	"github.com/crawlab-team/crawlab/core/notification"
+	"github.com/crawlab-team/crawlab/core/retention"
 	"github.com/crawlab-team/crawlab/core/schedule"
 	"github.com/crawlab-team/crawlab/core/system"
 	"github.com/crawlab-team/crawlab/core/task/handler"
 	"github.com/crawlab-team/crawlab/core/task/scheduler"
+	"github.com/crawlab-team/crawlab/core/trigger"
 	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/core/workflow"
 	"github.com/crawlab-team/crawlab/grpc"
 	"github.com/crawlab-team/crawlab/trace"
 	"go.mongodb.org/mongo-driver/bson"
@@ -29,12 +34,17 @@ import (
 
 type MasterServiceV2 struct {
 	// dependencies
-	cfgSvc       interfaces.NodeConfigService
-	server       *server.GrpcServerV2
-	schedulerSvc *scheduler.ServiceV2
-	handlerSvc   *handler.ServiceV2
-	scheduleSvc  *schedule.ServiceV2
-	systemSvc    *system.ServiceV2
+	cfgSvc            interfaces.NodeConfigService
+	server            *server.GrpcServerV2
+	schedulerSvc      *scheduler.ServiceV2
+	handlerSvc        *handler.ServiceV2
+	scheduleSvc       *schedule.ServiceV2
+	exportScheduleSvc *schedule.ExportServiceV2
+	retentionSvc      *retention.ServiceV2
+	systemSvc         *system.ServiceV2
+	leaderSvc         *leader.ServiceV2
+	triggerSvc        *trigger.ServiceV2
+	workflowSvc       *workflow.ServiceV2
 
 	// settings
 	cfgPath         string
@@ -62,6 +72,10 @@ func (svc *MasterServiceV2) Start() {
 		panic(err)
 	}
 
+	// start leader election, which decides whether this master runs cron, the node monitor and
+	// cleanup loops
+	go svc.leaderSvc.Start()
+
 	// start monitoring worker nodes
 	go svc.Monitor()
 
@@ -74,6 +88,21 @@ func (svc *MasterServiceV2) Start() {
 	// start schedule service
 	go svc.scheduleSvc.Start()
 
+	// resume exports interrupted by restart of this master
+	go export.ResumeExports()
+
+	// start export schedule service
+	go svc.exportScheduleSvc.Start()
+
+	// start result retention service
+	go svc.retentionSvc.Start()
+
+	// start trigger service
+	go svc.triggerSvc.Start()
+
+	// start workflow service
+	go svc.workflowSvc.Start()
+
 	// wait for quit signal
 	svc.Wait()
 
@@ -86,6 +115,7 @@ func (svc *MasterServiceV2) Wait() {
 }
 
 func (svc *MasterServiceV2) Stop() {
+	svc.leaderSvc.Stop()
 	_ = svc.server.Stop()
 	log.Infof("master[%s] service has stopped", svc.GetConfigService().GetNodeKey())
 }
@@ -197,6 +227,11 @@ func (svc *MasterServiceV2) monitor() (err error) {
 		return err
 	}
 
+	// only the leader monitors worker nodes
+	if !svc.leaderSvc.IsLeader() {
+		return nil
+	}
+
 	// all worker nodes
 	workerNodes, err := svc.getAllWorkerNodes()
 	if err != nil {
@@ -367,9 +402,33 @@ func newMasterServiceV2() (res *MasterServiceV2, err error) {
 		return nil, err
 	}
 
+	// export schedule service
+	svc.exportScheduleSvc, err = schedule.GetExportScheduleServiceV2()
+	if err != nil {
+		return nil, err
+	}
+
+	// result retention service
+	svc.retentionSvc = retention.GetRetentionServiceV2()
+
 	// system service
 	svc.systemSvc = system.GetSystemServiceV2()
 
+	// leader service
+	svc.leaderSvc = leader.GetLeaderServiceV2()
+
+	// trigger service
+	svc.triggerSvc, err = trigger.GetTriggerServiceV2()
+	if err != nil {
+		return nil, err
+	}
+
+	// workflow service
+	svc.workflowSvc, err = workflow.GetWorkflowServiceV2()
+	if err != nil {
+		return nil, err
+	}
+
 	// init
 	if err := svc.Init(); err != nil {
 		return nil, err
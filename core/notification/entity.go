@@ -10,4 +10,5 @@ type VariableData struct {
 	Schedule *models.ScheduleV2          `json:"schedule"`
 	Alert    *models.NotificationAlertV2 `json:"alert"`
 	Metric   *models.MetricV2            `json:"metric"`
+	Diff     *models.ResultDiffV2        `json:"diff"`
 }
@@ -301,6 +301,26 @@ func (svc *ServiceV2) geContentWithVariables(template string, variables []entity
 				content = strings.ReplaceAll(content, v.GetKey(), svc.getFormattedMetricValue(v.Name, vd.Metric))
 			}
 
+		case "diff":
+			if vd.Diff == nil {
+				content = strings.ReplaceAll(content, v.GetKey(), "N/A")
+				continue
+			}
+			switch v.Name {
+			case "id":
+				content = strings.ReplaceAll(content, v.GetKey(), vd.Diff.Id.Hex())
+			case "base_task_id":
+				content = strings.ReplaceAll(content, v.GetKey(), vd.Diff.BaseTaskId.Hex())
+			case "added_count":
+				content = strings.ReplaceAll(content, v.GetKey(), fmt.Sprintf("%d", vd.Diff.AddedCount))
+			case "removed_count":
+				content = strings.ReplaceAll(content, v.GetKey(), fmt.Sprintf("%d", vd.Diff.RemovedCount))
+			case "changed_count":
+				content = strings.ReplaceAll(content, v.GetKey(), fmt.Sprintf("%d", vd.Diff.ChangedCount))
+			case "unchanged_count":
+				content = strings.ReplaceAll(content, v.GetKey(), fmt.Sprintf("%d", vd.Diff.UnchangedCount))
+			}
+
 		}
 	}
 	return content
@@ -323,6 +343,8 @@ func (svc *ServiceV2) getVariableData(args ...any) (vd VariableData) {
 			vd.Alert = arg.(*models.NotificationAlertV2)
 		case *models.MetricV2:
 			vd.Metric = arg.(*models.MetricV2)
+		case *models.ResultDiffV2:
+			vd.Diff = arg.(*models.ResultDiffV2)
 		}
 	}
 	return vd
@@ -2,6 +2,7 @@ package notification
 
 import (
 	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +37,19 @@ func TestParseTemplateVariables_WithRepeatedVariables_ReturnsUniqueVariables(t *
 	// contains all expected variables
 	assert.ElementsMatch(t, expected, variables)
 }
+
+func TestGeContentWithVariables_WithDiff_ReturnsCounts(t *testing.T) {
+	svc := ServiceV2{}
+	template := "${diff:added_count} added, ${diff:removed_count} removed, ${diff:changed_count} changed"
+	variables := svc.parseTemplateVariables(template)
+
+	content := svc.geContentWithVariables(template, variables, svc.getVariableData(&models.ResultDiffV2{
+		AddedCount:   1,
+		RemovedCount: 2,
+		ChangedCount: 3,
+	}))
+	assert.Equal(t, "1 added, 2 removed, 3 changed", content)
+
+	content = svc.geContentWithVariables(template, variables, svc.getVariableData())
+	assert.Equal(t, "N/A added, N/A removed, N/A changed", content)
+}
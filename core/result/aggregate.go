@@ -0,0 +1,328 @@
+package result
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/db/generic"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"time"
+)
+
+// Aggregator is a result service supporting aggregation queries
+type Aggregator interface {
+	Aggregate(query generic.ListQuery, agg *entity.ResultAggregation) (res *entity.ResultAggregationResult, err error)
+}
+
+// limits of aggregation queries
+const (
+	aggregationDefaultLimit      = 100
+	aggregationMaxLimit          = 1000
+	aggregationDefaultFacetLimit = 10
+	aggregationMaxGroupBy        = 5
+	aggregationMaxMetrics        = 10
+	aggregationMaxFacets         = 10
+	aggregationMaxTime           = 30 * time.Second
+)
+
+// aggregationFieldRegexp matches field names allowed in aggregation queries, which may be nested
+// with dots but never reference operators or expressions
+var aggregationFieldRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+var aggregationMetricOps = map[string]bool{
+	constants.ResultMetricOpCount: true,
+	constants.ResultMetricOpSum:   true,
+	constants.ResultMetricOpAvg:   true,
+	constants.ResultMetricOpMin:   true,
+	constants.ResultMetricOpMax:   true,
+}
+
+// mongoBucketFormats are formats of $dateToString of time buckets
+var mongoBucketFormats = map[string]string{
+	constants.ResultBucketHour:  "%Y-%m-%dT%H:00",
+	constants.ResultBucketDay:   "%Y-%m-%d",
+	constants.ResultBucketWeek:  "%G-W%V",
+	constants.ResultBucketMonth: "%Y-%m",
+	constants.ResultBucketYear:  "%Y",
+}
+
+// Aggregate runs an aggregation query over results of a spider matching the query
+func Aggregate(spiderId primitive.ObjectID, query generic.ListQuery, agg *entity.ResultAggregation) (res *entity.ResultAggregationResult, err error) {
+	if err := ValidateAggregation(agg); err != nil {
+		return nil, err
+	}
+	svc, err := GetResultService(spiderId)
+	if err != nil {
+		return nil, err
+	}
+	aggregator, ok := svc.(Aggregator)
+	if !ok {
+		return nil, errors.New("aggregation is not supported by the data source of results")
+	}
+	return aggregator.Aggregate(query, agg)
+}
+
+// ValidateAggregation checks an aggregation query against whitelisted functions, time buckets and
+// limits, and sets default limits
+func ValidateAggregation(agg *entity.ResultAggregation) (err error) {
+	if len(agg.GroupBy) == 0 && len(agg.Metrics) == 0 && len(agg.Facets) == 0 {
+		return errors.New("no group-by fields, metrics or facets")
+	}
+	if len(agg.GroupBy) > aggregationMaxGroupBy {
+		return errors.New(fmt.Sprintf("too many group-by fields (max: %d)", aggregationMaxGroupBy))
+	}
+	if len(agg.Metrics) > aggregationMaxMetrics {
+		return errors.New(fmt.Sprintf("too many metrics (max: %d)", aggregationMaxMetrics))
+	}
+	if len(agg.Facets) > aggregationMaxFacets {
+		return errors.New(fmt.Sprintf("too many facets (max: %d)", aggregationMaxFacets))
+	}
+
+	// group-by fields
+	names := map[string]bool{}
+	for _, g := range agg.GroupBy {
+		if !aggregationFieldRegexp.MatchString(g.Field) {
+			return errors.New(fmt.Sprintf("invalid group-by field: %s", g.Field))
+		}
+		if _, ok := mongoBucketFormats[g.Bucket]; g.Bucket != "" && !ok {
+			return errors.New(fmt.Sprintf("invalid time bucket: %s", g.Bucket))
+		}
+		if names[g.GetName()] {
+			return errors.New(fmt.Sprintf("duplicate column: %s", g.GetName()))
+		}
+		names[g.GetName()] = true
+	}
+
+	// metrics
+	for _, m := range agg.Metrics {
+		if !aggregationMetricOps[m.Op] {
+			return errors.New(fmt.Sprintf("invalid metric: %s", m.Op))
+		}
+		if m.Op != constants.ResultMetricOpCount && !aggregationFieldRegexp.MatchString(m.Field) {
+			return errors.New(fmt.Sprintf("invalid metric field: %s", m.Field))
+		}
+		if names[m.GetName()] {
+			return errors.New(fmt.Sprintf("duplicate column: %s", m.GetName()))
+		}
+		names[m.GetName()] = true
+	}
+
+	// sort
+	for _, s := range agg.Sort {
+		if !names[s.Key] {
+			return errors.New(fmt.Sprintf("invalid sort column: %s", s.Key))
+		}
+		if s.Direction != generic.SortDirectionAsc && s.Direction != generic.SortDirectionDesc {
+			return errors.New(fmt.Sprintf("invalid sort direction: %s", s.Direction))
+		}
+	}
+
+	// facets
+	for _, f := range agg.Facets {
+		if !aggregationFieldRegexp.MatchString(f) {
+			return errors.New(fmt.Sprintf("invalid facet field: %s", f))
+		}
+	}
+
+	// limits
+	if agg.Limit <= 0 {
+		agg.Limit = aggregationDefaultLimit
+	} else if agg.Limit > aggregationMaxLimit {
+		agg.Limit = aggregationMaxLimit
+	}
+	if agg.FacetLimit <= 0 {
+		agg.FacetLimit = aggregationDefaultFacetLimit
+	} else if agg.FacetLimit > aggregationMaxLimit {
+		agg.FacetLimit = aggregationMaxLimit
+	}
+
+	return nil
+}
+
+// GetAggregationSort returns sort of groups by aliases of output columns, i.e. "g<i>" of the i-th
+// group-by field and "m<i>" of the i-th metric. Groups are sorted by the first metric descending,
+// or by group-by fields ascending without metrics, if not specified.
+func GetAggregationSort(agg *entity.ResultAggregation) (sort []generic.ListSort) {
+	aliases := map[string]string{}
+	for i, g := range agg.GroupBy {
+		aliases[g.GetName()] = fmt.Sprintf("g%d", i)
+	}
+	for i, m := range agg.Metrics {
+		aliases[m.GetName()] = fmt.Sprintf("m%d", i)
+	}
+	for _, s := range agg.Sort {
+		sort = append(sort, generic.ListSort{Key: aliases[s.Key], Direction: s.Direction})
+	}
+	if len(sort) > 0 {
+		return sort
+	}
+	if len(agg.Metrics) > 0 {
+		sort = append(sort, generic.ListSort{Key: "m0", Direction: generic.SortDirectionDesc})
+	}
+	for i := range agg.GroupBy {
+		sort = append(sort, generic.ListSort{Key: fmt.Sprintf("g%d", i), Direction: generic.SortDirectionAsc})
+	}
+	return sort
+}
+
+// AggregateMongo runs an aggregation query over results in a mongo collection with the
+// aggregation pipeline
+func AggregateMongo(col *mongo.Col, query bson.M, agg *entity.ResultAggregation) (res *entity.ResultAggregationResult, err error) {
+	res = &entity.ResultAggregationResult{Rows: []map[string]interface{}{}}
+	opts := options.Aggregate().SetMaxTime(aggregationMaxTime).SetAllowDiskUse(true)
+
+	// groups
+	if len(agg.GroupBy) > 0 || len(agg.Metrics) > 0 {
+		var docs []bson.M
+		if err := col.Aggregate(newMongoGroupPipeline(query, agg), opts).All(&docs); err != nil {
+			return nil, trace.TraceError(err)
+		}
+		for _, doc := range docs {
+			res.Rows = append(res.Rows, getMongoGroupRow(doc, agg))
+		}
+	}
+
+	// facets
+	if len(agg.Facets) > 0 {
+		var docs []bson.M
+		if err := col.Aggregate(newMongoFacetPipeline(query, agg), opts).All(&docs); err != nil {
+			return nil, trace.TraceError(err)
+		}
+		res.Facets = map[string][]entity.ResultFacetValue{}
+		for i, f := range agg.Facets {
+			res.Facets[f] = []entity.ResultFacetValue{}
+			if len(docs) == 0 {
+				continue
+			}
+			values, _ := docs[0][fmt.Sprintf("f%d", i)].(bson.A)
+			for _, v := range values {
+				doc, ok := v.(bson.M)
+				if !ok {
+					continue
+				}
+				res.Facets[f] = append(res.Facets[f], entity.ResultFacetValue{
+					Value: doc["_id"],
+					Count: toInt64(doc["count"]),
+				})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// newMongoGroupPipeline returns the pipeline grouping results by group-by fields with aliases
+// "g<i>" in _id and computing metrics with aliases "m<i>"
+func newMongoGroupPipeline(query bson.M, agg *entity.ResultAggregation) (pipeline mongo2.Pipeline) {
+	if len(query) > 0 {
+		pipeline = append(pipeline, bson.D{{"$match", query}})
+	}
+
+	// group
+	var groupId interface{}
+	if len(agg.GroupBy) > 0 {
+		id := bson.D{}
+		for i, g := range agg.GroupBy {
+			id = append(id, bson.E{Key: fmt.Sprintf("g%d", i), Value: getMongoGroupByExpr(g)})
+		}
+		groupId = id
+	}
+	group := bson.D{{"_id", groupId}}
+	for i, m := range agg.Metrics {
+		group = append(group, bson.E{Key: fmt.Sprintf("m%d", i), Value: getMongoMetricExpr(m)})
+	}
+	pipeline = append(pipeline, bson.D{{"$group", group}})
+
+	// sort
+	sort := bson.D{}
+	for _, s := range GetAggregationSort(agg) {
+		key := s.Key
+		if key[0] == 'g' {
+			key = "_id." + key
+		}
+		direction := 1
+		if s.Direction == generic.SortDirectionDesc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: key, Value: direction})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: 1})
+	pipeline = append(pipeline, bson.D{{"$sort", sort}})
+
+	// limit
+	pipeline = append(pipeline, bson.D{{"$limit", agg.Limit}})
+
+	return pipeline
+}
+
+// newMongoFacetPipeline returns the pipeline counting top values of facets with aliases "f<i>"
+func newMongoFacetPipeline(query bson.M, agg *entity.ResultAggregation) (pipeline mongo2.Pipeline) {
+	if len(query) > 0 {
+		pipeline = append(pipeline, bson.D{{"$match", query}})
+	}
+	facets := bson.D{}
+	for i, f := range agg.Facets {
+		facets = append(facets, bson.E{Key: fmt.Sprintf("f%d", i), Value: bson.A{
+			bson.D{{"$group", bson.D{{"_id", "$" + f}, {"count", bson.D{{"$sum", 1}}}}}},
+			bson.D{{"$sort", bson.D{{"count", -1}, {"_id", 1}}}},
+			bson.D{{"$limit", agg.FacetLimit}},
+		}})
+	}
+	return append(pipeline, bson.D{{"$facet", facets}})
+}
+
+func getMongoGroupByExpr(g entity.ResultGroupBy) interface{} {
+	if g.Bucket == "" {
+		return "$" + g.Field
+	}
+	return bson.D{{"$dateToString", bson.D{
+		{"format", mongoBucketFormats[g.Bucket]},
+		{"date", bson.D{{"$convert", bson.D{
+			{"input", "$" + g.Field},
+			{"to", "date"},
+			{"onError", nil},
+			{"onNull", nil},
+		}}}},
+	}}}
+}
+
+func getMongoMetricExpr(m entity.ResultMetric) interface{} {
+	if m.Op == constants.ResultMetricOpCount {
+		return bson.D{{"$sum", 1}}
+	}
+	return bson.D{{"$" + m.Op, "$" + m.Field}}
+}
+
+func getMongoGroupRow(doc bson.M, agg *entity.ResultAggregation) (row map[string]interface{}) {
+	row = map[string]interface{}{}
+	id, _ := doc["_id"].(bson.M)
+	for i, g := range agg.GroupBy {
+		row[g.GetName()] = id[fmt.Sprintf("g%d", i)]
+	}
+	for i, m := range agg.Metrics {
+		row[m.GetName()] = doc[fmt.Sprintf("m%d", i)]
+	}
+	return row
+}
+
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,104 @@
+package result
+
+import (
+	"testing"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/db/generic"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValidateAggregation(t *testing.T) {
+	agg := &entity.ResultAggregation{
+		GroupBy: []entity.ResultGroupBy{{Field: "category"}, {Field: "meta.ts", Bucket: constants.ResultBucketDay, Name: "day"}},
+		Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpCount}, {Op: constants.ResultMetricOpAvg, Field: "price"}},
+		Sort:    []generic.ListSort{{Key: "avg_price", Direction: generic.SortDirectionDesc}},
+		Facets:  []string{"brand"},
+	}
+	require.Nil(t, ValidateAggregation(agg))
+	require.Equal(t, aggregationDefaultLimit, agg.Limit)
+	require.Equal(t, aggregationDefaultFacetLimit, agg.FacetLimit)
+
+	agg.Limit = 100000
+	require.Nil(t, ValidateAggregation(agg))
+	require.Equal(t, aggregationMaxLimit, agg.Limit)
+
+	invalid := []*entity.ResultAggregation{
+		{},
+		{GroupBy: []entity.ResultGroupBy{{Field: "$where"}}},
+		{GroupBy: []entity.ResultGroupBy{{Field: "a", Bucket: "minute"}}},
+		{GroupBy: []entity.ResultGroupBy{{Field: "a"}, {Field: "b", Name: "a"}}},
+		{Metrics: []entity.ResultMetric{{Op: "push", Field: "a"}}},
+		{Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpSum}}},
+		{Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpSum, Field: "a"}}, Sort: []generic.ListSort{{Key: "a", Direction: generic.SortDirectionAsc}}},
+		{Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpCount}}, Sort: []generic.ListSort{{Key: "count", Direction: "up"}}},
+		{Facets: []string{"a b"}},
+	}
+	for _, agg := range invalid {
+		require.NotNil(t, ValidateAggregation(agg))
+	}
+}
+
+func TestGetAggregationSort(t *testing.T) {
+	agg := &entity.ResultAggregation{
+		GroupBy: []entity.ResultGroupBy{{Field: "category"}, {Field: "ts", Bucket: constants.ResultBucketDay}},
+		Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpCount}},
+	}
+	require.Equal(t, []generic.ListSort{
+		{Key: "m0", Direction: generic.SortDirectionDesc},
+		{Key: "g0", Direction: generic.SortDirectionAsc},
+		{Key: "g1", Direction: generic.SortDirectionAsc},
+	}, GetAggregationSort(agg))
+
+	agg.Sort = []generic.ListSort{{Key: "ts", Direction: generic.SortDirectionAsc}}
+	require.Equal(t, []generic.ListSort{{Key: "g1", Direction: generic.SortDirectionAsc}}, GetAggregationSort(agg))
+}
+
+func TestNewMongoGroupPipeline(t *testing.T) {
+	agg := &entity.ResultAggregation{
+		GroupBy: []entity.ResultGroupBy{{Field: "category"}, {Field: "ts", Bucket: constants.ResultBucketDay, Name: "day"}},
+		Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpCount}, {Op: constants.ResultMetricOpSum, Field: "price"}},
+		Limit:   10,
+	}
+	pipeline := newMongoGroupPipeline(bson.M{"_tid": "t"}, agg)
+	require.Len(t, pipeline, 4)
+	require.Equal(t, bson.D{{"$match", bson.M{"_tid": "t"}}}, pipeline[0])
+	require.Equal(t, bson.D{{"$group", bson.D{
+		{"_id", bson.D{
+			{"g0", "$category"},
+			{"g1", bson.D{{"$dateToString", bson.D{
+				{"format", "%Y-%m-%d"},
+				{"date", bson.D{{"$convert", bson.D{{"input", "$ts"}, {"to", "date"}, {"onError", nil}, {"onNull", nil}}}}},
+			}}}},
+		}},
+		{"m0", bson.D{{"$sum", 1}}},
+		{"m1", bson.D{{"$sum", "$price"}}},
+	}}}, pipeline[1])
+	require.Equal(t, bson.D{{"$sort", bson.D{{"m0", -1}, {"_id.g0", 1}, {"_id.g1", 1}, {"_id", 1}}}}, pipeline[2])
+	require.Equal(t, bson.D{{"$limit", 10}}, pipeline[3])
+
+	// rows
+	row := getMongoGroupRow(bson.M{
+		"_id": bson.M{"g0": "books", "g1": "2024-03-01"},
+		"m0":  int32(3),
+		"m1":  12.5,
+	}, agg)
+	require.Equal(t, map[string]interface{}{"category": "books", "day": "2024-03-01", "count": int32(3), "sum_price": 12.5}, row)
+
+	// totals without group-by fields
+	agg = &entity.ResultAggregation{Metrics: []entity.ResultMetric{{Op: constants.ResultMetricOpMax, Field: "price"}}, Limit: 10}
+	pipeline = newMongoGroupPipeline(nil, agg)
+	require.Equal(t, bson.D{{"$group", bson.D{{"_id", nil}, {"m0", bson.D{{"$max", "$price"}}}}}}, pipeline[0])
+}
+
+func TestNewMongoFacetPipeline(t *testing.T) {
+	agg := &entity.ResultAggregation{Facets: []string{"brand"}, FacetLimit: 5}
+	pipeline := newMongoFacetPipeline(nil, agg)
+	require.Equal(t, bson.D{{"$facet", bson.D{{"f0", bson.A{
+		bson.D{{"$group", bson.D{{"_id", "$brand"}, {"count", bson.D{{"$sum", 1}}}}}},
+		bson.D{{"$sort", bson.D{{"count", -1}, {"_id", 1}}}},
+		bson.D{{"$limit", 5}},
+	}}}}}, pipeline[0])
+}
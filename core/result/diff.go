@@ -0,0 +1,439 @@
+package result
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/db/generic"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DiffOptions are options of diffing results of two tasks
+type DiffOptions struct {
+	Keys         []string // key fields to match records on, dedup keys of the data collection if empty
+	IgnoreFields []string // fields excluded from comparison
+	Limit        int      // max number of listed records of each kind, 1000 if zero
+	Save         bool     // whether to save the diff
+}
+
+// diffBatchSize is the number of result records fetched from result service at a time
+const diffBatchSize = 1000
+
+// diffIgnoredFields are internal fields of result records excluded from comparison
+var diffIgnoredFields = []string{"_id", constants.TaskKey, constants.HashKey, constants.LineageKey}
+
+// DiffTaskResults compares results of a task against those of a base task of the same spider,
+// returning added, removed and changed records
+func DiffTaskResults(baseTaskId, taskId primitive.ObjectID, opts *DiffOptions) (diff *models2.ResultDiffV2, err error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+
+	// tasks
+	taskModelSvc := service.NewModelServiceV2[models2.TaskV2]()
+	baseTask, err := taskModelSvc.GetById(baseTaskId)
+	if err != nil {
+		return nil, err
+	}
+	task, err := taskModelSvc.GetById(taskId)
+	if err != nil {
+		return nil, err
+	}
+	if baseTask.SpiderId != task.SpiderId {
+		return nil, errors.New("tasks do not belong to the same spider")
+	}
+
+	// keys
+	dc, err := getDiffDataCollection(task.SpiderId)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := getDiffKeys(dc, opts.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// result service
+	resultSvc, err := GetResultService(task.SpiderId)
+	if err != nil {
+		return nil, err
+	}
+
+	// diff
+	d := newResultDiffer(keys, opts.IgnoreFields, opts.Limit)
+	if err := iterateTaskResults(resultSvc, baseTaskId, d.AddBase); err != nil {
+		return nil, err
+	}
+	if err := iterateTaskResults(resultSvc, taskId, func(record bson.M) error {
+		d.AddTarget(record)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if d.NeedsBaseRecords() {
+		// second pass over base records to list removed and changed records
+		if err := iterateTaskResults(resultSvc, baseTaskId, func(record bson.M) error {
+			d.AddBaseRecord(record)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	diff = d.Diff()
+	diff.SpiderId = task.SpiderId
+	diff.BaseTaskId = baseTaskId
+	diff.TaskId = taskId
+
+	// save
+	if opts.Save {
+		diff.SetCreated(task.CreatedBy)
+		diff.SetUpdated(task.CreatedBy)
+		diff.Id, err = service.NewModelServiceV2[models2.ResultDiffV2]().InsertOne(*diff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+// GetPreviousTask returns the latest task of the same spider created before a task, whose results
+// are the default base of diffing
+func GetPreviousTask(taskId primitive.ObjectID) (prev *models2.TaskV2, err error) {
+	taskModelSvc := service.NewModelServiceV2[models2.TaskV2]()
+	task, err := taskModelSvc.GetById(taskId)
+	if err != nil {
+		return nil, err
+	}
+	return taskModelSvc.GetOne(bson.M{
+		"spider_id": task.SpiderId,
+		"_id":       bson.M{"$lt": task.Id},
+		"status":    constants.TaskStatusFinished,
+	}, &mongo.FindOptions{Sort: bson.D{{"_id", -1}}})
+}
+
+// getDiffDataCollection returns the data collection of a spider, or nil if the spider has none
+func getDiffDataCollection(spiderId primitive.ObjectID) (dc *models2.DataCollectionV2, err error) {
+	spider, err := service.NewModelServiceV2[models2.SpiderV2]().GetById(spiderId)
+	if err != nil {
+		return nil, err
+	}
+	if spider.ColId.IsZero() {
+		return nil, nil
+	}
+	return service.NewModelServiceV2[models2.DataCollectionV2]().GetById(spider.ColId)
+}
+
+// getDiffKeys returns key fields to match records on, which are dedup keys of the data collection
+// if not specified. Results of a data collection with dedup enabled cannot be diffed, as a record
+// is stored once for all tasks and only carries the task id of the first or the last task.
+func getDiffKeys(dc *models2.DataCollectionV2, keys []string) (res []string, err error) {
+	if dc != nil && dc.Dedup.Enabled {
+		return nil, errors.New("cannot diff results of a data collection with dedup enabled, as records are shared by tasks")
+	}
+	if len(keys) > 0 {
+		return keys, nil
+	}
+	if dc == nil || len(dc.Dedup.Keys) == 0 {
+		return nil, errors.New("no key fields to match records on, please specify keys or set dedup keys of the data collection")
+	}
+	return dc.Dedup.Keys, nil
+}
+
+// iterateTaskResults calls fn with result records of a task in batches
+func iterateTaskResults(resultSvc interfaces.ResultService, taskId primitive.ObjectID, fn func(record bson.M) error) (err error) {
+	query := generic.ListQuery{
+		generic.ListQueryCondition{
+			Key:   constants.TaskKey,
+			Op:    generic.OpEqual,
+			Value: taskId,
+		},
+	}
+	for skip := 0; ; skip += diffBatchSize {
+		results, err := resultSvc.List(query, &generic.ListOptions{
+			Skip:  skip,
+			Limit: diffBatchSize,
+			Sort:  []generic.ListSort{{"_id", generic.SortDirectionAsc}},
+		})
+		if err != nil {
+			return trace.TraceError(err)
+		}
+		for _, r := range results {
+			if record, ok := toDiffRecord(r); ok {
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+		}
+		if len(results) < diffBatchSize {
+			return nil
+		}
+	}
+}
+
+func toDiffRecord(r interface{}) (record bson.M, ok bool) {
+	switch r := r.(type) {
+	case bson.M:
+		return r, true
+	case map[string]interface{}:
+		return r, true
+	case interfaces.Result:
+		return r.Value(), true
+	default:
+		return nil, false
+	}
+}
+
+// diffMaxBaseRecords is the max number of distinct base records of a diff
+const diffMaxBaseRecords = 1000000
+
+// diffHash is a md5 hash of key fields or compared fields of a record
+type diffHash [md5.Size]byte
+
+// resultDiffer matches records of a target task against records of a base task on key fields.
+// Only hashes of base records are held in memory, and removed and changed records are listed by
+// adding base records again in a second pass, so that memory usage does not grow with the size
+// of records.
+type resultDiffer struct {
+	keys    []string
+	ignored map[string]bool
+	limit   int
+
+	base        map[diffHash]diffHash // hash of compared fields of base records by hash of key fields
+	matched     map[diffHash]bool     // keys of target records
+	matchedBase int                   // number of base records matched by target records
+	changed     map[diffHash]bson.M   // changed target records to be listed
+	listed      map[diffHash]bool     // keys of base records added in the second pass
+	diff        *models2.ResultDiffV2
+}
+
+// AddBase adds a record of the base task
+func (d *resultDiffer) AddBase(record bson.M) (err error) {
+	k := d.getKey(record)
+	if _, ok := d.base[k]; ok {
+		// duplicate, keep the first one
+		return nil
+	}
+	if len(d.base) >= diffMaxBaseRecords {
+		return errors.New(fmt.Sprintf("too many results of base task to diff, max %d", diffMaxBaseRecords))
+	}
+	d.base[k] = d.getHash(record)
+	return nil
+}
+
+// AddTarget compares a record of the target task with the matched base record. Records with
+// duplicate keys in the target task are ignored.
+func (d *resultDiffer) AddTarget(record bson.M) {
+	k := d.getKey(record)
+	if d.matched[k] {
+		return
+	}
+	d.matched[k] = true
+
+	h, ok := d.base[k]
+	if !ok {
+		d.diff.AddedCount++
+		if len(d.diff.Added) < d.limit {
+			d.diff.Added = append(d.diff.Added, d.strip(record))
+		} else {
+			d.diff.Truncated = true
+		}
+		return
+	}
+	d.matchedBase++
+
+	if h == d.getHash(record) {
+		d.diff.UnchangedCount++
+		return
+	}
+	d.diff.ChangedCount++
+	if len(d.changed) < d.limit {
+		d.changed[k] = record
+	} else {
+		d.diff.Truncated = true
+	}
+}
+
+// NeedsBaseRecords returns whether base records are to be added again by AddBaseRecord to list
+// removed and changed records
+func (d *resultDiffer) NeedsBaseRecords() bool {
+	return len(d.changed) > 0 || d.matchedBase < len(d.base)
+}
+
+// AddBaseRecord adds a record of the base task again after all target records are added, which
+// is listed if removed or changed
+func (d *resultDiffer) AddBaseRecord(record bson.M) {
+	k := d.getKey(record)
+	if d.listed[k] {
+		return
+	}
+	d.listed[k] = true
+
+	if !d.matched[k] {
+		if len(d.diff.Removed) < d.limit {
+			d.diff.Removed = append(d.diff.Removed, d.strip(record))
+		} else {
+			d.diff.Truncated = true
+		}
+		return
+	}
+
+	targetRecord, ok := d.changed[k]
+	if !ok {
+		return
+	}
+	fields := d.compare(record, targetRecord)
+	if len(fields) == 0 {
+		// equal values of different types
+		d.diff.ChangedCount--
+		d.diff.UnchangedCount++
+		return
+	}
+	d.diff.Changed = append(d.diff.Changed, entity.ResultChange{
+		Key:    d.getKeyValues(targetRecord),
+		Fields: fields,
+	})
+}
+
+// Diff returns the diff, with base records not matched by any target record as removed
+func (d *resultDiffer) Diff() (diff *models2.ResultDiffV2) {
+	d.diff.RemovedCount = len(d.base) - d.matchedBase
+	d.diff.Keys = d.keys
+	return d.diff
+}
+
+// compare returns changed fields between two records, sorted by field name
+func (d *resultDiffer) compare(oldRecord, newRecord bson.M) (fields []entity.ResultFieldDiff) {
+	names := map[string]bool{}
+	for k := range oldRecord {
+		names[k] = true
+	}
+	for k := range newRecord {
+		names[k] = true
+	}
+	var sortedNames []string
+	for k := range names {
+		if d.ignored[k] {
+			continue
+		}
+		sortedNames = append(sortedNames, k)
+	}
+	sort.Strings(sortedNames)
+
+	for _, k := range sortedNames {
+		oldValue, newValue := oldRecord[k], newRecord[k]
+		if diffValuesEqual(oldValue, newValue) {
+			continue
+		}
+		fields = append(fields, entity.ResultFieldDiff{Field: k, Old: oldValue, New: newValue})
+	}
+	return fields
+}
+
+func (d *resultDiffer) getKeyValues(record bson.M) (values bson.M) {
+	values = bson.M{}
+	for _, k := range d.keys {
+		values[k] = record[k]
+	}
+	return values
+}
+
+func (d *resultDiffer) getKey(record bson.M) diffHash {
+	var values []interface{}
+	for _, k := range d.keys {
+		values = append(values, normalizeDiffValue(record[k]))
+	}
+	data, _ := json.Marshal(values)
+	return md5.Sum(data)
+}
+
+// getHash returns the hash of compared fields of a record, which is equal for records without
+// changed fields
+func (d *resultDiffer) getHash(record bson.M) (res diffHash) {
+	var names []string
+	for k, v := range record {
+		if d.ignored[k] || v == nil {
+			continue
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	h := md5.New()
+	for _, k := range names {
+		_, _ = fmt.Fprintf(h, "%q:%#v\n", k, normalizeDiffValue(record[k]))
+	}
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+// strip removes internal fields of a record
+func (d *resultDiffer) strip(record bson.M) (res bson.M) {
+	res = bson.M{}
+	for k, v := range record {
+		if k == constants.TaskKey || k == constants.HashKey || k == constants.LineageKey {
+			continue
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// diffValuesEqual returns whether two field values are equal, regardless of numeric types
+func diffValuesEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeDiffValue(a), normalizeDiffValue(b))
+}
+
+// normalizeDiffValue converts numbers to float64 and times to utc time
+func normalizeDiffValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case time.Time:
+		return v.UTC()
+	default:
+		return v
+	}
+}
+
+func newResultDiffer(keys, ignoreFields []string, limit int) (d *resultDiffer) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	d = &resultDiffer{
+		keys:    keys,
+		ignored: map[string]bool{},
+		limit:   limit,
+		base:    map[diffHash]diffHash{},
+		matched: map[diffHash]bool{},
+		changed: map[diffHash]bson.M{},
+		listed:  map[diffHash]bool{},
+		diff:    &models2.ResultDiffV2{},
+	}
+	for _, k := range diffIgnoredFields {
+		d.ignored[k] = true
+	}
+	for _, k := range ignoreFields {
+		d.ignored[k] = true
+	}
+	return d
+}
@@ -0,0 +1,104 @@
+package result
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestResultDiffer(t *testing.T) {
+	d := newResultDiffer([]string{"sku"}, []string{"crawled_at"}, 0)
+
+	// base task
+	base := []bson.M{
+		{"_id": primitive.NewObjectID(), constants.TaskKey: primitive.NewObjectID(), "sku": "a", "price": int32(10), "crawled_at": 1},
+		{"_id": primitive.NewObjectID(), "sku": "b", "price": 20.0, "title": "B"},
+		{"_id": primitive.NewObjectID(), "sku": "c", "price": 30},
+		{"_id": primitive.NewObjectID(), "sku": "c", "price": 31}, // duplicate
+	}
+	for _, record := range base {
+		require.Nil(t, d.AddBase(record))
+	}
+
+	// target task
+	d.AddTarget(bson.M{"_id": primitive.NewObjectID(), constants.TaskKey: primitive.NewObjectID(), "sku": "a", "price": 10.0, "crawled_at": 2})
+	d.AddTarget(bson.M{"_id": primitive.NewObjectID(), "sku": "b", "price": 18.5})
+	d.AddTarget(bson.M{"_id": primitive.NewObjectID(), constants.TaskKey: primitive.NewObjectID(), "sku": "d", "price": 40})
+	d.AddTarget(bson.M{"_id": primitive.NewObjectID(), "sku": "d", "price": 41}) // duplicate
+
+	// second pass
+	require.True(t, d.NeedsBaseRecords())
+	for _, record := range base {
+		d.AddBaseRecord(record)
+	}
+
+	diff := d.Diff()
+	require.Equal(t, []string{"sku"}, diff.Keys)
+	require.Equal(t, 1, diff.AddedCount)
+	require.Equal(t, 1, diff.RemovedCount)
+	require.Equal(t, 1, diff.ChangedCount)
+	require.Equal(t, 1, diff.UnchangedCount)
+	require.True(t, diff.HasChanges())
+	require.False(t, diff.Truncated)
+
+	require.Equal(t, "d", diff.Added[0]["sku"])
+	require.NotContains(t, diff.Added[0], constants.TaskKey)
+	require.Len(t, diff.Removed, 1)
+	require.Equal(t, 30, diff.Removed[0]["price"])
+	require.Equal(t, bson.M{"sku": "b"}, diff.Changed[0].Key)
+	require.Equal(t, []entity.ResultFieldDiff{
+		{Field: "price", Old: 20.0, New: 18.5},
+		{Field: "title", Old: "B", New: nil},
+	}, diff.Changed[0].Fields)
+}
+
+func TestResultDiffer_Limit(t *testing.T) {
+	d := newResultDiffer([]string{"sku", "store"}, nil, 1)
+	d.AddTarget(bson.M{"sku": "a", "store": 1})
+	d.AddTarget(bson.M{"sku": "a", "store": 2})
+
+	diff := d.Diff()
+	require.Equal(t, 2, diff.AddedCount)
+	require.Len(t, diff.Added, 1)
+	require.True(t, diff.Truncated)
+	require.True(t, diff.HasChanges())
+}
+
+func TestResultDiffer_Unchanged(t *testing.T) {
+	d := newResultDiffer([]string{"sku"}, nil, 0)
+	require.Nil(t, d.AddBase(bson.M{"_id": primitive.NewObjectID(), "sku": "a", "price": int64(10), "title": nil}))
+	d.AddTarget(bson.M{"_id": primitive.NewObjectID(), "sku": "a", "price": 10.0})
+
+	// no second pass without removed or changed records
+	require.False(t, d.NeedsBaseRecords())
+	diff := d.Diff()
+	require.Equal(t, 1, diff.UnchangedCount)
+	require.False(t, diff.HasChanges())
+}
+
+func TestGetDiffKeys(t *testing.T) {
+	dc := &models.DataCollectionV2{}
+	dc.Dedup.Keys = []string{"sku"}
+
+	keys, err := getDiffKeys(dc, nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"sku"}, keys)
+	keys, err = getDiffKeys(dc, []string{"url"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"url"}, keys)
+	_, err = getDiffKeys(nil, nil)
+	require.NotNil(t, err)
+
+	// records of a dedup collection are shared by tasks
+	dc.Dedup.Enabled = true
+	dc.Dedup.Type = constants.DedupTypeOverwrite
+	_, err = getDiffKeys(dc, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "dedup enabled")
+	_, err = getDiffKeys(dc, []string{"url"})
+	require.NotNil(t, err)
+}
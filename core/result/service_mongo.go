@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	"github.com/crawlab-team/crawlab/core/models/models"
 	"github.com/crawlab-team/crawlab/core/models/service"
@@ -81,6 +82,10 @@ func (svc *ServiceMongo) Insert(docs ...interface{}) (err error) {
 	return nil
 }
 
+func (svc *ServiceMongo) Aggregate(query generic.ListQuery, agg *entity.ResultAggregation) (res *entity.ResultAggregationResult, err error) {
+	return AggregateMongo(mongo.GetMongoCol(svc.dc.Name), svc.getQuery(query), agg)
+}
+
 func (svc *ServiceMongo) Index(fields []string) {
 	for _, field := range fields {
 		_ = mongo.GetMongoCol(svc.dc.Name).CreateIndex(mongo2.IndexModel{Keys: bson.M{field: 1}})
@@ -0,0 +1,208 @@
+package result
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	parser "github.com/crawlab-team/crawlab/template-parser"
+	"github.com/robertkrimen/otto"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transformTimeout is the max time of evaluating a js expression of a compute transform
+const transformTimeout = 100 * time.Millisecond
+
+var errTransformTimeout = errors.New("js expression timed out")
+
+// Transformer applies result transforms to records. JS expressions of compute transforms are
+// evaluated in a copy of the js vm of template parser, with the record as variable "record".
+// Each record gets its own copy, so that globals set by expressions do not leak into others.
+type Transformer struct {
+	transforms []entity.ResultTransform
+	regexps    map[int]*regexp.Regexp
+	scripts    map[int]*otto.Script
+	vm         *otto.Otto // base vm, which is copied and never run
+	mu         sync.Mutex // otto vm is not safe for concurrent use
+}
+
+// Transform applies transforms to a record in order. A failed transform does not stop the
+// following ones, and the errors are returned.
+func (t *Transformer) Transform(record map[string]interface{}) (errs []error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// fresh global scope of the record
+	var vm *otto.Otto
+	if len(t.scripts) > 0 {
+		vm = t.vm.Copy()
+	}
+
+	for i, tr := range t.transforms {
+		if err := t.transform(vm, i, tr, record); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("transform %d (%s %s): %v", i, tr.Type, tr.Field, err)))
+		}
+	}
+	return errs
+}
+
+func (t *Transformer) transform(vm *otto.Otto, i int, tr entity.ResultTransform, record map[string]interface{}) (err error) {
+	switch tr.Type {
+	case constants.ResultTransformTypeRename:
+		if v, ok := record[tr.Field]; ok {
+			delete(record, tr.Field)
+			record[tr.Target] = v
+		}
+	case constants.ResultTransformTypeDrop:
+		delete(record, tr.Field)
+	case constants.ResultTransformTypeTrim:
+		if tr.Field == "" {
+			for k, v := range record {
+				if s, ok := v.(string); ok {
+					record[k] = strings.TrimSpace(s)
+				}
+			}
+		} else if s, ok := record[tr.Field].(string); ok {
+			record[tr.GetTarget()] = strings.TrimSpace(s)
+		}
+	case constants.ResultTransformTypeRegex:
+		s, ok := record[tr.Field].(string)
+		if !ok {
+			return nil
+		}
+		m := t.regexps[i].FindStringSubmatch(s)
+		if m == nil {
+			record[tr.GetTarget()] = nil
+			return nil
+		}
+		group := tr.Group
+		if group == 0 && len(m) > 1 {
+			group = 1
+		}
+		record[tr.GetTarget()] = m[group]
+	case constants.ResultTransformTypeUrl:
+		s, ok := record[tr.Field].(string)
+		if !ok || s == "" {
+			return nil
+		}
+		base, _ := record[constants.SourceUrlKey].(string)
+		u, err := normalizeUrl(s, base)
+		if err != nil {
+			return err
+		}
+		record[tr.GetTarget()] = u
+	case constants.ResultTransformTypeCompute:
+		v, err := t.compute(vm, t.scripts[i], record)
+		if err != nil {
+			return err
+		}
+		record[tr.Target] = v
+	}
+	return nil
+}
+
+// compute evaluates a compiled js expression against a record, interrupting it on timeout
+func (t *Transformer) compute(vm *otto.Otto, script *otto.Script, record map[string]interface{}) (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errTransformTimeout {
+				err = errTransformTimeout
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	vm.Interrupt = make(chan func(), 1)
+	timer := time.AfterFunc(transformTimeout, func() {
+		vm.Interrupt <- func() {
+			panic(errTransformTimeout)
+		}
+	})
+	defer timer.Stop()
+
+	if err := vm.Set("record", record); err != nil {
+		return nil, err
+	}
+	v, err := vm.Run(script)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsUndefined() || v.IsNull() {
+		return nil, nil
+	}
+	return v.Export()
+}
+
+// normalizeUrl resolves a url against the base url if relative, lower-cases scheme and host,
+// removes default ports and fragment, and sorts query parameters
+func normalizeUrl(s, base string) (res string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() && base != "" {
+		b, err := url.Parse(base)
+		if err == nil {
+			u = b.ResolveReference(u)
+		}
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+	return u.String(), nil
+}
+
+// NewTransformer validates result transforms and compiles their regular expressions and js
+// expressions
+func NewTransformer(transforms []entity.ResultTransform) (t *Transformer, err error) {
+	t = &Transformer{
+		transforms: transforms,
+		regexps:    map[int]*regexp.Regexp{},
+		scripts:    map[int]*otto.Script{},
+		vm:         parser.NewVM(),
+	}
+	for i, tr := range transforms {
+		if tr.Field == "" && tr.Type != constants.ResultTransformTypeTrim && tr.Type != constants.ResultTransformTypeCompute {
+			return nil, errors.New(fmt.Sprintf("transform %d: empty field", i))
+		}
+		switch tr.Type {
+		case constants.ResultTransformTypeRename, constants.ResultTransformTypeCompute:
+			if tr.Target == "" {
+				return nil, errors.New(fmt.Sprintf("transform %d: empty target", i))
+			}
+			if tr.Type == constants.ResultTransformTypeCompute {
+				t.scripts[i], err = t.vm.Compile("", tr.Expression)
+				if err != nil {
+					return nil, errors.New(fmt.Sprintf("transform %d: invalid expression: %v", i, err))
+				}
+			}
+		case constants.ResultTransformTypeRegex:
+			t.regexps[i], err = regexp.Compile(tr.Pattern)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("transform %d: invalid pattern: %v", i, err))
+			}
+			if tr.Group < 0 || tr.Group > t.regexps[i].NumSubexp() {
+				return nil, errors.New(fmt.Sprintf("transform %d: invalid group: %d", i, tr.Group))
+			}
+		case constants.ResultTransformTypeDrop, constants.ResultTransformTypeTrim, constants.ResultTransformTypeUrl:
+		default:
+			return nil, errors.New(fmt.Sprintf("transform %d: invalid type: %s", i, tr.Type))
+		}
+	}
+	return t, nil
+}
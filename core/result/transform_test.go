@@ -0,0 +1,89 @@
+package result
+
+import (
+	"testing"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformer_Transform(t *testing.T) {
+	transformer, err := NewTransformer([]entity.ResultTransform{
+		{Type: constants.ResultTransformTypeTrim},
+		{Type: constants.ResultTransformTypeRename, Field: "name", Target: "title"},
+		{Type: constants.ResultTransformTypeDrop, Field: "debug"},
+		{Type: constants.ResultTransformTypeRegex, Field: "price_text", Target: "price", Pattern: `([\d.]+)`},
+		{Type: constants.ResultTransformTypeRegex, Field: "sku", Pattern: `^(\w+)-(\d+)$`, Group: 2},
+		{Type: constants.ResultTransformTypeUrl, Field: "link"},
+		{Type: constants.ResultTransformTypeCompute, Target: "total", Expression: "parseFloat(record.price) * record.quantity"},
+		{Type: constants.ResultTransformTypeCompute, Target: "label", Expression: "record.title.toUpperCase() + ' #' + record.sku"},
+	})
+	require.Nil(t, err)
+
+	record := map[string]interface{}{
+		"name":                 "  Book  ",
+		"debug":                true,
+		"price_text":           "$12.50",
+		"sku":                  "BK-42",
+		"link":                 "../items/1?b=2&a=1#reviews",
+		"quantity":             2,
+		constants.SourceUrlKey: "HTTPS://Example.com:443/list/page",
+	}
+	require.Empty(t, transformer.Transform(record))
+	require.Equal(t, map[string]interface{}{
+		"title":                "Book",
+		"price_text":           "$12.50",
+		"price":                "12.50",
+		"sku":                  "42",
+		"link":                 "https://example.com/items/1?a=1&b=2",
+		"quantity":             2,
+		"total":                float64(25),
+		"label":                "BOOK #42",
+		constants.SourceUrlKey: "HTTPS://Example.com:443/list/page",
+	}, record)
+}
+
+func TestTransformer_Errors(t *testing.T) {
+	// invalid transforms
+	invalid := [][]entity.ResultTransform{
+		{{Type: "upper", Field: "a"}},
+		{{Type: constants.ResultTransformTypeRename, Field: "a"}},
+		{{Type: constants.ResultTransformTypeDrop}},
+		{{Type: constants.ResultTransformTypeRegex, Field: "a", Pattern: "("}},
+		{{Type: constants.ResultTransformTypeRegex, Field: "a", Pattern: "(a)", Group: 2}},
+		{{Type: constants.ResultTransformTypeCompute, Target: "a", Expression: "record.("}},
+	}
+	for _, transforms := range invalid {
+		_, err := NewTransformer(transforms)
+		require.NotNil(t, err)
+	}
+
+	// failed transforms do not stop following ones
+	transformer, err := NewTransformer([]entity.ResultTransform{
+		{Type: constants.ResultTransformTypeCompute, Target: "a", Expression: "record.missing.field"},
+		{Type: constants.ResultTransformTypeCompute, Target: "b", Expression: "while (true) {}"},
+		{Type: constants.ResultTransformTypeCompute, Target: "c", Expression: "1 + 1"},
+	})
+	require.Nil(t, err)
+	record := map[string]interface{}{}
+	errs := transformer.Transform(record)
+	require.Len(t, errs, 2)
+	require.Contains(t, errs[1].Error(), errTransformTimeout.Error())
+	require.Equal(t, map[string]interface{}{"c": float64(2)}, record)
+}
+
+func TestTransformer_IsolatesRecords(t *testing.T) {
+	// globals set by an expression are visible to later transforms of the same record only
+	transformer, err := NewTransformer([]entity.ResultTransform{
+		{Type: constants.ResultTransformTypeCompute, Target: "seen", Expression: "typeof counter === 'undefined' ? 0 : counter"},
+		{Type: constants.ResultTransformTypeCompute, Target: "count", Expression: "counter = (typeof counter === 'undefined' ? 0 : counter) + 1"},
+		{Type: constants.ResultTransformTypeCompute, Target: "again", Expression: "counter"},
+	})
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		record := map[string]interface{}{}
+		require.Empty(t, transformer.Transform(record))
+		require.Equal(t, map[string]interface{}{"seen": int64(0), "count": float64(1), "again": float64(1)}, record)
+	}
+}
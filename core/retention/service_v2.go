@@ -0,0 +1,326 @@
+package retention
+
+import (
+	"bytes"
+	"errors"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/export"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/node/leader"
+	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"sync"
+	"time"
+)
+
+// ServiceV2 is the reaper of results, which periodically deletes results of data collections in
+// built-in mongo according to their retention policies, optionally archiving them beforehand
+type ServiceV2 struct {
+	// dependencies
+	dcModelSvc     *service.ModelServiceV2[models2.DataCollectionV2]
+	spiderModelSvc *service.ModelServiceV2[models2.SpiderV2]
+	runModelSvc    *service.ModelServiceV2[models2.RetentionRunV2]
+
+	// settings
+	interval time.Duration
+
+	// internals
+	running sync.Map // names of data collections whose results are being reaped
+	stopped bool
+}
+
+func (svc *ServiceV2) Start() {
+	for {
+		if svc.stopped {
+			return
+		}
+
+		if leader.IsLeader() {
+			if _, err := svc.RunAll(false); err != nil {
+				trace.PrintError(err)
+			}
+		}
+
+		time.Sleep(svc.interval)
+	}
+}
+
+func (svc *ServiceV2) Stop() {
+	svc.stopped = true
+}
+
+// RunAll applies retention policies to all data collections, including spider result collections
+// without data collection records which are subject to the global policy, and returns reports of
+// those with results matched by their policies
+func (svc *ServiceV2) RunAll(dryRun bool) (runs []*models2.RetentionRunV2, err error) {
+	dcs, err := svc.dcModelSvc.GetMany(bson.M{}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return nil, err
+	}
+	spiders, err := svc.spiderModelSvc.GetMany(bson.M{}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return nil, err
+	}
+	for _, colName := range getSpiderResultColNames(spiders, dcs) {
+		dcs = append(dcs, models2.DataCollectionV2{Name: colName})
+	}
+
+	for i := range dcs {
+		run, err := svc.Run(&dcs[i], dryRun)
+		if err != nil {
+			log.Errorf("[RetentionServiceV2] failed to apply retention policy of data collection %s: %v", dcs[i].Name, err)
+			continue
+		}
+		if run != nil && (run.MatchedCount > 0 || run.Error != "") {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// Run applies the retention policy of a data collection, and returns the report, or nil if the
+// policy does not limit results. Results are only counted in dry run. Reports of actual runs
+// with matched results are saved.
+func (svc *ServiceV2) Run(dc *models2.DataCollectionV2, dryRun bool) (run *models2.RetentionRunV2, err error) {
+	policy := GetPolicy(dc)
+	if policy.IsEmpty() || dc.Name == "" {
+		return nil, nil
+	}
+
+	// skip if results are being reaped
+	if !dryRun {
+		if _, loaded := svc.running.LoadOrStore(dc.Name, true); loaded {
+			return nil, errors.New("results of data collection are being reaped")
+		}
+		defer svc.running.Delete(dc.Name)
+	}
+
+	// run
+	run = &models2.RetentionRunV2{
+		DataCollectionId: dc.Id,
+		ColName:          dc.Name,
+		Policy:           policy,
+		DryRun:           dryRun,
+		Status:           constants.TaskStatusRunning,
+		StartTs:          time.Now(),
+	}
+	if err := svc.run(run); err != nil {
+		run.Status = constants.TaskStatusError
+		run.Error = err.Error()
+		log.Errorf("[RetentionServiceV2] retention run error (data collection: %s): %v", dc.Name, err)
+	} else {
+		run.Status = constants.TaskStatusFinished
+	}
+	run.EndTs = time.Now()
+
+	// report
+	if !dryRun && (run.MatchedCount > 0 || run.Error != "") {
+		if run.DeletedCount > 0 {
+			log.Infof("[RetentionServiceV2] deleted %d results of data collection %s (archive: %s)", run.DeletedCount, dc.Name, run.ArchiveLocation)
+		}
+		run.SetCreated(dc.GetCreatedBy())
+		run.SetUpdated(dc.GetCreatedBy())
+		run.Id, err = svc.runModelSvc.InsertOne(*run)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return run, nil
+}
+
+func (svc *ServiceV2) run(run *models2.RetentionRunV2) (err error) {
+	col := mongo.GetMongoCol(run.ColName)
+
+	// bound of deleted results
+	run.BeforeId, err = getBeforeId(col, run.Policy, run.StartTs)
+	if err != nil {
+		return err
+	}
+	if run.BeforeId.IsZero() {
+		return nil
+	}
+	query := bson.M{"_id": bson.M{"$lt": run.BeforeId}}
+
+	// count
+	n, err := col.Count(query)
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	run.MatchedCount = int64(n)
+	if run.DryRun || run.MatchedCount == 0 {
+		return nil
+	}
+
+	// archive
+	if run.Policy.Archive.Enabled {
+		if err := svc.archive(run); err != nil {
+			return err
+		}
+	}
+
+	// delete
+	res, err := col.GetCollection().DeleteMany(col.GetContext(), query)
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	run.DeletedCount = res.DeletedCount
+
+	return nil
+}
+
+// archive exports results to be deleted and delivers the exported file to the archive destination
+func (svc *ServiceV2) archive(run *models2.RetentionRunV2) (err error) {
+	archive := run.Policy.Archive
+	exportType := archive.ExportType
+	if exportType == "" {
+		exportType = constants.ExportTypeCsv
+	}
+	exportSvc, err := export.GetExportService(exportType)
+	if err != nil {
+		return err
+	}
+
+	// export
+	filter := &entity.Filter{
+		Conditions: []*entity.Condition{
+			{Key: "_id", Op: constants.FilterOpLessThan, Value: run.BeforeId},
+		},
+	}
+	exp, err := exportSvc.ExportAndWait(run.ColName, filter, &entity.ExportOptions{Gzip: archive.Gzip})
+	if err != nil {
+		return err
+	}
+	run.ArchiveExportId = exp.Id
+	if exp.Status != constants.TaskStatusFinished {
+		return errors.New("failed to archive results: " + exp.Error)
+	}
+
+	// deliver, or keep the exported file in export directory if no destination
+	if archive.Destination.Type == "" {
+		run.ArchiveLocation = exp.DownloadPath
+		return nil
+	}
+	// the exported file is removed after delivery, and exported again by the next run on failure
+	run.ArchiveLocation, err = export.Deliver(archive.Destination, exp.DownloadPath)
+	if err := os.Remove(exp.DownloadPath); err != nil && !os.IsNotExist(err) {
+		log.Warnf("[RetentionServiceV2] failed to remove archived file %s: %v", exp.DownloadPath, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPolicy returns the retention policy of a data collection, or the global retention policy in
+// settings "result.retention" if it is not enabled
+func GetPolicy(dc *models2.DataCollectionV2) (policy entity.RetentionPolicy) {
+	if dc != nil && dc.Retention.Enabled {
+		return dc.Retention
+	}
+	if err := viper.UnmarshalKey("result.retention", &policy); err != nil {
+		log.Warnf("[RetentionServiceV2] invalid global retention policy: %v", err)
+		return entity.RetentionPolicy{}
+	}
+	return policy
+}
+
+// getBeforeId returns the _id before which results are deleted by a retention policy, or a zero
+// id if no results should be deleted
+func getBeforeId(col *mongo.Col, policy entity.RetentionPolicy, now time.Time) (id primitive.ObjectID, err error) {
+	// max age
+	id = getMaxAgeBeforeId(policy, now)
+
+	// max count
+	if policy.MaxCount > 0 {
+		var record bson.M
+		err := col.Find(bson.M{}, &mongo.FindOptions{
+			Sort:  bson.D{{"_id", -1}},
+			Skip:  int(policy.MaxCount - 1),
+			Limit: 1,
+		}).One(&record)
+		if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+			return id, trace.TraceError(err)
+		}
+		if err == nil {
+			// oldest result to keep
+			oldestId, ok := record["_id"].(primitive.ObjectID)
+			if !ok {
+				return id, errors.New("results without object id cannot be limited by count")
+			}
+			id = maxObjectId(id, oldestId)
+		}
+	}
+
+	return id, nil
+}
+
+// getMaxAgeBeforeId returns the smallest _id created at the max age of a retention policy, or a
+// zero id if the policy does not limit age
+func getMaxAgeBeforeId(policy entity.RetentionPolicy, now time.Time) (id primitive.ObjectID) {
+	if policy.MaxAgeDays <= 0 {
+		return id
+	}
+	return primitive.NewObjectIDFromTimestamp(now.Add(-time.Duration(policy.MaxAgeDays) * 24 * time.Hour))
+}
+
+// getSpiderResultColNames returns distinct names of result collections of spiders saving results
+// in built-in mongo, which have no data collection records
+func getSpiderResultColNames(spiders []models2.SpiderV2, dcs []models2.DataCollectionV2) (colNames []string) {
+	seen := map[string]bool{}
+	for _, dc := range dcs {
+		seen[dc.Name] = true
+	}
+	for _, s := range spiders {
+		if !s.DataSourceId.IsZero() {
+			continue
+		}
+		colName := utils.GetSpiderCol(s.ColName, s.Name)
+		if seen[colName] {
+			continue
+		}
+		seen[colName] = true
+		colNames = append(colNames, colName)
+	}
+	return colNames
+}
+
+func maxObjectId(a, b primitive.ObjectID) primitive.ObjectID {
+	if bytes.Compare(a[:], b[:]) >= 0 {
+		return a
+	}
+	return b
+}
+
+func NewRetentionServiceV2() (svc *ServiceV2) {
+	svc = &ServiceV2{
+		dcModelSvc:     service.NewModelServiceV2[models2.DataCollectionV2](),
+		spiderModelSvc: service.NewModelServiceV2[models2.SpiderV2](),
+		runModelSvc:    service.NewModelServiceV2[models2.RetentionRunV2](),
+		interval:       1 * time.Hour,
+	}
+	if interval := viper.GetDuration("result.retention.interval"); interval > 0 {
+		svc.interval = interval
+	}
+	return svc
+}
+
+var retentionSvcV2 *ServiceV2
+var retentionSvcV2Once = new(sync.Once)
+
+func GetRetentionServiceV2() *ServiceV2 {
+	retentionSvcV2Once.Do(func() {
+		retentionSvcV2 = NewRetentionServiceV2()
+	})
+	return retentionSvcV2
+}
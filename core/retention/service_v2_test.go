@@ -0,0 +1,82 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/entity"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetMaxAgeBeforeId(t *testing.T) {
+	now := time.Date(2024, 3, 31, 8, 0, 0, 0, time.UTC)
+
+	// no limit
+	require.True(t, getMaxAgeBeforeId(entity.RetentionPolicy{}, now).IsZero())
+
+	// ids created before max age are less than the bound
+	id := getMaxAgeBeforeId(entity.RetentionPolicy{MaxAgeDays: 30}, now)
+	require.Equal(t, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), id.Timestamp().UTC())
+	older := primitive.NewObjectIDFromTimestamp(now.Add(-31 * 24 * time.Hour))
+	newer := primitive.NewObjectIDFromTimestamp(now.Add(-29 * 24 * time.Hour))
+	require.Equal(t, id, maxObjectId(older, id))
+	require.Equal(t, newer, maxObjectId(id, newer))
+}
+
+func TestMaxObjectId(t *testing.T) {
+	a := primitive.NewObjectID()
+	b := primitive.NewObjectID()
+	require.Equal(t, b, maxObjectId(a, b))
+	require.Equal(t, b, maxObjectId(b, a))
+	require.Equal(t, a, maxObjectId(a, primitive.NilObjectID))
+}
+
+func TestGetPolicy(t *testing.T) {
+	defer viper.Set("result.retention", nil)
+
+	// none
+	require.True(t, GetPolicy(nil).IsEmpty())
+
+	// global
+	viper.Set("result.retention", map[string]interface{}{
+		"enabled":    true,
+		"maxAgeDays": 90,
+		"archive": map[string]interface{}{
+			"enabled":    true,
+			"exportType": "parquet",
+		},
+	})
+	dc := &models2.DataCollectionV2{}
+	policy := GetPolicy(dc)
+	require.False(t, policy.IsEmpty())
+	require.Equal(t, 90, policy.MaxAgeDays)
+	require.Equal(t, int64(0), policy.MaxCount)
+	require.True(t, policy.Archive.Enabled)
+	require.Equal(t, "parquet", policy.Archive.ExportType)
+
+	// data collection
+	dc.Retention = entity.RetentionPolicy{Enabled: true, MaxCount: 1000}
+	policy = GetPolicy(dc)
+	require.Equal(t, 0, policy.MaxAgeDays)
+	require.Equal(t, int64(1000), policy.MaxCount)
+	require.False(t, policy.Archive.Enabled)
+
+	// enabled without limits
+	dc.Retention = entity.RetentionPolicy{Enabled: true}
+	require.True(t, GetPolicy(dc).IsEmpty())
+}
+
+func TestGetSpiderResultColNames(t *testing.T) {
+	spiders := []models2.SpiderV2{
+		{Name: "a"},
+		{Name: "b", ColName: "results_b"},
+		{Name: "c", ColName: "items"},
+		{Name: "d", ColName: "items"},
+		{Name: "e", DataSourceId: primitive.NewObjectID()},
+	}
+	dcs := []models2.DataCollectionV2{{Name: "results_b"}}
+	require.Equal(t, []string{"results_a", "items"}, getSpiderResultColNames(spiders, dcs))
+}
@@ -0,0 +1,362 @@
+package schedule
+
+import (
+	"bytes"
+	"errors"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/export"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/node/leader"
+	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"sync"
+	"time"
+)
+
+// ExportServiceV2 runs export schedules with cron, delivering exported files to their
+// destinations and recording each run
+type ExportServiceV2 struct {
+	// dependencies
+	modelSvc    *service.ModelServiceV2[models2.ExportScheduleV2]
+	runModelSvc *service.ModelServiceV2[models2.ExportRunV2]
+
+	// settings variables
+	loc            *time.Location
+	updateInterval time.Duration
+	overlap        time.Duration // window before the high-water mark re-read by incremental runs
+
+	// internals
+	cron      *cron.Cron
+	logger    cron.Logger
+	schedules []models2.ExportScheduleV2
+	running   sync.Map // ids of export schedules with a running run
+	stopped   bool
+	mu        sync.Mutex
+}
+
+func (svc *ExportServiceV2) Init() (err error) {
+	return svc.fetch()
+}
+
+func (svc *ExportServiceV2) Start() {
+	// runs interrupted by restart of the previous leader will never be delivered
+	leader.GetLeaderServiceV2().OnLeading(func() {
+		if err := svc.runModelSvc.UpdateMany(bson.M{"status": constants.TaskStatusRunning}, bson.M{"$set": bson.M{
+			"status": constants.TaskStatusError,
+			"error":  "interrupted by master restart",
+			"end_ts": time.Now(),
+		}}); err != nil {
+			trace.PrintError(err)
+		}
+	})
+
+	svc.cron.Start()
+	go svc.Update()
+}
+
+func (svc *ExportServiceV2) Wait() {
+	utils.DefaultWait()
+	svc.Stop()
+}
+
+func (svc *ExportServiceV2) Stop() {
+	svc.stopped = true
+	svc.cron.Stop()
+}
+
+func (svc *ExportServiceV2) Enable(s models2.ExportScheduleV2, by primitive.ObjectID) (err error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if s.EntryId > 0 {
+		svc.cron.Remove(s.EntryId)
+	}
+	id, err := svc.cron.AddFunc(s.Cron, svc.schedule(s.Id))
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	return svc.updateEntry(s.Id, true, id, by)
+}
+
+func (svc *ExportServiceV2) Disable(s models2.ExportScheduleV2, by primitive.ObjectID) (err error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	svc.cron.Remove(s.EntryId)
+	return svc.updateEntry(s.Id, false, -1, by)
+}
+
+// updateEntry saves cron entry of an export schedule without overwriting the high-water mark
+// advanced by runs
+func (svc *ExportServiceV2) updateEntry(id primitive.ObjectID, enabled bool, entryId cron.EntryID, by primitive.ObjectID) (err error) {
+	return svc.modelSvc.UpdateById(id, bson.M{"$set": bson.M{
+		"enabled":    enabled,
+		"entry_id":   entryId,
+		"updated_ts": time.Now(),
+		"updated_by": by,
+	}})
+}
+
+func (svc *ExportServiceV2) Update() {
+	for {
+		if svc.stopped {
+			return
+		}
+
+		svc.update()
+
+		time.Sleep(svc.updateInterval)
+	}
+}
+
+func (svc *ExportServiceV2) update() {
+	// fetch enabled schedules
+	if err := svc.fetch(); err != nil {
+		trace.PrintError(err)
+		return
+	}
+
+	// entry id map
+	entryIdsMap := map[cron.EntryID]bool{}
+	for _, e := range svc.cron.Entries() {
+		entryIdsMap[e.ID] = false
+	}
+
+	// iterate enabled schedules
+	for _, s := range svc.schedules {
+		_, ok := entryIdsMap[s.EntryId]
+		if ok {
+			entryIdsMap[s.EntryId] = true
+		} else {
+			if err := svc.Enable(s, s.GetCreatedBy()); err != nil {
+				trace.PrintError(err)
+				continue
+			}
+		}
+	}
+
+	// remove non-existent entries
+	for id, ok := range entryIdsMap {
+		if !ok {
+			svc.cron.Remove(id)
+		}
+	}
+}
+
+func (svc *ExportServiceV2) fetch() (err error) {
+	query := bson.M{
+		"enabled": true,
+	}
+	svc.schedules, err = svc.modelSvc.GetMany(query, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+func (svc *ExportServiceV2) schedule(id primitive.ObjectID) (fn func()) {
+	return func() {
+		// cron runs on all masters, while only the leader runs export schedules
+		if !leader.IsLeader() {
+			return
+		}
+		if _, err := svc.Run(id); err != nil {
+			trace.PrintError(err)
+		}
+	}
+}
+
+// Run exports records of an export schedule and delivers the exported file to its destination.
+// It returns the recorded run, or an error if the schedule already has a running run.
+func (svc *ExportServiceV2) Run(id primitive.ObjectID) (run *models2.ExportRunV2, err error) {
+	// skip if the previous run has not finished
+	if _, loaded := svc.running.LoadOrStore(id, true); loaded {
+		return nil, errors.New("export schedule is already running")
+	}
+	defer svc.running.Delete(id)
+
+	// schedule
+	s, err := svc.modelSvc.GetById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// run
+	run = &models2.ExportRunV2{
+		ScheduleId: s.Id,
+		Status:     constants.TaskStatusRunning,
+		StartTs:    time.Now(),
+	}
+	if s.Incremental {
+		run.AfterId = getIncrementalAfterId(s.LastId, svc.overlap)
+	}
+	run.SetCreated(s.GetCreatedBy())
+	run.SetUpdated(s.GetCreatedBy())
+	run.Id, err = svc.runModelSvc.InsertOne(*run)
+	if err != nil {
+		return nil, err
+	}
+
+	// execute
+	if err := svc.run(s, run); err != nil {
+		run.Status = constants.TaskStatusError
+		run.Error = err.Error()
+		log.Errorf("export schedule run error (id: %s): %v", s.Id.Hex(), err)
+	} else {
+		run.Status = constants.TaskStatusFinished
+		log.Infof("export schedule run finished (id: %s, rows: %d, location: %s)", s.Id.Hex(), run.RowCount, run.Location)
+	}
+	run.EndTs = time.Now()
+	if err := svc.runModelSvc.ReplaceById(run.Id, *run); err != nil {
+		trace.PrintError(err)
+	}
+	return run, nil
+}
+
+func (svc *ExportServiceV2) run(s *models2.ExportScheduleV2, run *models2.ExportRunV2) (err error) {
+	exportSvc, err := export.GetExportService(s.ExportType)
+	if err != nil {
+		return err
+	}
+
+	// filter
+	var filter *entity.Filter
+	if len(s.Conditions) > 0 {
+		filter = &entity.Filter{}
+		for i := range s.Conditions {
+			filter.Conditions = append(filter.Conditions, &s.Conditions[i])
+		}
+	}
+
+	// export. records of incremental runs are exported from the overlap window before the
+	// high-water mark, excluding those already exported, as _ids generated on different hosts or
+	// committed late may be less than the high-water mark
+	opts := &entity.ExportOptions{
+		Columns: s.Columns,
+		Gzip:    s.Gzip,
+	}
+	if s.Incremental {
+		opts.AfterId = run.AfterId
+		opts.ExcludeIds = s.LastIds
+		opts.Overlap = svc.overlap
+	}
+	exp, err := exportSvc.ExportAndWait(s.Target, filter, opts)
+	if err != nil {
+		return err
+	}
+	run.ExportId = exp.Id
+	run.RowCount = exp.RowCount
+	if exp.Status != constants.TaskStatusFinished {
+		return errors.New(exp.Error)
+	}
+
+	// deliver, and remove the exported file which is not used by later runs
+	run.Location, err = export.Deliver(s.Destination, exp.DownloadPath)
+	if err := os.Remove(exp.DownloadPath); err != nil && !os.IsNotExist(err) {
+		log.Warnf("failed to remove exported file %s: %v", exp.DownloadPath, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// advance high-water mark
+	if !exp.LastId.IsZero() {
+		lastId, lastIds := getIncrementalLastIds(s.LastId, s.LastIds, exp.LastId, exp.RecentIds, svc.overlap)
+		run.LastId = lastId
+		if err := svc.modelSvc.UpdateById(s.Id, bson.M{"$set": bson.M{
+			"last_id":  lastId,
+			"last_ids": lastIds,
+		}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getIncrementalAfterId returns the _id after which an incremental run exports records, which is
+// the start of the overlap window before the high-water _id, or a zero id if nothing is exported
+func getIncrementalAfterId(lastId primitive.ObjectID, overlap time.Duration) (afterId primitive.ObjectID) {
+	if lastId.IsZero() {
+		return primitive.NilObjectID
+	}
+	return primitive.NewObjectIDFromTimestamp(lastId.Timestamp().Add(-overlap))
+}
+
+// getIncrementalLastIds returns the high-water _id after an incremental run, and the _ids
+// exported within the overlap window before it, merged from the previous and the current run
+func getIncrementalLastIds(prevLastId primitive.ObjectID, prevLastIds []primitive.ObjectID, lastId primitive.ObjectID, recentIds []primitive.ObjectID, overlap time.Duration) (resLastId primitive.ObjectID, resLastIds []primitive.ObjectID) {
+	resLastId = lastId
+	if bytes.Compare(prevLastId[:], lastId[:]) > 0 {
+		resLastId = prevLastId
+	}
+	since := resLastId.Timestamp().Add(-overlap)
+	resLastIds = []primitive.ObjectID{}
+	for _, ids := range [][]primitive.ObjectID{prevLastIds, recentIds} {
+		for _, id := range ids {
+			if !id.Timestamp().Before(since) {
+				resLastIds = append(resLastIds, id)
+			}
+		}
+	}
+	return resLastId, resLastIds
+}
+
+func NewExportScheduleServiceV2() (svc *ExportServiceV2, err error) {
+	// service
+	svc = &ExportServiceV2{
+		loc:            time.Local,
+		updateInterval: 1 * time.Minute,
+		overlap:        10 * time.Minute,
+	}
+	if overlap := viper.GetDuration("export.schedule.overlap"); overlap > 0 {
+		svc.overlap = overlap
+	}
+	svc.modelSvc = service.NewModelServiceV2[models2.ExportScheduleV2]()
+	svc.runModelSvc = service.NewModelServiceV2[models2.ExportRunV2]()
+
+	// logger
+	svc.logger = NewLogger()
+
+	// cron
+	svc.cron = cron.New(
+		cron.WithLogger(svc.logger),
+		cron.WithLocation(svc.loc),
+		cron.WithChain(cron.Recover(svc.logger)),
+	)
+
+	// initialize
+	if err := svc.Init(); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+var exportSvcV2 *ExportServiceV2
+var exportSvcV2Once = new(sync.Once)
+
+func GetExportScheduleServiceV2() (res *ExportServiceV2, err error) {
+	if exportSvcV2 != nil {
+		return exportSvcV2, nil
+	}
+	exportSvcV2Once.Do(func() {
+		exportSvcV2, err = NewExportScheduleServiceV2()
+		if err != nil {
+			log.Errorf("failed to get export schedule service: %v", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exportSvcV2, nil
+}
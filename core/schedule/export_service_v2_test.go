@@ -0,0 +1,40 @@
+package schedule
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestGetIncrementalAfterId(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	// first run exports all records
+	require.True(t, getIncrementalAfterId(primitive.NilObjectID, 5*time.Minute).IsZero())
+
+	// records from the overlap window are read again
+	afterId := getIncrementalAfterId(primitive.NewObjectIDFromTimestamp(now), 5*time.Minute)
+	require.Equal(t, now.Add(-5*time.Minute), afterId.Timestamp().UTC())
+}
+
+func TestGetIncrementalLastIds(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+	newId := func(d time.Duration) primitive.ObjectID {
+		return primitive.NewObjectIDFromTimestamp(now.Add(d))
+	}
+	prevOld := newId(-8 * time.Minute)
+	prevLast := newId(-2 * time.Minute)
+	late := newId(-3 * time.Minute) // committed after the previous run
+	last := newId(0)
+
+	// high-water mark advances, and ids out of the window are dropped
+	lastId, lastIds := getIncrementalLastIds(prevLast, []primitive.ObjectID{prevOld, prevLast}, last, []primitive.ObjectID{late, last}, 5*time.Minute)
+	require.Equal(t, last, lastId)
+	require.Equal(t, []primitive.ObjectID{prevLast, late, last}, lastIds)
+
+	// high-water mark does not go back if only late records are exported
+	lastId, lastIds = getIncrementalLastIds(last, []primitive.ObjectID{last}, late, []primitive.ObjectID{late}, 5*time.Minute)
+	require.Equal(t, last, lastId)
+	require.Equal(t, []primitive.ObjectID{last, late}, lastIds)
+}
@@ -1,32 +1,48 @@
 package schedule
 
 import (
+	"errors"
+	"fmt"
 	"github.com/apex/log"
 	"github.com/crawlab-team/crawlab/core/config"
+	"github.com/crawlab-team/crawlab/core/constants"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/node/leader"
 	"github.com/crawlab-team/crawlab/core/spider/admin"
+	"github.com/crawlab-team/crawlab/core/task/scheduler"
 	"github.com/crawlab-team/crawlab/core/utils"
+	"github.com/crawlab-team/crawlab/core/workflow"
 	"github.com/crawlab-team/crawlab/trace"
 	"github.com/robfig/cron/v3"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"math/rand"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	maxMisfireRuns      = 100 // max missed runs of a schedule fired or recorded on startup
+	defaultMisfireLimit = 10  // max missed runs fired by fire_all misfire policy if not set
+)
+
 type ServiceV2 struct {
 	// dependencies
 	interfaces.WithConfigPath
-	modelSvc *service.ModelServiceV2[models2.ScheduleV2]
-	adminSvc *admin.ServiceV2
+	modelSvc    *service.ModelServiceV2[models2.ScheduleV2]
+	runModelSvc *service.ModelServiceV2[models2.ScheduleRunV2]
+	adminSvc    *admin.ServiceV2
 
 	// settings variables
 	loc            *time.Location
 	delay          bool
 	skip           bool
 	updateInterval time.Duration
+	queueInterval  time.Duration // interval of checking previous tasks of queued runs
 
 	// internals
 	cron      *cron.Cron
@@ -34,6 +50,13 @@ type ServiceV2 struct {
 	schedules []models2.ScheduleV2
 	stopped   bool
 	mu        sync.Mutex
+	queued    sync.Map                             // ids of schedules with a run queued after previous tasks
+	entries   map[primitive.ObjectID]scheduleEntry // cron entries added by schedule id
+}
+
+type scheduleEntry struct {
+	id   cron.EntryID
+	spec string
 }
 
 func (svc *ServiceV2) GetLocation() (loc *time.Location) {
@@ -75,6 +98,9 @@ func (svc *ServiceV2) Init() (err error) {
 func (svc *ServiceV2) Start() {
 	svc.cron.Start()
 	go svc.Update()
+
+	// runs missed while no master was leading
+	leader.GetLeaderServiceV2().OnLeading(svc.catchUp)
 }
 
 func (svc *ServiceV2) Wait() {
@@ -91,25 +117,53 @@ func (svc *ServiceV2) Enable(s models2.ScheduleV2, by primitive.ObjectID) (err e
 	svc.mu.Lock()
 	defer svc.mu.Unlock()
 
-	id, err := svc.cron.AddFunc(s.Cron, svc.schedule(s.Id))
+	sched, id, err := svc.addEntry(s)
 	if err != nil {
-		return trace.TraceError(err)
+		return err
 	}
-	s.Enabled = true
-	s.EntryId = id
-	s.SetUpdated(by)
-	return svc.modelSvc.ReplaceById(s.Id, s)
+	return svc.modelSvc.UpdateById(s.Id, bson.M{"$set": bson.M{
+		"enabled":      true,
+		"entry_id":     id,
+		"next_fire_ts": sched.Next(time.Now().In(svc.loc)),
+		"updated_ts":   time.Now(),
+		"updated_by":   by,
+	}})
 }
 
 func (svc *ServiceV2) Disable(s models2.ScheduleV2, by primitive.ObjectID) (err error) {
 	svc.mu.Lock()
 	defer svc.mu.Unlock()
 
-	svc.cron.Remove(s.EntryId)
-	s.Enabled = false
-	s.EntryId = -1
-	s.SetUpdated(by)
-	return svc.modelSvc.ReplaceById(s.Id, s)
+	if e, ok := svc.entries[s.Id]; ok {
+		svc.cron.Remove(e.id)
+		delete(svc.entries, s.Id)
+	}
+	return svc.modelSvc.UpdateById(s.Id, bson.M{"$set": bson.M{
+		"enabled":      false,
+		"entry_id":     -1,
+		"next_fire_ts": time.Time{},
+		"updated_ts":   time.Now(),
+		"updated_by":   by,
+	}})
+}
+
+// addEntry adds the cron entry of a schedule, replacing the existing one so that changes of cron
+// and time zone take effect. The caller must hold the lock.
+func (svc *ServiceV2) addEntry(s models2.ScheduleV2) (sched cron.Schedule, id cron.EntryID, err error) {
+	spec, err := GetSpec(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	sched, err = cron.ParseStandard(spec)
+	if err != nil {
+		return nil, 0, trace.TraceError(err)
+	}
+	if e, ok := svc.entries[s.Id]; ok {
+		svc.cron.Remove(e.id)
+	}
+	id = svc.cron.Schedule(sched, cron.FuncJob(svc.schedule(s.Id)))
+	svc.entries[s.Id] = scheduleEntry{id: id, spec: spec}
+	return sched, id, nil
 }
 
 func (svc *ServiceV2) Update() {
@@ -135,39 +189,31 @@ func (svc *ServiceV2) update() {
 		return
 	}
 
-	// entry id map
-	entryIdsMap := svc.getEntryIdsMap()
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
 
-	// iterate enabled schedules
+	// add entries of enabled schedules without entries or with changed cron, e.g. after master
+	// restarts or schedules are updated by other masters
+	enabled := map[primitive.ObjectID]bool{}
 	for _, s := range svc.schedules {
-		_, ok := entryIdsMap[s.EntryId]
-		if ok {
-			entryIdsMap[s.EntryId] = true
-		} else {
-			if !s.Enabled {
-				err := svc.Enable(s, s.GetCreatedBy())
-				if err != nil {
-					trace.PrintError(err)
-					continue
-				}
+		enabled[s.Id] = true
+		if e, ok := svc.entries[s.Id]; ok {
+			if spec, err := GetSpec(s); err != nil || spec == e.spec {
+				continue
 			}
 		}
-	}
-
-	// remove non-existent entries
-	for id, ok := range entryIdsMap {
-		if !ok {
-			svc.cron.Remove(id)
+		if _, _, err := svc.addEntry(s); err != nil {
+			trace.PrintError(err)
 		}
 	}
-}
 
-func (svc *ServiceV2) getEntryIdsMap() (res map[cron.EntryID]bool) {
-	res = map[cron.EntryID]bool{}
-	for _, e := range svc.cron.Entries() {
-		res[e.ID] = false
+	// remove entries of schedules no longer enabled
+	for id, e := range svc.entries {
+		if !enabled[id] {
+			svc.cron.Remove(e.id)
+			delete(svc.entries, id)
+		}
 	}
-	return res
 }
 
 func (svc *ServiceV2) fetch() (err error) {
@@ -175,7 +221,7 @@ func (svc *ServiceV2) fetch() (err error) {
 		"enabled": true,
 	}
 	svc.schedules, err = svc.modelSvc.GetMany(query, nil)
-	if err != nil {
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
 		return err
 	}
 	return nil
@@ -183,57 +229,465 @@ func (svc *ServiceV2) fetch() (err error) {
 
 func (svc *ServiceV2) schedule(id primitive.ObjectID) (fn func()) {
 	return func() {
-		// schedule
-		s, err := svc.modelSvc.GetById(id)
-		if err != nil {
-			trace.PrintError(err)
+		// cron runs on all masters, while only the leader fires
+		if !leader.IsLeader() {
 			return
 		}
 
-		// spider
-		spider, err := service.NewModelServiceV2[models2.SpiderV2]().GetById(s.SpiderId)
+		// cron fires at whole seconds
+		svc.fire(id, time.Now().In(svc.loc).Truncate(time.Second), false)
+	}
+}
+
+// fire runs a schedule fired at the given scheduled time, and records the fire event
+func (svc *ServiceV2) fire(id primitive.ObjectID, ts time.Time, misfire bool) {
+	// schedule
+	s, err := svc.modelSvc.GetById(id)
+	if err != nil {
+		trace.PrintError(err)
+		return
+	}
+
+	// fire times
+	if err := svc.updateFireTs(s, ts); err != nil {
+		trace.PrintError(err)
+	}
+
+	// run
+	run := newScheduleRun(s, ts, misfire)
+	run.TaskIds, run.Reason, err = svc.run(s)
+	if err != nil {
+		run.Status = constants.ScheduleRunStatusError
+		run.Reason = err.Error()
+		trace.PrintError(err)
+	} else if run.Reason != "" {
+		run.Status = constants.ScheduleRunStatusSkipped
+		log.Infof("[ScheduleServiceV2] skipped run of schedule %s: %s", s.Id.Hex(), run.Reason)
+	} else {
+		run.Status = constants.ScheduleRunStatusFired
+	}
+	if _, err := svc.runModelSvc.InsertOne(*run); err != nil {
+		trace.PrintError(err)
+	}
+}
+
+// run schedules tasks of a schedule, and returns the task ids, or the reason if skipped
+func (svc *ServiceV2) run(s *models2.ScheduleV2) (taskIds []primitive.ObjectID, reason string, err error) {
+	// jitter
+	if s.Jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(time.Duration(s.Jitter) * time.Second))))
+	}
+
+	// concurrency policy
+	reason, err = svc.applyConcurrencyPolicy(s)
+	if err != nil || reason != "" {
+		return nil, reason, err
+	}
+
+	// workflow
+	if !s.WorkflowId.IsZero() {
+		return svc.runWorkflow(s)
+	}
+
+	// spider
+	spider, err := service.NewModelServiceV2[models2.SpiderV2]().GetById(s.SpiderId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// options
+	opts := &interfaces.SpiderRunOptions{
+		Mode:       s.Mode,
+		NodeIds:    s.NodeIds,
+		Cmd:        s.Cmd,
+		Param:      s.Param,
+		Priority:   s.Priority,
+		ScheduleId: s.Id,
+		UserId:     s.GetCreatedBy(),
+	}
+
+	// normalize options
+	if opts.Mode == "" {
+		opts.Mode = spider.Mode
+	}
+	if len(opts.NodeIds) == 0 {
+		opts.NodeIds = spider.NodeIds
+	}
+	if opts.Cmd == "" {
+		opts.Cmd = spider.Cmd
+	}
+	if opts.Param == "" {
+		opts.Param = spider.Param
+	}
+	if opts.Priority == 0 {
+		if spider.Priority > 0 {
+			opts.Priority = spider.Priority
+		} else {
+			opts.Priority = 5
+		}
+	}
+
+	// schedule or assign a task in the task queue
+	taskIds, err = svc.adminSvc.Schedule(s.SpiderId, opts)
+	return taskIds, "", err
+}
+
+// updateFireTs saves the scheduled time of the last fire and the next fire time of a schedule
+func (svc *ServiceV2) updateFireTs(s *models2.ScheduleV2, ts time.Time) (err error) {
+	update := bson.M{"$max": bson.M{"last_fire_ts": ts}}
+	if sched, err := parseSpec(*s); err == nil {
+		update["$set"] = bson.M{"next_fire_ts": sched.Next(time.Now().In(svc.loc))}
+	}
+	return svc.modelSvc.UpdateById(s.Id, update)
+}
+
+// catchUp fires or skips runs of schedules missed while master was down according to their
+// misfire policies
+func (svc *ServiceV2) catchUp() {
+	// wait for task statuses to be initialized by task scheduler, which clears the task queue
+	schedulerSvc, err := scheduler.GetTaskSchedulerServiceV2()
+	if err != nil {
+		trace.PrintError(err)
+		return
+	}
+	<-schedulerSvc.Ready()
+
+	schedules, err := svc.modelSvc.GetMany(bson.M{"enabled": true}, nil)
+	if err != nil {
+		if !errors.Is(err, mongo2.ErrNoDocuments) {
+			trace.PrintError(err)
+		}
+		return
+	}
+	now := time.Now().In(svc.loc)
+	for _, s := range schedules {
+		sched, err := parseSpec(s)
 		if err != nil {
 			trace.PrintError(err)
-			return
+			continue
+		}
+		missed, total := getMissedRuns(sched, s.LastFireTs.In(svc.loc), s.NextFireTs.In(svc.loc), now, maxMisfireRuns)
+		if total == 0 {
+			continue
+		}
+		fired, skipped := getMisfireRuns(s, missed)
+		log.Infof("[ScheduleServiceV2] schedule %s missed %d runs while master was down, firing %d (misfire policy: %s)", s.Id.Hex(), total, len(fired), s.MisfirePolicy)
+
+		// record skipped runs
+		for _, ts := range skipped {
+			run := newScheduleRun(&s, ts, true)
+			run.Status = constants.ScheduleRunStatusSkipped
+			run.Reason = "missed while master was down"
+			if _, err := svc.runModelSvc.InsertOne(*run); err != nil {
+				trace.PrintError(err)
+			}
+		}
+		if len(fired) == 0 {
+			if err := svc.updateFireTs(&s, missed[len(missed)-1]); err != nil {
+				trace.PrintError(err)
+			}
+			continue
+		}
+
+		// fire in order, which may wait for previous runs with queue concurrency policy
+		go func(id primitive.ObjectID, fired []time.Time) {
+			for _, ts := range fired {
+				if svc.stopped {
+					return
+				}
+				svc.fire(id, ts, true)
+			}
+		}(s.Id, fired)
+	}
+}
+
+// applyConcurrencyPolicy checks previous tasks of a schedule which are pending or running against
+// its concurrency policy, and returns the reason if a new task should not be scheduled. Queued
+// runs wait until previous tasks end, and at most one run of a schedule is queued at a time.
+// Schedules of workflows check previous workflow runs instead of tasks.
+func (svc *ServiceV2) applyConcurrencyPolicy(s *models2.ScheduleV2) (reason string, err error) {
+	switch s.ConcurrencyPolicy {
+	case constants.ScheduleConcurrencyPolicySkip:
+		if !s.WorkflowId.IsZero() {
+			runs, err := getActiveWorkflowRuns(s.Id)
+			if err != nil {
+				return "", err
+			}
+			if len(runs) > 0 {
+				return fmt.Sprintf("%d previous workflow runs are running", len(runs)), nil
+			}
+			return "", nil
+		}
+		tasks, err := getActiveTasks(s.Id)
+		if err != nil {
+			return "", err
+		}
+		if len(tasks) > 0 {
+			return fmt.Sprintf("%d previous tasks are pending or running", len(tasks)), nil
+		}
+		return "", nil
+
+	case constants.ScheduleConcurrencyPolicyQueue:
+		if _, loaded := svc.queued.LoadOrStore(s.Id, true); loaded {
+			return "a run is already queued", nil
+		}
+		defer svc.queued.Delete(s.Id)
+		for {
+			if svc.stopped {
+				return "service stopped while queued", nil
+			}
+			active, err := isActive(s)
+			if err != nil {
+				return "", err
+			}
+			if !active {
+				break
+			}
+			time.Sleep(svc.queueInterval)
 		}
 
-		// options
-		opts := &interfaces.SpiderRunOptions{
-			Mode:       s.Mode,
-			NodeIds:    s.NodeIds,
-			Cmd:        s.Cmd,
-			Param:      s.Param,
-			Priority:   s.Priority,
-			ScheduleId: s.Id,
-			UserId:     s.GetCreatedBy(),
+		// skip if schedule is disabled or removed while queued
+		s2, err := svc.modelSvc.GetById(s.Id)
+		if err != nil {
+			if errors.Is(err, mongo2.ErrNoDocuments) {
+				return "schedule removed while queued", nil
+			}
+			return "", err
+		}
+		if !s2.Enabled {
+			return "schedule disabled while queued", nil
 		}
+		return "", nil
 
-		// normalize options
-		if opts.Mode == "" {
-			opts.Mode = spider.Mode
+	case constants.ScheduleConcurrencyPolicyReplace:
+		if !s.WorkflowId.IsZero() {
+			return "", svc.cancelWorkflowRuns(s)
 		}
-		if len(opts.NodeIds) == 0 {
-			opts.NodeIds = spider.NodeIds
+		tasks, err := getActiveTasks(s.Id)
+		if err != nil {
+			return "", err
 		}
-		if opts.Cmd == "" {
-			opts.Cmd = spider.Cmd
+		tasks = getReplacedTasks(tasks)
+		if len(tasks) == 0 {
+			return "", nil
 		}
-		if opts.Param == "" {
-			opts.Param = spider.Param
+		schedulerSvc, err := scheduler.GetTaskSchedulerServiceV2()
+		if err != nil {
+			return "", err
 		}
-		if opts.Priority == 0 {
-			if spider.Priority > 0 {
-				opts.Priority = spider.Priority
-			} else {
-				opts.Priority = 5
+		for _, t := range tasks {
+			log.Infof("[ScheduleServiceV2] cancelling task[%s] replaced by new run of schedule %s", t.Id.Hex(), s.Id.Hex())
+			if err := schedulerSvc.Cancel(t.Id, s.GetCreatedBy()); err != nil {
+				trace.PrintError(err)
 			}
 		}
+		return "", nil
+
+	default:
+		return "", nil
+	}
+}
 
-		// schedule or assign a task in the task queue
-		if _, err := svc.adminSvc.Schedule(s.SpiderId, opts); err != nil {
+// cancelWorkflowRuns cancels running workflow runs of a schedule replaced by its new run
+func (svc *ServiceV2) cancelWorkflowRuns(s *models2.ScheduleV2) (err error) {
+	runs, err := getActiveWorkflowRuns(s.Id)
+	if err != nil || len(runs) == 0 {
+		return err
+	}
+	workflowSvc, err := workflow.GetWorkflowServiceV2()
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		log.Infof("[ScheduleServiceV2] cancelling workflow run[%s] replaced by new run of schedule %s", run.Id.Hex(), s.Id.Hex())
+		if err := workflowSvc.Cancel(run.Id, s.GetCreatedBy()); err != nil {
 			trace.PrintError(err)
 		}
 	}
+	return nil
+}
+
+// runWorkflow starts a run of the workflow of a schedule, and returns the tasks of its first steps
+func (svc *ServiceV2) runWorkflow(s *models2.ScheduleV2) (taskIds []primitive.ObjectID, reason string, err error) {
+	workflowSvc, err := workflow.GetWorkflowServiceV2()
+	if err != nil {
+		return nil, "", err
+	}
+	run, err := workflowSvc.Run(s.WorkflowId, s.Id, s.GetCreatedBy())
+	if err != nil {
+		return nil, "", err
+	}
+	for _, step := range run.Steps {
+		if !step.TaskId.IsZero() {
+			taskIds = append(taskIds, step.TaskId)
+		}
+	}
+	return taskIds, "", nil
+}
+
+// isActive returns whether previous tasks of a schedule are pending or running, or previous runs
+// if the schedule runs a workflow
+func isActive(s *models2.ScheduleV2) (ok bool, err error) {
+	if !s.WorkflowId.IsZero() {
+		runs, err := getActiveWorkflowRuns(s.Id)
+		return len(runs) > 0, err
+	}
+	tasks, err := getActiveTasks(s.Id)
+	return len(tasks) > 0, err
+}
+
+func getActiveWorkflowRuns(scheduleId primitive.ObjectID) (runs []models2.WorkflowRunV2, err error) {
+	runs, err = service.NewModelServiceV2[models2.WorkflowRunV2]().GetMany(bson.M{
+		"schedule_id": scheduleId,
+		"status":      constants.WorkflowRunStatusRunning,
+	}, nil)
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return runs, nil
+}
+
+// getActiveTasks returns pending or running tasks of a schedule
+func getActiveTasks(scheduleId primitive.ObjectID) (tasks []models2.TaskV2, err error) {
+	tasks, err = service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{
+		"schedule_id": scheduleId,
+		"status": bson.M{
+			"$in": []string{
+				constants.TaskStatusPending,
+				constants.TaskStatusRunning,
+			},
+		},
+	}, nil)
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// getReplacedTasks returns active tasks to be cancelled for a replacing run, excluding sub-tasks
+// of active parent tasks, which are cancelled along with their parents
+func getReplacedTasks(tasks []models2.TaskV2) (res []models2.TaskV2) {
+	ids := map[primitive.ObjectID]bool{}
+	for _, t := range tasks {
+		ids[t.Id] = true
+	}
+	for _, t := range tasks {
+		if !t.ParentId.IsZero() && ids[t.ParentId] {
+			continue
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// parseSpec returns the cron schedule of a schedule
+func parseSpec(s models2.ScheduleV2) (sched cron.Schedule, err error) {
+	spec, err := GetSpec(s)
+	if err != nil {
+		return nil, err
+	}
+	sched, err = cron.ParseStandard(spec)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+	return sched, nil
+}
+
+// getMissedRuns returns scheduled times before now which were not fired, starting after the last
+// fire time, or at the next fire time if never fired. At most max latest ones are returned along
+// with the total number.
+func getMissedRuns(sched cron.Schedule, last, next, now time.Time, max int) (missed []time.Time, total int) {
+	var ts time.Time
+	if !last.IsZero() {
+		ts = sched.Next(last)
+	} else if !next.IsZero() {
+		ts = next
+	} else {
+		return nil, 0
+	}
+	for !ts.IsZero() && ts.Before(now) {
+		total++
+		missed = append(missed, ts)
+		if len(missed) > max {
+			missed = missed[1:]
+		}
+		ts = sched.Next(ts)
+	}
+	return missed, total
+}
+
+// getMisfireRuns splits missed runs of a schedule into those to fire and to skip according to its
+// misfire policy. The latest missed runs are fired.
+func getMisfireRuns(s models2.ScheduleV2, missed []time.Time) (fired, skipped []time.Time) {
+	n := 0
+	switch s.MisfirePolicy {
+	case constants.ScheduleMisfirePolicyFireOnce:
+		n = 1
+	case constants.ScheduleMisfirePolicyFireAll:
+		n = s.MisfireLimit
+		if n == 0 {
+			n = defaultMisfireLimit
+		}
+	}
+	n = min(n, len(missed))
+	return missed[len(missed)-n:], missed[:len(missed)-n]
+}
+
+func newScheduleRun(s *models2.ScheduleV2, ts time.Time, misfire bool) (run *models2.ScheduleRunV2) {
+	run = &models2.ScheduleRunV2{
+		ScheduleId: s.Id,
+		SpiderId:   s.SpiderId,
+		Ts:         ts,
+		Misfire:    misfire,
+	}
+	run.SetCreated(s.GetCreatedBy())
+	run.SetUpdated(s.GetCreatedBy())
+	return run
+}
+
+// GetSpec validates the time zone, jitter and concurrency policy of a schedule, and returns its
+// cron spec with the time zone of the schedule if set
+func GetSpec(s models2.ScheduleV2) (spec string, err error) {
+	if s.Jitter < 0 {
+		return "", errors.New(fmt.Sprintf("invalid jitter: %d", s.Jitter))
+	}
+	switch s.ConcurrencyPolicy {
+	case "",
+		constants.ScheduleConcurrencyPolicyAllow,
+		constants.ScheduleConcurrencyPolicySkip,
+		constants.ScheduleConcurrencyPolicyQueue,
+		constants.ScheduleConcurrencyPolicyReplace:
+	default:
+		return "", errors.New(fmt.Sprintf("invalid concurrency policy: %s", s.ConcurrencyPolicy))
+	}
+	switch s.MisfirePolicy {
+	case "",
+		constants.ScheduleMisfirePolicyIgnore,
+		constants.ScheduleMisfirePolicyFireOnce,
+		constants.ScheduleMisfirePolicyFireAll:
+	default:
+		return "", errors.New(fmt.Sprintf("invalid misfire policy: %s", s.MisfirePolicy))
+	}
+	if s.MisfireLimit < 0 || s.MisfireLimit > maxMisfireRuns {
+		return "", errors.New(fmt.Sprintf("invalid misfire limit: %d", s.MisfireLimit))
+	}
+	spec = strings.TrimSpace(s.Cron)
+	if s.Timezone == "" {
+		return spec, nil
+	}
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return "", errors.New("time zone is set in both cron and timezone")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return "", errors.New(fmt.Sprintf("invalid timezone: %s", s.Timezone))
+	}
+	return "CRON_TZ=" + s.Timezone + " " + spec, nil
 }
 
 func NewScheduleServiceV2() (svc2 *ServiceV2, err error) {
@@ -245,12 +699,15 @@ func NewScheduleServiceV2() (svc2 *ServiceV2, err error) {
 		delay:          false,
 		skip:           false,
 		updateInterval: 1 * time.Minute,
+		queueInterval:  5 * time.Second,
+		entries:        map[primitive.ObjectID]scheduleEntry{},
 	}
 	svc.adminSvc, err = admin.GetSpiderAdminServiceV2()
 	if err != nil {
 		return nil, err
 	}
 	svc.modelSvc = service.NewModelServiceV2[models2.ScheduleV2]()
+	svc.runModelSvc = service.NewModelServiceV2[models2.ScheduleRunV2]()
 
 	// logger
 	svc.logger = NewLogger()
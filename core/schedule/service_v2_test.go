@@ -0,0 +1,107 @@
+package schedule
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestGetSpec(t *testing.T) {
+	spec, err := GetSpec(models2.ScheduleV2{Cron: "0 9 * * *"})
+	require.Nil(t, err)
+	require.Equal(t, "0 9 * * *", spec)
+
+	spec, err = GetSpec(models2.ScheduleV2{Cron: "0 9 * * *", Timezone: "Asia/Tokyo"})
+	require.Nil(t, err)
+	require.Equal(t, "CRON_TZ=Asia/Tokyo 0 9 * * *", spec)
+
+	// runs at 9:00 in the time zone of the schedule
+	sched, err := cron.ParseStandard(spec)
+	require.Nil(t, err)
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	next := sched.Next(time.Date(2023, 12, 31, 12, 0, 0, 0, time.UTC))
+	require.Equal(t, time.Date(2024, 1, 1, 9, 0, 0, 0, loc).Unix(), next.Unix())
+
+	_, err = GetSpec(models2.ScheduleV2{Cron: "0 9 * * *", Timezone: "Mars/Olympus"})
+	require.NotNil(t, err)
+	_, err = GetSpec(models2.ScheduleV2{Cron: "CRON_TZ=UTC 0 9 * * *", Timezone: "Asia/Tokyo"})
+	require.NotNil(t, err)
+	_, err = GetSpec(models2.ScheduleV2{Cron: "0 9 * * *", Jitter: -1})
+	require.NotNil(t, err)
+	_, err = GetSpec(models2.ScheduleV2{Cron: "0 9 * * *", ConcurrencyPolicy: "wait"})
+	require.NotNil(t, err)
+	_, err = GetSpec(models2.ScheduleV2{Cron: "0 9 * * *", ConcurrencyPolicy: constants.ScheduleConcurrencyPolicyReplace})
+	require.Nil(t, err)
+}
+
+func TestGetReplacedTasks(t *testing.T) {
+	parent := models2.TaskV2{HasSub: true}
+	parent.Id = primitive.NewObjectID()
+	sub := models2.TaskV2{ParentId: parent.Id}
+	sub.Id = primitive.NewObjectID()
+	retry := models2.TaskV2{FirstAttemptId: primitive.NewObjectID(), Attempt: 2} // first attempt has ended
+	retry.Id = primitive.NewObjectID()
+
+	tasks := getReplacedTasks([]models2.TaskV2{parent, sub, retry})
+	require.Len(t, tasks, 2)
+	require.Equal(t, parent.Id, tasks[0].Id)
+	require.Equal(t, retry.Id, tasks[1].Id)
+}
+
+func TestGetMissedRuns(t *testing.T) {
+	sched, err := cron.ParseStandard("0 9 * * *")
+	require.Nil(t, err)
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 9, 0, 0, 0, time.UTC)
+	}
+	now := time.Date(2024, 1, 4, 12, 0, 0, 0, time.UTC)
+
+	// missed after last fire
+	missed, total := getMissedRuns(sched, day(1), time.Time{}, now, 10)
+	require.Equal(t, 3, total)
+	require.Equal(t, []time.Time{day(2), day(3), day(4)}, missed)
+
+	// never fired
+	missed, total = getMissedRuns(sched, time.Time{}, day(3), now, 10)
+	require.Equal(t, 2, total)
+	require.Equal(t, []time.Time{day(3), day(4)}, missed)
+
+	// latest ones
+	missed, total = getMissedRuns(sched, day(1), time.Time{}, now, 2)
+	require.Equal(t, 3, total)
+	require.Equal(t, []time.Time{day(3), day(4)}, missed)
+
+	// none
+	_, total = getMissedRuns(sched, day(4), time.Time{}, now, 10)
+	require.Equal(t, 0, total)
+	_, total = getMissedRuns(sched, time.Time{}, time.Time{}, now, 10)
+	require.Equal(t, 0, total)
+}
+
+func TestGetMisfireRuns(t *testing.T) {
+	missed := []time.Time{
+		time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC),
+	}
+
+	fired, skipped := getMisfireRuns(models2.ScheduleV2{}, missed)
+	require.Len(t, fired, 0)
+	require.Equal(t, missed, skipped)
+
+	fired, skipped = getMisfireRuns(models2.ScheduleV2{MisfirePolicy: constants.ScheduleMisfirePolicyFireOnce}, missed)
+	require.Equal(t, missed[2:], fired)
+	require.Equal(t, missed[:2], skipped)
+
+	fired, skipped = getMisfireRuns(models2.ScheduleV2{MisfirePolicy: constants.ScheduleMisfirePolicyFireAll, MisfireLimit: 2}, missed)
+	require.Equal(t, missed[1:], fired)
+	require.Equal(t, missed[:1], skipped)
+
+	fired, skipped = getMisfireRuns(models2.ScheduleV2{MisfirePolicy: constants.ScheduleMisfirePolicyFireAll}, missed)
+	require.Equal(t, missed, fired)
+	require.Len(t, skipped, 0)
+}
@@ -1,9 +1,11 @@
 package admin
 
 import (
+	errors2 "errors"
 	log2 "github.com/apex/log"
 	config2 "github.com/crawlab-team/crawlab/core/config"
 	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/errors"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
@@ -14,6 +16,7 @@ import (
 	"github.com/robfig/cron/v3"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
 	"sync"
 )
 
@@ -39,7 +42,33 @@ func (svc *ServiceV2) Schedule(id primitive.ObjectID, opts *interfaces.SpiderRun
 	return svc.scheduleTasks(s, opts)
 }
 
+// Restart reruns a task as new tasks. A parent task is rerun as a new parent task with sub-tasks on
+// the nodes of its sub-tasks.
+func (svc *ServiceV2) Restart(t *models2.TaskV2, by primitive.ObjectID) (taskIds []primitive.ObjectID, err error) {
+	var subTasks []models2.TaskV2
+	if t.HasSub {
+		subTasks, err = service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{
+			"parent_id":        t.Id,
+			"first_attempt_id": bson.M{"$exists": false},
+		}, nil)
+		if err != nil && !errors2.Is(err, mongo2.ErrNoDocuments) {
+			return nil, err
+		}
+	}
+	opts := getRestartOptions(t, subTasks)
+	opts.UserId = by
+	return svc.Schedule(t.SpiderId, opts)
+}
+
 func (svc *ServiceV2) scheduleTasks(s *models2.SpiderV2, opts *interfaces.SpiderRunOptions) (taskIds []primitive.ObjectID, err error) {
+	// normalize options
+	if opts.Mode == "" {
+		opts.Mode = s.Mode
+	}
+	if opts.NodeIds == nil {
+		opts.NodeIds = s.NodeIds
+	}
+
 	// main task
 	t := &models2.TaskV2{
 		SpiderId:   s.Id,
@@ -49,16 +78,15 @@ func (svc *ServiceV2) scheduleTasks(s *models2.SpiderV2, opts *interfaces.Spider
 		Param:      opts.Param,
 		ScheduleId: opts.ScheduleId,
 		Priority:   opts.Priority,
+		TriggerId:  opts.TriggerId,
 	}
 	t.SetId(primitive.NewObjectID())
+	t.TriggerDepth = opts.Depth
+	t.WorkflowRunId = opts.WorkflowRunId
+	t.WorkflowStep = opts.WorkflowStep
+	t.UpstreamTaskIds = opts.UpstreamTaskIds
 
 	// normalize
-	if t.Mode == "" {
-		t.Mode = s.Mode
-	}
-	if t.NodeIds == nil {
-		t.NodeIds = s.NodeIds
-	}
 	if t.Cmd == "" {
 		t.Cmd = s.Cmd
 	}
@@ -68,21 +96,137 @@ func (svc *ServiceV2) scheduleTasks(s *models2.SpiderV2, opts *interfaces.Spider
 	if t.Priority == 0 {
 		t.Priority = s.Priority
 	}
+	sch := svc.getSchedule(opts)
+	t.Limits = getLimits(s, sch)
+	t.RetryPolicy = getRetryPolicy(s, sch)
+	if step := svc.getWorkflowStep(opts); step != nil && step.RetryPolicy.MaxAttempts > 0 {
+		t.RetryPolicy = step.RetryPolicy
+	}
 
 	nodeIds, err := svc.getNodeIds(opts)
 	if err != nil {
 		return nil, err
 	}
-	if len(nodeIds) > 0 {
-		t.NodeId = nodeIds[0]
+
+	if svc.isMultiTask(opts) {
+		// parent task (not executed by any runner)
+		t.HasSub = true
+		t2, err := svc.schedulerSvc.Enqueue(t, opts.UserId)
+		if err != nil {
+			return nil, err
+		}
+		taskIds = append(taskIds, t2.Id)
+
+		// sub-tasks
+		for _, nodeId := range nodeIds {
+			st := &models2.TaskV2{
+				SpiderId:    t.SpiderId,
+				Mode:        constants.RunTypeSelectedNodes,
+				NodeId:      nodeId,
+				NodeIds:     []primitive.ObjectID{nodeId},
+				Cmd:         t.Cmd,
+				Param:       t.Param,
+				ScheduleId:  t.ScheduleId,
+				Priority:    t.Priority,
+				Limits:      t.Limits,
+				RetryPolicy: t.RetryPolicy,
+				ParentId:    t.Id,
+				TriggerId:   t.TriggerId,
+			}
+			st.SetId(primitive.NewObjectID())
+			st.TriggerDepth = t.TriggerDepth
+			st.WorkflowRunId = t.WorkflowRunId
+			st.WorkflowStep = t.WorkflowStep
+			st.UpstreamTaskIds = t.UpstreamTaskIds
+			st2, err := svc.schedulerSvc.Enqueue(st, opts.UserId)
+			if err != nil {
+				return nil, err
+			}
+			taskIds = append(taskIds, st2.Id)
+		}
+	} else {
+		// single task
+		if len(nodeIds) > 0 {
+			t.NodeId = nodeIds[0]
+		}
+		t2, err := svc.schedulerSvc.Enqueue(t, opts.UserId)
+		if err != nil {
+			return nil, err
+		}
+		taskIds = append(taskIds, t2.Id)
+	}
+
+	return taskIds, nil
+}
+
+// getRestartOptions returns options of rerunning a task, which runs on the nodes of its sub-tasks
+// if it is a parent task
+func getRestartOptions(t *models2.TaskV2, subTasks []models2.TaskV2) (opts *interfaces.SpiderRunOptions) {
+	opts = &interfaces.SpiderRunOptions{
+		Mode:     t.Mode,
+		NodeIds:  t.NodeIds,
+		Cmd:      t.Cmd,
+		Param:    t.Param,
+		Priority: t.Priority,
+	}
+	if len(subTasks) > 0 {
+		opts.Mode = constants.RunTypeSelectedNodes
+		opts.NodeIds = nil
+		for _, st := range subTasks {
+			opts.NodeIds = append(opts.NodeIds, st.NodeId)
+		}
 	}
-	t2, err := svc.schedulerSvc.Enqueue(t, opts.UserId)
+	return opts
+}
+
+// getSchedule returns the schedule which tasks are scheduled by, or nil if scheduled manually
+func (svc *ServiceV2) getSchedule(opts *interfaces.SpiderRunOptions) (sch *models2.ScheduleV2) {
+	if opts.ScheduleId.IsZero() {
+		return nil
+	}
+	sch, err := service.NewModelServiceV2[models2.ScheduleV2]().GetById(opts.ScheduleId)
 	if err != nil {
-		return nil, err
+		trace.PrintError(err)
+		return nil
 	}
-	taskIds = append(taskIds, t2.Id)
+	return sch
+}
 
-	return taskIds, nil
+// getWorkflowStep returns the workflow step which tasks are scheduled by, or nil if not scheduled
+// by a workflow run
+func (svc *ServiceV2) getWorkflowStep(opts *interfaces.SpiderRunOptions) (step *entity.WorkflowRunStep) {
+	if opts.WorkflowRunId.IsZero() {
+		return nil
+	}
+	run, err := service.NewModelServiceV2[models2.WorkflowRunV2]().GetById(opts.WorkflowRunId)
+	if err != nil {
+		trace.PrintError(err)
+		return nil
+	}
+	for i := range run.Steps {
+		if run.Steps[i].Key == opts.WorkflowStep {
+			return &run.Steps[i]
+		}
+	}
+	return nil
+}
+
+// getLimits returns resource limits of tasks, which are the limits of the schedule (if any)
+// with unset ones taken from the spider
+func getLimits(s *models2.SpiderV2, sch *models2.ScheduleV2) (limits entity.TaskLimits) {
+	if sch == nil {
+		return s.Limits
+	}
+	return sch.Limits.WithDefaults(s.Limits)
+}
+
+// getRetryPolicy returns retry policy of tasks, which is the policy of the schedule (if set)
+// or otherwise the policy of the spider
+func getRetryPolicy(s *models2.SpiderV2, sch *models2.ScheduleV2) (policy entity.TaskRetryPolicy) {
+	if sch != nil && sch.RetryPolicy.MaxAttempts > 0 {
+		return sch.RetryPolicy
+	}
+	return s.RetryPolicy
 }
 
 func (svc *ServiceV2) getNodeIds(opts *interfaces.SpiderRunOptions) (nodeIds []primitive.ObjectID, err error) {
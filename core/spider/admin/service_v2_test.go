@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestGetRestartOptions(t *testing.T) {
+	nodeIds := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+
+	// single task
+	task := &models2.TaskV2{
+		Mode:     constants.RunTypeRandom,
+		Cmd:      "python main.py",
+		Param:    "--page 1",
+		Priority: 3,
+	}
+	opts := getRestartOptions(task, nil)
+	require.Equal(t, constants.RunTypeRandom, opts.Mode)
+	require.Empty(t, opts.NodeIds)
+	require.Equal(t, "python main.py", opts.Cmd)
+	require.Equal(t, "--page 1", opts.Param)
+	require.Equal(t, 3, opts.Priority)
+
+	// parent task of all nodes reruns on the nodes of its sub-tasks
+	task = &models2.TaskV2{Mode: constants.RunTypeAllNodes, HasSub: true}
+	opts = getRestartOptions(task, []models2.TaskV2{
+		{NodeId: nodeIds[0], ParentId: task.Id},
+		{NodeId: nodeIds[1], ParentId: task.Id},
+	})
+	require.Equal(t, constants.RunTypeSelectedNodes, opts.Mode)
+	require.Equal(t, nodeIds, opts.NodeIds)
+
+	// sub-task reruns on its node
+	task = &models2.TaskV2{Mode: constants.RunTypeSelectedNodes, NodeId: nodeIds[1], NodeIds: nodeIds[1:]}
+	opts = getRestartOptions(task, nil)
+	require.Equal(t, constants.RunTypeSelectedNodes, opts.Mode)
+	require.Equal(t, nodeIds[1:], opts.NodeIds)
+}
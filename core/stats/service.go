@@ -205,11 +205,30 @@ func (svc *Service) getTaskStatsByStatus(query bson.M) (data interface{}, err er
 func (svc *Service) getTaskStatsByNode(query bson.M) (data interface{}, err error) {
 	pipeline := mongo2.Pipeline{
 		{{"$match", query}},
+		// resource usage in task stat, which is missing for tasks without recorded usage
+		// and thus ignored by $avg and $max
+		{{
+			"$lookup",
+			bson.M{
+				"from":         interfaces.ModelColNameTaskStat,
+				"localField":   "_id",
+				"foreignField": "_id",
+				"as":           "_ts",
+			},
+		}},
+		{{
+			"$unwind",
+			bson.M{"path": "$_ts", "preserveNullAndEmptyArrays": true},
+		}},
 		{{
 			"$group",
 			bson.M{
-				"_id":   "$node_id",
-				"tasks": bson.M{"$sum": 1},
+				"_id":               "$node_id",
+				"tasks":             bson.M{"$sum": 1},
+				"avg_max_rss":       bson.M{"$avg": "$_ts.max_rss"},
+				"max_max_rss":       bson.M{"$max": "$_ts.max_rss"},
+				"avg_user_cpu_time": bson.M{"$avg": "$_ts.user_cpu_time"},
+				"avg_sys_cpu_time":  bson.M{"$avg": "$_ts.sys_cpu_time"},
 			},
 		}},
 		{{
@@ -224,10 +243,14 @@ func (svc *Service) getTaskStatsByNode(query bson.M) (data interface{}, err erro
 		{{
 			"$project",
 			bson.M{
-				"node_id":   "$node_id",
-				"node":      bson.M{"$arrayElemAt": bson.A{"$_n", 0}},
-				"node_name": bson.M{"$arrayElemAt": bson.A{"$_n.name", 0}},
-				"tasks":     "$tasks",
+				"node_id":           "$node_id",
+				"node":              bson.M{"$arrayElemAt": bson.A{"$_n", 0}},
+				"node_name":         bson.M{"$arrayElemAt": bson.A{"$_n.name", 0}},
+				"tasks":             "$tasks",
+				"avg_max_rss":       "$avg_max_rss",
+				"max_max_rss":       "$max_max_rss",
+				"avg_user_cpu_time": "$avg_user_cpu_time",
+				"avg_sys_cpu_time":  "$avg_sys_cpu_time",
 			},
 		}},
 	}
@@ -241,11 +264,30 @@ func (svc *Service) getTaskStatsByNode(query bson.M) (data interface{}, err erro
 func (svc *Service) getTaskStatsBySpider(query bson.M) (data interface{}, err error) {
 	pipeline := mongo2.Pipeline{
 		{{"$match", query}},
+		// resource usage in task stat, which is missing for tasks without recorded usage
+		// and thus ignored by $avg and $max
+		{{
+			"$lookup",
+			bson.M{
+				"from":         interfaces.ModelColNameTaskStat,
+				"localField":   "_id",
+				"foreignField": "_id",
+				"as":           "_ts",
+			},
+		}},
+		{{
+			"$unwind",
+			bson.M{"path": "$_ts", "preserveNullAndEmptyArrays": true},
+		}},
 		{{
 			"$group",
 			bson.M{
-				"_id":   "$spider_id",
-				"tasks": bson.M{"$sum": 1},
+				"_id":               "$spider_id",
+				"tasks":             bson.M{"$sum": 1},
+				"avg_max_rss":       bson.M{"$avg": "$_ts.max_rss"},
+				"max_max_rss":       bson.M{"$max": "$_ts.max_rss"},
+				"avg_user_cpu_time": bson.M{"$avg": "$_ts.user_cpu_time"},
+				"avg_sys_cpu_time":  bson.M{"$avg": "$_ts.sys_cpu_time"},
 			},
 		}},
 		{{
@@ -260,10 +302,14 @@ func (svc *Service) getTaskStatsBySpider(query bson.M) (data interface{}, err er
 		{{
 			"$project",
 			bson.M{
-				"spider_id":   "$spider_id",
-				"spider":      bson.M{"$arrayElemAt": bson.A{"$_s", 0}},
-				"spider_name": bson.M{"$arrayElemAt": bson.A{"$_s.name", 0}},
-				"tasks":       "$tasks",
+				"spider_id":         "$spider_id",
+				"spider":            bson.M{"$arrayElemAt": bson.A{"$_s", 0}},
+				"spider_name":       bson.M{"$arrayElemAt": bson.A{"$_s.name", 0}},
+				"tasks":             "$tasks",
+				"avg_max_rss":       "$avg_max_rss",
+				"max_max_rss":       "$max_max_rss",
+				"avg_user_cpu_time": "$avg_user_cpu_time",
+				"avg_sys_cpu_time":  "$avg_sys_cpu_time",
 			},
 		}},
 		{{"$limit", 10}},
@@ -0,0 +1,161 @@
+//go:build linux
+// +build linux
+
+package sys_exec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	cgroupRoot      = "/sys/fs/cgroup"
+	cgroupCpuPeriod = 100000 // cpu.max period in microseconds
+)
+
+// Cgroup is a cgroup v2 in which a process is started with resource limits applied
+type Cgroup struct {
+	path string
+	f    *os.File
+}
+
+type CgroupOptions struct {
+	MemoryLimit int64   // memory limit in bytes
+	CpuLimit    float64 // cpu quota in cores
+}
+
+// Attach makes the command start inside the cgroup
+func (cg *Cgroup) Attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.f.Fd())
+}
+
+// OomKilled returns whether any process in the cgroup was killed by the OOM killer
+func (cg *Cgroup) OomKilled() (ok bool) {
+	f, err := os.Open(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n > 0
+		}
+	}
+	return false
+}
+
+// GetPeakMemory returns peak memory usage in bytes of all processes in the cgroup, or 0 if
+// not available (requires linux 5.19+)
+func (cg *Cgroup) GetPeakMemory() (peak int64) {
+	data, err := os.ReadFile(filepath.Join(cg.path, "memory.peak"))
+	if err != nil {
+		return 0
+	}
+	peak, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return peak
+}
+
+// Remove removes the cgroup, which must not contain any process
+func (cg *Cgroup) Remove() (err error) {
+	_ = cg.f.Close()
+	if err := os.Remove(cg.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// NewCgroup creates a cgroup named name under parent (absolute path in the cgroup v2 hierarchy)
+// with memory and cpu limits. An error is returned if cgroup v2 is not available or the
+// required controllers cannot be enabled.
+func NewCgroup(parent string, name string, opts *CgroupOptions) (cg *Cgroup, err error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, errors.New("cgroup v2 is not available")
+	}
+	if !strings.HasPrefix(parent, cgroupRoot+"/") {
+		return nil, errors.New(fmt.Sprintf("cgroup path %s is not under %s", parent, cgroupRoot))
+	}
+
+	// controllers
+	var controllers []string
+	if opts.MemoryLimit > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if opts.CpuLimit > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+
+	// enable controllers from root down to parent
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	dir := cgroupRoot
+	for _, part := range strings.Split(strings.TrimPrefix(parent, cgroupRoot+"/"), "/") {
+		if len(controllers) > 0 {
+			if err := writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+				return nil, err
+			}
+		}
+		dir = filepath.Join(dir, part)
+	}
+	if len(controllers) > 0 {
+		if err := writeCgroupFile(parent, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+			return nil, err
+		}
+	}
+
+	// cgroup
+	p := filepath.Join(parent, name)
+	if err := os.Mkdir(p, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	cg = &Cgroup{path: p}
+
+	// limits
+	if opts.MemoryLimit > 0 {
+		if err := writeCgroupFile(p, "memory.max", strconv.FormatInt(opts.MemoryLimit, 10)); err != nil {
+			_ = os.Remove(p)
+			return nil, err
+		}
+		// do not let the task swap instead of being killed
+		_ = writeCgroupFile(p, "memory.swap.max", "0")
+		// kill the whole process tree of the task on oom instead of a single process in it
+		_ = writeCgroupFile(p, "memory.oom.group", "1")
+	}
+	if opts.CpuLimit > 0 {
+		quota := int64(opts.CpuLimit * cgroupCpuPeriod)
+		if err := writeCgroupFile(p, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCpuPeriod)); err != nil {
+			_ = os.Remove(p)
+			return nil, err
+		}
+	}
+
+	// directory fd used to start process inside the cgroup
+	cg.f, err = os.Open(p)
+	if err != nil {
+		_ = os.Remove(p)
+		return nil, err
+	}
+
+	return cg, nil
+}
+
+func writeCgroupFile(dir string, name string, value string) (err error) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return errors.New(fmt.Sprintf("error writing %s to %s: %v", value, filepath.Join(dir, name), err))
+	}
+	return nil
+}
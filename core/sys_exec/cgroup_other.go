@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package sys_exec
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Cgroup is not supported on platforms other than linux
+type Cgroup struct{}
+
+type CgroupOptions struct {
+	MemoryLimit int64   // memory limit in bytes
+	CpuLimit    float64 // cpu quota in cores
+}
+
+func (cg *Cgroup) Attach(cmd *exec.Cmd) {}
+
+func (cg *Cgroup) OomKilled() (ok bool) {
+	return false
+}
+
+func (cg *Cgroup) GetPeakMemory() (peak int64) {
+	return 0
+}
+
+func (cg *Cgroup) Remove() (err error) {
+	return nil
+}
+
+func NewCgroup(parent string, name string, opts *CgroupOptions) (cg *Cgroup, err error) {
+	return nil, errors.New("cgroup is not supported on this platform")
+}
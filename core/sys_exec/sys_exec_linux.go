@@ -5,6 +5,7 @@ package sys_exec
 
 import (
 	"errors"
+	"os"
 	"os/exec"
 	"strings"
 	"syscall"
@@ -28,3 +29,36 @@ func SetPgid(cmd *exec.Cmd) {
 		cmd.SysProcAttr.Setpgid = true
 	}
 }
+
+// SignalProcessGroup sends a signal to the process group led by the process of cmd, which
+// must be started with SetPgid
+func SignalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) (err error) {
+	if cmd == nil || cmd.Process == nil {
+		return errors.New("process is not started")
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+// ProcessGroupExists returns whether any process in the process group led by the process of cmd exists
+func ProcessGroupExists(cmd *exec.Cmd) (ok bool) {
+	if cmd == nil || cmd.Process == nil {
+		return false
+	}
+	return syscall.Kill(-cmd.Process.Pid, 0) == nil
+}
+
+// GetMaxRss returns peak resident memory in bytes of an exited process (including its waited
+// descendants), or 0 if not available
+func GetMaxRss(ps *os.ProcessState) (rss int64) {
+	if ps == nil {
+		return 0
+	}
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return 0
+	}
+	return int64(ru.Maxrss) * 1024 // maxrss is in kilobytes on linux
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package sys_exec
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalProcessGroup(t *testing.T) {
+	// shell with a background grandchild
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30")
+	SetPgid(cmd)
+	require.Nil(t, cmd.Start())
+	done := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+	require.True(t, ProcessGroupExists(cmd))
+
+	require.Nil(t, SignalProcessGroup(cmd, syscall.SIGTERM))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process not terminated")
+	}
+
+	// grandchild is terminated as well
+	require.Eventually(t, func() bool {
+		return !ProcessGroupExists(cmd)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// signalling an exited group is not an error
+	require.Nil(t, SignalProcessGroup(cmd, syscall.SIGKILL))
+}
+
+func TestGetMaxRss(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+	require.NotNil(t, cmd.Run())
+	require.Equal(t, 3, cmd.ProcessState.ExitCode())
+	require.Greater(t, GetMaxRss(cmd.ProcessState), int64(0))
+	require.Equal(t, int64(0), GetMaxRss(nil))
+}
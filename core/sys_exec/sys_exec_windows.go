@@ -5,8 +5,11 @@ package sys_exec
 
 import (
 	"errors"
+	"github.com/shirou/gopsutil/process"
+	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
 func BuildCmd(cmdStr string) (cmd *exec.Cmd, err error) {
@@ -16,3 +19,30 @@ func BuildCmd(cmdStr string) (cmd *exec.Cmd, err error) {
 	args := strings.Split(cmdStr, " ")
 	return exec.Command(args[0], args[1:]...), nil
 }
+
+// SetPgid is not supported on windows
+func SetPgid(cmd *exec.Cmd) {
+}
+
+// SignalProcessGroup terminates the process tree of cmd, as signals other than kill are not
+// supported on windows
+func SignalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) (err error) {
+	if cmd == nil || cmd.Process == nil {
+		return errors.New("process is not started")
+	}
+	return KillProcess(cmd, &KillProcessOptions{Force: sig == syscall.SIGKILL})
+}
+
+// ProcessGroupExists returns whether the process of cmd exists
+func ProcessGroupExists(cmd *exec.Cmd) (ok bool) {
+	if cmd == nil || cmd.Process == nil {
+		return false
+	}
+	ok, _ = process.PidExists(int32(cmd.Process.Pid))
+	return ok
+}
+
+// GetMaxRss is not supported on windows
+func GetMaxRss(ps *os.ProcessState) (rss int64) {
+	return 0
+}
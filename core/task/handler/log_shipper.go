@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/crawlab-team/crawlab/core/entity"
+	log2 "github.com/crawlab-team/crawlab/core/task/log"
+	"github.com/crawlab-team/crawlab/trace"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// logShipper ships log entries of a task runner to master in batches. Entries are batched by
+// size and time, buffered in a bounded channel and spooled to disk when sending fails, and
+// the spool is replayed in order once sending succeeds again. When both the channel and the
+// spool are full, writers are blocked, which in turn blocks the task process on its output.
+type logShipper struct {
+	// dependencies
+	send      func(entries []entity.TaskLogEntry) (err error) // send a batch to master
+	reconnect func() (err error)                              // re-establish stream after failure
+
+	// settings
+	batchSize       int
+	flushInterval   time.Duration
+	maxSpoolEntries int
+	closeTimeout    time.Duration
+	spoolPath       string
+
+	// internals
+	mu          sync.Mutex // guards seq and ch order
+	seq         int64      // sequence number of last log entry
+	ch          chan entity.TaskLogEntry
+	closed      chan struct{}
+	closeOnce   sync.Once
+	done        chan struct{}
+	batch       []entity.TaskLogEntry
+	spoolCount  int       // number of entries in spool
+	broken      bool      // whether the stream needs to be re-established
+	nextRetryTs time.Time // next time to retry after failure
+	b           *backoff.ExponentialBackOff
+}
+
+// Write creates a log entry with the next sequence number, capture timestamp and detected level,
+// and blocks when buffer is full. Entries written after Close are dropped.
+func (s *logShipper) Write(stream string, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	e := entity.TaskLogEntry{
+		Seq:    s.seq,
+		Stream: stream,
+		Level:  log2.DetectLevel(line),
+		Ts:     time.Now(),
+		Msg:    line,
+	}
+	select {
+	case s.ch <- e:
+	case <-s.closed:
+	}
+}
+
+// Start starts the shipping loop
+func (s *logShipper) Start() {
+	go s.loop()
+}
+
+// Close stops accepting entries and flushes remaining entries, retrying until close timeout
+func (s *logShipper) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	<-s.done
+}
+
+func (s *logShipper) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		// stop consuming entries if spool is full (back-pressure)
+		ch := s.ch
+		if s.spoolCount+len(s.batch) >= s.maxSpoolEntries {
+			ch = nil
+		}
+
+		select {
+		case e := <-ch:
+			s.batch = append(s.batch, e)
+			if len(s.batch) >= s.batchSize {
+				s.flush()
+			}
+		case <-ticker.C:
+			s.flush()
+		case <-s.closed:
+			s.drain()
+			return
+		}
+	}
+}
+
+// drain flushes remaining entries until everything is sent or close timeout is reached
+func (s *logShipper) drain() {
+	// remaining buffered entries
+	for len(s.ch) > 0 {
+		s.batch = append(s.batch, <-s.ch)
+	}
+
+	deadline := time.Now().Add(s.closeTimeout)
+	for {
+		s.nextRetryTs = time.Time{}
+		s.flush()
+		if len(s.batch) == 0 && s.spoolCount == 0 {
+			_ = os.Remove(s.spoolPath)
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warnf("[logShipper] failed to ship %d log entries before timeout, kept in spool: %s", s.spoolCount+len(s.batch), s.spoolPath)
+			if len(s.batch) > 0 {
+				_ = s.spool(s.batch)
+			}
+			return
+		}
+		time.Sleep(s.flushInterval)
+	}
+}
+
+// flush replays spooled entries and then sends current batch, spooling it on failure
+func (s *logShipper) flush() {
+	if len(s.batch) == 0 && s.spoolCount == 0 {
+		return
+	}
+
+	// wait until next retry after failure
+	if time.Now().Before(s.nextRetryTs) {
+		s.spoolBatch()
+		return
+	}
+
+	// re-establish stream
+	if s.broken {
+		if err := s.reconnect(); err != nil {
+			s.fail(err)
+			s.spoolBatch()
+			return
+		}
+		s.broken = false
+	}
+
+	// replay spool
+	if s.spoolCount > 0 {
+		if err := s.replay(); err != nil {
+			s.fail(err)
+			s.spoolBatch()
+			return
+		}
+	}
+
+	// send current batch
+	if len(s.batch) > 0 {
+		if err := s.send(s.batch); err != nil {
+			s.fail(err)
+			s.spoolBatch()
+			return
+		}
+		s.batch = nil
+	}
+
+	s.b.Reset()
+}
+
+func (s *logShipper) fail(err error) {
+	log.Warnf("[logShipper] failed to send log entries: %v", err)
+	s.broken = true
+	s.nextRetryTs = time.Now().Add(s.b.NextBackOff())
+}
+
+// spoolBatch moves current batch to spool if any
+func (s *logShipper) spoolBatch() {
+	if len(s.batch) == 0 {
+		return
+	}
+	if err := s.spool(s.batch); err != nil {
+		// keep entries in memory and retry spooling later
+		trace.PrintError(err)
+		return
+	}
+	s.batch = nil
+}
+
+// spool appends a batch to spool file as a json line
+func (s *logShipper) spool(entries []entity.TaskLogEntry) (err error) {
+	if err := os.MkdirAll(filepath.Dir(s.spoolPath), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.spoolPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.spoolCount += len(entries)
+	return nil
+}
+
+// loadSpool counts entries left in spool file by a previous shipper, so that they are replayed
+// on next flush
+func (s *logShipper) loadSpool() (err error) {
+	f, err := os.Open(s.spoolPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for sc.Scan() {
+		var entries []entity.TaskLogEntry
+		if err := json.Unmarshal(sc.Bytes(), &entries); err != nil {
+			continue
+		}
+		s.spoolCount += len(entries)
+	}
+	return sc.Err()
+}
+
+// replay sends spooled batches in order, and keeps the unsent ones in spool
+func (s *logShipper) replay() (err error) {
+	f, err := os.Open(s.spoolPath)
+	if err != nil {
+		return err
+	}
+	var batches [][]entity.TaskLogEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for sc.Scan() {
+		var entries []entity.TaskLogEntry
+		if err := json.Unmarshal(sc.Bytes(), &entries); err != nil {
+			continue
+		}
+		batches = append(batches, entries)
+	}
+	_ = f.Close()
+
+	for i, entries := range batches {
+		if err := s.send(entries); err != nil {
+			// rewrite spool with unsent batches
+			_ = os.Remove(s.spoolPath)
+			s.spoolCount = 0
+			for _, rest := range batches[i:] {
+				if err := s.spool(rest); err != nil {
+					trace.PrintError(err)
+				}
+			}
+			return err
+		}
+	}
+
+	// all sent
+	s.spoolCount = 0
+	return os.Remove(s.spoolPath)
+}
+
+func newLogShipper(spoolPath string, send func(entries []entity.TaskLogEntry) error, reconnect func() error) (s *logShipper) {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 500 * time.Millisecond
+	b.MaxInterval = 30 * time.Second
+	b.MaxElapsedTime = 0 // retry until closed
+	return &logShipper{
+		send:            send,
+		reconnect:       reconnect,
+		batchSize:       20,
+		flushInterval:   500 * time.Millisecond,
+		maxSpoolEntries: 100000,
+		closeTimeout:    30 * time.Second,
+		spoolPath:       spoolPath,
+		ch:              make(chan entity.TaskLogEntry, 1000),
+		closed:          make(chan struct{}),
+		done:            make(chan struct{}),
+		b:               b,
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogShipper_SpoolAndReplay(t *testing.T) {
+	var mu sync.Mutex
+	var sent []entity.TaskLogEntry
+	down := true
+	send := func(entries []entity.TaskLogEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			return errors.New("stream closed")
+		}
+		sent = append(sent, entries...)
+		return nil
+	}
+	reconnects := 0
+	reconnect := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		reconnects++
+		return nil
+	}
+
+	s := newLogShipper(filepath.Join(t.TempDir(), "spool.jsonl"), send, reconnect)
+	s.batchSize = 3
+	s.flushInterval = 10 * time.Millisecond
+	s.b.InitialInterval = 10 * time.Millisecond
+	s.b.Reset()
+	s.Start()
+
+	for i := 0; i < 10; i++ {
+		s.Write(constants.LogStreamStdout, "line")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// master is back
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	for i := 0; i < 5; i++ {
+		s.Write(constants.LogStreamStderr, "ERROR: line")
+	}
+	s.Close()
+
+	require.Len(t, sent, 15)
+	for i, e := range sent {
+		require.Equal(t, int64(i+1), e.Seq)
+	}
+	require.Equal(t, constants.LogLevelError, sent[14].Level)
+	require.Greater(t, reconnects, 0)
+	require.Equal(t, 0, s.spoolCount)
+}
+
+func TestLogShipper_BackPressure(t *testing.T) {
+	send := func(entries []entity.TaskLogEntry) error {
+		return errors.New("stream closed")
+	}
+	reconnect := func() error {
+		return errors.New("master unavailable")
+	}
+
+	s := newLogShipper(filepath.Join(t.TempDir(), "spool.jsonl"), send, reconnect)
+	s.batchSize = 2
+	s.flushInterval = 10 * time.Millisecond
+	s.maxSpoolEntries = 4
+	s.closeTimeout = 50 * time.Millisecond
+	s.ch = make(chan entity.TaskLogEntry, 2)
+	s.Start()
+
+	// writer is blocked once both spool and channel are full
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			s.Write(constants.LogStreamStdout, "line")
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("writer should be blocked")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// close unblocks writer and keeps unsent entries in spool
+	s.Close()
+	<-written
+	require.GreaterOrEqual(t, s.spoolCount, 4)
+}
+
+func TestLogShipper_LoadSpool(t *testing.T) {
+	spoolPath := filepath.Join(t.TempDir(), "spool.jsonl")
+
+	// spool left by a previous shipper
+	s := newLogShipper(spoolPath, nil, nil)
+	require.Nil(t, s.spool([]entity.TaskLogEntry{{Seq: 1}, {Seq: 2}}))
+	require.Nil(t, s.spool([]entity.TaskLogEntry{{Seq: 3}}))
+
+	var sent []entity.TaskLogEntry
+	s = newLogShipper(spoolPath, func(entries []entity.TaskLogEntry) error {
+		sent = append(sent, entries...)
+		return nil
+	}, func() error {
+		return nil
+	})
+	require.Nil(t, s.loadSpool())
+	require.Equal(t, 3, s.spoolCount)
+	s.Start()
+	s.Close()
+
+	require.Len(t, sent, 3)
+	for i, e := range sent {
+		require.Equal(t, int64(i+1), e.Seq)
+	}
+	require.NoFileExists(t, spoolPath)
+}
@@ -1,9 +1,9 @@
 package handler
 
 import (
-	"github.com/crawlab-team/crawlab/core/models/models"
-	"github.com/google/uuid"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -12,59 +12,47 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 )
 
-type MockRunner struct {
-	mock.Mock
-	Runner
-}
-
-func (m *MockRunner) downloadFile(url string, filePath string) error {
-	args := m.Called(url, filePath)
-	return args.Error(0)
-}
-
-func newMockRunner() *MockRunner {
-	r := &MockRunner{}
-	r.s = &models.Spider{}
-	return r
+func newTestRunner(t *testing.T) *RunnerV2 {
+	s := &models2.SpiderV2{}
+	s.SetId(primitive.NewObjectID())
+	return &RunnerV2{
+		s:   s,
+		cwd: filepath.Join(t.TempDir(), s.Id.Hex()),
+	}
 }
 
 func TestSyncFiles_SuccessWithDummyFiles(t *testing.T) {
 	// Create a test server that responds with a list of files
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/scan") {
-			w.Write([]byte(`{"file1.txt":{"path": "file1.txt", "hash": "hash1"}, "file2.txt":{"path": "file2.txt", "hash": "hash2"}}`))
+			w.Write([]byte(`{"file1.txt":{"path": "file1.txt", "hash": "hash1", "mode": 420}, "file2.txt":{"path": "file2.txt", "hash": "hash2", "mode": 420}}`))
 			return
 		}
 
 		if strings.HasSuffix(r.URL.Path, "/download") {
-			w.Write([]byte("file content"))
+			w.Write([]byte("file content " + r.URL.Query().Get("path")))
 			return
 		}
 	}))
 	defer ts.Close()
 
-	// Create a mock runner
-	r := newMockRunner()
-	r.On("downloadFile", mock.Anything, mock.Anything).Return(nil)
-
 	// Set the master URL to the test server URL
 	viper.Set("api.endpoint", ts.URL)
-
-	localPath := filepath.Join(os.TempDir(), uuid.New().String())
-	os.MkdirAll(filepath.Join(localPath, r.s.GetId().Hex()), os.ModePerm)
-	defer os.RemoveAll(localPath)
-	viper.Set("workspace", localPath)
+	defer viper.Set("api.endpoint", nil)
 
 	// Call the method under test
+	r := newTestRunner(t)
 	err := r.syncFiles()
 	assert.NoError(t, err)
 
 	// Assert that the files were downloaded
-	assert.FileExists(t, filepath.Join(localPath, r.s.GetId().Hex(), "file1.txt"))
-	assert.FileExists(t, filepath.Join(localPath, r.s.GetId().Hex(), "file2.txt"))
+	for _, name := range []string{"file1.txt", "file2.txt"} {
+		data, err := os.ReadFile(filepath.Join(r.cwd, name))
+		assert.NoError(t, err)
+		assert.Equal(t, "file content "+name, string(data))
+	}
 }
 
 func TestSyncFiles_DeletesFilesNotOnMaster(t *testing.T) {
@@ -77,22 +65,15 @@ func TestSyncFiles_DeletesFilesNotOnMaster(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	// Create a mock runner
-	r := newMockRunner()
-	r.On("downloadFile", mock.Anything, mock.Anything).Return(nil)
-
 	// Set the master URL to the test server URL
 	viper.Set("api.endpoint", ts.URL)
-
-	localPath := filepath.Join(os.TempDir(), uuid.New().String())
-	os.MkdirAll(filepath.Join(localPath, r.s.GetId().Hex()), os.ModePerm)
-	defer os.RemoveAll(localPath)
-	viper.Set("workspace", localPath)
+	defer viper.Set("api.endpoint", nil)
 
 	// Create a dummy file that should be deleted
-	dummyFilePath := filepath.Join(localPath, r.s.GetId().Hex(), "dummy.txt")
-	dummyFile, _ := os.Create(dummyFilePath)
-	dummyFile.Close()
+	r := newTestRunner(t)
+	assert.NoError(t, os.MkdirAll(r.cwd, os.ModePerm))
+	dummyFilePath := filepath.Join(r.cwd, "dummy.txt")
+	assert.NoError(t, os.WriteFile(dummyFilePath, []byte("dummy"), 0644))
 
 	// Call the method under test
 	err := r.syncFiles()
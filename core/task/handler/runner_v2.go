@@ -32,6 +32,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -58,9 +59,26 @@ type RunnerV2 struct {
 	sub  grpc.TaskService_SubscribeClient // grpc task service stream client
 
 	// log internals
-	scannerStdout *bufio.Reader
-	scannerStderr *bufio.Reader
-	logBatchSize  int
+	scannerStdout  *bufio.Reader
+	scannerStderr  *bufio.Reader
+	logBatchSize   int
+	logReadTimeout time.Duration  // max time to wait for log readers after process ends
+	logWg          sync.WaitGroup // wait group of log readers
+	logShipper     *logShipper    // ships log entries to master
+	subMu          sync.Mutex     // guards sub, which is reassigned by log shipper on reconnect
+
+	// exit internals
+	exited     chan struct{} // closed when process exits
+	exitMu     sync.Mutex
+	exitReason string // reason of process exit set by runner, e.g. cancellation
+	cancelled  bool   // whether the task is cancelled
+
+	// limits internals
+	cg          *sys_exec.Cgroup // cgroup enforcing memory and cpu limits (linux only)
+	limitMu     sync.Mutex
+	limitStatus string        // task status set when a limit is exceeded
+	limitErr    error         // error describing the exceeded limit
+	limitStop   chan struct{} // stops limit watchers
 }
 
 func (r *RunnerV2) Init() (err error) {
@@ -108,6 +126,9 @@ func (r *RunnerV2) Run() (err error) {
 	// configure environment variables
 	r.configureEnv()
 
+	// configure resource limits
+	r.configureLimits()
+
 	// configure logging
 	r.configureLogging()
 
@@ -117,7 +138,7 @@ func (r *RunnerV2) Run() (err error) {
 	}
 
 	// start logging
-	go r.startLogging()
+	r.startLogging()
 
 	// process id
 	if r.cmd.Process == nil {
@@ -137,6 +158,9 @@ func (r *RunnerV2) Run() (err error) {
 	// start health check
 	go r.startHealthCheck()
 
+	// start watching resource limits
+	r.startLimitWatchers()
+
 	// declare task status
 	status := ""
 
@@ -160,6 +184,24 @@ func (r *RunnerV2) Run() (err error) {
 		status = constants.TaskStatusError
 	}
 
+	// cancelled, even if the process exited normally after cancel signal
+	if r.isCancelled() {
+		err = constants.ErrTaskCancelled
+		status = constants.TaskStatusCancelled
+	}
+
+	// killed after exceeding a resource limit
+	if limitStatus, limitErr := r.stopLimitWatchers(); limitStatus != "" {
+		err = limitErr
+		status = limitStatus
+	}
+
+	// exit reason
+	r.t.ExitReason = r.getExitReason(signal)
+
+	// flush logs
+	r.stopLogging()
+
 	// update task status
 	if err := r.updateTask(status, err); err != nil {
 		return err
@@ -168,14 +210,29 @@ func (r *RunnerV2) Run() (err error) {
 	return err
 }
 
+// Cancel sends cancel signal (SIGTERM by default) to the process group of the task, so that
+// the spider can shut down gracefully, and kills the whole process group with SIGKILL if any
+// process still exists after cancel timeout
 func (r *RunnerV2) Cancel() (err error) {
-	// kill process
-	opts := &sys_exec.KillProcessOptions{
-		Timeout: r.svc.GetCancelTimeout(),
-		Force:   true,
+	if r.cmd == nil || r.cmd.Process == nil {
+		return errors.New("task process is not started")
 	}
-	if err := sys_exec.KillProcess(r.cmd, opts); err != nil {
-		return err
+
+	// cancel signal
+	sig := r.svc.GetCancelSignal()
+	timeout := r.svc.GetCancelTimeout()
+	r.setExitReason(fmt.Sprintf("cancelled with %s", getSignalName(sig)), true)
+	if err := sys_exec.SignalProcessGroup(r.cmd, sig); err != nil {
+		return trace.TraceError(err)
+	}
+
+	// wait for process group to exit and escalate to SIGKILL after timeout
+	if sig != syscall.SIGKILL && !r.waitProcessGroup(timeout) {
+		log.Warnf("task[%s] process group still exists %s after %s, killing", r.tid.Hex(), timeout, getSignalName(sig))
+		r.setExitReason(fmt.Sprintf("cancelled with %s, killed with SIGKILL after %s", getSignalName(sig), timeout), true)
+		if err := sys_exec.SignalProcessGroup(r.cmd, syscall.SIGKILL); err != nil {
+			return trace.TraceError(err)
+		}
 	}
 
 	// make sure the process does not exist
@@ -196,8 +253,89 @@ func (r *RunnerV2) Cancel() (err error) {
 	return nil
 }
 
+// waitProcessGroup waits until no process in the process group of the task exists, and returns
+// false if any still exists after timeout
+func (r *RunnerV2) waitProcessGroup(timeout time.Duration) (ok bool) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-deadline:
+			return !sys_exec.ProcessGroupExists(r.cmd)
+		case <-ticker.C:
+			// leader must have exited (and been reaped) before checking the group
+			select {
+			case <-r.exited:
+				if !sys_exec.ProcessGroupExists(r.cmd) {
+					return true
+				}
+			default:
+			}
+		}
+	}
+}
+
+func (r *RunnerV2) setExitReason(reason string, cancelled bool) {
+	r.exitMu.Lock()
+	defer r.exitMu.Unlock()
+	r.exitReason = reason
+	r.cancelled = r.cancelled || cancelled
+}
+
+func (r *RunnerV2) isCancelled() (ok bool) {
+	r.exitMu.Lock()
+	defer r.exitMu.Unlock()
+	return r.cancelled
+}
+
+// getExitReason returns the reason why the task process exited
+func (r *RunnerV2) getExitReason(signal constants.TaskSignal) (reason string) {
+	r.exitMu.Lock()
+	defer r.exitMu.Unlock()
+	if r.exitReason != "" {
+		return r.exitReason
+	}
+	if signal == constants.TaskSignalLost {
+		return "process lost"
+	}
+	if r.cmd == nil || r.cmd.ProcessState == nil {
+		return ""
+	}
+	if ws, ok := r.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return fmt.Sprintf("terminated by %s", getSignalName(ws.Signal()))
+	}
+	return fmt.Sprintf("exited with code %d", r.cmd.ProcessState.ExitCode())
+}
+
+// signalNames are names of common signals terminating task processes
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// getSignalName returns the name of a signal, e.g. SIGTERM
+func getSignalName(sig syscall.Signal) (name string) {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
 // CleanUp clean up task runner
 func (r *RunnerV2) CleanUp() (err error) {
+	// remove cgroup
+	if r.cg != nil {
+		if err := r.cg.Remove(); err != nil {
+			return trace.TraceError(err)
+		}
+	}
 	return nil
 }
 
@@ -237,9 +375,126 @@ func (r *RunnerV2) configureCmd() (err error) {
 	// set working directory
 	r.cmd.Dir = r.cwd
 
+	// start in its own process group so that descendants can be signalled together
+	sys_exec.SetPgid(r.cmd)
+
 	return nil
 }
 
+// configureLimits creates a cgroup for memory and cpu limits of the task if any. If cgroup v2
+// is not available, memory limit is enforced by watching memory usage of the process tree
+// and cpu limit is not enforced.
+func (r *RunnerV2) configureLimits() {
+	r.limitStop = make(chan struct{})
+	limits := r.t.Limits
+	if limits.MemoryLimit <= 0 && limits.CpuLimit <= 0 {
+		return
+	}
+	cgroupPath := viper.GetString("task.cgroup.path")
+	if cgroupPath == "" {
+		cgroupPath = "/sys/fs/cgroup/crawlab"
+	}
+	cg, err := sys_exec.NewCgroup(cgroupPath, r.tid.Hex(), &sys_exec.CgroupOptions{
+		MemoryLimit: limits.MemoryLimit * 1024 * 1024,
+		CpuLimit:    limits.CpuLimit,
+	})
+	if err != nil {
+		log.Warnf("task[%s] unable to create cgroup, memory limit will be enforced by watching memory usage and cpu limit is ignored: %v", r.tid.Hex(), err)
+		return
+	}
+	r.cg = cg
+	r.cg.Attach(r.cmd)
+}
+
+// startLimitWatchers kills the task process when it exceeds max runtime, or memory limit if not
+// enforced by cgroup
+func (r *RunnerV2) startLimitWatchers() {
+	limits := r.t.Limits
+
+	// max runtime
+	if limits.Timeout > 0 {
+		timeout := time.Duration(limits.Timeout) * time.Second
+		go func() {
+			select {
+			case <-time.After(timeout):
+				r.exceedLimit(constants.TaskStatusTimeout, errors.New(fmt.Sprintf("task exceeded max runtime of %s", timeout)))
+			case <-r.limitStop:
+			}
+		}()
+	}
+
+	// memory limit
+	if limits.MemoryLimit > 0 && r.cg == nil {
+		go r.watchMemory(limits.MemoryLimit)
+	}
+}
+
+// stopLimitWatchers stops limit watchers and returns the task status and error if any limit is exceeded
+func (r *RunnerV2) stopLimitWatchers() (status string, err error) {
+	close(r.limitStop)
+
+	r.limitMu.Lock()
+	defer r.limitMu.Unlock()
+
+	// killed by oom killer in cgroup
+	if r.limitStatus == "" && r.cg != nil && r.cg.OomKilled() {
+		r.limitStatus = constants.TaskStatusOomKilled
+		r.limitErr = errors.New(fmt.Sprintf("task exceeded memory limit of %d MB", r.t.Limits.MemoryLimit))
+	}
+
+	return r.limitStatus, r.limitErr
+}
+
+func (r *RunnerV2) watchMemory(memoryLimit int64) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.limitStop:
+			return
+		case <-ticker.C:
+			p, err := process.NewProcess(int32(r.pid))
+			if err != nil {
+				return
+			}
+			if getProcessTreeRss(p) > uint64(memoryLimit)*1024*1024 {
+				r.exceedLimit(constants.TaskStatusOomKilled, errors.New(fmt.Sprintf("task exceeded memory limit of %d MB", memoryLimit)))
+				return
+			}
+		}
+	}
+}
+
+// exceedLimit records the exceeded limit and kills the task process
+func (r *RunnerV2) exceedLimit(status string, e error) {
+	r.limitMu.Lock()
+	if r.limitStatus != "" {
+		r.limitMu.Unlock()
+		return
+	}
+	r.limitStatus = status
+	r.limitErr = e
+	r.limitMu.Unlock()
+
+	log.Warnf("task[%s] %v, killing process group", r.tid.Hex(), e)
+	r.setExitReason(fmt.Sprintf("%v, killed with SIGKILL", e), false)
+	if err := sys_exec.SignalProcessGroup(r.cmd, syscall.SIGKILL); err != nil {
+		trace.PrintError(err)
+	}
+}
+
+// getProcessTreeRss returns the total resident memory of a process and its descendants in bytes
+func getProcessTreeRss(p *process.Process) (rss uint64) {
+	if mem, err := p.MemoryInfo(); err == nil {
+		rss += mem.RSS
+	}
+	children, _ := p.Children()
+	for _, c := range children {
+		rss += getProcessTreeRss(c)
+	}
+	return rss
+}
+
 func (r *RunnerV2) configureLogging() {
 	// set stdout reader
 	stdout, _ := r.cmd.StdoutPipe()
@@ -250,33 +505,71 @@ func (r *RunnerV2) configureLogging() {
 	r.scannerStderr = bufio.NewReaderSize(stderr, r.bufferSize)
 }
 
+// startLogging starts the log shipper and log readers. The shipper is set and readers are added to
+// the wait group before returning, so that stopLogging always sees them.
 func (r *RunnerV2) startLogging() {
+	// start log shipper
+	r.logShipper = newLogShipper(r.getLogSpoolPath(), r.sendLogEntries, r.initSub)
+	r.logShipper.batchSize = r.logBatchSize
+	r.logShipper.Start()
+
 	// start reading stdout
+	r.logWg.Add(2)
 	go r.startLoggingReaderStdout()
 
 	// start reading stderr
 	go r.startLoggingReaderStderr()
 }
 
-func (r *RunnerV2) startLoggingReaderStdout() {
-	for {
-		line, err := r.scannerStdout.ReadString(byte('\n'))
-		if err != nil {
-			break
-		}
-		line = strings.TrimSuffix(line, "\n")
-		r.writeLogLines([]string{line})
+// stopLogging waits for log readers to reach the end of output and flushes remaining log entries
+func (r *RunnerV2) stopLogging() {
+	if r.logShipper == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		r.logWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(r.logReadTimeout):
+		log.Warnf("task[%s] timeout waiting for log readers", r.tid.Hex())
 	}
+	r.logShipper.Close()
+}
+
+// getLogSpoolPath returns the path of spool file where unsent log entries are kept
+func (r *RunnerV2) getLogSpoolPath() (spoolPath string) {
+	return filepath.Join(getLogSpoolDir(), r.tid.Hex()+".jsonl")
+}
+
+// getLogSpoolDir returns the directory of spool files of task runners
+func getLogSpoolDir() (spoolDir string) {
+	spoolDir = viper.GetString("log.spool.path")
+	if spoolDir == "" {
+		spoolDir = filepath.Join(os.TempDir(), "crawlab", "log-spool")
+	}
+	return spoolDir
+}
+
+func (r *RunnerV2) startLoggingReaderStdout() {
+	r.startLoggingReader(r.scannerStdout, constants.LogStreamStdout)
 }
 
 func (r *RunnerV2) startLoggingReaderStderr() {
+	r.startLoggingReader(r.scannerStderr, constants.LogStreamStderr)
+}
+
+func (r *RunnerV2) startLoggingReader(reader *bufio.Reader, stream string) {
+	defer r.logWg.Done()
 	for {
-		line, err := r.scannerStderr.ReadString(byte('\n'))
+		line, err := reader.ReadString(byte('\n'))
 		if err != nil {
 			break
 		}
 		line = strings.TrimSuffix(line, "\n")
-		r.writeLogLines([]string{line})
+		r.logShipper.Write(stream, line)
 	}
 }
 
@@ -315,6 +608,17 @@ func (r *RunnerV2) configureEnv() {
 		r.cmd.Env = append(r.cmd.Env, "CRAWLAB_GRPC_AUTH_KEY="+constants.DefaultGrpcAuthKey)
 	}
 
+	// workflow envs
+	if !r.t.WorkflowRunId.IsZero() {
+		r.cmd.Env = append(r.cmd.Env, "CRAWLAB_WORKFLOW_RUN_ID="+r.t.WorkflowRunId.Hex())
+		r.cmd.Env = append(r.cmd.Env, "CRAWLAB_WORKFLOW_STEP="+r.t.WorkflowStep)
+		var upstreamTaskIds []string
+		for _, id := range r.t.UpstreamTaskIds {
+			upstreamTaskIds = append(upstreamTaskIds, id.Hex())
+		}
+		r.cmd.Env = append(r.cmd.Env, "CRAWLAB_UPSTREAM_TASK_ID="+strings.Join(upstreamTaskIds, ","))
+	}
+
 	// global environment variables
 	envs, err := client.NewModelServiceV2[models2.EnvironmentV2]().GetMany(nil, nil)
 	if err != nil {
@@ -478,7 +782,9 @@ func (r *RunnerV2) downloadFile(url string, filePath string, fileInfo *entity.Fs
 // to task runner's channel (RunnerV2.ch) according to exit code
 func (r *RunnerV2) wait() {
 	// wait for process to finish
-	if err := r.cmd.Wait(); err != nil {
+	err := r.cmd.Wait()
+	close(r.exited)
+	if err != nil {
 		var exitError *exec.ExitError
 		ok := errors.As(err, &exitError)
 		if !ok {
@@ -540,30 +846,40 @@ func (r *RunnerV2) updateTask(status string, e error) (err error) {
 }
 
 func (r *RunnerV2) initSub() (err error) {
-	r.sub, err = r.c.TaskClient.Subscribe(context.Background())
+	sub, err := r.c.TaskClient.Subscribe(context.Background())
 	if err != nil {
 		return trace.TraceError(err)
 	}
+	r.subMu.Lock()
+	r.sub = sub
+	r.subMu.Unlock()
 	return nil
 }
 
-func (r *RunnerV2) writeLogLines(lines []string) {
+func (r *RunnerV2) getSub() (sub grpc.TaskService_SubscribeClient) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	return r.sub
+}
+
+func (r *RunnerV2) sendLogEntries(entries []entity.TaskLogEntry) (err error) {
+	return sendLogEntries(r.getSub(), r.tid, entries)
+}
+
+// sendLogEntries sends log entries of a task to master through the task service stream
+func sendLogEntries(sub grpc.TaskService_SubscribeClient, tid primitive.ObjectID, entries []entity.TaskLogEntry) (err error) {
 	data, err := json.Marshal(&entity.StreamMessageTaskData{
-		TaskId: r.tid,
-		Logs:   lines,
+		TaskId:     tid,
+		LogEntries: entries,
 	})
 	if err != nil {
-		trace.PrintError(err)
-		return
+		return trace.TraceError(err)
 	}
 	msg := &grpc.StreamMessage{
 		Code: grpc.StreamMessageCode_INSERT_LOGS,
 		Data: data,
 	}
-	if err := r.sub.Send(msg); err != nil {
-		trace.PrintError(err)
-		return
-	}
+	return sub.Send(msg)
 }
 
 func (r *RunnerV2) _updateTaskStat(status string) {
@@ -578,7 +894,7 @@ func (r *RunnerV2) _updateTaskStat(status string) {
 	case constants.TaskStatusRunning:
 		ts.StartTs = time.Now()
 		ts.WaitDuration = ts.StartTs.Sub(ts.BaseModelV2.CreatedAt).Milliseconds()
-	case constants.TaskStatusFinished, constants.TaskStatusError, constants.TaskStatusCancelled:
+	case constants.TaskStatusFinished, constants.TaskStatusError, constants.TaskStatusCancelled, constants.TaskStatusTimeout, constants.TaskStatusOomKilled:
 		if ts.StartTs.IsZero() {
 			ts.StartTs = time.Now()
 			ts.WaitDuration = ts.StartTs.Sub(ts.BaseModelV2.CreatedAt).Milliseconds()
@@ -586,6 +902,7 @@ func (r *RunnerV2) _updateTaskStat(status string) {
 		ts.EndTs = time.Now()
 		ts.RuntimeDuration = ts.EndTs.Sub(ts.StartTs).Milliseconds()
 		ts.TotalDuration = ts.EndTs.Sub(ts.BaseModelV2.CreatedAt).Milliseconds()
+		r.setTaskStatUsage(ts)
 	}
 	if r.svc.GetNodeConfigService().IsMaster() {
 		err = service2.NewModelServiceV2[models2.TaskStatV2]().ReplaceById(ts.Id, *ts)
@@ -602,6 +919,26 @@ func (r *RunnerV2) _updateTaskStat(status string) {
 	}
 }
 
+// setTaskStatUsage sets exit code, terminating signal and resource usage of the exited task process
+func (r *RunnerV2) setTaskStatUsage(ts *models2.TaskStatV2) {
+	if r.cmd == nil || r.cmd.ProcessState == nil {
+		return
+	}
+	ps := r.cmd.ProcessState
+	exitCode := ps.ExitCode()
+	ts.ExitCode = &exitCode
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		ts.Signal = getSignalName(ws.Signal())
+	}
+	ts.UserCpuTime = ps.UserTime().Milliseconds()
+	ts.SysCpuTime = ps.SystemTime().Milliseconds()
+	ts.MaxRss = sys_exec.GetMaxRss(ps)
+	if r.cg != nil {
+		// cgroup peak covers all descendants including orphaned ones
+		ts.MaxRss = max(ts.MaxRss, r.cg.GetPeakMemory())
+	}
+}
+
 func (r *RunnerV2) sendNotification() {
 	req := &grpc.TaskServiceSendNotificationRequest{
 		NodeKey: r.svc.GetNodeConfigService().GetNodeKey(),
@@ -636,7 +973,7 @@ func (r *RunnerV2) _updateSpiderStat(status string) {
 				"wait_duration": ts.WaitDuration, // wait duration
 			},
 		}
-	case constants.TaskStatusFinished, constants.TaskStatusError, constants.TaskStatusCancelled:
+	case constants.TaskStatusFinished, constants.TaskStatusError, constants.TaskStatusCancelled, constants.TaskStatusTimeout, constants.TaskStatusOomKilled:
 		update = bson.M{
 			"$set": bson.M{
 				"last_task_id": r.tid, // last task id
@@ -693,7 +1030,9 @@ func NewTaskRunnerV2(id primitive.ObjectID, svc *ServiceV2) (r2 *RunnerV2, err e
 		svc:              svc,
 		tid:              id,
 		ch:               make(chan constants.TaskSignal),
+		exited:           make(chan struct{}),
 		logBatchSize:     20,
+		logReadTimeout:   5 * time.Second,
 	}
 
 	// task
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/apex/log"
 	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
 	errors2 "github.com/crawlab-team/crawlab/core/errors"
 	grpcclient "github.com/crawlab-team/crawlab/core/grpc/client"
 	"github.com/crawlab-team/crawlab/core/interfaces"
@@ -13,10 +14,15 @@ import (
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	nodeconfig "github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/grpc"
 	"github.com/crawlab-team/crawlab/trace"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -32,6 +38,7 @@ type ServiceV2 struct {
 	fetchInterval     time.Duration
 	fetchTimeout      time.Duration
 	cancelTimeout     time.Duration
+	cancelSignal      syscall.Signal // signal sent to process group first when cancelling a task
 
 	// internals variables
 	stopped   bool
@@ -51,6 +58,7 @@ func (svc *ServiceV2) Start() {
 
 	go svc.ReportStatus()
 	go svc.Fetch()
+	go svc.replayLogSpools()
 }
 
 func (svc *ServiceV2) Run(taskId primitive.ObjectID) (err error) {
@@ -198,6 +206,14 @@ func (svc *ServiceV2) SetCancelTimeout(timeout time.Duration) {
 	svc.cancelTimeout = timeout
 }
 
+func (svc *ServiceV2) GetCancelSignal() (sig syscall.Signal) {
+	return svc.cancelSignal
+}
+
+func (svc *ServiceV2) SetCancelSignal(sig syscall.Signal) {
+	svc.cancelSignal = sig
+}
+
 func (svc *ServiceV2) GetNodeConfigService() (cfgSvc interfaces.NodeConfigService) {
 	return svc.cfgSvc
 }
@@ -249,8 +265,8 @@ func (svc *ServiceV2) getRunners() (runners []*RunnerV2) {
 	svc.mu.Lock()
 	defer svc.mu.Unlock()
 	svc.runners.Range(func(key, value interface{}) bool {
-		r := value.(RunnerV2)
-		runners = append(runners, &r)
+		r := value.(*RunnerV2)
+		runners = append(runners, r)
 		return true
 	})
 	return runners
@@ -391,6 +407,59 @@ func (svc *ServiceV2) run(taskId primitive.ObjectID) (err error) {
 	return nil
 }
 
+// replayLogSpools ships log entries left in spool files by task runners of a previous process,
+// which exited before master could be reached. Spool files are kept if shipping fails again.
+func (svc *ServiceV2) replayLogSpools() {
+	spoolPaths, err := filepath.Glob(filepath.Join(getLogSpoolDir(), "*.jsonl"))
+	if err != nil {
+		trace.PrintError(err)
+		return
+	}
+	for _, spoolPath := range spoolPaths {
+		tid, err := primitive.ObjectIDFromHex(strings.TrimSuffix(filepath.Base(spoolPath), ".jsonl"))
+		if err != nil {
+			continue
+		}
+		if _, err := svc.getRunner(tid); err == nil {
+			// shipped by the runner of the task
+			continue
+		}
+		svc.replayLogSpool(tid, spoolPath)
+	}
+}
+
+func (svc *ServiceV2) replayLogSpool(tid primitive.ObjectID, spoolPath string) {
+	var sub grpc.TaskService_SubscribeClient
+	s := newLogShipper(spoolPath, func(entries []entity.TaskLogEntry) error {
+		return sendLogEntries(sub, tid, entries)
+	}, func() (err error) {
+		sub, err = svc.c.TaskClient.Subscribe(context.Background())
+		return err
+	})
+	if err := s.loadSpool(); err != nil {
+		trace.PrintError(err)
+		return
+	}
+	log.Infof("[TaskHandlerService] replaying %d spooled log entries of task[%s]", s.spoolCount, tid.Hex())
+
+	// stream is established on first flush
+	s.broken = true
+	s.Start()
+	s.Close()
+	if sub != nil {
+		_ = sub.CloseSend()
+	}
+}
+
+// cancelSignals are signals that can be configured to be sent first when cancelling a task
+var cancelSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGKILL": syscall.SIGKILL,
+}
+
 func newTaskHandlerServiceV2() (svc2 *ServiceV2, err error) {
 	// service
 	svc := &ServiceV2{
@@ -399,11 +468,25 @@ func newTaskHandlerServiceV2() (svc2 *ServiceV2, err error) {
 		fetchTimeout:      15 * time.Second,
 		reportInterval:    5 * time.Second,
 		cancelTimeout:     5 * time.Second,
+		cancelSignal:      syscall.SIGTERM,
 		mu:                sync.Mutex{},
 		runners:           sync.Map{},
 		syncLocks:         sync.Map{},
 	}
 
+	// cancel settings
+	if timeout := viper.GetDuration("task.handler.cancelTimeout"); timeout > 0 {
+		svc.cancelTimeout = timeout
+	}
+	if name := viper.GetString("task.handler.cancelSignal"); name != "" {
+		sig, ok := cancelSignals[strings.ToUpper(name)]
+		if !ok {
+			log.Warnf("[NewTaskHandlerService] invalid cancel signal %s, using SIGTERM", name)
+			sig = syscall.SIGTERM
+		}
+		svc.cancelSignal = sig
+	}
+
 	// dependency injection
 	svc.cfgSvc = nodeconfig.GetNodeConfigService()
 
@@ -0,0 +1,113 @@
+package log
+
+import (
+	"sync"
+)
+
+// Broker fans out log lines of tasks received by master to live subscribers (e.g. log tailing
+// clients). Publishing never blocks: a subscriber that falls behind by more than its buffer is
+// marked as lagged and closed.
+//
+// The broker is in-process only. With multiple masters, log lines are published on the master
+// whose grpc stream received them from the task runner, so a subscriber on another master does
+// not receive them live; they are only available from the log driver once written. Clients
+// tailing logs should therefore be routed to the same master as workers (e.g. sticky routing of
+// a load balancer), or re-read logs from the log driver.
+type Broker struct {
+	// settings
+	bufferSize int
+
+	// internals
+	mu   sync.RWMutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+// Subscription receives log lines of a task published after it is created
+type Subscription struct {
+	id     string
+	b      *Broker
+	ch     chan Line
+	lagged bool
+	once   sync.Once
+}
+
+// Lines returns the channel of log lines, which is closed when the subscription is closed
+func (s *Subscription) Lines() <-chan Line {
+	return s.ch
+}
+
+// Lagged returns whether the subscription was closed because the subscriber fell behind
+func (s *Subscription) Lagged() bool {
+	s.b.mu.RLock()
+	defer s.b.mu.RUnlock()
+	return s.lagged
+}
+
+// Close unsubscribes and closes the channel of log lines
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.unsubscribe(s)
+}
+
+// Subscribe creates a subscription to log lines of a task
+func (b *Broker) Subscribe(id string) (sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub = &Subscription{
+		id: id,
+		b:  b,
+		ch: make(chan Line, b.bufferSize),
+	}
+	if b.subs[id] == nil {
+		b.subs[id] = map[*Subscription]struct{}{}
+	}
+	b.subs[id][sub] = struct{}{}
+	return sub
+}
+
+// Publish sends log lines of a task to its subscribers
+func (b *Broker) Publish(id string, lines ...Line) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[id] {
+		for _, l := range lines {
+			select {
+			case sub.ch <- l:
+				continue
+			default:
+			}
+			sub.lagged = true
+			b.unsubscribe(sub)
+			break
+		}
+	}
+}
+
+// unsubscribe removes a subscription and closes its channel. Lock must be held by caller.
+func (b *Broker) unsubscribe(sub *Subscription) {
+	sub.once.Do(func() {
+		delete(b.subs[sub.id], sub)
+		if len(b.subs[sub.id]) == 0 {
+			delete(b.subs, sub.id)
+		}
+		close(sub.ch)
+	})
+}
+
+func newLogBroker() (b *Broker) {
+	return &Broker{
+		bufferSize: 1000,
+		subs:       map[string]map[*Subscription]struct{}{},
+	}
+}
+
+var logBroker *Broker
+var logBrokerOnce sync.Once
+
+func GetLogBroker() (b *Broker) {
+	logBrokerOnce.Do(func() {
+		logBroker = newLogBroker()
+	})
+	return logBroker
+}
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker_PublishAndSubscribe(t *testing.T) {
+	b := newLogBroker()
+
+	sub1 := b.Subscribe("t1")
+	sub2 := b.Subscribe("t2")
+	b.Publish("t1", Line{Msg: "line 1"}, Line{Msg: "line 2"})
+
+	require.Equal(t, "line 1", (<-sub1.Lines()).Msg)
+	require.Equal(t, "line 2", (<-sub1.Lines()).Msg)
+	require.Len(t, sub2.Lines(), 0)
+
+	sub1.Close()
+	_, ok := <-sub1.Lines()
+	require.False(t, ok)
+	require.False(t, sub1.Lagged())
+
+	// publishing without subscribers is a no-op
+	b.Publish("t1", Line{Msg: "line 3"})
+	sub2.Close()
+	require.Len(t, b.subs, 0)
+}
+
+func TestBroker_Lagged(t *testing.T) {
+	b := newLogBroker()
+	b.bufferSize = 2
+
+	sub := b.Subscribe("t1")
+	b.Publish("t1", Line{Msg: "line 1"}, Line{Msg: "line 2"}, Line{Msg: "line 3"})
+
+	var lines []string
+	for l := range sub.Lines() {
+		lines = append(lines, l.Msg)
+	}
+	require.Equal(t, []string{"line 1", "line 2"}, lines)
+	require.True(t, sub.Lagged())
+
+	// closing a lagged subscription is safe
+	sub.Close()
+}
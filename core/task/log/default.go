@@ -1,5 +1,52 @@
 package log
 
-import "time"
+import (
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+	"time"
+)
 
 var DefaultLogTtl = 30 * 24 * time.Hour
+
+// getLogTtl returns the retention duration of task logs from "log.ttl",
+// e.g. "3600s", "60m", "24h" or "30d"
+func getLogTtl() time.Duration {
+	ttl := viper.GetString("log.ttl")
+	if ttl == "" {
+		return DefaultLogTtl
+	}
+
+	if strings.HasSuffix(ttl, "s") {
+		ttl = strings.TrimSuffix(ttl, "s")
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return DefaultLogTtl
+		}
+		return time.Duration(n) * time.Second
+	} else if strings.HasSuffix(ttl, "m") {
+		ttl = strings.TrimSuffix(ttl, "m")
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return DefaultLogTtl
+		}
+		return time.Duration(n) * time.Minute
+	} else if strings.HasSuffix(ttl, "h") {
+		ttl = strings.TrimSuffix(ttl, "h")
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return DefaultLogTtl
+		}
+		return time.Duration(n) * time.Hour
+
+	} else if strings.HasSuffix(ttl, "d") {
+		ttl = strings.TrimSuffix(ttl, "d")
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return DefaultLogTtl
+		}
+		return time.Duration(n) * 24 * time.Hour
+	} else {
+		return DefaultLogTtl
+	}
+}
@@ -1,5 +1,7 @@
 package log
 
+import "github.com/spf13/viper"
+
 func GetLogDriver(logDriverType string) (driver Driver, err error) {
 	switch logDriverType {
 	case DriverTypeFile:
@@ -8,11 +10,26 @@ func GetLogDriver(logDriverType string) (driver Driver, err error) {
 			return driver, err
 		}
 	case DriverTypeMongo:
-		return driver, ErrNotImplemented
+		driver, err = GetMongoLogDriver()
+		if err != nil {
+			return driver, err
+		}
 	case DriverTypeEs:
-		return driver, ErrNotImplemented
+		driver, err = GetEsLogDriver()
+		if err != nil {
+			return driver, err
+		}
 	default:
 		return driver, ErrInvalidType
 	}
 	return driver, nil
 }
+
+// GetDefaultLogDriver returns the log driver configured by "log.driver" (file by default)
+func GetDefaultLogDriver() (driver Driver, err error) {
+	logDriverType := viper.GetString("log.driver")
+	if logDriverType == "" {
+		logDriverType = DriverTypeFile
+	}
+	return GetLogDriver(logDriverType)
+}
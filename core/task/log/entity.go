@@ -14,3 +14,23 @@ type Metadata struct {
 	TotalBytes int64  `json:"total_bytes,omitempty" bson:"total_bytes"`
 	Md5        string `json:"md5,omitempty" bson:"md5"`
 }
+
+// Line is a log line of a task with metadata
+type Line struct {
+	TaskId   string    `json:"task_id" bson:"task_id"`
+	SpiderId string    `json:"spider_id,omitempty" bson:"spider_id,omitempty"`
+	NodeId   string    `json:"node_id,omitempty" bson:"node_id,omitempty"`
+	Stream   string    `json:"stream,omitempty" bson:"stream,omitempty"`
+	Level    string    `json:"level,omitempty" bson:"level,omitempty"`
+	Seq      int64     `json:"seq,omitempty" bson:"seq,omitempty"` // sequence number assigned by task runner
+	Line     int64     `json:"line" bson:"line"`                   // line number assigned by log driver
+	Msg      string    `json:"msg" bson:"msg"`
+	Ts       time.Time `json:"ts" bson:"ts"`
+}
+
+// Filter filters log lines of a task
+type Filter struct {
+	Pattern string
+	Stream  string
+	Level   string
+}
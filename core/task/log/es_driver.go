@@ -0,0 +1,453 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/spf13/viper"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultEsLogIndex   = "crawlab_task_logs"
+	DefaultEsLogAddress = "http://localhost:9200"
+)
+
+type EsLogDriver struct {
+	// settings
+	index string
+
+	// internals
+	c     *elasticsearch.Client
+	mu    sync.Mutex
+	lines *lineCache // last line number of each task
+}
+
+type esSearchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source Line `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+type esCountResponse struct {
+	Count int64 `json:"count"`
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+}
+
+func (d *EsLogDriver) Init() (err error) {
+	if err := d.initIndex(); err != nil {
+		return err
+	}
+
+	go d.cleanup()
+	go d.evictLines()
+
+	return nil
+}
+
+func (d *EsLogDriver) initIndex() (err error) {
+	// create index with mappings if not exists
+	res, err := d.c.Indices.Exists([]string{d.index})
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode == 200 {
+		return nil
+	}
+	mappings := `{
+  "mappings": {
+    "properties": {
+      "task_id": {"type": "keyword"},
+      "spider_id": {"type": "keyword"},
+      "node_id": {"type": "keyword"},
+      "stream": {"type": "keyword"},
+      "level": {"type": "keyword"},
+      "seq": {"type": "long"},
+      "line": {"type": "long"},
+      "msg": {"type": "text"},
+      "ts": {"type": "date"}
+    }
+  }
+}`
+	res, err = d.c.Indices.Create(d.index, d.c.Indices.Create.WithBody(strings.NewReader(mappings)))
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	defer res.Body.Close()
+	if res.IsError() && !strings.Contains(res.String(), "resource_already_exists_exception") {
+		return errors.New(fmt.Sprintf("[EsLogDriver] [%s] error creating index: %s", res.Status(), res.String()))
+	}
+	return nil
+}
+
+func (d *EsLogDriver) Close() (err error) {
+	return nil
+}
+
+func (d *EsLogDriver) WriteLine(id string, line string) (err error) {
+	return d.WriteLines(id, []string{line})
+}
+
+func (d *EsLogDriver) WriteLines(id string, lines []string) (err error) {
+	ts := time.Now()
+	var entries []Line
+	for _, line := range lines {
+		entries = append(entries, Line{Msg: line, Ts: ts})
+	}
+	return d.writeLines(id, entries)
+}
+
+func (d *EsLogDriver) WriteEntries(id string, entries []entity.TaskLogEntry) (err error) {
+	var lines []Line
+	for _, e := range entries {
+		lines = append(lines, Line{
+			Stream: e.Stream,
+			Level:  e.Level,
+			Seq:    e.Seq,
+			Msg:    e.Msg,
+			Ts:     e.Ts,
+		})
+	}
+	return d.writeLines(id, lines)
+}
+
+func (d *EsLogDriver) Find(id string, pattern string, skip int, limit int) (lines []string, err error) {
+	docs, err := d.FindLines(id, &Filter{Pattern: pattern}, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		lines = append(lines, doc.Msg)
+	}
+	return lines, nil
+}
+
+func (d *EsLogDriver) Count(id string, pattern string) (n int, err error) {
+	return d.CountLines(id, &Filter{Pattern: pattern})
+}
+
+func (d *EsLogDriver) FindLines(id string, filter *Filter, skip int, limit int) (lines []Line, err error) {
+	data, err := d.search(d.getQuery(id, filter), []any{map[string]any{"line": "asc"}}, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range data.Hits.Hits {
+		lines = append(lines, hit.Source)
+	}
+	return lines, nil
+}
+
+func (d *EsLogDriver) CountLines(id string, filter *Filter) (n int, err error) {
+	body, err := json.Marshal(map[string]any{"query": d.getQuery(id, filter)})
+	if err != nil {
+		return n, trace.TraceError(err)
+	}
+	res, err := d.c.Count(
+		d.c.Count.WithContext(context.Background()),
+		d.c.Count.WithIndex(d.index),
+		d.c.Count.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return n, trace.TraceError(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return 0, nil
+	}
+	if res.IsError() {
+		return n, errors.New(fmt.Sprintf("[EsLogDriver] [%s] error counting log lines: %s", res.Status(), res.String()))
+	}
+	var data esCountResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return n, trace.TraceError(err)
+	}
+	return int(data.Count), nil
+}
+
+func (d *EsLogDriver) GetLastSeq(id string) (seq int64, err error) {
+	// plain log lines have no sequence number and are sorted last
+	data, err := d.search(d.getQuery(id, nil), []any{map[string]any{"seq": "desc"}}, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(data.Hits.Hits) == 0 {
+		return 0, nil
+	}
+	return data.Hits.Hits[0].Source.Seq, nil
+}
+
+func (d *EsLogDriver) Search(pattern string, skip int, limit int) (lines []Line, total int, err error) {
+	data, err := d.search(d.getQuery("", &Filter{Pattern: pattern}), []any{"_score", map[string]any{"ts": "desc"}}, skip, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, hit := range data.Hits.Hits {
+		lines = append(lines, hit.Source)
+	}
+	return lines, int(data.Hits.Total.Value), nil
+}
+
+func (d *EsLogDriver) search(query map[string]any, sort []any, skip int, limit int) (data *esSearchResponse, err error) {
+	q := map[string]any{
+		"query":            query,
+		"sort":             sort,
+		"from":             skip,
+		"track_total_hits": true,
+	}
+	if limit > 0 {
+		q["size"] = limit
+	}
+	body, err := json.Marshal(q)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+	res, err := d.c.Search(
+		d.c.Search.WithContext(context.Background()),
+		d.c.Search.WithIndex(d.index),
+		d.c.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+	defer res.Body.Close()
+	data = &esSearchResponse{}
+	if res.StatusCode == 404 {
+		return data, nil
+	}
+	if res.IsError() {
+		return nil, errors.New(fmt.Sprintf("[EsLogDriver] [%s] error searching log lines: %s", res.Status(), res.String()))
+	}
+	if err := json.NewDecoder(res.Body).Decode(data); err != nil {
+		return nil, trace.TraceError(err)
+	}
+	return data, nil
+}
+
+func (d *EsLogDriver) writeLines(id string, lines []Line) (err error) {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// last line number
+	n, err := d.getLastLine(id)
+	if err != nil {
+		return err
+	}
+
+	// bulk request body
+	meta := getTaskMeta(id)
+	var buf bytes.Buffer
+	for _, l := range lines {
+		n++
+		l.TaskId = id
+		l.SpiderId = meta.spiderId
+		l.NodeId = meta.nodeId
+		l.Line = n
+		action, _ := json.Marshal(map[string]any{
+			"index": map[string]any{
+				"_index": d.index,
+				"_id":    fmt.Sprintf("%s-%d", id, n),
+			},
+		})
+		doc, err := json.Marshal(l)
+		if err != nil {
+			return trace.TraceError(err)
+		}
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(doc)
+		buf.WriteByte('\n')
+	}
+
+	// perform bulk request
+	res, err := esapi.BulkRequest{Body: &buf}.Do(context.Background(), d.c)
+	if err != nil {
+		d.lines.delete(id)
+		return trace.TraceError(err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		d.lines.delete(id)
+		return errors.New(fmt.Sprintf("[EsLogDriver] [%s] error indexing log lines: %s", res.Status(), res.String()))
+	}
+	var data esBulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return trace.TraceError(err)
+	}
+	if data.Errors {
+		log.Warnf("[EsLogDriver] some log lines of task[%s] failed to be indexed", id)
+	}
+	d.lines.set(id, n)
+
+	return nil
+}
+
+// getQuery returns a bool query filtered by task id, stream and level (if any) with a full-text
+// match on pattern (if any)
+func (d *EsLogDriver) getQuery(id string, f *Filter) (query map[string]any) {
+	if f == nil {
+		f = &Filter{}
+	}
+	filter := []any{}
+	if id != "" {
+		filter = append(filter, map[string]any{"term": map[string]any{"task_id": id}})
+	}
+	if f.Stream != "" {
+		filter = append(filter, map[string]any{"term": map[string]any{"stream": f.Stream}})
+	}
+	if f.Level != "" {
+		filter = append(filter, map[string]any{"term": map[string]any{"level": f.Level}})
+	}
+	must := []any{}
+	if f.Pattern != "" {
+		must = append(must, map[string]any{
+			"match": map[string]any{
+				"msg": map[string]any{
+					"query":    f.Pattern,
+					"operator": "and",
+				},
+			},
+		})
+	}
+	return map[string]any{
+		"bool": map[string]any{
+			"filter": filter,
+			"must":   must,
+		},
+	}
+}
+
+func (d *EsLogDriver) getLastLine(id string) (n int64, err error) {
+	// attempt to get from cache
+	n, ok := d.lines.get(id)
+	if ok {
+		return n, nil
+	}
+
+	// last log line in index
+	data, err := d.search(d.getQuery(id, nil), []any{map[string]any{"line": "desc"}}, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(data.Hits.Hits) == 0 {
+		return 0, nil
+	}
+	return data.Hits.Hits[0].Source.Line, nil
+}
+
+// evictLines periodically evicts cached line numbers of idle tasks
+func (d *EsLogDriver) evictLines() {
+	for {
+		time.Sleep(d.lines.ttl)
+		d.mu.Lock()
+		d.lines.evict(time.Now())
+		d.mu.Unlock()
+	}
+}
+
+// cleanup periodically deletes log lines older than log ttl
+func (d *EsLogDriver) cleanup() {
+	for {
+		body, _ := json.Marshal(map[string]any{
+			"query": map[string]any{
+				"range": map[string]any{
+					"ts": map[string]any{
+						"lt": time.Now().Add(-getLogTtl()),
+					},
+				},
+			},
+		})
+		res, err := d.c.DeleteByQuery([]string{d.index}, bytes.NewReader(body))
+		if err != nil {
+			trace.PrintError(err)
+		} else {
+			if res.IsError() && res.StatusCode != 404 {
+				log.Errorf("[EsLogDriver] [%s] error removing outdated log lines: %s", res.Status(), res.String())
+			}
+			_ = res.Body.Close()
+		}
+
+		time.Sleep(10 * time.Minute)
+	}
+}
+
+// getEsLogConfig returns elasticsearch client config from "log.es.*" settings
+func getEsLogConfig() (cfg elasticsearch.Config) {
+	var addresses []string
+	for _, address := range viper.GetStringSlice("log.es.addresses") {
+		for _, a := range strings.Split(address, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addresses = append(addresses, a)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		addresses = []string{DefaultEsLogAddress}
+	}
+	return elasticsearch.Config{
+		Addresses: addresses,
+		Username:  viper.GetString("log.es.username"),
+		Password:  viper.GetString("log.es.password"),
+	}
+}
+
+var esLogDriver Driver
+
+func newEsLogDriver(cfg elasticsearch.Config, index string) (driver Driver, err error) {
+	// client
+	c, err := elasticsearch.NewClient(cfg)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// driver
+	driver = &EsLogDriver{
+		index: index,
+		c:     c,
+		mu:    sync.Mutex{},
+		lines: newLineCache(lineCacheTtl),
+	}
+
+	// init
+	if err := driver.Init(); err != nil {
+		return nil, err
+	}
+
+	return driver, nil
+}
+
+func GetEsLogDriver() (driver Driver, err error) {
+	if esLogDriver != nil {
+		return esLogDriver, nil
+	}
+	index := viper.GetString("log.es.index")
+	if index == "" {
+		index = DefaultEsLogIndex
+	}
+	esLogDriver, err = newEsLogDriver(getEsLogConfig(), index)
+	if err != nil {
+		return nil, err
+	}
+	return esLogDriver, nil
+}
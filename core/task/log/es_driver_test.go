@@ -0,0 +1,223 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newEsStandInServer returns a minimal ES-compatible server supporting the requests used by EsLogDriver
+func newEsStandInServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	var docs []Line
+
+	// matches returns whether a log line matches the bool query generated by EsLogDriver.getQuery
+	matches := func(doc Line, query map[string]any) bool {
+		b := query["bool"].(map[string]any)
+		var fields map[string]any
+		_ = json.Unmarshal([]byte(toJson(doc)), &fields)
+		for _, f := range b["filter"].([]any) {
+			for k, v := range f.(map[string]any)["term"].(map[string]any) {
+				if fields[k] != v {
+					return false
+				}
+			}
+		}
+		for _, m := range b["must"].([]any) {
+			q := m.(map[string]any)["match"].(map[string]any)["msg"].(map[string]any)["query"].(string)
+			for _, token := range strings.Fields(strings.ToLower(q)) {
+				if !strings.Contains(strings.ToLower(doc.Msg), token) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/_bulk":
+			sc := bufio.NewScanner(r.Body)
+			sc.Buffer(make([]byte, 1024*1024), 1024*1024)
+			for sc.Scan() {
+				if !sc.Scan() {
+					break
+				}
+				var doc Line
+				require.Nil(t, json.Unmarshal(sc.Bytes(), &doc))
+				docs = append(docs, doc)
+			}
+			_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+		case strings.HasSuffix(r.URL.Path, "/_search"), strings.HasSuffix(r.URL.Path, "/_count"):
+			var body map[string]any
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			var hits []Line
+			for _, doc := range docs {
+				if matches(doc, body["query"].(map[string]any)) {
+					hits = append(hits, doc)
+				}
+			}
+			if strings.HasSuffix(r.URL.Path, "/_count") {
+				_ = json.NewEncoder(w).Encode(map[string]any{"count": len(hits)})
+				return
+			}
+			// sort by seq or line, missing values last
+			key := func(l Line) int64 { return l.Line }
+			if strings.Contains(toJson(body["sort"]), "seq") {
+				key = func(l Line) int64 { return l.Seq }
+			}
+			desc := strings.Contains(toJson(body["sort"]), "desc")
+			sort.SliceStable(hits, func(i, j int) bool {
+				if desc {
+					return key(hits[i]) > key(hits[j])
+				}
+				return key(hits[i]) < key(hits[j])
+			})
+			total := len(hits)
+			from := int(body["from"].(float64))
+			if from > len(hits) {
+				from = len(hits)
+			}
+			hits = hits[from:]
+			if size, ok := body["size"].(float64); ok && int(size) < len(hits) {
+				hits = hits[:int(size)]
+			}
+			var items []map[string]any
+			for _, hit := range hits {
+				items = append(items, map[string]any{"_source": hit})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"hits": map[string]any{
+					"total": map[string]any{"value": total},
+					"hits":  items,
+				},
+			})
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		}
+	}))
+}
+
+func toJson(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestEsLogDriver_WriteLinesAndFind(t *testing.T) {
+	svr := newEsStandInServer(t)
+	defer svr.Close()
+
+	d, err := newEsLogDriver(elasticsearch.Config{Addresses: []string{svr.URL}}, DefaultEsLogIndex)
+	require.Nil(t, err)
+
+	id := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id, taskMeta{})
+	err = d.WriteLines(id, []string{"line 1", "ERROR: connection refused", "line 3"})
+	require.Nil(t, err)
+	err = d.WriteLine(id, "line 4")
+	require.Nil(t, err)
+
+	n, err := d.Count(id, "")
+	require.Nil(t, err)
+	require.Equal(t, 4, n)
+
+	lines, err := d.Find(id, "", 1, 2)
+	require.Nil(t, err)
+	require.Equal(t, []string{"ERROR: connection refused", "line 3"}, lines)
+
+	lines, err = d.Find(id, "connection refused", 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, []string{"ERROR: connection refused"}, lines)
+
+	n, err = d.Count(id, "connection refused")
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+
+	// line numbers continue from the index after the cached line number is evicted
+	d.(*EsLogDriver).lines.evict(time.Now().Add(2 * lineCacheTtl))
+	err = d.WriteLine(id, "line 5")
+	require.Nil(t, err)
+	docs, err := d.FindLines(id, nil, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, docs, 5)
+	for i, doc := range docs {
+		require.Equal(t, int64(i+1), doc.Line)
+	}
+}
+
+func TestEsLogDriver_FindLines(t *testing.T) {
+	svr := newEsStandInServer(t)
+	defer svr.Close()
+
+	d, err := newEsLogDriver(elasticsearch.Config{Addresses: []string{svr.URL}}, DefaultEsLogIndex)
+	require.Nil(t, err)
+
+	id := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id, taskMeta{})
+	err = d.WriteEntries(id, []entity.TaskLogEntry{
+		{Seq: 1, Stream: constants.LogStreamStdout, Level: constants.LogLevelInfo, Msg: "INFO: started"},
+		{Seq: 2, Stream: constants.LogStreamStderr, Level: constants.LogLevelError, Msg: "ERROR: failed"},
+	})
+	require.Nil(t, err)
+
+	lines, err := d.FindLines(id, &Filter{Stream: constants.LogStreamStderr}, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, "ERROR: failed", lines[0].Msg)
+	require.Equal(t, constants.LogLevelError, lines[0].Level)
+
+	n, err := d.CountLines(id, &Filter{Level: constants.LogLevelInfo})
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+
+	// plain lines have no sequence number
+	err = d.WriteLine(id, "plain")
+	require.Nil(t, err)
+	seq, err := d.GetLastSeq(id)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), seq)
+}
+
+func TestEsLogDriver_Search(t *testing.T) {
+	svr := newEsStandInServer(t)
+	defer svr.Close()
+
+	d, err := newEsLogDriver(elasticsearch.Config{Addresses: []string{svr.URL}}, DefaultEsLogIndex)
+	require.Nil(t, err)
+
+	id1 := primitive.NewObjectID().Hex()
+	id2 := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id1, taskMeta{spiderId: "s1", nodeId: "n1"})
+	taskMetaCache.Set(id2, taskMeta{spiderId: "s2", nodeId: "n2"})
+	require.Nil(t, d.WriteLines(id1, []string{"ok", "timeout error"}))
+	require.Nil(t, d.WriteLines(id2, []string{"timeout error", "ok"}))
+
+	lines, total, err := d.(SearchDriver).Search("timeout", 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, total)
+	var taskIds, spiderIds []string
+	for _, line := range lines {
+		taskIds = append(taskIds, line.TaskId)
+		spiderIds = append(spiderIds, line.SpiderId)
+	}
+	require.ElementsMatch(t, []string{id1, id2}, taskIds)
+	require.ElementsMatch(t, []string{"s1", "s2"}, spiderIds)
+}
@@ -3,15 +3,16 @@ package log
 import (
 	"bufio"
 	"bytes"
-	"errors"
+	"encoding/json"
 	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/trace"
 	"github.com/spf13/viper"
 	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -19,8 +20,9 @@ import (
 
 type FileLogDriver struct {
 	// settings
-	logFileName string
-	rootPath    string
+	logFileName     string
+	entriesFileName string
+	rootPath        string
 
 	// internals
 	mu sync.Mutex
@@ -41,6 +43,16 @@ func (d *FileLogDriver) WriteLine(id string, line string) (err error) {
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
+
+	// append to structured log entries if present, so that plain lines are kept in order with them
+	if utils.Exists(d.getLogFilePath(id, d.entriesFileName)) {
+		var lines []Line
+		for _, text := range strings.Split(line, "\n") {
+			lines = append(lines, Line{Msg: text, Ts: time.Now()})
+		}
+		return d.appendEntries(id, lines)
+	}
+
 	filePath := d.getLogFilePath(id, d.logFileName)
 
 	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0760))
@@ -71,58 +83,256 @@ func (d *FileLogDriver) WriteLines(id string, lines []string) (err error) {
 }
 
 func (d *FileLogDriver) Find(id string, pattern string, skip int, limit int) (lines []string, err error) {
-	if pattern != "" {
-		return lines, errors.New("not implemented")
+	entries, err := d.FindLines(id, &Filter{Pattern: pattern}, skip, limit)
+	if err != nil {
+		return nil, err
 	}
-	if !utils.Exists(d.getLogFilePath(id, d.logFileName)) {
-		return nil, nil
+	for _, l := range entries {
+		lines = append(lines, l.Msg)
 	}
+	return lines, nil
+}
 
-	f, err := os.Open(d.getLogFilePath(id, d.logFileName))
-	if err != nil {
-		return nil, trace.TraceError(err)
+func (d *FileLogDriver) Count(id string, pattern string) (n int, err error) {
+	// count lines of plain log file directly if not filtered
+	if pattern == "" && !utils.Exists(d.getLogFilePath(id, d.entriesFileName)) {
+		if !utils.Exists(d.getLogFilePath(id, d.logFileName)) {
+			return 0, nil
+		}
+		f, err := os.Open(d.getLogFilePath(id, d.logFileName))
+		if err != nil {
+			return n, trace.TraceError(err)
+		}
+		defer f.Close()
+		return d.lineCounter(f)
 	}
-	defer f.Close()
 
-	sc := bufio.NewReaderSize(f, 1024*1024*10)
+	return d.CountLines(id, &Filter{Pattern: pattern})
+}
 
-	i := -1
-	for {
-		line, err := sc.ReadString(byte('\n'))
+func (d *FileLogDriver) WriteEntries(id string, entries []entity.TaskLogEntry) (err error) {
+	d.initDir(id)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// plain log lines written before the first entries are moved into the entries file
+	var lines []Line
+	plainFilePath := d.getLogFilePath(id, d.logFileName)
+	migrate := !utils.Exists(d.getLogFilePath(id, d.entriesFileName)) && utils.Exists(plainFilePath)
+	if migrate {
+		data, err := os.ReadFile(plainFilePath)
 		if err != nil {
-			break
+			return trace.TraceError(err)
 		}
-		line = strings.TrimSuffix(line, "\n")
+		for _, text := range strings.SplitAfter(string(data), "\n") {
+			if !strings.HasSuffix(text, "\n") {
+				continue
+			}
+			lines = append(lines, Line{Msg: strings.TrimSuffix(text, "\n")})
+		}
+	}
 
-		i++
+	for _, e := range entries {
+		lines = append(lines, Line{
+			Stream: e.Stream,
+			Level:  e.Level,
+			Seq:    e.Seq,
+			Msg:    e.Msg,
+			Ts:     e.Ts,
+		})
+	}
+	if err := d.appendEntries(id, lines); err != nil {
+		return err
+	}
 
-		if i < skip {
-			continue
+	if migrate {
+		if err := os.Remove(plainFilePath); err != nil {
+			return trace.TraceError(err)
 		}
+	}
 
-		if i >= skip+limit {
-			break
+	return nil
+}
+
+// appendEntries appends log lines to the structured log entries file of a task. The caller must
+// hold the lock.
+func (d *FileLogDriver) appendEntries(id string, lines []Line) (err error) {
+	filePath := d.getLogFilePath(id, d.entriesFileName)
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0760))
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Errorf("close file error: %s", err.Error())
 		}
+	}(f)
 
-		lines = append(lines, line)
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		data, err := json.Marshal(l)
+		if err != nil {
+			return trace.TraceError(err)
+		}
+		_, _ = w.Write(data)
+		_ = w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return trace.TraceError(err)
 	}
 
-	return lines, nil
+	return nil
 }
 
-func (d *FileLogDriver) Count(id string, pattern string) (n int, err error) {
-	if pattern != "" {
-		return n, errors.New("not implemented")
+func (d *FileLogDriver) FindLines(id string, filter *Filter, skip int, limit int) (lines []Line, err error) {
+	i := -1
+	err = d.scan(id, filter, func(l Line) bool {
+		i++
+		if i < skip {
+			return true
+		}
+		if limit > 0 && i >= skip+limit {
+			return false
+		}
+		lines = append(lines, l)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
-	if !utils.Exists(d.getLogFilePath(id, d.logFileName)) {
+	return lines, nil
+}
+
+func (d *FileLogDriver) CountLines(id string, filter *Filter) (n int, err error) {
+	err = d.scan(id, filter, func(l Line) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// GetLastSeq reads the entries file backwards in chunks until a line with a sequence number is
+// found, as sequence numbers increase with lines
+func (d *FileLogDriver) GetLastSeq(id string) (seq int64, err error) {
+	filePath := d.getLogFilePath(id, d.entriesFileName)
+	if !utils.Exists(filePath) {
 		return 0, nil
 	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, trace.TraceError(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, trace.TraceError(err)
+	}
 
-	f, err := os.Open(d.getLogFilePath(id, d.logFileName))
+	var rest []byte // partial line at the start of the chunk read previously
+	for off := fi.Size(); off > 0; {
+		size := min(int64(1024*64), off)
+		off -= size
+		buf := make([]byte, size, size+int64(len(rest)))
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return 0, trace.TraceError(err)
+		}
+		buf = append(buf, rest...)
+
+		// the first line may be partial unless at the start of file
+		lines := bytes.Split(buf, []byte("\n"))
+		start := 0
+		rest = nil
+		if off > 0 {
+			rest = lines[0]
+			start = 1
+		}
+		for i := len(lines) - 1; i >= start; i-- {
+			var l Line
+			if err := json.Unmarshal(lines[i], &l); err != nil {
+				continue
+			}
+			if l.Seq > 0 {
+				return l.Seq, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// scan iterates log lines of a task matching the filter until fn returns false. Once structured
+// log entries are written, plain log lines are written to the same entries file, so the entries
+// file is read if present, otherwise the plain log file is read.
+func (d *FileLogDriver) scan(id string, filter *Filter, fn func(l Line) bool) (err error) {
+	// log file
+	structured := utils.Exists(d.getLogFilePath(id, d.entriesFileName))
+	filePath := d.getLogFilePath(id, d.logFileName)
+	if structured {
+		filePath = d.getLogFilePath(id, d.entriesFileName)
+	} else if !utils.Exists(filePath) {
+		return nil
+	}
+
+	// pattern
+	var re *regexp.Regexp
+	if filter != nil && filter.Pattern != "" {
+		re, err = regexp.Compile(filter.Pattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(filePath)
 	if err != nil {
-		return n, trace.TraceError(err)
+		return trace.TraceError(err)
 	}
-	return d.lineCounter(f)
+	defer f.Close()
+
+	sc := bufio.NewReaderSize(f, 1024*1024*10)
+
+	var n int64
+	for {
+		text, err := sc.ReadString(byte('\n'))
+		if err != nil {
+			break
+		}
+		text = strings.TrimSuffix(text, "\n")
+		n++
+
+		// parse line
+		var l Line
+		if structured {
+			if err := json.Unmarshal([]byte(text), &l); err != nil {
+				continue
+			}
+		} else {
+			l.Msg = text
+		}
+		l.TaskId = id
+		l.Line = n
+
+		// filter
+		if filter != nil {
+			if filter.Stream != "" && l.Stream != filter.Stream {
+				continue
+			}
+			if filter.Level != "" && l.Level != filter.Level {
+				continue
+			}
+			if re != nil && !re.MatchString(l.Msg) {
+				continue
+			}
+		}
+
+		if !fn(l) {
+			break
+		}
+	}
+
+	return nil
 }
 
 func (d *FileLogDriver) Flush() (err error) {
@@ -183,43 +393,7 @@ func (d *FileLogDriver) lineCounter(r io.Reader) (n int, err error) {
 }
 
 func (d *FileLogDriver) getTtl() time.Duration {
-	ttl := viper.GetString("log.ttl")
-	if ttl == "" {
-		return DefaultLogTtl
-	}
-
-	if strings.HasSuffix(ttl, "s") {
-		ttl = strings.TrimSuffix(ttl, "s")
-		n, err := strconv.Atoi(ttl)
-		if err != nil {
-			return DefaultLogTtl
-		}
-		return time.Duration(n) * time.Second
-	} else if strings.HasSuffix(ttl, "m") {
-		ttl = strings.TrimSuffix(ttl, "m")
-		n, err := strconv.Atoi(ttl)
-		if err != nil {
-			return DefaultLogTtl
-		}
-		return time.Duration(n) * time.Minute
-	} else if strings.HasSuffix(ttl, "h") {
-		ttl = strings.TrimSuffix(ttl, "h")
-		n, err := strconv.Atoi(ttl)
-		if err != nil {
-			return DefaultLogTtl
-		}
-		return time.Duration(n) * time.Hour
-
-	} else if strings.HasSuffix(ttl, "d") {
-		ttl = strings.TrimSuffix(ttl, "d")
-		n, err := strconv.Atoi(ttl)
-		if err != nil {
-			return DefaultLogTtl
-		}
-		return time.Duration(n) * 24 * time.Hour
-	} else {
-		return DefaultLogTtl
-	}
+	return getLogTtl()
 }
 
 func (d *FileLogDriver) cleanup() {
@@ -260,8 +434,9 @@ var logDriver Driver
 func newFileLogDriver() (driver Driver, err error) {
 	// driver
 	driver = &FileLogDriver{
-		logFileName: "log.txt",
-		mu:          sync.Mutex{},
+		logFileName:     "log.txt",
+		entriesFileName: "log.jsonl",
+		mu:              sync.Mutex{},
 	}
 
 	// init
@@ -2,11 +2,15 @@ package log
 
 import (
 	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func setupFileDriverTest() {
@@ -22,7 +26,7 @@ func TestFileDriver_WriteLine(t *testing.T) {
 	setupFileDriverTest()
 	t.Cleanup(cleanupFileDriverTest)
 
-	d, err := newFileLogDriver(nil)
+	d, err := newFileLogDriver()
 	require.Nil(t, err)
 	defer d.Close()
 
@@ -42,7 +46,7 @@ func TestFileDriver_WriteLines(t *testing.T) {
 	setupFileDriverTest()
 	t.Cleanup(cleanupFileDriverTest)
 
-	d, err := newFileLogDriver(nil)
+	d, err := newFileLogDriver()
 	require.Nil(t, err)
 	defer d.Close()
 
@@ -66,7 +70,7 @@ func TestFileDriver_Find(t *testing.T) {
 	setupFileDriverTest()
 	t.Cleanup(cleanupFileDriverTest)
 
-	d, err := newFileLogDriver(nil)
+	d, err := newFileLogDriver()
 	require.Nil(t, err)
 	defer d.Close()
 
@@ -130,3 +134,111 @@ func TestFileDriver_Find(t *testing.T) {
 
 	cleanupFileDriverTest()
 }
+
+func TestFileDriver_FindLines(t *testing.T) {
+	viper.Set("log.path", t.TempDir())
+	t.Cleanup(func() { viper.Set("log.path", "") })
+
+	d, err := newFileLogDriver()
+	require.Nil(t, err)
+	defer d.Close()
+
+	id := primitive.NewObjectID()
+
+	err = d.WriteEntries(id.Hex(), []entity.TaskLogEntry{
+		{Seq: 1, Stream: constants.LogStreamStdout, Level: constants.LogLevelInfo, Ts: time.Now(), Msg: "INFO: started"},
+		{Seq: 2, Stream: constants.LogStreamStderr, Level: constants.LogLevelError, Ts: time.Now(), Msg: "ERROR: failed"},
+		{Seq: 3, Stream: constants.LogStreamStdout, Ts: time.Now(), Msg: "done"},
+	})
+	require.Nil(t, err)
+
+	lines, err := d.FindLines(id.Hex(), &Filter{Stream: constants.LogStreamStderr}, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, "ERROR: failed", lines[0].Msg)
+	require.Equal(t, int64(2), lines[0].Seq)
+	require.Equal(t, int64(2), lines[0].Line)
+
+	n, err := d.CountLines(id.Hex(), &Filter{Stream: constants.LogStreamStdout})
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+
+	n, err = d.CountLines(id.Hex(), &Filter{Level: constants.LogLevelInfo})
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+
+	msgs, err := d.Find(id.Hex(), "^d", 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, []string{"done"}, msgs)
+}
+
+func TestFileDriver_WriteLinesWithEntries(t *testing.T) {
+	viper.Set("log.path", t.TempDir())
+	t.Cleanup(func() { viper.Set("log.path", "") })
+
+	d, err := newFileLogDriver()
+	require.Nil(t, err)
+	defer d.Close()
+
+	id := primitive.NewObjectID()
+
+	// plain lines before and after structured entries are kept in order
+	err = d.WriteLines(id.Hex(), []string{"plain 1", "plain 2"})
+	require.Nil(t, err)
+	err = d.WriteEntries(id.Hex(), []entity.TaskLogEntry{
+		{Seq: 1, Stream: constants.LogStreamStdout, Ts: time.Now(), Msg: "entry 1"},
+	})
+	require.Nil(t, err)
+	err = d.WriteLine(id.Hex(), "plain 3")
+	require.Nil(t, err)
+	err = d.WriteEntries(id.Hex(), []entity.TaskLogEntry{
+		{Seq: 2, Stream: constants.LogStreamStderr, Ts: time.Now(), Msg: "entry 2"},
+	})
+	require.Nil(t, err)
+
+	msgs, err := d.Find(id.Hex(), "", 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, []string{"plain 1", "plain 2", "entry 1", "plain 3", "entry 2"}, msgs)
+
+	n, err := d.Count(id.Hex(), "")
+	require.Nil(t, err)
+	require.Equal(t, 5, n)
+
+	n, err = d.Count(id.Hex(), "^plain")
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+
+	lines, err := d.FindLines(id.Hex(), &Filter{Stream: constants.LogStreamStderr}, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, int64(5), lines[0].Line)
+}
+
+func TestFileDriver_GetLastSeq(t *testing.T) {
+	viper.Set("log.path", t.TempDir())
+	t.Cleanup(func() { viper.Set("log.path", "") })
+
+	d, err := newFileLogDriver()
+	require.Nil(t, err)
+	defer d.Close()
+
+	id := primitive.NewObjectID()
+
+	seq, err := d.GetLastSeq(id.Hex())
+	require.Nil(t, err)
+	require.Equal(t, int64(0), seq)
+
+	// entries spanning multiple chunks followed by plain lines
+	var entries []entity.TaskLogEntry
+	for i := 1; i <= 5000; i++ {
+		entries = append(entries, entity.TaskLogEntry{Seq: int64(i), Ts: time.Now(), Msg: fmt.Sprintf("entry %d", i)})
+	}
+	err = d.WriteEntries(id.Hex(), entries)
+	require.Nil(t, err)
+	err = d.WriteLines(id.Hex(), []string{"plain 1", "plain 2"})
+	require.Nil(t, err)
+
+	seq, err = d.GetLastSeq(id.Hex())
+	require.Nil(t, err)
+	require.Equal(t, int64(5000), seq)
+}
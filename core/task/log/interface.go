@@ -1,5 +1,7 @@
 package log
 
+import "github.com/crawlab-team/crawlab/core/entity"
+
 type Driver interface {
 	Init() (err error)
 	Close() (err error)
@@ -7,4 +9,18 @@ type Driver interface {
 	WriteLines(id string, lines []string) (err error)
 	Find(id string, pattern string, skip int, limit int) (lines []string, err error)
 	Count(id string, pattern string) (n int, err error)
+	// WriteEntries writes structured log entries captured by task runner
+	WriteEntries(id string, entries []entity.TaskLogEntry) (err error)
+	// FindLines returns structured log lines matching the filter
+	FindLines(id string, filter *Filter, skip int, limit int) (lines []Line, err error)
+	// CountLines counts structured log lines matching the filter
+	CountLines(id string, filter *Filter) (n int, err error)
+	// GetLastSeq returns the greatest sequence number of structured log lines, or 0 if none
+	GetLastSeq(id string) (seq int64, err error)
+}
+
+// SearchDriver is a Driver able to search log lines across all tasks
+type SearchDriver interface {
+	Driver
+	Search(pattern string, skip int, limit int) (lines []Line, total int, err error)
 }
@@ -0,0 +1,41 @@
+package log
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	"regexp"
+	"strings"
+)
+
+// levelRegex matches upper-case level names delimited as in common log formats, e.g.
+// "ERROR: msg", "[WARNING] msg", "2024-01-01 00:00:00 [scrapy.core.engine] INFO: msg",
+// "ERROR:root:msg" (python logging) or "2024-01-01 00:00:00,000 - name - DEBUG - msg"
+var levelRegex = regexp.MustCompile(`(?:^|[\s\[(|:\-])(DEBUG|INFO|WARNING|WARN|ERROR|CRITICAL|FATAL)(?:[\s\]):|\-]|$)`)
+
+// levelPrefixLength is the length of the line prefix in which level is detected
+const levelPrefixLength = 128
+
+var levelMap = map[string]string{
+	"DEBUG":    constants.LogLevelDebug,
+	"INFO":     constants.LogLevelInfo,
+	"WARN":     constants.LogLevelWarning,
+	"WARNING":  constants.LogLevelWarning,
+	"ERROR":    constants.LogLevelError,
+	"CRITICAL": constants.LogLevelCritical,
+	"FATAL":    constants.LogLevelCritical,
+}
+
+// DetectLevel detects log level of a log line from its prefix, and returns empty string if not detected
+func DetectLevel(line string) (level string) {
+	if strings.HasPrefix(line, "Traceback (most recent call last)") {
+		return constants.LogLevelError
+	}
+	prefix := line
+	if len(prefix) > levelPrefixLength {
+		prefix = prefix[:levelPrefixLength]
+	}
+	m := levelRegex.FindStringSubmatch(prefix)
+	if m == nil {
+		return ""
+	}
+	return levelMap[m[1]]
+}
@@ -0,0 +1,26 @@
+package log
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectLevel(t *testing.T) {
+	cases := map[string]string{
+		"ERROR: connection refused":       constants.LogLevelError,
+		"ERROR:root:something went wrong": constants.LogLevelError,
+		"[WARNING] retrying":              constants.LogLevelWarning,
+		"WARN retrying":                   constants.LogLevelWarning,
+		"2024-01-01 00:00:00 [scrapy.core.engine] INFO: Spider opened":            constants.LogLevelInfo,
+		"2024-01-01 00:00:00,000 - my_logger - DEBUG - fetching page":             constants.LogLevelDebug,
+		"2024-01-01 00:00:00 [scrapy.core.scraper] CRITICAL: unhandled exception": constants.LogLevelCritical,
+		"Traceback (most recent call last):":                                      constants.LogLevelError,
+		"processed 10 items without error":                                        "",
+		"INFORMATION is not a level":                                              "",
+	}
+	for line, level := range cases {
+		require.Equal(t, level, DetectLevel(line), line)
+	}
+}
@@ -0,0 +1,51 @@
+package log
+
+import "time"
+
+// lineCacheTtl is the idle time after which the last line number of a task is evicted
+const lineCacheTtl = 10 * time.Minute
+
+// lineCache caches last line numbers of tasks written by a log driver. Tasks not written for a
+// while, which have usually ended, are evicted so that the cache does not grow on long-running
+// masters. It is not safe for concurrent use and is guarded by the mutex of the driver.
+type lineCache struct {
+	ttl   time.Duration
+	items map[string]*lineCacheItem
+}
+
+type lineCacheItem struct {
+	n  int64     // last line number
+	ts time.Time // last write time
+}
+
+func (c *lineCache) get(id string) (n int64, ok bool) {
+	item, ok := c.items[id]
+	if !ok {
+		return 0, false
+	}
+	return item.n, true
+}
+
+func (c *lineCache) set(id string, n int64) {
+	c.items[id] = &lineCacheItem{n: n, ts: time.Now()}
+}
+
+func (c *lineCache) delete(id string) {
+	delete(c.items, id)
+}
+
+// evict removes tasks not written since ttl before now
+func (c *lineCache) evict(now time.Time) {
+	for id, item := range c.items {
+		if now.After(item.ts.Add(c.ttl)) {
+			delete(c.items, id)
+		}
+	}
+}
+
+func newLineCache(ttl time.Duration) (c *lineCache) {
+	return &lineCache{
+		ttl:   ttl,
+		items: map[string]*lineCacheItem{},
+	}
+}
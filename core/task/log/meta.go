@@ -0,0 +1,44 @@
+package log
+
+import (
+	"github.com/ReneKroon/ttlcache"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// taskMeta is the task metadata stamped on each log line
+type taskMeta struct {
+	spiderId string
+	nodeId   string
+}
+
+var taskMetaCache = newTaskMetaCache()
+
+func newTaskMetaCache() (cache *ttlcache.Cache) {
+	cache = ttlcache.NewCache()
+	cache.SetTTL(10 * time.Minute)
+	return cache
+}
+
+// getTaskMeta returns spider id and node id of a task, or empty metadata if the task is not found
+func getTaskMeta(id string) (meta taskMeta) {
+	if res, ok := taskMetaCache.Get(id); ok {
+		return res.(taskMeta)
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return meta
+	}
+	t, err := service.NewModelServiceV2[models2.TaskV2]().GetById(oid)
+	if err != nil {
+		return meta
+	}
+	meta = taskMeta{
+		spiderId: t.SpiderId.Hex(),
+		nodeId:   t.NodeId.Hex(),
+	}
+	taskMetaCache.Set(id, meta)
+	return meta
+}
@@ -0,0 +1,326 @@
+package log
+
+import (
+	"errors"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"time"
+)
+
+const (
+	MongoLogColName      = "task_logs"
+	mongoLogTtlIndexName = "ts_ttl"
+)
+
+// mongoLogSearchMaxCount is the max number of log lines counted by a search across all tasks
+const mongoLogSearchMaxCount = 10000
+
+// MongoLogDriver saves log lines in mongo. Line numbers are allocated from a counter document of
+// each task in the counters collection, so that masters writing logs of the same task do not
+// collide on the unique index of line numbers.
+type MongoLogDriver struct {
+	// settings
+	colName string
+
+	// internals
+	col        *mongo.Col
+	counterCol *mongo.Col // last line number of each task
+}
+
+func (d *MongoLogDriver) Init() (err error) {
+	d.col = mongo.GetMongoCol(d.colName)
+	d.counterCol = mongo.GetMongoCol(d.colName + "_counters")
+
+	// indexes
+	if err := d.col.CreateIndexes([]mongo2.IndexModel{
+		{Keys: bson.D{{"task_id", 1}, {"line", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"ts", -1}, {"line", -1}}},
+	}); err != nil {
+		return err
+	}
+
+	// ttl indexes
+	if err := ensureTtlIndex(d.col); err != nil {
+		return err
+	}
+	if err := ensureTtlIndex(d.counterCol); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (d *MongoLogDriver) Close() (err error) {
+	return nil
+}
+
+func (d *MongoLogDriver) WriteLine(id string, line string) (err error) {
+	return d.WriteLines(id, []string{line})
+}
+
+func (d *MongoLogDriver) WriteLines(id string, lines []string) (err error) {
+	ts := time.Now()
+	var entries []Line
+	for _, line := range lines {
+		entries = append(entries, Line{Msg: line, Ts: ts})
+	}
+	return d.writeLines(id, entries)
+}
+
+func (d *MongoLogDriver) WriteEntries(id string, entries []entity.TaskLogEntry) (err error) {
+	var lines []Line
+	for _, e := range entries {
+		lines = append(lines, Line{
+			Stream: e.Stream,
+			Level:  e.Level,
+			Seq:    e.Seq,
+			Msg:    e.Msg,
+			Ts:     e.Ts,
+		})
+	}
+	return d.writeLines(id, lines)
+}
+
+func (d *MongoLogDriver) Find(id string, pattern string, skip int, limit int) (lines []string, err error) {
+	docs, err := d.FindLines(id, &Filter{Pattern: pattern}, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		lines = append(lines, doc.Msg)
+	}
+	return lines, nil
+}
+
+func (d *MongoLogDriver) Count(id string, pattern string) (n int, err error) {
+	return d.CountLines(id, &Filter{Pattern: pattern})
+}
+
+func (d *MongoLogDriver) FindLines(id string, filter *Filter, skip int, limit int) (lines []Line, err error) {
+	if err := d.col.Find(d.getQuery(id, filter), &mongo.FindOptions{
+		Sort:  bson.D{{"line", 1}},
+		Skip:  skip,
+		Limit: limit,
+	}).All(&lines); err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, trace.TraceError(err)
+	}
+	return lines, nil
+}
+
+func (d *MongoLogDriver) CountLines(id string, filter *Filter) (n int, err error) {
+	return d.col.Count(d.getQuery(id, filter))
+}
+
+func (d *MongoLogDriver) GetLastSeq(id string) (seq int64, err error) {
+	// sequence numbers increase with line numbers, so the last line having one has the greatest
+	var doc Line
+	if err := d.col.Find(bson.M{"task_id": id, "seq": bson.M{"$gt": 0}}, &mongo.FindOptions{
+		Sort:  bson.D{{"line", -1}},
+		Limit: 1,
+	}).One(&doc); err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, trace.TraceError(err)
+	}
+	return doc.Seq, nil
+}
+
+// Search finds log lines across all tasks containing the pattern, newest first. The total is
+// capped at mongoLogSearchMaxCount so that a search does not count the whole collection.
+func (d *MongoLogDriver) Search(pattern string, skip int, limit int) (lines []Line, total int, err error) {
+	query := bson.M{}
+	if pattern != "" {
+		query["msg"] = getMsgQuery(pattern)
+	}
+	if err := d.col.Find(query, &mongo.FindOptions{
+		Sort:  bson.D{{"ts", -1}, {"line", -1}},
+		Skip:  skip,
+		Limit: limit,
+	}).All(&lines); err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return nil, 0, trace.TraceError(err)
+	}
+	n, err := d.col.GetCollection().CountDocuments(d.col.GetContext(), query, options.Count().SetLimit(mongoLogSearchMaxCount))
+	if err != nil {
+		return nil, 0, trace.TraceError(err)
+	}
+	return lines, int(n), nil
+}
+
+func (d *MongoLogDriver) getQuery(id string, filter *Filter) (query bson.M) {
+	query = bson.M{"task_id": id}
+	if filter == nil {
+		return query
+	}
+	if filter.Pattern != "" {
+		query["msg"] = getMsgQuery(filter.Pattern)
+	}
+	if filter.Stream != "" {
+		query["stream"] = filter.Stream
+	}
+	if filter.Level != "" {
+		query["level"] = filter.Level
+	}
+	return query
+}
+
+func (d *MongoLogDriver) writeLines(id string, lines []Line) (err error) {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// allocate line numbers
+	n, err := d.allocateLines(id, int64(len(lines)))
+	if err != nil {
+		return err
+	}
+
+	// documents
+	meta := getTaskMeta(id)
+	var docs []interface{}
+	for _, l := range lines {
+		n++
+		l.TaskId = id
+		l.SpiderId = meta.spiderId
+		l.NodeId = meta.nodeId
+		l.Line = n
+		docs = append(docs, l)
+	}
+
+	// insert
+	if _, err := d.col.InsertMany(docs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// allocateLines atomically reserves n line numbers of a task, and returns the line number before
+// the first reserved one
+func (d *MongoLogDriver) allocateLines(id string, n int64) (last int64, err error) {
+	for i := 0; i < 2; i++ {
+		var counter struct {
+			Line int64 `bson:"line"`
+		}
+		err = d.counterCol.GetCollection().FindOneAndUpdate(
+			d.counterCol.GetContext(),
+			bson.M{"_id": id},
+			bson.M{"$inc": bson.M{"line": n}, "$set": bson.M{"ts": time.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&counter)
+		if err == nil {
+			return counter.Line - n, nil
+		}
+		if !errors.Is(err, mongo2.ErrNoDocuments) {
+			return 0, trace.TraceError(err)
+		}
+
+		// create the counter from the last line in db, which has been written before counters
+		// were introduced. another master creating it at the same time is fine.
+		lastLine, err := d.getLastLine(id)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := d.counterCol.GetCollection().InsertOne(d.counterCol.GetContext(), bson.M{
+			"_id":  id,
+			"line": lastLine,
+			"ts":   time.Now(),
+		}); err != nil && !mongo2.IsDuplicateKeyError(err) {
+			return 0, trace.TraceError(err)
+		}
+	}
+	return 0, trace.TraceError(err)
+}
+
+func (d *MongoLogDriver) getLastLine(id string) (n int64, err error) {
+	var doc Line
+	if err := d.col.Find(bson.M{"task_id": id}, &mongo.FindOptions{
+		Sort:  bson.D{{"line", -1}},
+		Limit: 1,
+	}).One(&doc); err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, trace.TraceError(err)
+	}
+	return doc.Line, nil
+}
+
+// getMsgQuery returns the query of log messages containing the pattern literally, which is
+// escaped so that user input is neither an invalid nor an expensive regular expression
+func getMsgQuery(pattern string) (query bson.M) {
+	return bson.M{"$regex": regexp.QuoteMeta(pattern)}
+}
+
+// ensureTtlIndex creates the ttl index on "ts" of a collection, and re-creates it if "log.ttl" has
+// changed
+func ensureTtlIndex(col *mongo.Col) (err error) {
+	ttlSeconds := int32(getLogTtl().Seconds())
+
+	indexes, err := col.ListIndexes()
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index["name"] != mongoLogTtlIndexName {
+			continue
+		}
+		var expire int64
+		switch v := index["expireAfterSeconds"].(type) {
+		case int32:
+			expire = int64(v)
+		case int64:
+			expire = v
+		case float64:
+			expire = int64(v)
+		}
+		if expire == int64(ttlSeconds) {
+			return nil
+		}
+		log.Infof("[MongoLogDriver] log ttl changed, re-creating ttl index")
+		if err := col.DeleteIndex(mongoLogTtlIndexName); err != nil {
+			return err
+		}
+	}
+
+	return col.CreateIndex(mongo2.IndexModel{
+		Keys:    bson.D{{"ts", 1}},
+		Options: options.Index().SetName(mongoLogTtlIndexName).SetExpireAfterSeconds(ttlSeconds),
+	})
+}
+
+var mongoLogDriver Driver
+
+func newMongoLogDriver() (driver Driver, err error) {
+	// driver
+	driver = &MongoLogDriver{
+		colName: MongoLogColName,
+	}
+
+	// init
+	if err := driver.Init(); err != nil {
+		return nil, err
+	}
+
+	return driver, nil
+}
+
+func GetMongoLogDriver() (driver Driver, err error) {
+	if mongoLogDriver != nil {
+		return mongoLogDriver, nil
+	}
+	mongoLogDriver, err = newMongoLogDriver()
+	if err != nil {
+		return nil, err
+	}
+	return mongoLogDriver, nil
+}
@@ -0,0 +1,194 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestMongoLogDriver returns a mongo log driver writing to a test collection, skipping the test
+// if MongoDB is not available
+func newTestMongoLogDriver(t *testing.T) (d *MongoLogDriver) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := mongo2.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017").SetServerSelectionTimeout(time.Second))
+	if err == nil {
+		err = c.Ping(ctx, nil)
+		_ = c.Disconnect(ctx)
+	}
+	if err != nil {
+		t.Skipf("MongoDB is not available: %v", err)
+	}
+
+	viper.Set("mongo.db", "crawlab_test")
+	d = &MongoLogDriver{
+		colName: "test_task_logs",
+	}
+	require.Nil(t, d.Init())
+	t.Cleanup(func() {
+		_ = d.col.GetCollection().Drop(d.col.GetContext())
+		_ = d.counterCol.GetCollection().Drop(d.counterCol.GetContext())
+	})
+	return d
+}
+
+func TestLineCache(t *testing.T) {
+	c := newLineCache(time.Minute)
+	c.set("a", 10)
+	c.set("b", 20)
+
+	n, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, int64(10), n)
+
+	c.delete("a")
+	_, ok = c.get("a")
+	require.False(t, ok)
+
+	// idle tasks are evicted
+	c.evict(time.Now())
+	_, ok = c.get("b")
+	require.True(t, ok)
+	c.evict(time.Now().Add(2 * time.Minute))
+	_, ok = c.get("b")
+	require.False(t, ok)
+	require.Empty(t, c.items)
+}
+
+func TestMongoLogDriver_getQuery(t *testing.T) {
+	d := &MongoLogDriver{}
+	require.Equal(t, bson.M{"task_id": "t1"}, d.getQuery("t1", nil))
+	require.Equal(t, bson.M{
+		"task_id": "t1",
+		"msg":     bson.M{"$regex": `error \(code 1\)`},
+		"stream":  constants.LogStreamStderr,
+		"level":   constants.LogLevelError,
+	}, d.getQuery("t1", &Filter{
+		Pattern: "error (code 1)",
+		Stream:  constants.LogStreamStderr,
+		Level:   constants.LogLevelError,
+	}))
+}
+
+func TestMongoLogDriver_WriteLinesAndFind(t *testing.T) {
+	d := newTestMongoLogDriver(t)
+
+	id := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id, taskMeta{})
+	require.Nil(t, d.WriteLines(id, []string{"line 1", "ERROR: connection refused", "line 3"}))
+	require.Nil(t, d.WriteLine(id, "line 4"))
+
+	n, err := d.Count(id, "")
+	require.Nil(t, err)
+	require.Equal(t, 4, n)
+
+	lines, err := d.Find(id, "", 1, 2)
+	require.Nil(t, err)
+	require.Equal(t, []string{"ERROR: connection refused", "line 3"}, lines)
+
+	lines, err = d.Find(id, "connection refused", 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, []string{"ERROR: connection refused"}, lines)
+
+	// patterns are matched literally
+	lines, err = d.Find(id, "ERROR: connection refused (", 0, 10)
+	require.Nil(t, err)
+	require.Empty(t, lines)
+
+	// line numbers continue from the db if the counter is missing
+	_, err = d.counterCol.GetCollection().DeleteMany(d.counterCol.GetContext(), bson.M{})
+	require.Nil(t, err)
+	require.Nil(t, d.WriteLine(id, "line 5"))
+	docs, err := d.FindLines(id, nil, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, docs, 5)
+	for i, doc := range docs {
+		require.Equal(t, int64(i+1), doc.Line)
+	}
+}
+
+func TestMongoLogDriver_WriteLinesConcurrently(t *testing.T) {
+	d1 := newTestMongoLogDriver(t)
+	d2 := &MongoLogDriver{colName: d1.colName}
+	require.Nil(t, d2.Init())
+
+	// drivers of different masters writing the same task do not collide on line numbers
+	id := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id, taskMeta{})
+	var wg sync.WaitGroup
+	for _, d := range []*MongoLogDriver{d1, d2, d1, d2} {
+		wg.Add(1)
+		go func(d *MongoLogDriver) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				require.Nil(t, d.WriteLines(id, []string{"a", "b"}))
+			}
+		}(d)
+	}
+	wg.Wait()
+	docs, err := d1.FindLines(id, nil, 0, 100)
+	require.Nil(t, err)
+	require.Len(t, docs, 80)
+	for i, doc := range docs {
+		require.Equal(t, int64(i+1), doc.Line)
+	}
+}
+
+func TestMongoLogDriver_FindLines(t *testing.T) {
+	d := newTestMongoLogDriver(t)
+
+	id := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id, taskMeta{})
+	require.Nil(t, d.WriteEntries(id, []entity.TaskLogEntry{
+		{Seq: 1, Stream: constants.LogStreamStdout, Level: constants.LogLevelInfo, Msg: "INFO: started"},
+		{Seq: 2, Stream: constants.LogStreamStderr, Level: constants.LogLevelError, Msg: "ERROR: failed"},
+	}))
+
+	lines, err := d.FindLines(id, &Filter{Stream: constants.LogStreamStderr}, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, "ERROR: failed", lines[0].Msg)
+	require.Equal(t, int64(2), lines[0].Seq)
+
+	n, err := d.CountLines(id, &Filter{Level: constants.LogLevelInfo})
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+
+	// plain lines have no sequence number
+	require.Nil(t, d.WriteLine(id, "plain"))
+	seq, err := d.GetLastSeq(id)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), seq)
+}
+
+func TestMongoLogDriver_Search(t *testing.T) {
+	d := newTestMongoLogDriver(t)
+
+	id1 := primitive.NewObjectID().Hex()
+	id2 := primitive.NewObjectID().Hex()
+	taskMetaCache.Set(id1, taskMeta{spiderId: "s1", nodeId: "n1"})
+	taskMetaCache.Set(id2, taskMeta{spiderId: "s2", nodeId: "n2"})
+	require.Nil(t, d.WriteLines(id1, []string{"ok", "timeout error"}))
+	require.Nil(t, d.WriteLines(id2, []string{"timeout error", "ok"}))
+
+	lines, total, err := d.Search("timeout", 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, total)
+	var taskIds, spiderIds []string
+	for _, line := range lines {
+		taskIds = append(taskIds, line.TaskId)
+		spiderIds = append(spiderIds, line.SpiderId)
+	}
+	require.ElementsMatch(t, []string{id1, id2}, taskIds)
+	require.ElementsMatch(t, []string{"s1", "s2"}, spiderIds)
+}
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	errors2 "errors"
+	"fmt"
 	"github.com/apex/log"
 	"github.com/crawlab-team/crawlab/core/constants"
 	"github.com/crawlab-team/crawlab/core/errors"
@@ -10,13 +11,16 @@ import (
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	nodeconfig "github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/core/node/leader"
 	"github.com/crawlab-team/crawlab/core/task/handler"
 	"github.com/crawlab-team/crawlab/core/utils"
 	grpc "github.com/crawlab-team/crawlab/grpc"
 	"github.com/crawlab-team/crawlab/trace"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"sync"
 	"time"
 )
 
@@ -28,14 +32,28 @@ type ServiceV2 struct {
 
 	// settings
 	interval time.Duration
+
+	// internals
+	ready chan struct{} // closed after task statuses are initialized
 }
 
 func (svc *ServiceV2) Start() {
-	go svc.initTaskStatus()
+	go func() {
+		svc.initTaskStatus()
+		close(svc.ready)
+	}()
 	go svc.cleanupTasks()
+	go svc.updateParentTasks()
+	go svc.retryTasks()
 	utils.DefaultWait()
 }
 
+// Ready returns a channel closed after task statuses of existing tasks are initialized and the
+// task queue is cleared on startup
+func (svc *ServiceV2) Ready() <-chan struct{} {
+	return svc.ready
+}
+
 func (svc *ServiceV2) Enqueue(t *models2.TaskV2, by primitive.ObjectID) (t2 *models2.TaskV2, err error) {
 	// set task status
 	t.Status = constants.TaskStatusPending
@@ -49,6 +67,23 @@ func (svc *ServiceV2) Enqueue(t *models2.TaskV2, by primitive.ObjectID) (t2 *mod
 		return nil, err
 	}
 
+	// task stat
+	ts := models2.TaskStatV2{}
+	ts.SetId(id)
+	ts.SetCreated(by)
+	ts.SetUpdated(by)
+
+	// add task stat
+	_, err = service.NewModelServiceV2[models2.TaskStatV2]().InsertOne(ts)
+	if err != nil {
+		return nil, trace.TraceError(err)
+	}
+
+	// skip enqueue for parent tasks, whose status is rolled up from sub-tasks
+	if t.HasSub {
+		return t, nil
+	}
+
 	// task queue item
 	tq := models2.TaskQueueItemV2{
 		Priority: t.Priority,
@@ -58,24 +93,12 @@ func (svc *ServiceV2) Enqueue(t *models2.TaskV2, by primitive.ObjectID) (t2 *mod
 	tq.SetCreated(by)
 	tq.SetUpdated(by)
 
-	// task stat
-	ts := models2.TaskStatV2{}
-	ts.SetId(id)
-	ts.SetCreated(by)
-	ts.SetUpdated(by)
-
 	// enqueue task
 	_, err = service.NewModelServiceV2[models2.TaskQueueItemV2]().InsertOne(tq)
 	if err != nil {
 		return nil, trace.TraceError(err)
 	}
 
-	// add task stat
-	_, err = service.NewModelServiceV2[models2.TaskStatV2]().InsertOne(ts)
-	if err != nil {
-		return nil, trace.TraceError(err)
-	}
-
 	// success
 	return t, nil
 }
@@ -87,6 +110,11 @@ func (svc *ServiceV2) Cancel(id primitive.ObjectID, by primitive.ObjectID) (err
 		return trace.TraceError(err)
 	}
 
+	// cancel sub-tasks of parent task
+	if t.HasSub {
+		return svc.cancelParentTask(t, by)
+	}
+
 	// initial status
 	initialStatus := t.Status
 
@@ -150,32 +178,54 @@ func (svc *ServiceV2) SaveTask(t *models2.TaskV2, by primitive.ObjectID) (err er
 	}
 }
 
-// initTaskStatus initialize task status of existing tasks
+// initTaskStatus initialize task status of existing tasks. With leader election of multiple
+// masters, only tasks of this master are initialized, as tasks of other masters and worker nodes
+// keep running.
 func (svc *ServiceV2) initTaskStatus() {
-	// set status of running tasks as TaskStatusAbnormal
-	runningTasks, err := service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{
+	// parent tasks are not run by any node, whose status is rolled up from sub-tasks
+	query := bson.M{
 		"status": bson.M{
 			"$in": []string{
 				constants.TaskStatusPending,
 				constants.TaskStatusRunning,
 			},
 		},
-	}, nil)
+		"has_sub": bson.M{"$ne": true},
+	}
+	queueQuery := bson.M{}
+	if leader.GetLeaderServiceV2().IsEnabled() {
+		n, err := service.NewModelServiceV2[models2.NodeV2]().GetOne(bson.M{"key": svc.nodeCfgSvc.GetNodeKey()}, nil)
+		if err != nil {
+			if !errors2.Is(err, mongo2.ErrNoDocuments) {
+				trace.PrintError(err)
+			}
+			return
+		}
+		query["node_id"] = n.Id
+		queueQuery["nid"] = n.Id
+	}
+
+	// set status of running tasks as TaskStatusAbnormal
+	runningTasks, err := service.NewModelServiceV2[models2.TaskV2]().GetMany(query, nil)
 	if err != nil {
 		if errors2.Is(err, mongo2.ErrNoDocuments) {
 			return
 		}
 		trace.PrintError(err)
 	}
+	var wg sync.WaitGroup
 	for _, t := range runningTasks {
+		wg.Add(1)
 		go func(t *models2.TaskV2) {
+			defer wg.Done()
 			t.Status = constants.TaskStatusAbnormal
 			if err := svc.SaveTask(t, primitive.NilObjectID); err != nil {
 				trace.PrintError(err)
 			}
 		}(&t)
 	}
-	if err := service.NewModelServiceV2[models2.TaskQueueItemV2]().DeleteMany(nil); err != nil {
+	wg.Wait()
+	if err := service.NewModelServiceV2[models2.TaskQueueItemV2]().DeleteMany(queueQuery); err != nil {
 		return
 	}
 }
@@ -194,16 +244,387 @@ func (svc *ServiceV2) isMasterNode(t *models2.TaskV2) (ok bool, err error) {
 	return n.IsMaster, nil
 }
 
+func (svc *ServiceV2) cancelParentTask(t *models2.TaskV2, by primitive.ObjectID) (err error) {
+	// sub-tasks
+	subTasks, err := svc.getSubTasks(t.Id)
+	if err != nil {
+		return err
+	}
+
+	// cancel cancellable sub-tasks
+	for _, st := range subTasks {
+		if !utils.IsCancellable(st.Status) {
+			continue
+		}
+		if err := svc.Cancel(st.Id, by); err != nil {
+			trace.PrintError(err)
+		}
+	}
+
+	// set parent task status as "cancelled"
+	t.Status = constants.TaskStatusCancelled
+	return svc.SaveTask(t, by)
+}
+
+func (svc *ServiceV2) getSubTasks(parentId primitive.ObjectID) (subTasks []models2.TaskV2, err error) {
+	subTasks, err = service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{
+		"parent_id": parentId,
+	}, nil)
+	if err != nil {
+		if errors2.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, trace.TraceError(err)
+	}
+	return subTasks, nil
+}
+
+// updateParentTasks periodically rolls up status of unfinished parent tasks from their sub-tasks
+func (svc *ServiceV2) updateParentTasks() {
+	for {
+		// only the leader of masters rolls up parent tasks
+		if !leader.IsLeader() {
+			time.Sleep(svc.interval)
+			continue
+		}
+
+		// abnormal parent tasks are included as well, which could be marked abnormal on restart
+		// by earlier versions while their sub-tasks were still running
+		parentTasks, err := service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{
+			"has_sub": true,
+			"status": bson.M{
+				"$in": []string{
+					constants.TaskStatusPending,
+					constants.TaskStatusRunning,
+					constants.TaskStatusAbnormal,
+				},
+			},
+		}, nil)
+		if err != nil && !errors2.Is(err, mongo2.ErrNoDocuments) {
+			trace.PrintError(err)
+		}
+		for _, t := range parentTasks {
+			if err := svc.updateParentTask(&t); err != nil {
+				trace.PrintError(err)
+			}
+		}
+		time.Sleep(svc.interval)
+	}
+}
+
+func (svc *ServiceV2) updateParentTask(t *models2.TaskV2) (err error) {
+	// last attempts of sub-tasks
+	subTasks, err := svc.getSubTasks(t.Id)
+	if err != nil {
+		return err
+	}
+	subTasks, err = GetLastAttempts(subTasks)
+	if err != nil {
+		return err
+	}
+	if len(subTasks) == 0 {
+		return nil
+	}
+
+	// count sub-tasks by status
+	counts := map[string]int{}
+	for _, st := range subTasks {
+		counts[st.Status]++
+	}
+
+	// rolled up status
+	status := getParentTaskStatus(counts, len(subTasks))
+	if status == t.Status {
+		return nil
+	}
+	t.Status = status
+	if status == constants.TaskStatusError {
+		errCount := counts[constants.TaskStatusError] + counts[constants.TaskStatusAbnormal] +
+			counts[constants.TaskStatusTimeout] + counts[constants.TaskStatusOomKilled]
+		t.Error = fmt.Sprintf("%d of %d sub-tasks failed", errCount, len(subTasks))
+	}
+	if err := svc.SaveTask(t, primitive.NilObjectID); err != nil {
+		return err
+	}
+
+	// update task stat
+	return svc.updateParentTaskStat(t, subTasks)
+}
+
+func (svc *ServiceV2) updateParentTaskStat(t *models2.TaskV2, subTasks []models2.TaskV2) (err error) {
+	ts, err := service.NewModelServiceV2[models2.TaskStatV2]().GetById(t.Id)
+	if err != nil {
+		return trace.TraceError(err)
+	}
+	switch t.Status {
+	case constants.TaskStatusRunning:
+		if ts.StartTs.IsZero() {
+			ts.StartTs = time.Now()
+			ts.WaitDuration = ts.StartTs.Sub(ts.CreatedAt).Milliseconds()
+		}
+	case constants.TaskStatusFinished, constants.TaskStatusError, constants.TaskStatusCancelled, constants.TaskStatusTimeout, constants.TaskStatusOomKilled:
+		// sub-task ids
+		var ids []primitive.ObjectID
+		for _, st := range subTasks {
+			ids = append(ids, st.Id)
+		}
+
+		// sum up result count and resource usage of sub-tasks
+		subTaskStats, err := service.NewModelServiceV2[models2.TaskStatV2]().GetMany(bson.M{
+			"_id": bson.M{"$in": ids},
+		}, nil)
+		if err != nil && !errors2.Is(err, mongo2.ErrNoDocuments) {
+			return trace.TraceError(err)
+		}
+		ts.ResultCount = 0
+		ts.ResultNewCount = 0
+		ts.ResultUpdatedCount = 0
+		ts.ResultSkippedCount = 0
+		ts.ResultRejectedCount = 0
+		ts.UserCpuTime = 0
+		ts.SysCpuTime = 0
+		ts.MaxRss = 0
+		for _, sts := range subTaskStats {
+			ts.ResultCount += sts.ResultCount
+			ts.ResultNewCount += sts.ResultNewCount
+			ts.ResultUpdatedCount += sts.ResultUpdatedCount
+			ts.ResultSkippedCount += sts.ResultSkippedCount
+			ts.ResultRejectedCount += sts.ResultRejectedCount
+			ts.UserCpuTime += sts.UserCpuTime
+			ts.SysCpuTime += sts.SysCpuTime
+			ts.MaxRss = max(ts.MaxRss, sts.MaxRss)
+		}
+
+		if ts.StartTs.IsZero() {
+			ts.StartTs = time.Now()
+			ts.WaitDuration = ts.StartTs.Sub(ts.CreatedAt).Milliseconds()
+		}
+		ts.EndTs = time.Now()
+		ts.RuntimeDuration = ts.EndTs.Sub(ts.StartTs).Milliseconds()
+		ts.TotalDuration = ts.EndTs.Sub(ts.CreatedAt).Milliseconds()
+	}
+	ts.SetUpdated(primitive.NilObjectID)
+	return service.NewModelServiceV2[models2.TaskStatV2]().ReplaceById(ts.Id, *ts)
+}
+
+// retryTasks periodically applies retry policy to failed tasks
+func (svc *ServiceV2) retryTasks() {
+	for {
+		// only the leader of masters retries tasks
+		if !leader.IsLeader() {
+			time.Sleep(svc.interval)
+			continue
+		}
+
+		tasks, err := service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{
+			"status": bson.M{
+				"$in": []string{
+					constants.TaskStatusError,
+					constants.TaskStatusAbnormal,
+					constants.TaskStatusTimeout,
+					constants.TaskStatusOomKilled,
+					constants.TaskStatusCancelled,
+				},
+			},
+			"has_sub":                   bson.M{"$ne": true}, // sub-tasks are retried individually
+			"retry_policy.max_attempts": bson.M{"$gt": 1},
+			"retried":                   bson.M{"$ne": true},
+		}, nil)
+		if err != nil && !errors2.Is(err, mongo2.ErrNoDocuments) {
+			trace.PrintError(err)
+		}
+		for _, t := range tasks {
+			if err := svc.retryTask(&t); err != nil {
+				trace.PrintError(err)
+			}
+		}
+		time.Sleep(svc.interval)
+	}
+}
+
+// retryTask creates a retry task of a failed task if retryable and its backoff delay has passed
+func (svc *ServiceV2) retryTask(t *models2.TaskV2) (err error) {
+	attempt := t.Attempt
+	if attempt == 0 {
+		attempt = 1
+	}
+
+	// task stat
+	ts, err := service.NewModelServiceV2[models2.TaskStatV2]().GetById(t.Id)
+	if err != nil {
+		return trace.TraceError(err)
+	}
+
+	// mark as retried without retrying if not retryable
+	if !t.RetryPolicy.IsRetryable(attempt, t.Status, ts.ExitCode) {
+		return svc.setTaskRetried(t.Id)
+	}
+
+	// wait for backoff delay
+	endTs := ts.EndTs
+	if endTs.IsZero() {
+		endTs = t.UpdatedAt
+	}
+	if time.Now().Before(endTs.Add(t.RetryPolicy.GetDelay(attempt))) {
+		return nil
+	}
+
+	// retry task linked to the first attempt
+	rt := newRetryTask(t, attempt+1)
+	if _, err := svc.Enqueue(rt, t.CreatedBy); err != nil {
+		return err
+	}
+	log.Infof("task[%s] failed with status %s, retrying as task[%s] (attempt %d of %d)", t.Id.Hex(), t.Status, rt.Id.Hex(), rt.Attempt, t.RetryPolicy.MaxAttempts)
+
+	return svc.setTaskRetried(t.Id)
+}
+
+func (svc *ServiceV2) setTaskRetried(id primitive.ObjectID) (err error) {
+	return service.NewModelServiceV2[models2.TaskV2]().UpdateById(id, bson.M{
+		"$set": bson.M{"retried": true},
+	})
+}
+
+// newRetryTask returns the task of the given attempt retrying a failed task. Retries of a sub-task
+// remain sub-tasks of its parent task.
+func newRetryTask(t *models2.TaskV2, attempt int) (rt *models2.TaskV2) {
+	firstAttemptId := t.Id
+	if !t.FirstAttemptId.IsZero() {
+		firstAttemptId = t.FirstAttemptId
+	}
+	rt = &models2.TaskV2{
+		SpiderId:       t.SpiderId,
+		Mode:           t.Mode,
+		NodeIds:        t.NodeIds,
+		Cmd:            t.Cmd,
+		Param:          t.Param,
+		ScheduleId:     t.ScheduleId,
+		Priority:       t.Priority,
+		Limits:         t.Limits,
+		RetryPolicy:    t.RetryPolicy,
+		ParentId:       t.ParentId,
+		Attempt:        attempt,
+		FirstAttemptId: firstAttemptId,
+		TriggerId:      t.TriggerId,
+	}
+	rt.TriggerDepth = t.TriggerDepth
+	rt.WorkflowRunId = t.WorkflowRunId
+	rt.WorkflowStep = t.WorkflowStep
+	rt.UpstreamTaskIds = t.UpstreamTaskIds
+	if t.Mode == constants.RunTypeSelectedNodes {
+		rt.NodeId = t.NodeId
+	}
+	rt.SetId(primitive.NewObjectID())
+	return rt
+}
+
+// GetLastAttempts returns the last attempt of each retry chain among the given tasks, in the order
+// of their first attempts. Failed attempts going to be retried are returned as pending.
+func GetLastAttempts(tasks []models2.TaskV2) (lastAttempts []models2.TaskV2, err error) {
+	// last attempt of each chain keyed by first attempt
+	var firstIds []primitive.ObjectID
+	chains := map[primitive.ObjectID]models2.TaskV2{}
+	for _, t := range tasks {
+		firstId := t.Id
+		if !t.FirstAttemptId.IsZero() {
+			firstId = t.FirstAttemptId
+		}
+		last, ok := chains[firstId]
+		if !ok {
+			firstIds = append(firstIds, firstId)
+		}
+		if !ok || max(t.Attempt, 1) > max(last.Attempt, 1) {
+			chains[firstId] = t
+		}
+	}
+
+	for _, id := range firstIds {
+		t := chains[id]
+		ok, err := IsPendingRetry(&t)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			t.Status = constants.TaskStatusPending
+		}
+		lastAttempts = append(lastAttempts, t)
+	}
+	return lastAttempts, nil
+}
+
+// IsPendingRetry returns whether a failed task is going to be retried by its retry policy
+func IsPendingRetry(t *models2.TaskV2) (ok bool, err error) {
+	if t.RetryPolicy.MaxAttempts <= 1 || t.HasSub || t.Retried {
+		return false, nil
+	}
+	switch t.Status {
+	case constants.TaskStatusPending, constants.TaskStatusRunning, constants.TaskStatusFinished:
+		return false, nil
+	}
+	ts, err := service.NewModelServiceV2[models2.TaskStatV2]().GetById(t.Id)
+	if err != nil {
+		return false, trace.TraceError(err)
+	}
+	return t.RetryPolicy.IsRetryable(max(t.Attempt, 1), t.Status, ts.ExitCode), nil
+}
+
+// GetParentTaskStatus returns the status of a parent task rolled up from its sub-tasks
+func GetParentTaskStatus(subTasks []models2.TaskV2) (status string) {
+	counts := map[string]int{}
+	for _, st := range subTasks {
+		counts[st.Status]++
+	}
+	return getParentTaskStatus(counts, len(subTasks))
+}
+
+// getParentTaskStatus returns the status of a parent task given the status counts of its sub-tasks:
+// running while any sub-task runs, error if any sub-task failed, finished when all finished.
+func getParentTaskStatus(counts map[string]int, total int) (status string) {
+	switch {
+	case counts[constants.TaskStatusPending] == total:
+		return constants.TaskStatusPending
+	case counts[constants.TaskStatusPending]+counts[constants.TaskStatusRunning] > 0:
+		return constants.TaskStatusRunning
+	case counts[constants.TaskStatusError]+counts[constants.TaskStatusAbnormal]+
+		counts[constants.TaskStatusTimeout]+counts[constants.TaskStatusOomKilled] > 0:
+		return constants.TaskStatusError
+	case counts[constants.TaskStatusCancelled] > 0:
+		return constants.TaskStatusCancelled
+	default:
+		return constants.TaskStatusFinished
+	}
+}
+
+// cleanupTasks periodically deletes tasks and task stats older than the max age in settings
+// "task.retention.maxAgeDays" (30 days by default, disabled if not positive)
 func (svc *ServiceV2) cleanupTasks() {
+	maxAgeDays := 30
+	if viper.IsSet("task.retention.maxAgeDays") {
+		maxAgeDays = viper.GetInt("task.retention.maxAgeDays")
+	}
+	if maxAgeDays <= 0 {
+		return
+	}
+	interval := 30 * time.Minute
+	if d := viper.GetDuration("task.retention.interval"); d > 0 {
+		interval = d
+	}
+
 	for {
-		// task stats over 30 days ago
+		// only the leader of masters cleans up tasks
+		if !leader.IsLeader() {
+			time.Sleep(interval)
+			continue
+		}
+
+		// task stats over max age
 		taskStats, err := service.NewModelServiceV2[models2.TaskStatV2]().GetMany(bson.M{
 			"create_ts": bson.M{
-				"$lt": time.Now().Add(-30 * 24 * time.Hour),
+				"$lt": time.Now().Add(-time.Duration(maxAgeDays) * 24 * time.Hour),
 			},
 		}, nil)
 		if err != nil {
-			time.Sleep(30 * time.Minute)
+			time.Sleep(interval)
 			continue
 		}
 
@@ -227,9 +648,11 @@ func (svc *ServiceV2) cleanupTasks() {
 			}); err != nil {
 				trace.PrintError(err)
 			}
+
+			log.Infof("[TaskSchedulerServiceV2] deleted %d tasks older than %d days", len(ids), maxAgeDays)
 		}
 
-		time.Sleep(30 * time.Minute)
+		time.Sleep(interval)
 	}
 }
 
@@ -237,6 +660,7 @@ func NewTaskSchedulerServiceV2() (svc2 *ServiceV2, err error) {
 	// service
 	svc := &ServiceV2{
 		interval: 5 * time.Second,
+		ready:    make(chan struct{}),
 	}
 	svc.nodeCfgSvc = nodeconfig.GetNodeConfigService()
 	svc.svr, err = server.GetGrpcServerV2()
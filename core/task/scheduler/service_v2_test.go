@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetParentTaskStatus_AllPending_ReturnsPending(t *testing.T) {
+	counts := map[string]int{constants.TaskStatusPending: 3}
+	assert.Equal(t, constants.TaskStatusPending, getParentTaskStatus(counts, 3))
+}
+
+func TestGetParentTaskStatus_AnyRunning_ReturnsRunning(t *testing.T) {
+	counts := map[string]int{
+		constants.TaskStatusRunning: 1,
+		constants.TaskStatusError:   1,
+		constants.TaskStatusPending: 1,
+	}
+	assert.Equal(t, constants.TaskStatusRunning, getParentTaskStatus(counts, 3))
+}
+
+func TestGetParentTaskStatus_AnyFailed_ReturnsError(t *testing.T) {
+	counts := map[string]int{
+		constants.TaskStatusFinished:  1,
+		constants.TaskStatusAbnormal:  1,
+		constants.TaskStatusCancelled: 1,
+	}
+	assert.Equal(t, constants.TaskStatusError, getParentTaskStatus(counts, 3))
+}
+
+func TestGetParentTaskStatus_AllFinished_ReturnsFinished(t *testing.T) {
+	counts := map[string]int{constants.TaskStatusFinished: 2}
+	assert.Equal(t, constants.TaskStatusFinished, getParentTaskStatus(counts, 2))
+}
+
+func TestGetParentTaskStatus_AnyLimitExceeded_ReturnsError(t *testing.T) {
+	counts := map[string]int{
+		constants.TaskStatusFinished:  1,
+		constants.TaskStatusTimeout:   1,
+		constants.TaskStatusOomKilled: 1,
+	}
+	assert.Equal(t, constants.TaskStatusError, getParentTaskStatus(counts, 3))
+}
+
+func TestNewRetryTask_LinksToFirstAttempt(t *testing.T) {
+	parentId := primitive.NewObjectID()
+	first := &models2.TaskV2{Mode: constants.RunTypeRandom, NodeId: primitive.NewObjectID(), ParentId: parentId}
+	first.SetId(primitive.NewObjectID())
+
+	rt := newRetryTask(first, 2)
+	assert.Equal(t, first.Id, rt.FirstAttemptId)
+	assert.Equal(t, parentId, rt.ParentId)
+	assert.Equal(t, 2, rt.Attempt)
+	assert.True(t, rt.NodeId.IsZero())
+	assert.NotEqual(t, first.Id, rt.Id)
+
+	rt2 := newRetryTask(rt, 3)
+	assert.Equal(t, first.Id, rt2.FirstAttemptId)
+	assert.Equal(t, parentId, rt2.ParentId)
+	assert.Equal(t, 3, rt2.Attempt)
+}
+
+func TestGetLastAttempts(t *testing.T) {
+	// sub-task on node 1 failed and was retried, sub-task on node 2 finished
+	first1 := models2.TaskV2{Status: constants.TaskStatusError, Attempt: 1, Retried: true}
+	first1.SetId(primitive.NewObjectID())
+	first2 := models2.TaskV2{Status: constants.TaskStatusFinished, Attempt: 1}
+	first2.SetId(primitive.NewObjectID())
+	retry1 := models2.TaskV2{Status: constants.TaskStatusFinished, Attempt: 2, FirstAttemptId: first1.Id}
+	retry1.SetId(primitive.NewObjectID())
+
+	lastAttempts, err := GetLastAttempts([]models2.TaskV2{first1, retry1, first2})
+	assert.Nil(t, err)
+	assert.Len(t, lastAttempts, 2)
+	assert.Equal(t, retry1.Id, lastAttempts[0].Id)
+	assert.Equal(t, first2.Id, lastAttempts[1].Id)
+	assert.Equal(t, constants.TaskStatusFinished, GetParentTaskStatus(lastAttempts))
+}
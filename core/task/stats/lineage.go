@@ -0,0 +1,155 @@
+package stats
+
+import (
+	log2 "github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// lineageFields are all fields of the lineage envelope in constants.LineageKey
+var lineageFields = []string{
+	constants.LineageFieldTaskId,
+	constants.LineageFieldSpiderId,
+	constants.LineageFieldScheduleId,
+	constants.LineageFieldNodeKey,
+	constants.LineageFieldIngestTs,
+	constants.LineageFieldSourceUrl,
+}
+
+// lineageIndexFields are fields of the lineage envelope indexed in built-in mongo, as results are
+// listed by them. Task id is not indexed as results are already queried by constants.TaskKey.
+var lineageIndexFields = []string{
+	constants.LineageFieldSpiderId,
+	constants.LineageFieldScheduleId,
+	constants.LineageFieldNodeKey,
+	constants.LineageFieldIngestTs,
+}
+
+// lineage stamps records of a task with the lineage envelope
+type lineage struct {
+	fields     []string
+	taskId     primitive.ObjectID
+	spiderId   primitive.ObjectID
+	scheduleId primitive.ObjectID
+	nodeKey    string
+}
+
+// Stamp sets the lineage envelope on each record. The source url supplied by sdk in
+// constants.SourceUrlKey is moved into the envelope.
+func (l *lineage) Stamp(records []map[string]interface{}, ts time.Time) {
+	for _, record := range records {
+		meta := bson.M{}
+		for _, f := range l.fields {
+			switch f {
+			case constants.LineageFieldTaskId:
+				meta[f] = l.taskId
+			case constants.LineageFieldSpiderId:
+				meta[f] = l.spiderId
+			case constants.LineageFieldScheduleId:
+				if !l.scheduleId.IsZero() {
+					meta[f] = l.scheduleId
+				}
+			case constants.LineageFieldNodeKey:
+				if l.nodeKey != "" {
+					meta[f] = l.nodeKey
+				}
+			case constants.LineageFieldIngestTs:
+				meta[f] = ts
+			case constants.LineageFieldSourceUrl:
+				if url, ok := record[constants.SourceUrlKey].(string); ok {
+					if url != "" {
+						meta[f] = url
+					}
+					delete(record, constants.SourceUrlKey)
+				}
+			}
+		}
+		record[constants.LineageKey] = meta
+	}
+}
+
+// HasField returns whether a field is in the lineage envelope
+func (l *lineage) HasField(field string) bool {
+	for _, f := range l.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// getLineageFields returns fields of the lineage envelope configured in the data collection,
+// or in global settings "result.lineage" if not enabled there, or nil if lineage is disabled
+func getLineageFields(dc *models2.DataCollectionV2) (fields []string) {
+	enabled := viper.GetBool("result.lineage.enabled")
+	configured := viper.GetStringSlice("result.lineage.fields")
+	if dc != nil && dc.Lineage.Enabled {
+		enabled = true
+		if len(dc.Lineage.Fields) > 0 {
+			configured = dc.Lineage.Fields
+		}
+	}
+	if !enabled {
+		return nil
+	}
+	if len(configured) == 0 {
+		return lineageFields
+	}
+	for _, f := range lineageFields {
+		for _, c := range configured {
+			if f == c {
+				fields = append(fields, f)
+				break
+			}
+		}
+	}
+	if len(fields) == 0 {
+		log2.Warnf("[TaskStatsServiceV2] no valid lineage fields in %v", configured)
+	}
+	return fields
+}
+
+// newLineage returns a lineage of a task, or nil if lineage is disabled
+func newLineage(t *models2.TaskV2, dc *models2.DataCollectionV2, nodeKey string) (l *lineage) {
+	fields := getLineageFields(dc)
+	if len(fields) == 0 {
+		return nil
+	}
+	return &lineage{
+		fields:     fields,
+		taskId:     t.Id,
+		spiderId:   t.SpiderId,
+		scheduleId: t.ScheduleId,
+		nodeKey:    nodeKey,
+	}
+}
+
+// ensureLineageIndexes creates indexes on indexed fields of the lineage envelope of a collection once
+func (svc *ServiceV2) ensureLineageIndexes(col *mongo.Col, l *lineage) {
+	if _, ok := svc.lineageIndexes.LoadOrStore(col.GetName(), true); ok {
+		return
+	}
+	var indexes []mongo2.IndexModel
+	for _, f := range lineageIndexFields {
+		if !l.HasField(f) {
+			continue
+		}
+		direction := 1
+		if f == constants.LineageFieldIngestTs {
+			direction = -1
+		}
+		indexes = append(indexes, mongo2.IndexModel{Keys: bson.D{{constants.LineageKey + "." + f, direction}}})
+	}
+	if len(indexes) == 0 {
+		return
+	}
+	if err := col.CreateIndexes(indexes); err != nil {
+		log2.Warnf("[TaskStatsServiceV2] failed to create lineage indexes on %s: %v", col.GetName(), err)
+	}
+}
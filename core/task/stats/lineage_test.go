@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLineage_Stamp(t *testing.T) {
+	task := &models2.TaskV2{
+		SpiderId:   primitive.NewObjectID(),
+		ScheduleId: primitive.NewObjectID(),
+	}
+	task.Id = primitive.NewObjectID()
+	dc := &models2.DataCollectionV2{}
+	dc.Lineage.Enabled = true
+	l := newLineage(task, dc, "master")
+	require.NotNil(t, l)
+
+	ts := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	records := []map[string]interface{}{
+		{"title": "a", constants.SourceUrlKey: "https://crawlab.cn/a"},
+		{"title": "b"},
+	}
+	l.Stamp(records, ts)
+
+	require.Equal(t, bson.M{
+		constants.LineageFieldTaskId:     task.Id,
+		constants.LineageFieldSpiderId:   task.SpiderId,
+		constants.LineageFieldScheduleId: task.ScheduleId,
+		constants.LineageFieldNodeKey:    "master",
+		constants.LineageFieldIngestTs:   ts,
+		constants.LineageFieldSourceUrl:  "https://crawlab.cn/a",
+	}, records[0][constants.LineageKey])
+	_, ok := records[0][constants.SourceUrlKey]
+	require.False(t, ok)
+	require.Equal(t, "a", records[0]["title"])
+
+	// no schedule id or source url
+	task.ScheduleId = primitive.NilObjectID
+	l = newLineage(task, dc, "master")
+	l.Stamp(records[1:], ts)
+	meta := records[1][constants.LineageKey].(bson.M)
+	require.Len(t, meta, 4)
+	require.NotContains(t, meta, constants.LineageFieldScheduleId)
+	require.NotContains(t, meta, constants.LineageFieldSourceUrl)
+}
+
+func TestGetLineageFields(t *testing.T) {
+	defer viper.Set("result.lineage.enabled", nil)
+	defer viper.Set("result.lineage.fields", nil)
+
+	// disabled
+	require.Nil(t, getLineageFields(nil))
+	require.Nil(t, getLineageFields(&models2.DataCollectionV2{}))
+
+	// data collection
+	dc := &models2.DataCollectionV2{}
+	dc.Lineage.Enabled = true
+	require.Equal(t, lineageFields, getLineageFields(dc))
+	dc.Lineage.Fields = []string{constants.LineageFieldIngestTs, "unknown", constants.LineageFieldTaskId}
+	require.Equal(t, []string{constants.LineageFieldTaskId, constants.LineageFieldIngestTs}, getLineageFields(dc))
+
+	// global settings
+	viper.Set("result.lineage.enabled", true)
+	viper.Set("result.lineage.fields", []string{constants.LineageFieldNodeKey})
+	require.Equal(t, []string{constants.LineageFieldNodeKey}, getLineageFields(nil))
+	require.Equal(t, []string{constants.LineageFieldTaskId, constants.LineageFieldIngestTs}, getLineageFields(dc))
+}
@@ -1,31 +1,86 @@
 package stats
 
 import (
+	"errors"
+	"fmt"
 	log2 "github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
 	"github.com/crawlab-team/crawlab/core/database"
 	interfaces2 "github.com/crawlab-team/crawlab/core/database/interfaces"
+	ds2 "github.com/crawlab-team/crawlab/core/ds"
+	"github.com/crawlab-team/crawlab/core/entity"
 	"github.com/crawlab-team/crawlab/core/interfaces"
 	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
 	"github.com/crawlab-team/crawlab/core/models/service"
 	nodeconfig "github.com/crawlab-team/crawlab/core/node/config"
+	"github.com/crawlab-team/crawlab/core/result"
 	"github.com/crawlab-team/crawlab/core/task/log"
 	"github.com/crawlab-team/crawlab/core/utils"
 	"github.com/crawlab-team/crawlab/db/mongo"
 	"github.com/crawlab-team/crawlab/trace"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"sync"
 	"time"
 )
 
 type databaseServiceItem struct {
 	taskId    primitive.ObjectID
+	spiderId  primitive.ObjectID
 	dbId      primitive.ObjectID
 	dbSvc     interfaces2.DatabaseService
 	tableName string
+	dc        *models2.DataCollectionV2 // data collection with dedup settings, nil if not found
+	sink      *resultSink               // result sink of spider data source, nil for built-in mongo
+	sinks     []*resultSink             // additional result sinks
+	lineage   *lineage                  // lineage of results, nil if disabled
+	transform *result.Transformer       // transforms of results declared by spider, nil if none
 	time      time.Time
 }
 
+// allSinks returns the result sink of spider data source and additional result sinks
+func (item *databaseServiceItem) allSinks() (sinks []*resultSink) {
+	if item.sink != nil {
+		sinks = append(sinks, item.sink)
+	}
+	return append(sinks, item.sinks...)
+}
+
+// hasPendingSinks returns whether any result sink has buffered results not written yet
+func (item *databaseServiceItem) hasPendingSinks() bool {
+	for _, sink := range item.allSinks() {
+		if sink.Len() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupEnabled returns whether results should be deduplicated by hash of dedup keys
+func (item *databaseServiceItem) dedupEnabled() bool {
+	return item.dc != nil && item.dc.Dedup.Enabled && len(item.dc.Dedup.Keys) > 0
+}
+
+// validationEnabled returns whether results should be validated against data fields
+func (item *databaseServiceItem) validationEnabled() bool {
+	return item.dc != nil && item.dc.Validation.Enabled && len(item.dc.Fields) > 0
+}
+
+// resultCounts counts results of an insertion
+type resultCounts struct {
+	new      int // inserted
+	updated  int // existing results overwritten
+	skipped  int // duplicate results ignored
+	rejected int // invalid results rejected or quarantined
+}
+
+type logSeqItem struct {
+	seq  int64 // sequence number of last inserted log entry
+	time time.Time
+}
+
 type ServiceV2 struct {
 	// dependencies
 	nodeCfgSvc interfaces.NodeConfigService
@@ -35,15 +90,20 @@ type ServiceV2 struct {
 	databaseServiceItems map[string]*databaseServiceItem
 	databaseServiceTll   time.Duration
 	logDriver            log.Driver
+	logMu                sync.Mutex
+	logSeqItems          map[string]*logSeqItem
+	hashIndexes          sync.Map // collection names with ensured unique hash index
+	lineageIndexes       sync.Map // collection names with ensured lineage indexes
 }
 
 func (svc *ServiceV2) Init() (err error) {
 	go svc.cleanup()
+	go svc.flushSinks()
 	return nil
 }
 
 func (svc *ServiceV2) InsertData(taskId primitive.ObjectID, records ...map[string]interface{}) (err error) {
-	count := 0
+	var counts resultCounts
 
 	item, err := svc.getDatabaseServiceItem(taskId)
 	if err != nil {
@@ -52,13 +112,72 @@ func (svc *ServiceV2) InsertData(taskId primitive.ObjectID, records ...map[strin
 	dbId := item.dbId
 	dbSvc := item.dbSvc
 	tableName := item.tableName
+
+	// transform records
+	if item.transform != nil {
+		for _, record := range records {
+			if errs := item.transform.Transform(record); len(errs) > 0 {
+				log2.Warnf("[TaskStatsServiceV2] failed to transform data of task %s: %v", taskId.Hex(), errs[0])
+			}
+		}
+	}
+
+	// validate records
+	if item.validationEnabled() {
+		records, counts.rejected = svc.validateRecords(item, records)
+	}
+
+	// stamp records with lineage
+	if item.lineage != nil {
+		item.lineage.Stamp(records, time.Now())
+		if item.sink == nil && dbSvc == nil {
+			svc.ensureLineageIndexes(mongo.GetMongoCol(tableName), item.lineage)
+		}
+	}
+
+	// additional result sinks
+	for _, sink := range item.sinks {
+		if _, err := sink.Add(records...); err != nil {
+			log2.Errorf("failed to insert data into result sink of data source %s: %v", sink.dsId.Hex(), err)
+		}
+	}
+
 	if utils.IsPro() && dbSvc != nil {
 		for _, record := range records {
 			if err := dbSvc.CreateRow(dbId, "", tableName, record); err != nil {
 				log2.Errorf("failed to insert data: %v", err)
 				continue
 			}
-			count++
+			counts.new++
+		}
+	} else if len(records) == 0 {
+		// all records rejected
+	} else if item.sink != nil {
+		// data sources only support inserts, so results are deduplicated within the batch only,
+		// with the hash of dedup keys kept on results for deduplication by the data source
+		if item.dedupEnabled() {
+			var dedupCounts resultCounts
+			records, dedupCounts, err = dedupRecords(records, item.dc.Dedup.Keys, item.dc.Dedup.Type)
+			if err != nil {
+				log2.Errorf("failed to insert data: %v", err)
+				return err
+			}
+			counts.updated = dedupCounts.updated
+			counts.skipped = dedupCounts.skipped
+		}
+
+		// results are counted when flushed
+		n, err := item.sink.Add(records...)
+		if err != nil {
+			log2.Errorf("failed to insert data: %v", err)
+			return err
+		}
+		counts.new = n
+	} else if item.dedupEnabled() {
+		counts, err = svc.insertDataWithDedup(item, records)
+		if err != nil {
+			log2.Errorf("failed to insert data: %v", err)
+			return err
 		}
 	} else {
 		var records2 []interface{}
@@ -70,16 +189,226 @@ func (svc *ServiceV2) InsertData(taskId primitive.ObjectID, records ...map[strin
 			log2.Errorf("failed to insert data: %v", err)
 			return err
 		}
-		count = len(records)
+		counts.new = len(records)
 	}
 
-	go svc.updateTaskStats(taskId, count)
+	go svc.updateTaskStats(taskId, counts)
 
 	return nil
 }
 
+// validateRecords validates records against the data fields of the data collection, and returns
+// valid records with coerced values. Invalid records are quarantined if configured.
+func (svc *ServiceV2) validateRecords(item *databaseServiceItem, records []map[string]interface{}) (res []map[string]interface{}, rejected int) {
+	var quarantines []models2.ResultQuarantineV2
+	for _, record := range records {
+		err := validateRecord(record, item.dc.Fields)
+		if err == nil {
+			res = append(res, record)
+			continue
+		}
+		rejected++
+		if item.dc.Validation.Action == constants.ValidationActionQuarantine {
+			q := models2.ResultQuarantineV2{
+				TaskId:   item.taskId,
+				SpiderId: item.spiderId,
+				ColName:  item.tableName,
+				Record:   record,
+				Error:    err.Error(),
+			}
+			q.SetCreatedAt(time.Now())
+			quarantines = append(quarantines, q)
+		}
+	}
+	if len(quarantines) > 0 {
+		if _, err := service.NewModelServiceV2[models2.ResultQuarantineV2]().InsertMany(quarantines); err != nil {
+			log2.Errorf("failed to quarantine invalid data: %v", err)
+		}
+	}
+	return res, rejected
+}
+
+// insertDataWithDedup hashes the dedup keys of each record into constants.HashKey and upserts
+// records by hash, either ignoring or overwriting existing ones according to the dedup type
+func (svc *ServiceV2) insertDataWithDedup(item *databaseServiceItem, records []map[string]interface{}) (counts resultCounts, err error) {
+	dedup := item.dc.Dedup
+
+	// hash records and drop duplicates within the batch
+	records, counts, err = dedupRecords(records, dedup.Keys, dedup.Type)
+	if err != nil {
+		return counts, err
+	}
+	if len(records) == 0 {
+		return counts, nil
+	}
+
+	// unique index on hash
+	col := mongo.GetMongoCol(item.tableName)
+	svc.ensureHashIndex(col)
+
+	// upsert by hash
+	var models []mongo2.WriteModel
+	for _, record := range records {
+		query := bson.M{constants.HashKey: record[constants.HashKey]}
+		switch dedup.Type {
+		case constants.DedupTypeOverwrite:
+			models = append(models, mongo2.NewReplaceOneModel().SetFilter(query).SetReplacement(record).SetUpsert(true))
+		default:
+			models = append(models, mongo2.NewUpdateOneModel().SetFilter(query).SetUpdate(bson.M{"$setOnInsert": record}).SetUpsert(true))
+		}
+	}
+	res, err := col.GetCollection().BulkWrite(col.GetContext(), models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		// records upserted concurrently by another task are counted as skipped
+		var bwe mongo2.BulkWriteException
+		if !errors.As(err, &bwe) || !mongo2.IsDuplicateKeyError(err) || bwe.WriteConcernError != nil {
+			return counts, trace.TraceError(err)
+		}
+		counts.skipped += len(bwe.WriteErrors)
+	}
+	if res != nil {
+		counts.new += int(res.UpsertedCount)
+		switch dedup.Type {
+		case constants.DedupTypeOverwrite:
+			counts.updated += int(res.MatchedCount)
+		default:
+			counts.skipped += int(res.MatchedCount)
+		}
+	}
+
+	return counts, nil
+}
+
+// ensureHashIndex creates a unique index on constants.HashKey of a collection once
+func (svc *ServiceV2) ensureHashIndex(col *mongo.Col) {
+	if _, ok := svc.hashIndexes.LoadOrStore(col.GetName(), true); ok {
+		return
+	}
+	if err := col.CreateIndex(mongo2.IndexModel{
+		Keys:    bson.D{{constants.HashKey, 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		// e.g. existing duplicates or a non-unique index on the hash key
+		log2.Warnf("[TaskStatsServiceV2] failed to create unique hash index on %s: %v", col.GetName(), err)
+	}
+}
+
+// dedupRecords sets the hash of dedup keys on each record and removes duplicates within the
+// records, keeping the first for dedup type "ignore" and the last for "overwrite"
+func dedupRecords(records []map[string]interface{}, keys []string, dedupType string) (res []map[string]interface{}, counts resultCounts, err error) {
+	idx := map[string]int{}
+	for _, record := range records {
+		hash, err := utils.GetResultHash(record, keys)
+		if err != nil {
+			return nil, counts, err
+		}
+		record[constants.HashKey] = hash
+		i, ok := idx[hash]
+		if !ok {
+			idx[hash] = len(res)
+			res = append(res, record)
+			continue
+		}
+		switch dedupType {
+		case constants.DedupTypeOverwrite:
+			res[i] = record
+			counts.updated++
+		default:
+			counts.skipped++
+		}
+	}
+	return res, counts, nil
+}
+
 func (svc *ServiceV2) InsertLogs(id primitive.ObjectID, logs ...string) (err error) {
-	return svc.logDriver.WriteLines(id.Hex(), logs)
+	if err := svc.logDriver.WriteLines(id.Hex(), logs); err != nil {
+		return err
+	}
+
+	// publish to live subscribers
+	ts := time.Now()
+	var lines []log.Line
+	for _, l := range logs {
+		lines = append(lines, log.Line{TaskId: id.Hex(), Msg: l, Ts: ts})
+	}
+	log.GetLogBroker().Publish(id.Hex(), lines...)
+
+	return nil
+}
+
+// InsertLogEntries inserts log entries of a task, skipping entries already inserted, which
+// are re-sent by runners when log shipping is retried after failure
+func (svc *ServiceV2) InsertLogEntries(id primitive.ObjectID, entries ...entity.TaskLogEntry) (err error) {
+	// atomic operation
+	svc.logMu.Lock()
+	defer svc.logMu.Unlock()
+
+	// last sequence number
+	item, err := svc.getLogSeqItem(id)
+	if err != nil {
+		return err
+	}
+
+	// skip duplicates
+	var newEntries []entity.TaskLogEntry
+	seq := item.seq
+	for _, e := range entries {
+		if e.Seq > 0 && e.Seq <= seq {
+			continue
+		}
+		newEntries = append(newEntries, e)
+		if e.Seq > seq {
+			seq = e.Seq
+		}
+	}
+	if len(newEntries) == 0 {
+		return nil
+	}
+
+	// insert
+	if err := svc.logDriver.WriteEntries(id.Hex(), newEntries); err != nil {
+		// reset cached sequence number so that it is fetched again next time
+		delete(svc.logSeqItems, id.Hex())
+		return err
+	}
+	item.seq = seq
+	item.time = time.Now()
+
+	// publish to live subscribers
+	var lines []log.Line
+	for _, e := range newEntries {
+		lines = append(lines, log.Line{
+			TaskId: id.Hex(),
+			Stream: e.Stream,
+			Level:  e.Level,
+			Seq:    e.Seq,
+			Msg:    e.Msg,
+			Ts:     e.Ts,
+		})
+	}
+	log.GetLogBroker().Publish(id.Hex(), lines...)
+
+	return nil
+}
+
+func (svc *ServiceV2) getLogSeqItem(id primitive.ObjectID) (item *logSeqItem, err error) {
+	// attempt to get from cache
+	item, ok := svc.logSeqItems[id.Hex()]
+	if ok {
+		return item, nil
+	}
+
+	// sequence number of last log line
+	seq, err := svc.logDriver.GetLastSeq(id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	item = &logSeqItem{seq: seq, time: time.Now()}
+
+	// store in cache
+	svc.logSeqItems[id.Hex()] = item
+
+	return item, nil
 }
 
 func (svc *ServiceV2) getDatabaseServiceItem(taskId primitive.ObjectID) (item *databaseServiceItem, err error) {
@@ -118,22 +447,158 @@ func (svc *ServiceV2) getDatabaseServiceItem(taskId primitive.ObjectID) (item *d
 		}
 	}
 
+	// data collection
+	dc, err := svc.getDataCollection(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// result sinks
+	var sink *resultSink
+	if dbSvc == nil && !s.DataSourceId.IsZero() {
+		sink, err = svc.newResultSink(s.DataSourceId, s.ColName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var sinks []*resultSink
+	for _, dsId := range s.SinkDataSourceIds {
+		if dsId == s.DataSourceId {
+			continue
+		}
+		sink, err := svc.newResultSink(dsId, s.ColName)
+		if err != nil {
+			log2.Errorf("failed to create result sink of data source %s: %v", dsId.Hex(), err)
+			continue
+		}
+		if sink != nil {
+			sinks = append(sinks, sink)
+		}
+	}
+
+	// result transforms
+	var transformer *result.Transformer
+	if len(s.Transforms) > 0 {
+		transformer, err = result.NewTransformer(s.Transforms)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid result transforms of spider %s: %v", s.Id.Hex(), err))
+		}
+	}
+
+	// node key of lineage
+	var nodeKey string
+	if !t.NodeId.IsZero() {
+		n, err := service.NewModelServiceV2[models2.NodeV2]().GetById(t.NodeId)
+		if err != nil {
+			log2.Warnf("[TaskStatsServiceV2] failed to get node %s: %v", t.NodeId.Hex(), err)
+		} else {
+			nodeKey = n.Key
+		}
+	}
+
 	// store in cache
-	svc.databaseServiceItems[taskId.Hex()] = &databaseServiceItem{
+	item = &databaseServiceItem{
 		taskId:    taskId,
+		spiderId:  s.Id,
 		dbId:      s.DataSourceId,
 		dbSvc:     dbSvc,
 		tableName: s.ColName,
+		dc:        dc,
+		sink:      sink,
+		sinks:     sinks,
+		lineage:   newLineage(t, dc, nodeKey),
+		transform: transformer,
 		time:      time.Now(),
 	}
+	svc.databaseServiceItems[taskId.Hex()] = item
 
 	return item, nil
 }
 
-func (svc *ServiceV2) updateTaskStats(id primitive.ObjectID, resultCount int) {
+// newResultSink creates a result sink writing to a data source, or returns nil if the data source
+// does not exist so that results are written to built-in mongo
+func (svc *ServiceV2) newResultSink(dsId primitive.ObjectID, colName string) (sink *resultSink, err error) {
+	ds, err := service.NewModelServiceV2[models2.DatabaseV2]().GetById(dsId)
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			log2.Warnf("[TaskStatsServiceV2] data source %s not found", dsId.Hex())
+			return nil, nil
+		}
+		return nil, err
+	}
+	rs, err := ds2.GetSinkRegistry().NewSink(ds, colName)
+	if err != nil {
+		return nil, err
+	}
+	return newResultSink(dsId, rs), nil
+}
+
+// flushSink flushes a result sink of a task, and counts flushed results if it is the result
+// sink of spider data source
+func (svc *ServiceV2) flushSink(item *databaseServiceItem, sink *resultSink, close bool) {
+	var n int
+	var err error
+	if close {
+		n, err = sink.Close()
+	} else {
+		n, err = sink.Flush()
+	}
+	if err != nil {
+		log2.Errorf("failed to insert data into result sink of data source %s: %v", sink.dsId.Hex(), err)
+	}
+	if n > 0 && sink == item.sink {
+		svc.updateTaskStats(item.taskId, resultCounts{new: n})
+	}
+}
+
+// flushSinks periodically flushes result sinks of all tasks
+func (svc *ServiceV2) flushSinks() {
+	for {
+		time.Sleep(time.Second)
+
+		svc.mu.Lock()
+		var items []*databaseServiceItem
+		for _, item := range svc.databaseServiceItems {
+			items = append(items, item)
+		}
+		svc.mu.Unlock()
+
+		for _, item := range items {
+			for _, sink := range item.allSinks() {
+				svc.flushSink(item, sink, false)
+			}
+		}
+	}
+}
+
+// getDataCollection returns the data collection of a spider by id (compatible to old version)
+// or by name, or nil if it does not exist
+func (svc *ServiceV2) getDataCollection(s *models2.SpiderV2) (dc *models2.DataCollectionV2, err error) {
+	modelSvc := service.NewModelServiceV2[models2.DataCollectionV2]()
+	if !s.ColId.IsZero() {
+		dc, err = modelSvc.GetById(s.ColId)
+	} else if s.ColName != "" {
+		dc, err = modelSvc.GetOne(bson.M{"name": s.ColName}, nil)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dc, nil
+}
+
+func (svc *ServiceV2) updateTaskStats(id primitive.ObjectID, counts resultCounts) {
 	err := service.NewModelServiceV2[models2.TaskStatV2]().UpdateById(id, bson.M{
 		"$inc": bson.M{
-			"result_count": resultCount,
+			"result_count":          counts.new + counts.updated,
+			"result_new_count":      counts.new,
+			"result_updated_count":  counts.updated,
+			"result_skipped_count":  counts.skipped,
+			"result_rejected_count": counts.rejected,
 		},
 	})
 	if err != nil {
@@ -146,14 +611,36 @@ func (svc *ServiceV2) cleanup() {
 		// atomic operation
 		svc.mu.Lock()
 
+		var expiredItems []*databaseServiceItem
 		for k, v := range svc.databaseServiceItems {
-			if time.Now().After(v.time.Add(svc.databaseServiceTll)) {
-				delete(svc.databaseServiceItems, k)
+			if !time.Now().After(v.time.Add(svc.databaseServiceTll)) {
+				continue
+			}
+			// keep items with results failed to write, which are retried by flushSinks
+			if v.hasPendingSinks() {
+				continue
 			}
+			delete(svc.databaseServiceItems, k)
+			expiredItems = append(expiredItems, v)
 		}
 
 		svc.mu.Unlock()
 
+		// flush and close result sinks of expired items
+		for _, item := range expiredItems {
+			for _, sink := range item.allSinks() {
+				svc.flushSink(item, sink, true)
+			}
+		}
+
+		svc.logMu.Lock()
+		for k, v := range svc.logSeqItems {
+			if time.Now().After(v.time.Add(svc.databaseServiceTll)) {
+				delete(svc.logSeqItems, k)
+			}
+		}
+		svc.logMu.Unlock()
+
 		time.Sleep(10 * time.Minute)
 	}
 }
@@ -164,12 +651,13 @@ func NewTaskStatsServiceV2() (svc2 *ServiceV2, err error) {
 		mu:                   sync.Mutex{},
 		databaseServiceItems: map[string]*databaseServiceItem{},
 		databaseServiceTll:   10 * time.Minute,
+		logSeqItems:          map[string]*logSeqItem{},
 	}
 
 	svc.nodeCfgSvc = nodeconfig.GetNodeConfigService()
 
 	// log driver
-	svc.logDriver, err = log.GetLogDriver(log.DriverTypeFile)
+	svc.logDriver, err = log.GetDefaultLogDriver()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupRecords(t *testing.T) {
+	newRecords := func() []map[string]interface{} {
+		return []map[string]interface{}{
+			{"url": "a", "title": "1"},
+			{"url": "b", "title": "2"},
+			{"url": "a", "title": "3"},
+		}
+	}
+
+	// ignore keeps the first duplicate
+	res, counts, err := dedupRecords(newRecords(), []string{"url"}, constants.DedupTypeIgnore)
+	require.Nil(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, "1", res[0]["title"])
+	require.Equal(t, resultCounts{skipped: 1}, counts)
+	require.NotEmpty(t, res[0][constants.HashKey])
+	require.NotEqual(t, res[0][constants.HashKey], res[1][constants.HashKey])
+
+	// overwrite keeps the last duplicate
+	res, counts, err = dedupRecords(newRecords(), []string{"url"}, constants.DedupTypeOverwrite)
+	require.Nil(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, "3", res[0]["title"])
+	require.Equal(t, resultCounts{updated: 1}, counts)
+
+	// hash of multiple keys
+	res, counts, err = dedupRecords(newRecords(), []string{"url", "title"}, constants.DedupTypeIgnore)
+	require.Nil(t, err)
+	require.Len(t, res, 3)
+	require.Equal(t, resultCounts{}, counts)
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"maps"
+	"sync"
+)
+
+// resultSink buffers results of a task and writes them in batches to a result service. Batches
+// failed to write are kept in buffer and retried on next flush, until the buffer is full.
+type resultSink struct {
+	// settings
+	dsId          primitive.ObjectID
+	batchSize     int
+	maxBufferSize int
+
+	// internals
+	svc interfaces.ResultService
+	mu  sync.Mutex
+	buf []interface{}
+}
+
+// Add appends records to buffer, and flushes it if batch size is reached
+func (s *resultSink) Add(records ...map[string]interface{}) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range records {
+		// each sink gets its own copy as result services may modify records
+		s.buf = append(s.buf, entity.Result(maps.Clone(record)))
+	}
+	if len(s.buf) < s.batchSize {
+		return 0, nil
+	}
+	return s.flush()
+}
+
+// Flush writes buffered records and returns the number of records written
+func (s *resultSink) Flush() (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// Len returns the number of buffered records
+func (s *resultSink) Len() (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buf)
+}
+
+// Close flushes buffered records and closes the result service if closable
+func (s *resultSink) Close() (n int, err error) {
+	n, err = s.Flush()
+	if c, ok := s.svc.(io.Closer); ok {
+		_ = c.Close()
+	}
+	return n, err
+}
+
+func (s *resultSink) flush() (n int, err error) {
+	if len(s.buf) == 0 {
+		return 0, nil
+	}
+	if err := s.svc.Insert(s.buf...); err != nil {
+		if len(s.buf) > s.maxBufferSize {
+			log.Errorf("[TaskStatsServiceV2] result sink buffer of data source %s is full, dropped %d results", s.dsId.Hex(), len(s.buf)-s.maxBufferSize)
+			s.buf = s.buf[len(s.buf)-s.maxBufferSize:]
+		}
+		return 0, err
+	}
+	n = len(s.buf)
+	s.buf = nil
+	return n, nil
+}
+
+func newResultSink(dsId primitive.ObjectID, svc interfaces.ResultService) (s *resultSink) {
+	return &resultSink{
+		dsId:          dsId,
+		batchSize:     100,
+		maxBufferSize: 10000,
+		svc:           svc,
+	}
+}
@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/db/generic"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testResultService struct {
+	records []interface{}
+	err     error
+}
+
+func (svc *testResultService) Insert(records ...interface{}) (err error) {
+	if svc.err != nil {
+		return svc.err
+	}
+	svc.records = append(svc.records, records...)
+	return nil
+}
+
+func (svc *testResultService) List(query generic.ListQuery, opts *generic.ListOptions) (results []interface{}, err error) {
+	return nil, nil
+}
+
+func (svc *testResultService) Count(query generic.ListQuery) (n int, err error) {
+	return 0, nil
+}
+
+func (svc *testResultService) Index(fields []string) {}
+
+func (svc *testResultService) SetTime(t time.Time) {}
+
+func (svc *testResultService) GetTime() (t time.Time) {
+	return t
+}
+
+func TestResultSink_Batch(t *testing.T) {
+	rs := &testResultService{}
+	s := newResultSink(primitive.NewObjectID(), rs)
+	s.batchSize = 2
+
+	// buffered until batch size is reached
+	record := map[string]interface{}{"title": "a"}
+	n, err := s.Add(record)
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+	require.Len(t, rs.records, 0)
+
+	n, err = s.Add(map[string]interface{}{"title": "b"})
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+	require.Len(t, rs.records, 2)
+
+	// records are copied
+	rs.records[0].(entity.Result)["title"] = "c"
+	require.Equal(t, "a", record["title"])
+
+	// flush remaining records
+	_, _ = s.Add(map[string]interface{}{"title": "d"})
+	n, err = s.Flush()
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+	require.Len(t, rs.records, 3)
+}
+
+func TestResultSink_Retry(t *testing.T) {
+	rs := &testResultService{err: errors.New("unavailable")}
+	s := newResultSink(primitive.NewObjectID(), rs)
+	s.batchSize = 2
+	s.maxBufferSize = 3
+
+	// failed batches are kept up to max buffer size
+	for i := 0; i < 4; i++ {
+		_, _ = s.Add(map[string]interface{}{"i": i})
+	}
+	n, err := s.Flush()
+	require.NotNil(t, err)
+	require.Equal(t, 0, n)
+	require.Len(t, s.buf, 3)
+
+	// items with pending results are not expired
+	item := &databaseServiceItem{sink: s}
+	require.True(t, item.hasPendingSinks())
+
+	// retried on next flush
+	rs.err = nil
+	n, err = s.Flush()
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, 1, rs.records[0].(entity.Result)["i"])
+	require.Equal(t, 0, s.Len())
+	require.False(t, item.hasPendingSinks())
+}
@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are layouts of date strings accepted by date fields
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// validateRecord validates a record against declared data fields and coerces field values to
+// their declared types in place. Missing or null fields are not validated.
+func validateRecord(record map[string]interface{}, fields []entity.DataField) (err error) {
+	var errs []error
+	for _, f := range fields {
+		v, ok := record[f.Key]
+		if !ok || v == nil {
+			continue
+		}
+		res, err := coerceValue(v, f.Type)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %s: %v", f.Key, err))
+			continue
+		}
+		record[f.Key] = res
+	}
+	return errors.Join(errs...)
+}
+
+// coerceValue converts a value to the given data field type
+func coerceValue(v interface{}, fieldType string) (res interface{}, err error) {
+	switch fieldType {
+	case constants.DataFieldTypeNumeric:
+		return coerceNumber(v, "")
+	case constants.DataFieldTypeCurrency:
+		return coerceNumber(v, "$€£¥₹ ,")
+	case constants.DataFieldTypeDate:
+		return coerceDate(v)
+	case constants.DataFieldTypeUrl, constants.DataFieldTypeImage, constants.DataFieldTypeAudio, constants.DataFieldTypeVideo:
+		return coerceUrl(v)
+	default:
+		return v, nil
+	}
+}
+
+// coerceNumber converts a value to int64 or float64, removing cutset characters from strings
+func coerceNumber(v interface{}, cutset string) (res interface{}, err error) {
+	switch v := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		// json numbers
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	case string:
+		s := strings.TrimSpace(v)
+		for _, c := range cutset {
+			s = strings.ReplaceAll(s, string(c), "")
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n, nil
+		}
+		return nil, fmt.Errorf("cannot convert %q to number", v)
+	default:
+		return nil, fmt.Errorf("cannot convert %T to number", v)
+	}
+}
+
+// coerceDate converts a value to time, from date strings or unix timestamps in seconds or
+// milliseconds
+func coerceDate(v interface{}) (res interface{}, err error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return unixToTime(n), nil
+		}
+		return nil, fmt.Errorf("cannot convert %q to date", v)
+	default:
+		n, err := coerceNumber(v, "")
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %T to date", v)
+		}
+		f, _ := strconv.ParseFloat(fmt.Sprint(n), 64)
+		return unixToTime(int64(f)), nil
+	}
+}
+
+// unixToTime converts a unix timestamp in seconds or milliseconds to time
+func unixToTime(n int64) (t time.Time) {
+	if n > 1e11 || n < -1e11 {
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n, 0)
+}
+
+// coerceUrl validates a value as an absolute or protocol-relative url
+func coerceUrl(v interface{}) (res interface{}, err error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %T to url", v)
+	}
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", v)
+	}
+	return s, nil
+}
@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRecord(t *testing.T) {
+	fields := []entity.DataField{
+		{Key: "title", Type: constants.DataFieldTypeGeneral},
+		{Key: "count", Type: constants.DataFieldTypeNumeric},
+		{Key: "price", Type: constants.DataFieldTypeCurrency},
+		{Key: "date", Type: constants.DataFieldTypeDate},
+		{Key: "url", Type: constants.DataFieldTypeUrl},
+	}
+
+	// coercion
+	record := map[string]interface{}{
+		"title": "a",
+		"count": " 12 ",
+		"price": "$1,234.50",
+		"date":  "2024-03-01 08:00:00",
+		"url":   "https://crawlab.cn/docs",
+	}
+	require.Nil(t, validateRecord(record, fields))
+	require.Equal(t, int64(12), record["count"])
+	require.Equal(t, 1234.5, record["price"])
+	require.Equal(t, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), record["date"])
+
+	// json numbers and unix timestamps
+	record = map[string]interface{}{"count": float64(3), "date": float64(1709280000000)}
+	require.Nil(t, validateRecord(record, fields))
+	require.Equal(t, int64(3), record["count"])
+	require.Equal(t, time.UnixMilli(1709280000000), record["date"])
+
+	// missing and null fields are not validated
+	require.Nil(t, validateRecord(map[string]interface{}{"count": nil}, fields))
+
+	// invalid fields
+	record = map[string]interface{}{"count": "n/a", "date": "yesterday", "url": "/relative"}
+	err := validateRecord(record, fields)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "field count")
+	require.Contains(t, err.Error(), "field date")
+	require.Contains(t, err.Error(), "field url")
+}
@@ -0,0 +1,469 @@
+package trigger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/event"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/node/leader"
+	"github.com/crawlab-team/crawlab/core/spider/admin"
+	"github.com/crawlab-team/crawlab/core/task/scheduler"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ServiceV2 fires triggers on task status updated by runners, inbound webhooks and new files in
+// watched workspace directories, creating tasks of their spiders. Tasks created by triggers carry
+// the depth of the trigger chain, and triggers are not fired by tasks at max depth so that
+// triggers running each other do not loop forever.
+type ServiceV2 struct {
+	// dependencies
+	modelSvc    *service.ModelServiceV2[models2.TriggerV2]
+	runModelSvc *service.ModelServiceV2[models2.TriggerRunV2]
+	adminSvc    *admin.ServiceV2
+
+	// settings
+	maxDepth     int           // max depth of trigger chains
+	fileInterval time.Duration // interval of scanning watched directories
+	fileDelay    time.Duration // min age of new files, so that files being written are not picked up
+
+	// internals
+	ch      chan interfaces.EventData
+	stopped bool
+}
+
+// triggerEvent is an event firing a trigger
+type triggerEvent struct {
+	key          string // unique key of event per trigger, empty for webhooks
+	depth        int
+	sourceTaskId primitive.ObjectID
+	file         string
+	vars         map[string]string // placeholders of param
+}
+
+func (svc *ServiceV2) Start() {
+	// task status updated by runners
+	event.NewEventService().Register("trigger", "^"+constants.TaskEventStatusUpdated+"$", "^$", &svc.ch)
+	go func() {
+		for d := range svc.ch {
+			taskId, ok := d.GetData().(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			if err := svc.OnTaskStatus(taskId); err != nil {
+				trace.PrintError(err)
+			}
+		}
+	}()
+
+	// new files
+	for {
+		if svc.stopped {
+			return
+		}
+
+		if leader.IsLeader() {
+			svc.scanFiles()
+		}
+
+		time.Sleep(svc.fileInterval)
+	}
+}
+
+func (svc *ServiceV2) Stop() {
+	svc.stopped = true
+	event.NewEventService().Unregister("trigger")
+}
+
+// OnTaskStatus fires task triggers of the spider of a task with the updated status. Sub-tasks of
+// multi-node runs fire with the status of their parent once all of them have ended, and tasks to
+// be retried do not fire.
+func (svc *ServiceV2) OnTaskStatus(taskId primitive.ObjectID) (err error) {
+	t, err := service.NewModelServiceV2[models2.TaskV2]().GetById(taskId)
+	if err != nil {
+		return err
+	}
+
+	// parent task of sub-task
+	if !t.ParentId.IsZero() {
+		parent, err := service.NewModelServiceV2[models2.TaskV2]().GetById(t.ParentId)
+		if err != nil {
+			return err
+		}
+		if parent.HasSub {
+			subTasks, err := service.NewModelServiceV2[models2.TaskV2]().GetMany(bson.M{"parent_id": parent.Id}, nil)
+			if err != nil {
+				return err
+			}
+			subTasks, err = scheduler.GetLastAttempts(subTasks)
+			if err != nil {
+				return err
+			}
+			parent.Status = scheduler.GetParentTaskStatus(subTasks)
+			if parent.Status == constants.TaskStatusPending || parent.Status == constants.TaskStatusRunning {
+				return nil
+			}
+			t = parent
+		}
+	}
+
+	// skip if the task is going to be retried
+	if ok, err := scheduler.IsPendingRetry(t); err != nil || ok {
+		return err
+	}
+
+	// triggers
+	triggers, err := svc.modelSvc.GetMany(bson.M{
+		"type":             constants.TriggerTypeTask,
+		"enabled":          true,
+		"source_spider_id": t.SpiderId,
+	}, nil)
+	if err != nil {
+		if errors.Is(err, mongo2.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+	for _, tr := range triggers {
+		if !slices.Contains(getSourceStatuses(tr), t.Status) {
+			continue
+		}
+		if _, err := svc.fire(&tr, &triggerEvent{
+			key:          fmt.Sprintf("task:%s:%s", t.Id.Hex(), t.Status),
+			depth:        t.TriggerDepth + 1,
+			sourceTaskId: t.Id,
+			vars: map[string]string{
+				"task_id":     t.Id.Hex(),
+				"task_status": t.Status,
+				"spider_id":   t.SpiderId.Hex(),
+			},
+		}); err != nil {
+			trace.PrintError(err)
+		}
+	}
+	return nil
+}
+
+// Webhook fires a webhook trigger with the json payload of the request, which is mapped to
+// placeholders of param as "payload" for the raw payload and "payload.<field>" for its fields
+func (svc *ServiceV2) Webhook(tr *models2.TriggerV2, payload []byte) (run *models2.TriggerRunV2, err error) {
+	vars := map[string]string{}
+	if len(payload) > 0 {
+		vars["payload"] = string(payload)
+		var data interface{}
+		if err := json.Unmarshal(payload, &data); err == nil {
+			flattenPayload("payload", data, vars)
+		}
+	}
+	return svc.fire(tr, &triggerEvent{vars: vars})
+}
+
+// scanFiles fires file triggers with new files in their watched directories. Files modified
+// before a trigger was created are not new to it.
+func (svc *ServiceV2) scanFiles() {
+	triggers, err := svc.modelSvc.GetMany(bson.M{
+		"type":    constants.TriggerTypeFile,
+		"enabled": true,
+	}, nil)
+	if err != nil {
+		if !errors.Is(err, mongo2.ErrNoDocuments) {
+			trace.PrintError(err)
+		}
+		return
+	}
+	for _, tr := range triggers {
+		if err := svc.scanTriggerFiles(&tr); err != nil {
+			log.Errorf("[TriggerServiceV2] failed to scan files of trigger %s: %v", tr.Id.Hex(), err)
+		}
+	}
+}
+
+func (svc *ServiceV2) scanTriggerFiles(tr *models2.TriggerV2) (err error) {
+	workspacePath := viper.GetString("workspace")
+	dirPath, err := GetWatchDir(workspacePath, tr.WatchPath)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return trace.TraceError(err)
+	}
+
+	// new files
+	files := map[string]string{} // event keys to file paths
+	for _, e := range entries {
+		if e.IsDir() || !matchWatchPattern(tr.WatchPattern, e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(tr.GetCreatedAt()) || time.Since(info.ModTime()) < svc.fileDelay {
+			continue
+		}
+		relPath := filepath.ToSlash(filepath.Join(tr.WatchPath, e.Name()))
+		files["file:"+relPath] = filepath.Join(dirPath, e.Name())
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	// skip files which have fired the trigger
+	var keys []string
+	for key := range files {
+		keys = append(keys, key)
+	}
+	runs, err := svc.runModelSvc.GetMany(bson.M{
+		"trigger_id": tr.Id,
+		"key":        bson.M{"$in": keys},
+	}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return err
+	}
+	for _, run := range runs {
+		delete(files, run.Key)
+	}
+
+	slices.Sort(keys)
+	for _, key := range keys {
+		filePath, ok := files[key]
+		if !ok {
+			continue
+		}
+		if _, err := svc.fire(tr, &triggerEvent{
+			key:  key,
+			file: filePath,
+			vars: map[string]string{
+				"file":      filePath,
+				"file_name": filepath.Base(filePath),
+			},
+		}); err != nil {
+			trace.PrintError(err)
+		}
+	}
+	return nil
+}
+
+// fire creates tasks of the spider of a trigger for an event, and records the fire event. An
+// event with a key fires a trigger once, and nil is returned if it has fired already.
+func (svc *ServiceV2) fire(tr *models2.TriggerV2, e *triggerEvent) (run *models2.TriggerRunV2, err error) {
+	run = &models2.TriggerRunV2{
+		TriggerId:    tr.Id,
+		SpiderId:     tr.SpiderId,
+		Type:         tr.Type,
+		Key:          e.key,
+		SourceTaskId: e.sourceTaskId,
+		File:         e.file,
+		Depth:        e.depth,
+		Status:       constants.TriggerRunStatusFired,
+		Ts:           time.Now(),
+	}
+	run.SetCreated(tr.GetCreatedBy())
+	run.SetUpdated(tr.GetCreatedBy())
+
+	// loop protection
+	if e.depth > svc.maxDepth {
+		run.Status = constants.TriggerRunStatusSkipped
+		run.Reason = fmt.Sprintf("max trigger depth %d exceeded", svc.maxDepth)
+		log.Warnf("[TriggerServiceV2] skipped trigger %s: %s", tr.Id.Hex(), run.Reason)
+	}
+
+	// record before scheduling, so that an event with a key fires once
+	run.Id, err = svc.runModelSvc.InsertOne(*run)
+	if err != nil {
+		if mongo2.IsDuplicateKeyError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if run.Status == constants.TriggerRunStatusSkipped {
+		return run, nil
+	}
+
+	// schedule
+	param, err := expandParam(tr.Param, e.vars)
+	if err == nil {
+		opts := &interfaces.SpiderRunOptions{
+			Mode:      tr.Mode,
+			NodeIds:   tr.NodeIds,
+			Cmd:       tr.Cmd,
+			Param:     param,
+			Priority:  tr.Priority,
+			TriggerId: tr.Id,
+			Depth:     e.depth,
+			UserId:    tr.GetCreatedBy(),
+		}
+		run.TaskIds, err = svc.adminSvc.Schedule(tr.SpiderId, opts)
+	}
+	if err != nil {
+		run.Status = constants.TriggerRunStatusError
+		run.Reason = err.Error()
+		log.Errorf("[TriggerServiceV2] failed to fire trigger %s: %v", tr.Id.Hex(), err)
+	} else {
+		log.Infof("[TriggerServiceV2] fired trigger %s (%s), created tasks %v", tr.Id.Hex(), tr.Type, run.TaskIds)
+	}
+	if err := svc.runModelSvc.UpdateById(run.Id, bson.M{"$set": bson.M{
+		"status":   run.Status,
+		"reason":   run.Reason,
+		"task_ids": run.TaskIds,
+	}}); err != nil {
+		trace.PrintError(err)
+	}
+	return run, nil
+}
+
+// ValidateTrigger validates a trigger according to its type
+func ValidateTrigger(tr *models2.TriggerV2) (err error) {
+	if tr.SpiderId.IsZero() {
+		return errors.New("spider is not set")
+	}
+	switch tr.Type {
+	case constants.TriggerTypeTask:
+		if tr.SourceSpiderId.IsZero() {
+			return errors.New("source spider is not set")
+		}
+	case constants.TriggerTypeWebhook:
+	case constants.TriggerTypeFile:
+		if _, err := GetWatchDir(viper.GetString("workspace"), tr.WatchPath); err != nil {
+			return err
+		}
+		if _, err := filepath.Match(tr.WatchPattern, ""); err != nil {
+			return errors.New(fmt.Sprintf("invalid watch pattern: %s", tr.WatchPattern))
+		}
+	default:
+		return errors.New(fmt.Sprintf("invalid trigger type: %s", tr.Type))
+	}
+	return nil
+}
+
+// GetWatchDir returns the directory watched by a file trigger, which must be inside workspace
+func GetWatchDir(workspacePath, watchPath string) (dirPath string, err error) {
+	watchPath = filepath.Clean(filepath.FromSlash(watchPath))
+	if watchPath == "." || filepath.IsAbs(watchPath) || watchPath == ".." || strings.HasPrefix(watchPath, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("invalid watch path: %s", watchPath))
+	}
+	return filepath.Join(workspacePath, watchPath), nil
+}
+
+// GenerateToken returns a random secret token of webhook triggers
+func GenerateToken() (token string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", trace.TraceError(err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func getSourceStatuses(tr models2.TriggerV2) (statuses []string) {
+	if len(tr.SourceStatuses) == 0 {
+		return []string{constants.TaskStatusFinished}
+	}
+	return tr.SourceStatuses
+}
+
+func matchWatchPattern(pattern, name string) (ok bool) {
+	if pattern == "" {
+		return !strings.HasPrefix(name, ".")
+	}
+	ok, _ = filepath.Match(pattern, name)
+	return ok
+}
+
+// expandParam replaces placeholders of param in the form of {{name}} with event variables.
+// Unknown placeholders are kept as they are. As the command of a task is split into arguments on
+// spaces without quoting, an error is returned if a variable replacing a placeholder contains
+// whitespace, which would inject extra arguments.
+func expandParam(param string, vars map[string]string) (res string, err error) {
+	if !strings.Contains(param, "{{") {
+		return param, nil
+	}
+	var oldnew []string
+	for k, v := range vars {
+		placeholder := "{{" + k + "}}"
+		if !strings.Contains(param, placeholder) {
+			continue
+		}
+		if strings.ContainsFunc(v, unicode.IsSpace) {
+			return "", errors.New(fmt.Sprintf("value of placeholder %s contains whitespace", placeholder))
+		}
+		oldnew = append(oldnew, placeholder, v)
+	}
+	return strings.NewReplacer(oldnew...).Replace(param), nil
+}
+
+// flattenPayload maps fields of a json payload to variables named by their paths joined by dots.
+// Values which are not strings are json encoded.
+func flattenPayload(prefix string, data interface{}, vars map[string]string) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for k, v2 := range v {
+			key := prefix + "." + k
+			flattenPayload(key, v2, vars)
+		}
+		if prefix != "payload" {
+			b, _ := json.Marshal(v)
+			vars[prefix] = string(b)
+		}
+	case string:
+		vars[prefix] = v
+	default:
+		b, _ := json.Marshal(v)
+		vars[prefix] = string(b)
+	}
+}
+
+func newTriggerServiceV2() (svc *ServiceV2, err error) {
+	svc = &ServiceV2{
+		modelSvc:     service.NewModelServiceV2[models2.TriggerV2](),
+		runModelSvc:  service.NewModelServiceV2[models2.TriggerRunV2](),
+		maxDepth:     5,
+		fileInterval: 10 * time.Second,
+		fileDelay:    5 * time.Second,
+		ch:           make(chan interfaces.EventData),
+	}
+	if viper.IsSet("trigger.maxDepth") {
+		svc.maxDepth = viper.GetInt("trigger.maxDepth")
+	}
+	if d := viper.GetDuration("trigger.file.interval"); d > 0 {
+		svc.fileInterval = d
+	}
+	svc.adminSvc, err = admin.GetSpiderAdminServiceV2()
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+var triggerSvcV2 *ServiceV2
+var triggerSvcV2Once = new(sync.Once)
+
+func GetTriggerServiceV2() (res *ServiceV2, err error) {
+	triggerSvcV2Once.Do(func() {
+		triggerSvcV2, err = newTriggerServiceV2()
+		if err != nil {
+			log.Errorf("failed to get trigger service: %v", err)
+		}
+	})
+	return triggerSvcV2, err
+}
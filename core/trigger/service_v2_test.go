@@ -0,0 +1,94 @@
+package trigger
+
+import (
+	"encoding/json"
+	"github.com/crawlab-team/crawlab/core/constants"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandParam(t *testing.T) {
+	vars := map[string]string{
+		"task_id":        "abc",
+		"payload.branch": "main",
+	}
+	for param, expected := range map[string]string{
+		"--task {{task_id}} --branch {{payload.branch}}": "--task abc --branch main",
+		"--x {{unknown}}":  "--x {{unknown}}",
+		"--no-placeholder": "--no-placeholder",
+	} {
+		res, err := expandParam(param, vars)
+		require.Nil(t, err)
+		require.Equal(t, expected, res)
+	}
+
+	// values with whitespace would inject arguments
+	vars["payload.branch"] = "main --evil"
+	_, err := expandParam("--branch {{payload.branch}}", vars)
+	require.NotNil(t, err)
+	_, err = expandParam("--branch\t{{payload.branch}}", map[string]string{"payload.branch": "a\nb"})
+	require.NotNil(t, err)
+
+	// unused values are not checked
+	res, err := expandParam("--task {{task_id}}", vars)
+	require.Nil(t, err)
+	require.Equal(t, "--task abc", res)
+}
+
+func TestTriggerV2_Token(t *testing.T) {
+	// secret token is not returned with triggers
+	data, err := json.Marshal(models2.TriggerV2{Type: constants.TriggerTypeWebhook, Token: "secret"})
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "secret")
+}
+
+func TestFlattenPayload(t *testing.T) {
+	var data interface{}
+	require.Nil(t, json.Unmarshal([]byte(`{"ref":"main","commit":{"id":"c1","count":2},"tags":["a"]}`), &data))
+
+	vars := map[string]string{}
+	flattenPayload("payload", data, vars)
+	require.Equal(t, "main", vars["payload.ref"])
+	require.Equal(t, "c1", vars["payload.commit.id"])
+	require.Equal(t, "2", vars["payload.commit.count"])
+	require.Equal(t, `{"count":2,"id":"c1"}`, vars["payload.commit"])
+	require.Equal(t, `["a"]`, vars["payload.tags"])
+	_, ok := vars["payload"]
+	require.False(t, ok)
+}
+
+func TestGetWatchDir(t *testing.T) {
+	dir, err := GetWatchDir("/workspace", "inbox/data")
+	require.Nil(t, err)
+	require.Equal(t, filepath.Join("/workspace", "inbox", "data"), dir)
+
+	for _, p := range []string{"", ".", "..", "../etc", "inbox/../../etc", "/etc"} {
+		_, err = GetWatchDir("/workspace", p)
+		require.NotNil(t, err, p)
+	}
+}
+
+func TestMatchWatchPattern(t *testing.T) {
+	require.True(t, matchWatchPattern("", "data.csv"))
+	require.False(t, matchWatchPattern("", ".hidden"))
+	require.True(t, matchWatchPattern("*.csv", "data.csv"))
+	require.False(t, matchWatchPattern("*.csv", "data.json"))
+}
+
+func TestValidateTrigger(t *testing.T) {
+	spiderId := primitive.NewObjectID()
+
+	require.NotNil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeWebhook}))
+	require.Nil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeWebhook, SpiderId: spiderId}))
+	require.NotNil(t, ValidateTrigger(&models2.TriggerV2{Type: "unknown", SpiderId: spiderId}))
+
+	require.NotNil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeTask, SpiderId: spiderId}))
+	require.Nil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeTask, SpiderId: spiderId, SourceSpiderId: primitive.NewObjectID()}))
+
+	require.Nil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeFile, SpiderId: spiderId, WatchPath: "inbox", WatchPattern: "*.csv"}))
+	require.NotNil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeFile, SpiderId: spiderId, WatchPath: "../inbox"}))
+	require.NotNil(t, ValidateTrigger(&models2.TriggerV2{Type: constants.TriggerTypeFile, SpiderId: spiderId, WatchPath: "inbox", WatchPattern: "["}))
+}
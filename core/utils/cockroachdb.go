@@ -37,7 +37,7 @@ func getCockroachdbSession(ctx context.Context, ds *models.DataSource) (s db.Ses
 		User:     ds.Username,
 		Password: ds.Password,
 		Database: ds.Database,
-		Host:     fmt.Sprintf("%s:%s", host, port),
+		Host:     fmt.Sprintf("%s:%d", host, port),
 		Options:  nil,
 	}
 
@@ -82,7 +82,7 @@ func getCockroachdbSessionV2(ctx context.Context, ds *models2.DatabaseV2) (s db.
 		User:     ds.Username,
 		Password: ds.Password,
 		Database: ds.Database,
-		Host:     fmt.Sprintf("%s:%s", host, port),
+		Host:     fmt.Sprintf("%s:%d", host, port),
 		Options:  nil,
 	}
 
@@ -40,7 +40,7 @@ func getElasticsearchClient(ctx context.Context, ds *models.DataSource) (c *elas
 
 	// es hosts
 	addresses := []string{
-		fmt.Sprintf("http://%s:%s", host, port),
+		fmt.Sprintf("http://%s:%d", host, port),
 	}
 
 	// retry backoff
@@ -103,7 +103,7 @@ func getElasticsearchClientV2(ctx context.Context, ds *models2.DatabaseV2) (c *e
 
 	// es hosts
 	addresses := []string{
-		fmt.Sprintf("http://%s:%s", host, port),
+		fmt.Sprintf("http://%s:%d", host, port),
 	}
 
 	// retry backoff
@@ -33,7 +33,7 @@ func getKafkaConnection(ctx context.Context, ds *models.DataSource) (c *kafka.Co
 
 	// kafka connection address
 	network := "tcp"
-	address := fmt.Sprintf("%s:%s", host, port)
+	address := fmt.Sprintf("%s:%d", host, port)
 	topic := ds.Database
 	partition := 0 // TODO: parameterize
 
@@ -60,7 +60,7 @@ func getKafkaConnectionV2(ctx context.Context, ds *models2.DatabaseV2) (c *kafka
 
 	// kafka connection address
 	network := "tcp"
-	address := fmt.Sprintf("%s:%s", host, port)
+	address := fmt.Sprintf("%s:%d", host, port)
 	topic := ds.Database
 	partition := 0 // TODO: parameterize
 
@@ -19,6 +19,11 @@ func GetMongoQuery(query generic.ListQuery) (res bson.M) {
 		case generic.OpEqual:
 			res[c.Key] = c.Value
 		default:
+			// merge operators on the same key, e.g. a range
+			if m, ok := res[c.Key].(bson.M); ok {
+				m[c.Op] = c.Value
+				continue
+			}
 			res[c.Key] = bson.M{
 				c.Op: c.Value,
 			}
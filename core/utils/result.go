@@ -8,12 +8,12 @@ import (
 func GetResultHash(value interface{}, keys []string) (res string, err error) {
 	m := make(map[string]interface{})
 	for _, k := range keys {
-		_value, ok := value.(interfaces.Result)
-		if !ok {
-			continue
+		switch _value := value.(type) {
+		case interfaces.Result:
+			m[k] = _value.GetValue(k)
+		case map[string]interface{}:
+			m[k] = _value[k]
 		}
-		v := _value.GetValue(k)
-		m[k] = v
 	}
 	data, err := json.Marshal(m)
 	if err != nil {
@@ -0,0 +1,799 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	"github.com/crawlab-team/crawlab/core/event"
+	"github.com/crawlab-team/crawlab/core/interfaces"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/crawlab-team/crawlab/core/models/service"
+	"github.com/crawlab-team/crawlab/core/node/leader"
+	"github.com/crawlab-team/crawlab/core/spider/admin"
+	"github.com/crawlab-team/crawlab/core/task/scheduler"
+	"github.com/crawlab-team/crawlab/db/mongo"
+	"github.com/crawlab-team/crawlab/trace"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+)
+
+// maxRunConflicts is the max attempts of updating a run modified concurrently
+const maxRunConflicts = 10
+
+// ServiceV2 runs workflows, which are DAGs of spider steps. Runs are advanced when tasks of their
+// steps update status, and periodically by the leader of masters in case an update is missed.
+// Updates of runs are versioned, so that a step is started only once even if the run is advanced
+// by several masters at the same time.
+type ServiceV2 struct {
+	// dependencies
+	modelSvc     *service.ModelServiceV2[models2.WorkflowV2]
+	runModelSvc  *service.ModelServiceV2[models2.WorkflowRunV2]
+	taskModelSvc *service.ModelServiceV2[models2.TaskV2]
+	adminSvc     *admin.ServiceV2
+	schedulerSvc *scheduler.ServiceV2
+
+	// settings
+	interval     time.Duration // interval of advancing running runs
+	startTimeout time.Duration // max time of creating tasks of a started step
+
+	// internals
+	ch      chan interfaces.EventData
+	stopped bool
+}
+
+func (svc *ServiceV2) Start() {
+	// task status updated by runners
+	event.NewEventService().Register("workflow", "^"+constants.TaskEventStatusUpdated+"$", "^$", &svc.ch)
+	go func() {
+		for d := range svc.ch {
+			taskId, ok := d.GetData().(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			if err := svc.OnTaskStatus(taskId); err != nil {
+				trace.PrintError(err)
+			}
+		}
+	}()
+
+	// running runs
+	for {
+		if svc.stopped {
+			return
+		}
+
+		if leader.IsLeader() {
+			svc.advanceRuns()
+		}
+
+		time.Sleep(svc.interval)
+	}
+}
+
+func (svc *ServiceV2) Stop() {
+	svc.stopped = true
+	event.NewEventService().Unregister("workflow")
+}
+
+// Run starts a run of a workflow, optionally by a schedule
+func (svc *ServiceV2) Run(id primitive.ObjectID, scheduleId primitive.ObjectID, by primitive.ObjectID) (run *models2.WorkflowRunV2, err error) {
+	w, err := svc.modelSvc.GetById(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateWorkflow(w); err != nil {
+		return nil, err
+	}
+
+	run = newWorkflowRun(w, scheduleId, time.Now())
+	run.SetId(primitive.NewObjectID())
+	run.SetCreated(by)
+	run.SetUpdated(by)
+	if _, err := svc.runModelSvc.InsertOne(*run); err != nil {
+		return nil, trace.TraceError(err)
+	}
+	log.Infof("[WorkflowServiceV2] started run[%s] of workflow[%s]", run.Id.Hex(), w.Id.Hex())
+
+	if err := svc.Advance(run.Id); err != nil {
+		return nil, err
+	}
+	return svc.runModelSvc.GetById(run.Id)
+}
+
+// Cancel cancels a running run, with its pending steps and tasks of its running steps
+func (svc *ServiceV2) Cancel(id primitive.ObjectID, by primitive.ObjectID) (err error) {
+	var taskIds []primitive.ObjectID
+	if _, err := svc.UpdateRun(id, func(run *models2.WorkflowRunV2) (err error) {
+		taskIds, err = cancelRun(run, time.Now())
+		return err
+	}); err != nil {
+		return err
+	}
+	log.Infof("[WorkflowServiceV2] cancelled run[%s]", id.Hex())
+	for _, taskId := range taskIds {
+		if err := svc.cancelTask(taskId, by); err != nil {
+			trace.PrintError(err)
+		}
+	}
+	return nil
+}
+
+// RetryStep reruns a failed or cancelled step of a run, along with its downstream steps
+func (svc *ServiceV2) RetryStep(id primitive.ObjectID, key string) (err error) {
+	if _, err := svc.UpdateRun(id, func(run *models2.WorkflowRunV2) (err error) {
+		return retryStep(run, key)
+	}); err != nil {
+		return err
+	}
+	log.Infof("[WorkflowServiceV2] retrying step %s of run[%s]", key, id.Hex())
+	return svc.Advance(id)
+}
+
+// SkipStep skips a pending or running step of a run, cancelling its task if running. Downstream
+// steps only run if their edges from the step have condition "always".
+func (svc *ServiceV2) SkipStep(id primitive.ObjectID, key string, by primitive.ObjectID) (err error) {
+	var taskId primitive.ObjectID
+	if _, err := svc.UpdateRun(id, func(run *models2.WorkflowRunV2) (err error) {
+		taskId, err = skipStep(run, key, time.Now())
+		return err
+	}); err != nil {
+		return err
+	}
+	log.Infof("[WorkflowServiceV2] skipped step %s of run[%s]", key, id.Hex())
+	if !taskId.IsZero() {
+		if err := svc.cancelTask(taskId, by); err != nil {
+			trace.PrintError(err)
+		}
+	}
+	return svc.Advance(id)
+}
+
+// OnTaskStatus advances the run of a task with the updated status. Tasks of cancelled runs, such
+// as retries of failed tasks created after cancelling, are cancelled.
+func (svc *ServiceV2) OnTaskStatus(taskId primitive.ObjectID) (err error) {
+	t, err := svc.taskModelSvc.GetById(taskId)
+	if err != nil {
+		return err
+	}
+	if t.WorkflowRunId.IsZero() {
+		return nil
+	}
+	run, err := svc.runModelSvc.GetById(t.WorkflowRunId)
+	if err != nil {
+		return err
+	}
+	switch run.Status {
+	case constants.WorkflowRunStatusRunning:
+		return svc.Advance(run.Id)
+	case constants.WorkflowRunStatusCancelled:
+		return svc.cancelTask(t.Id, run.GetCreatedBy())
+	default:
+		return nil
+	}
+}
+
+// Advance updates steps of a running run from the status of their tasks, then starts steps whose
+// upstream steps have ended and skips steps whose edge conditions are not met, and ends the run
+// once no step is pending or running
+func (svc *ServiceV2) Advance(id primitive.ObjectID) (err error) {
+	for conflicts := 0; conflicts < maxRunConflicts; {
+		run, err := svc.runModelSvc.GetById(id)
+		if err != nil {
+			return err
+		}
+		if run.Status != constants.WorkflowRunStatusRunning {
+			return nil
+		}
+
+		// steps
+		synced, err := svc.syncSteps(run)
+		if err != nil {
+			return err
+		}
+		started, evaluated := evaluateRun(run, time.Now())
+		if !synced && !evaluated {
+			return nil
+		}
+
+		// save before starting steps, so that only one master starts them
+		ok, err := svc.saveRun(run)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			conflicts++
+			continue
+		}
+		if run.Status != constants.WorkflowRunStatusRunning {
+			log.Infof("[WorkflowServiceV2] run[%s] ended with status %s", run.Id.Hex(), run.Status)
+			return nil
+		}
+		if len(started) == 0 {
+			return nil
+		}
+		for _, i := range started {
+			svc.startStep(run, run.Steps[i].Key)
+		}
+	}
+	return errors.New(fmt.Sprintf("too many concurrent updates of workflow run[%s]", id.Hex()))
+}
+
+// UpdateRun applies an update to a run, which is reapplied to the latest run if modified
+// concurrently
+func (svc *ServiceV2) UpdateRun(id primitive.ObjectID, fn func(run *models2.WorkflowRunV2) (err error)) (run *models2.WorkflowRunV2, err error) {
+	for i := 0; i < maxRunConflicts; i++ {
+		run, err = svc.runModelSvc.GetById(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(run); err != nil {
+			return nil, err
+		}
+		ok, err := svc.saveRun(run)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return run, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("too many concurrent updates of workflow run[%s]", id.Hex()))
+}
+
+// advanceRuns advances all running runs
+func (svc *ServiceV2) advanceRuns() {
+	runs, err := svc.runModelSvc.GetMany(bson.M{"status": constants.WorkflowRunStatusRunning}, nil)
+	if err != nil {
+		if !errors.Is(err, mongo2.ErrNoDocuments) {
+			trace.PrintError(err)
+		}
+		return
+	}
+	for _, run := range runs {
+		if err := svc.Advance(run.Id); err != nil {
+			trace.PrintError(err)
+		}
+	}
+}
+
+// saveRun saves a run if not modified since it was read, and returns whether it is saved
+func (svc *ServiceV2) saveRun(run *models2.WorkflowRunV2) (ok bool, err error) {
+	version := run.Version
+	run.Version++
+	run.SetUpdatedAt(time.Now())
+	col := svc.runModelSvc.GetCol()
+	res, err := col.GetCollection().ReplaceOne(col.GetContext(), bson.M{
+		"_id":     run.Id,
+		"version": version,
+	}, run)
+	if err != nil {
+		return false, trace.TraceError(err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// syncSteps updates running steps of a run from the status of their tasks, and returns whether
+// any step is updated
+func (svc *ServiceV2) syncSteps(run *models2.WorkflowRunV2) (changed bool, err error) {
+	for i := range run.Steps {
+		s := &run.Steps[i]
+		if s.Status != constants.WorkflowStepStatusRunning {
+			continue
+		}
+
+		// task not created, e.g. the master starting the step stopped
+		if s.TaskId.IsZero() {
+			if time.Since(s.StartTs) > svc.startTimeout {
+				s.Status = constants.WorkflowStepStatusError
+				s.Error = "no task was created"
+				s.EndTs = time.Now()
+				changed = true
+			}
+			continue
+		}
+
+		status, taskId, err := svc.getTaskStatus(s.TaskId)
+		if err != nil {
+			if errors.Is(err, mongo2.ErrNoDocuments) {
+				s.Status = constants.WorkflowStepStatusError
+				s.Error = fmt.Sprintf("task[%s] not found", s.TaskId.Hex())
+				s.EndTs = time.Now()
+				changed = true
+				continue
+			}
+			return false, err
+		}
+		if taskId != s.TaskId {
+			s.TaskId = taskId
+			changed = true
+		}
+		if setStepTaskStatus(s, status, time.Now()) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// getTaskStatus returns the status of the task of a step, which is rolled up from sub-tasks if
+// the task has any, or the status of the last attempt if retried by retry policy. Failed tasks
+// going to be retried are pending.
+func (svc *ServiceV2) getTaskStatus(id primitive.ObjectID) (status string, taskId primitive.ObjectID, err error) {
+	t, err := svc.taskModelSvc.GetById(id)
+	if err != nil {
+		return "", id, err
+	}
+	if !t.HasSub {
+		return svc.getAttemptStatus(t)
+	}
+	subTasks, err := svc.taskModelSvc.GetMany(bson.M{"parent_id": t.Id}, nil)
+	if err != nil && !errors.Is(err, mongo2.ErrNoDocuments) {
+		return "", id, err
+	}
+	subTasks, err = scheduler.GetLastAttempts(subTasks)
+	if err != nil {
+		return "", id, err
+	}
+	return scheduler.GetParentTaskStatus(subTasks), t.Id, nil
+}
+
+// getAttemptStatus returns the status and id of the last attempt of a task
+func (svc *ServiceV2) getAttemptStatus(t *models2.TaskV2) (status string, taskId primitive.ObjectID, err error) {
+	if t.RetryPolicy.MaxAttempts <= 1 {
+		return t.Status, t.Id, nil
+	}
+
+	// last attempt, which links to the first attempt
+	firstId := t.Id
+	if !t.FirstAttemptId.IsZero() {
+		firstId = t.FirstAttemptId
+	}
+	rt, err := svc.taskModelSvc.GetOne(bson.M{
+		"first_attempt_id": firstId,
+	}, &mongo.FindOptions{Sort: bson.D{{"attempt", -1}}})
+	if err == nil {
+		t = rt
+	} else if !errors.Is(err, mongo2.ErrNoDocuments) {
+		return "", t.Id, err
+	}
+
+	// pending if going to be retried
+	ok, err := scheduler.IsPendingRetry(t)
+	if err != nil {
+		return "", t.Id, err
+	}
+	if ok {
+		return constants.TaskStatusPending, t.Id, nil
+	}
+	return t.Status, t.Id, nil
+}
+
+// startStep creates tasks of a started step, or marks it as failed if unable to
+func (svc *ServiceV2) startStep(run *models2.WorkflowRunV2, key string) {
+	s := getStep(run, key)
+	opts := &interfaces.SpiderRunOptions{
+		Mode:            s.Mode,
+		NodeIds:         s.NodeIds,
+		Cmd:             s.Cmd,
+		Param:           s.Param,
+		Priority:        s.Priority,
+		UserId:          run.GetCreatedBy(),
+		WorkflowRunId:   run.Id,
+		WorkflowStep:    s.Key,
+		UpstreamTaskIds: getUpstreamTaskIds(run, s.Key),
+	}
+	taskIds, scheduleErr := svc.adminSvc.Schedule(s.SpiderId, opts)
+	if scheduleErr == nil && len(taskIds) == 0 {
+		scheduleErr = errors.New("no task was created")
+	}
+
+	// tasks of a step cancelled or skipped while starting are cancelled
+	orphaned := false
+	if _, err := svc.UpdateRun(run.Id, func(run *models2.WorkflowRunV2) (err error) {
+		s := getStep(run, key)
+		orphaned = s == nil || s.Status != constants.WorkflowStepStatusRunning || !s.TaskId.IsZero()
+		if orphaned {
+			return nil
+		}
+		if scheduleErr != nil {
+			s.Status = constants.WorkflowStepStatusError
+			s.Error = scheduleErr.Error()
+			s.EndTs = time.Now()
+			return nil
+		}
+		s.TaskId = taskIds[0]
+		s.TaskIds = append(s.TaskIds, taskIds[0])
+		return nil
+	}); err != nil {
+		trace.PrintError(err)
+		return
+	}
+	if scheduleErr != nil {
+		log.Errorf("[WorkflowServiceV2] failed to start step %s of run[%s]: %v", key, run.Id.Hex(), scheduleErr)
+		return
+	}
+	if orphaned {
+		if err := svc.cancelTask(taskIds[0], run.GetCreatedBy()); err != nil {
+			trace.PrintError(err)
+		}
+		return
+	}
+	log.Infof("[WorkflowServiceV2] started step %s of run[%s] as task[%s]", key, run.Id.Hex(), taskIds[0].Hex())
+}
+
+// cancelTask cancels the last attempt of a task if it has not ended. Failed tasks going to be
+// retried are cancelled as well, so that they are not retried.
+func (svc *ServiceV2) cancelTask(id primitive.ObjectID, by primitive.ObjectID) (err error) {
+	status, taskId, err := svc.getTaskStatus(id)
+	if err != nil {
+		return err
+	}
+	if isTaskEnded(status) {
+		return nil
+	}
+	return svc.schedulerSvc.Cancel(taskId, by)
+}
+
+// ValidateWorkflow validates steps and edges of a workflow, which must form a DAG
+func ValidateWorkflow(w *models2.WorkflowV2) (err error) {
+	if len(w.Steps) == 0 {
+		return errors.New("workflow has no steps")
+	}
+
+	// steps
+	keys := map[string]bool{}
+	for _, s := range w.Steps {
+		if s.Key == "" {
+			return errors.New("step key is not set")
+		}
+		if keys[s.Key] {
+			return errors.New(fmt.Sprintf("duplicated step: %s", s.Key))
+		}
+		if s.SpiderId.IsZero() {
+			return errors.New(fmt.Sprintf("spider of step %s is not set", s.Key))
+		}
+		keys[s.Key] = true
+	}
+
+	// edges
+	edges := map[[2]string]bool{}
+	for _, e := range w.Edges {
+		if !keys[e.From] {
+			return errors.New(fmt.Sprintf("upstream step of edge not found: %s", e.From))
+		}
+		if !keys[e.To] {
+			return errors.New(fmt.Sprintf("downstream step of edge not found: %s", e.To))
+		}
+		if e.From == e.To {
+			return errors.New(fmt.Sprintf("step %s depends on itself", e.From))
+		}
+		switch e.Condition {
+		case "", constants.WorkflowEdgeConditionSuccess, constants.WorkflowEdgeConditionFailure, constants.WorkflowEdgeConditionAlways:
+		default:
+			return errors.New(fmt.Sprintf("invalid edge condition: %s", e.Condition))
+		}
+		if edges[[2]string{e.From, e.To}] {
+			return errors.New(fmt.Sprintf("duplicated edge from %s to %s", e.From, e.To))
+		}
+		edges[[2]string{e.From, e.To}] = true
+	}
+
+	// cycles, by removing steps without upstream steps until none is left
+	inDegrees := map[string]int{}
+	for _, e := range w.Edges {
+		inDegrees[e.To]++
+	}
+	var queue []string
+	for _, s := range w.Steps {
+		if inDegrees[s.Key] == 0 {
+			queue = append(queue, s.Key)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, e := range w.Edges {
+			if e.From != key {
+				continue
+			}
+			inDegrees[e.To]--
+			if inDegrees[e.To] == 0 {
+				queue = append(queue, e.To)
+			}
+		}
+	}
+	if visited < len(w.Steps) {
+		return errors.New("steps of workflow have cyclic dependencies")
+	}
+
+	return nil
+}
+
+func newWorkflowRun(w *models2.WorkflowV2, scheduleId primitive.ObjectID, now time.Time) (run *models2.WorkflowRunV2) {
+	run = &models2.WorkflowRunV2{
+		WorkflowId: w.Id,
+		ScheduleId: scheduleId,
+		Status:     constants.WorkflowRunStatusRunning,
+		Edges:      w.Edges,
+		StartTs:    now,
+	}
+	for _, s := range w.Steps {
+		run.Steps = append(run.Steps, entity.WorkflowRunStep{
+			WorkflowStep: s,
+			Status:       constants.WorkflowStepStatusPending,
+		})
+	}
+	return run
+}
+
+// evaluateRun starts pending steps whose upstream steps have ended with all edge conditions met,
+// skips those with any condition not met, and ends the run if no step is pending or running. It
+// returns indexes of started steps, and whether the run is updated.
+func evaluateRun(run *models2.WorkflowRunV2, now time.Time) (started []int, changed bool) {
+	for {
+		progressed := false
+		for i := range run.Steps {
+			s := &run.Steps[i]
+			if s.Status != constants.WorkflowStepStatusPending {
+				continue
+			}
+			ready, reason := evaluateStep(run, s.Key)
+			if !ready {
+				continue
+			}
+			if reason != "" {
+				s.Status = constants.WorkflowStepStatusSkipped
+				s.Error = reason
+				s.EndTs = now
+			} else {
+				s.Status = constants.WorkflowStepStatusRunning
+				s.TaskId = primitive.NilObjectID
+				s.StartTs = now
+				started = append(started, i)
+			}
+			progressed = true
+		}
+		if !progressed {
+			break
+		}
+		changed = true
+	}
+
+	// end of run
+	status := getRunStatus(run)
+	if status != constants.WorkflowRunStatusRunning {
+		run.Status = status
+		run.EndTs = now
+		changed = true
+	}
+
+	return started, changed
+}
+
+// evaluateStep returns whether all upstream steps of a step have ended, and if so the reason of
+// skipping the step if any edge condition is not met
+func evaluateStep(run *models2.WorkflowRunV2, key string) (ended bool, reason string) {
+	for _, e := range run.Edges {
+		if e.To != key {
+			continue
+		}
+		up := getStep(run, e.From)
+		if !isStepEnded(up.Status) {
+			return false, ""
+		}
+		if reason == "" && !matchCondition(e.Condition, up.Status) {
+			reason = fmt.Sprintf("upstream step %s %s", up.Key, up.Status)
+		}
+	}
+	return true, reason
+}
+
+// getRunStatus returns the status of a run from its steps, which is running while any step is
+// pending or running, and error if any step failed or was cancelled
+func getRunStatus(run *models2.WorkflowRunV2) (status string) {
+	status = constants.WorkflowRunStatusFinished
+	for _, s := range run.Steps {
+		switch s.Status {
+		case constants.WorkflowStepStatusPending, constants.WorkflowStepStatusRunning:
+			return constants.WorkflowRunStatusRunning
+		case constants.WorkflowStepStatusError, constants.WorkflowStepStatusCancelled:
+			status = constants.WorkflowRunStatusError
+		}
+	}
+	return status
+}
+
+// setStepTaskStatus updates a step from the status of its task, and returns whether it is ended
+func setStepTaskStatus(s *entity.WorkflowRunStep, taskStatus string, now time.Time) (ended bool) {
+	switch taskStatus {
+	case constants.TaskStatusFinished:
+		s.Status = constants.WorkflowStepStatusFinished
+	case constants.TaskStatusCancelled:
+		s.Status = constants.WorkflowStepStatusCancelled
+	case constants.TaskStatusError, constants.TaskStatusAbnormal, constants.TaskStatusTimeout, constants.TaskStatusOomKilled:
+		s.Status = constants.WorkflowStepStatusError
+		s.Error = fmt.Sprintf("task[%s] ended with status %s", s.TaskId.Hex(), taskStatus)
+	default:
+		return false
+	}
+	s.EndTs = now
+	return true
+}
+
+// cancelRun cancels pending and running steps of a run, and returns tasks of running steps
+func cancelRun(run *models2.WorkflowRunV2, now time.Time) (taskIds []primitive.ObjectID, err error) {
+	if run.Status != constants.WorkflowRunStatusRunning {
+		return nil, errors.New("workflow run is not running")
+	}
+	for i := range run.Steps {
+		s := &run.Steps[i]
+		switch s.Status {
+		case constants.WorkflowStepStatusRunning:
+			if !s.TaskId.IsZero() {
+				taskIds = append(taskIds, s.TaskId)
+			}
+		case constants.WorkflowStepStatusPending:
+		default:
+			continue
+		}
+		s.Status = constants.WorkflowStepStatusCancelled
+		s.EndTs = now
+	}
+	run.Status = constants.WorkflowRunStatusCancelled
+	run.EndTs = now
+	return taskIds, nil
+}
+
+// retryStep resets a failed or cancelled step and its downstream steps to pending, and resumes
+// the run if ended
+func retryStep(run *models2.WorkflowRunV2, key string) (err error) {
+	s := getStep(run, key)
+	if s == nil {
+		return errors.New(fmt.Sprintf("step not found: %s", key))
+	}
+	if s.Status != constants.WorkflowStepStatusError && s.Status != constants.WorkflowStepStatusCancelled {
+		return errors.New(fmt.Sprintf("step %s is %s, only failed or cancelled steps can be retried", key, s.Status))
+	}
+	keys := append([]string{key}, getDownstreamSteps(run, key)...)
+	for _, k := range keys[1:] {
+		if ds := getStep(run, k); ds.Status == constants.WorkflowStepStatusRunning {
+			return errors.New(fmt.Sprintf("downstream step %s is running", k))
+		}
+	}
+	for _, k := range keys {
+		ds := getStep(run, k)
+		ds.Status = constants.WorkflowStepStatusPending
+		ds.TaskId = primitive.NilObjectID
+		ds.Error = ""
+		ds.StartTs = time.Time{}
+		ds.EndTs = time.Time{}
+	}
+	run.Status = constants.WorkflowRunStatusRunning
+	run.EndTs = time.Time{}
+	return nil
+}
+
+// skipStep skips a pending or running step of a running run, and returns the task of the step
+// if running
+func skipStep(run *models2.WorkflowRunV2, key string, now time.Time) (taskId primitive.ObjectID, err error) {
+	if run.Status != constants.WorkflowRunStatusRunning {
+		return taskId, errors.New("workflow run is not running")
+	}
+	s := getStep(run, key)
+	if s == nil {
+		return taskId, errors.New(fmt.Sprintf("step not found: %s", key))
+	}
+	if s.Status != constants.WorkflowStepStatusPending && s.Status != constants.WorkflowStepStatusRunning {
+		return taskId, errors.New(fmt.Sprintf("step %s is %s, only pending or running steps can be skipped", key, s.Status))
+	}
+	if s.Status == constants.WorkflowStepStatusRunning {
+		taskId = s.TaskId
+	}
+	s.Status = constants.WorkflowStepStatusSkipped
+	s.Error = "skipped manually"
+	s.EndTs = now
+	return taskId, nil
+}
+
+// getUpstreamTaskIds returns tasks of upstream steps of a step which have run
+func getUpstreamTaskIds(run *models2.WorkflowRunV2, key string) (taskIds []primitive.ObjectID) {
+	for _, e := range run.Edges {
+		if e.To != key {
+			continue
+		}
+		if up := getStep(run, e.From); up != nil && !up.TaskId.IsZero() {
+			taskIds = append(taskIds, up.TaskId)
+		}
+	}
+	return taskIds
+}
+
+// getDownstreamSteps returns keys of all steps depending on a step directly or indirectly
+func getDownstreamSteps(run *models2.WorkflowRunV2, key string) (keys []string) {
+	visited := map[string]bool{key: true}
+	queue := []string{key}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		for _, e := range run.Edges {
+			if e.From != k || visited[e.To] {
+				continue
+			}
+			visited[e.To] = true
+			keys = append(keys, e.To)
+			queue = append(queue, e.To)
+		}
+	}
+	return keys
+}
+
+func getStep(run *models2.WorkflowRunV2, key string) (s *entity.WorkflowRunStep) {
+	for i := range run.Steps {
+		if run.Steps[i].Key == key {
+			return &run.Steps[i]
+		}
+	}
+	return nil
+}
+
+func matchCondition(condition string, status string) (ok bool) {
+	switch condition {
+	case constants.WorkflowEdgeConditionAlways:
+		return true
+	case constants.WorkflowEdgeConditionFailure:
+		return status == constants.WorkflowStepStatusError || status == constants.WorkflowStepStatusCancelled
+	default:
+		return status == constants.WorkflowStepStatusFinished
+	}
+}
+
+func isStepEnded(status string) (ok bool) {
+	return status != constants.WorkflowStepStatusPending && status != constants.WorkflowStepStatusRunning
+}
+
+func isTaskEnded(status string) (ok bool) {
+	return status != constants.TaskStatusPending && status != constants.TaskStatusRunning
+}
+
+func newWorkflowServiceV2() (svc *ServiceV2, err error) {
+	svc = &ServiceV2{
+		modelSvc:     service.NewModelServiceV2[models2.WorkflowV2](),
+		runModelSvc:  service.NewModelServiceV2[models2.WorkflowRunV2](),
+		taskModelSvc: service.NewModelServiceV2[models2.TaskV2](),
+		interval:     15 * time.Second,
+		startTimeout: time.Minute,
+		ch:           make(chan interfaces.EventData),
+	}
+	if d := viper.GetDuration("workflow.interval"); d > 0 {
+		svc.interval = d
+	}
+	svc.adminSvc, err = admin.GetSpiderAdminServiceV2()
+	if err != nil {
+		return nil, err
+	}
+	svc.schedulerSvc, err = scheduler.GetTaskSchedulerServiceV2()
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+var workflowSvcV2 *ServiceV2
+var workflowSvcV2Once = new(sync.Once)
+
+func GetWorkflowServiceV2() (res *ServiceV2, err error) {
+	workflowSvcV2Once.Do(func() {
+		workflowSvcV2, err = newWorkflowServiceV2()
+		if err != nil {
+			log.Errorf("failed to get workflow service: %v", err)
+		}
+	})
+	return workflowSvcV2, err
+}
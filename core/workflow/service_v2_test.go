@@ -0,0 +1,225 @@
+package workflow
+
+import (
+	"github.com/crawlab-team/crawlab/core/constants"
+	"github.com/crawlab-team/crawlab/core/entity"
+	models2 "github.com/crawlab-team/crawlab/core/models/models/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func newTestWorkflow(edges ...entity.WorkflowEdge) (w *models2.WorkflowV2) {
+	w = &models2.WorkflowV2{Edges: edges}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		w.Steps = append(w.Steps, entity.WorkflowStep{Key: key, SpiderId: primitive.NewObjectID()})
+	}
+	return w
+}
+
+func getStatuses(run *models2.WorkflowRunV2) (statuses map[string]string) {
+	statuses = map[string]string{}
+	for _, s := range run.Steps {
+		statuses[s.Key] = s.Status
+	}
+	return statuses
+}
+
+func endStep(run *models2.WorkflowRunV2, key string, status string) {
+	s := getStep(run, key)
+	s.TaskId = primitive.NewObjectID()
+	setStepTaskStatus(s, status, time.Now())
+}
+
+func TestValidateWorkflow(t *testing.T) {
+	require.Nil(t, ValidateWorkflow(newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "a", To: "c", Condition: constants.WorkflowEdgeConditionFailure},
+		entity.WorkflowEdge{From: "b", To: "d", Condition: constants.WorkflowEdgeConditionAlways},
+		entity.WorkflowEdge{From: "c", To: "d"},
+	)))
+
+	require.NotNil(t, ValidateWorkflow(&models2.WorkflowV2{}))
+	require.NotNil(t, ValidateWorkflow(newTestWorkflow(entity.WorkflowEdge{From: "a", To: "x"})))
+	require.NotNil(t, ValidateWorkflow(newTestWorkflow(entity.WorkflowEdge{From: "a", To: "a"})))
+	require.NotNil(t, ValidateWorkflow(newTestWorkflow(entity.WorkflowEdge{From: "a", To: "b", Condition: "maybe"})))
+	require.NotNil(t, ValidateWorkflow(newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "a", To: "b", Condition: constants.WorkflowEdgeConditionAlways},
+	)))
+
+	// cycle
+	require.NotNil(t, ValidateWorkflow(newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "b", To: "c"},
+		entity.WorkflowEdge{From: "c", To: "a"},
+	)))
+
+	// duplicated step
+	w := newTestWorkflow()
+	w.Steps = append(w.Steps, entity.WorkflowStep{Key: "a", SpiderId: primitive.NewObjectID()})
+	require.NotNil(t, ValidateWorkflow(w))
+
+	// spider not set
+	w = newTestWorkflow()
+	w.Steps[0].SpiderId = primitive.NilObjectID
+	require.NotNil(t, ValidateWorkflow(w))
+}
+
+func TestEvaluateRun(t *testing.T) {
+	// a -> b (success), a -> c (failure), b -> d (always), c -> d (success)
+	w := newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "a", To: "c", Condition: constants.WorkflowEdgeConditionFailure},
+		entity.WorkflowEdge{From: "b", To: "d", Condition: constants.WorkflowEdgeConditionAlways},
+		entity.WorkflowEdge{From: "c", To: "d"},
+	)
+	now := time.Now()
+
+	// a succeeded: b runs, c is skipped, d waits for b
+	run := newWorkflowRun(w, primitive.NilObjectID, now)
+	started, changed := evaluateRun(run, now)
+	require.True(t, changed)
+	require.Equal(t, []int{0}, started)
+	endStep(run, "a", constants.TaskStatusFinished)
+	started, changed = evaluateRun(run, now)
+	require.True(t, changed)
+	require.Equal(t, []int{1}, started)
+	require.Equal(t, map[string]string{
+		"a": constants.WorkflowStepStatusFinished,
+		"b": constants.WorkflowStepStatusRunning,
+		"c": constants.WorkflowStepStatusSkipped,
+		"d": constants.WorkflowStepStatusPending,
+	}, getStatuses(run))
+
+	// nothing to do while b is running
+	_, changed = evaluateRun(run, now)
+	require.False(t, changed)
+
+	// b failed: d is skipped as c was skipped, and the run failed
+	endStep(run, "b", constants.TaskStatusError)
+	started, _ = evaluateRun(run, now)
+	require.Empty(t, started)
+	require.Equal(t, constants.WorkflowStepStatusSkipped, getStep(run, "d").Status)
+	require.Equal(t, constants.WorkflowRunStatusError, run.Status)
+
+	// a failed: c runs, b and d are skipped
+	run = newWorkflowRun(w, primitive.NilObjectID, now)
+	evaluateRun(run, now)
+	endStep(run, "a", constants.TaskStatusError)
+	started, _ = evaluateRun(run, now)
+	require.Equal(t, []int{2}, started)
+	require.Equal(t, constants.WorkflowStepStatusSkipped, getStep(run, "b").Status)
+	require.Equal(t, constants.WorkflowStepStatusPending, getStep(run, "d").Status)
+
+	// d runs as its edge from skipped b is "always", and the run failed as a failed
+	endStep(run, "c", constants.TaskStatusFinished)
+	started, _ = evaluateRun(run, now)
+	require.Equal(t, []int{3}, started)
+	endStep(run, "d", constants.TaskStatusFinished)
+	evaluateRun(run, now)
+	require.Equal(t, constants.WorkflowRunStatusError, run.Status)
+}
+
+func TestEvaluateRunFinished(t *testing.T) {
+	// a -> b, a -> c, b -> d, c -> d
+	w := newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "a", To: "c"},
+		entity.WorkflowEdge{From: "b", To: "d"},
+		entity.WorkflowEdge{From: "c", To: "d"},
+	)
+	now := time.Now()
+	run := newWorkflowRun(w, primitive.NilObjectID, now)
+	evaluateRun(run, now)
+	endStep(run, "a", constants.TaskStatusFinished)
+	started, _ := evaluateRun(run, now)
+	require.Equal(t, []int{1, 2}, started)
+
+	// d waits for both b and c
+	endStep(run, "b", constants.TaskStatusFinished)
+	started, _ = evaluateRun(run, now)
+	require.Empty(t, started)
+	endStep(run, "c", constants.TaskStatusFinished)
+	started, _ = evaluateRun(run, now)
+	require.Equal(t, []int{3}, started)
+	require.Equal(t, []primitive.ObjectID{getStep(run, "b").TaskId, getStep(run, "c").TaskId}, getUpstreamTaskIds(run, "d"))
+
+	endStep(run, "d", constants.TaskStatusFinished)
+	evaluateRun(run, now)
+	require.Equal(t, constants.WorkflowRunStatusFinished, run.Status)
+	require.False(t, run.EndTs.IsZero())
+}
+
+func TestRetryStep(t *testing.T) {
+	// a -> b -> c, a -> d
+	w := newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "b", To: "c"},
+		entity.WorkflowEdge{From: "a", To: "d"},
+	)
+	now := time.Now()
+	run := newWorkflowRun(w, primitive.NilObjectID, now)
+	evaluateRun(run, now)
+	endStep(run, "a", constants.TaskStatusFinished)
+	evaluateRun(run, now)
+	endStep(run, "b", constants.TaskStatusError)
+	endStep(run, "d", constants.TaskStatusFinished)
+	evaluateRun(run, now)
+	require.Equal(t, constants.WorkflowStepStatusSkipped, getStep(run, "c").Status)
+	require.Equal(t, constants.WorkflowRunStatusError, run.Status)
+
+	// only failed or cancelled steps are retried
+	require.NotNil(t, retryStep(run, "a"))
+	require.NotNil(t, retryStep(run, "x"))
+
+	// b and its downstream step c are rerun
+	require.Nil(t, retryStep(run, "b"))
+	require.Equal(t, constants.WorkflowRunStatusRunning, run.Status)
+	started, _ := evaluateRun(run, now)
+	require.Equal(t, []int{1}, started)
+	require.Equal(t, constants.WorkflowStepStatusPending, getStep(run, "c").Status)
+	require.Equal(t, constants.WorkflowStepStatusFinished, getStep(run, "d").Status)
+	endStep(run, "b", constants.TaskStatusFinished)
+	endStep(run, "c", constants.TaskStatusFinished)
+	evaluateRun(run, now)
+	evaluateRun(run, now)
+	require.Equal(t, constants.WorkflowRunStatusFinished, run.Status)
+}
+
+func TestSkipAndCancelRun(t *testing.T) {
+	// a -> b (success), a -> c (always)
+	w := newTestWorkflow(
+		entity.WorkflowEdge{From: "a", To: "b"},
+		entity.WorkflowEdge{From: "a", To: "c", Condition: constants.WorkflowEdgeConditionAlways},
+	)
+	now := time.Now()
+	run := newWorkflowRun(w, primitive.NilObjectID, now)
+	evaluateRun(run, now)
+	taskId := primitive.NewObjectID()
+	getStep(run, "a").TaskId = taskId
+
+	// skipping a running step returns its task, and only runs downstream steps of "always" edges
+	skippedTaskId, err := skipStep(run, "a", now)
+	require.Nil(t, err)
+	require.Equal(t, taskId, skippedTaskId)
+	_, err = skipStep(run, "a", now)
+	require.NotNil(t, err)
+	started, _ := evaluateRun(run, now)
+	require.Equal(t, []int{2}, started)
+	require.Equal(t, constants.WorkflowStepStatusSkipped, getStep(run, "b").Status)
+
+	// cancelling returns tasks of running steps
+	cTaskId := primitive.NewObjectID()
+	getStep(run, "c").TaskId = cTaskId
+	taskIds, err := cancelRun(run, now)
+	require.Nil(t, err)
+	require.Equal(t, []primitive.ObjectID{cTaskId}, taskIds)
+	require.Equal(t, constants.WorkflowRunStatusCancelled, run.Status)
+	require.Equal(t, constants.WorkflowStepStatusCancelled, getStep(run, "c").Status)
+	// d has no upstream step and is running without a task yet
+	require.Equal(t, constants.WorkflowStepStatusCancelled, getStep(run, "d").Status)
+	_, err = cancelRun(run, now)
+	require.NotNil(t, err)
+}
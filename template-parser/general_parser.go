@@ -153,6 +153,11 @@ func (p *GeneralParser) GetPlaceholders() (placeholders []string) {
 	return p.placeholders
 }
 
+// NewVM returns a js vm in which math expressions of templates are evaluated
+func NewVM() (vm *otto.Otto) {
+	return otto.New()
+}
+
 func NewGeneralParser() (p Parser, err error) {
 	// tag regexp
 	tagPrefix := "\\{\\{"
@@ -193,7 +198,7 @@ func NewGeneralParser() (p Parser, err error) {
 	}
 
 	// math vm
-	vm := otto.New()
+	vm := NewVM()
 
 	// parser
 	p = &GeneralParser{